@@ -0,0 +1,454 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+)
+
+// DISPIDs of the `ISWbemSinkEvents` methods.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemsink
+const (
+	dispIDOnObjectReady = 1
+	dispIDOnCompleted   = 2
+)
+
+// wmAsyncCancel is a thread message (WM_APP + 1) waking the async query
+// thread to cancel the query.
+const wmAsyncCancel = 0x8000 + 1
+
+var (
+	// iidISWbemSinkEvents is the outgoing interface of `SWbemSink`.
+	iidISWbemSinkEvents = ole.NewGUID("{75718CA0-F029-11D1-A1AC-00C04FB6C223}")
+
+	user32                 = syscall.NewLazyDLL("user32.dll")
+	procGetMessageW        = user32.NewProc("GetMessageW")
+	procPeekMessageW       = user32.NewProc("PeekMessageW")
+	procDispatchMessageW   = user32.NewProc("DispatchMessageW")
+	procPostThreadMessageW = user32.NewProc("PostThreadMessageW")
+	procGetCurrentThreadID = syscall.NewLazyDLL("kernel32.dll").NewProc("GetCurrentThreadId")
+)
+
+// AsyncQuery is a WQL query performed asynchronously by `Client.QueryAsync`.
+type AsyncQuery struct {
+	threadID   uint32
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+	done       chan struct{}
+	err        error
+}
+
+// QueryAsync starts the WQL query using `SWbemServices.ExecQueryAsync` and
+// sends the result objects to @out as the provider delivers them, e.g.
+//
+//	out := make(chan Win32_Process)
+//	q, err := c.QueryAsync("SELECT * FROM Win32_Process", out)
+//	if err != nil {
+//	    ...
+//	}
+//	for p := range out {
+//	    ...
+//	}
+//	if err := q.Wait(); err != nil {
+//	    ...
+//	}
+//
+// @out should be a channel of structures or structure pointers. Every object
+// is unmarshalled using the Client decoder. @out is closed when the query is
+// completed, cancelled or failed.
+//
+// QueryAsync returns as soon as the query is started. Unlike `QueryChan` the
+// objects are pushed by the provider through the `SWbemSink` events, so the
+// query runs on its own OS thread in a single-threaded apartment, which pumps
+// the sink events until the query is completed. The query waits for @out
+// receiver before handling the next object.
+//
+// The query always establishes its own connection (using the `Client.Connect`
+// args if @connectServerArgs are empty) on that thread,
+// `Client.SWbemServicesClient` isn't used.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemservices-execqueryasync
+func (c *Client) QueryAsync(query string, out interface{}, connectServerArgs ...interface{}) (*AsyncQuery, error) {
+	if !isChannelTypeOK(out) || reflect.TypeOf(out).ChanDir()&reflect.SendDir == 0 {
+		return nil, ErrInvalidEntityType
+	}
+	if len(connectServerArgs) == 0 {
+		connectServerArgs = c.connectArgs
+	}
+	q := &AsyncQuery{
+		cancelled: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	started := make(chan error, 1)
+	go q.run(c, query, reflect.ValueOf(out), connectServerArgs, started)
+	if err := <-started; err != nil {
+		<-q.done
+		return nil, err
+	}
+	return q, nil
+}
+
+// Cancel cancels the query using `SWbemSink.Cancel`. The objects not received
+// from the channel yet are dropped. Cancel could be called multiple times and
+// after the query is completed.
+func (q *AsyncQuery) Cancel() {
+	q.cancelOnce.Do(func() {
+		close(q.cancelled)
+		select {
+		case <-q.done:
+		default:
+			_, _, _ = procPostThreadMessageW.Call(uintptr(q.threadID), wmAsyncCancel, 0, 0)
+		}
+	})
+}
+
+// Done returns a channel that's closed when the query is finished and all
+// its resources are released.
+func (q *AsyncQuery) Done() <-chan struct{} {
+	return q.done
+}
+
+// Wait waits for the query to finish and returns the query error, if any.
+// Cancelled queries return nil. ErrFieldMismatch is returned if no other
+// errors occurred.
+func (q *AsyncQuery) Wait() error {
+	<-q.done
+	return q.err
+}
+
+func (q *AsyncQuery) isCancelled() bool {
+	select {
+	case <-q.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// run performs the query on the current goroutine locked to its OS thread.
+// The start error (or nil) is sent to @started.
+func (q *AsyncQuery) run(c *Client, query string, out reflect.Value, connectServerArgs []interface{}, started chan<- error) {
+	defer close(q.done)
+	defer out.Close()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	start := func(err error) {
+		if started != nil {
+			started <- err
+			started = nil
+		}
+	}
+	err := q.exec(c, query, out, connectServerArgs, func() { start(nil) })
+	start(err) // No-op if the query was started.
+	q.err = err
+}
+
+func (q *AsyncQuery) exec(c *Client, query string, out reflect.Value, connectServerArgs []interface{}, started func()) (err error) {
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	// The sink events are delivered through the message loop of the thread
+	// the sink was created on.
+	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+		if code, ok := oleErrorCode(err); !ok || code != sFalse {
+			return fmt.Errorf("CoInitializeEx error; %v", err)
+		}
+	}
+	defer ole.CoUninitialize()
+
+	progID := c.LocatorProgID
+	if progID == "" {
+		progID = defaultLocatorProgID
+	}
+	services, err := newSWbemServices(progID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if clErr := services.Close(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	services.Decoder = c.Decoder
+	conn, err := c.connectServer(services, connectServerArgs)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if clErr := conn.Close(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	c.configure(conn)
+
+	sinkUnknown, err := oleutil.CreateObject("WbemScripting.SWbemSink")
+	if err != nil {
+		return fmt.Errorf("CreateObject SWbemSink error; %v", err)
+	}
+	sink, err := sinkUnknown.QueryInterface(ole.IID_IDispatch)
+	sinkUnknown.Release()
+	if err != nil {
+		return fmt.Errorf("SWbemSink QueryInterface error; %v", err)
+	}
+	defer sink.Release()
+
+	argType, elemType := checkElemType(out.Type().Elem())
+	var (
+		completed        bool
+		cancelRequested  bool
+		errFieldMismatch error
+		received         int
+	)
+	cancel := func() {
+		if !cancelRequested {
+			cancelRequested = true
+			_, _ = oleutil.CallMethod(sink, "Cancel")
+		}
+	}
+	events := newAsyncSink(func(dispID int32, args []*ole.VARIANT) {
+		switch {
+		case dispID == dispIDOnObjectReady && len(args) > 0:
+			obj := args[0].ToIDispatch()
+			if cancelRequested || obj == nil || q.isCancelled() {
+				return
+			}
+			if received++; conn.maxResults > 0 && received > conn.maxResults {
+				err = ErrResultLimitExceeded{Limit: conn.maxResults}
+				cancel()
+				return
+			}
+			ev := reflect.New(elemType)
+			if unmarshalErr := conn.Unmarshal(obj, ev.Interface()); unmarshalErr != nil {
+				if _, ok := unmarshalErr.(ErrFieldMismatch); !ok {
+					err = unmarshalErr
+					cancel()
+					return
+				}
+				errFieldMismatch = unmarshalErr
+			}
+			if argType != multiArgTypeStructPtr {
+				ev = ev.Elem()
+			}
+			_, _, _ = reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: out, Send: ev},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(q.cancelled)},
+			})
+		case dispID == dispIDOnCompleted:
+			completed = true
+			if len(args) == 0 || err != nil {
+				return
+			}
+			if hr, ok := args[0].Value().(int32); ok && hr != 0 {
+				if uint32(hr) == wbemECallCancelled && cancelRequested {
+					return
+				}
+				err = fmt.Errorf("ExecQueryAsync error; %w", newWMIError(ole.NewError(uintptr(uint32(hr)))))
+			}
+		}
+	})
+	defer events.release()
+
+	cookie, point, err := advise(sink, iidISWbemSinkEvents, events)
+	if err != nil {
+		return fmt.Errorf("SWbemSink Advise error; %v", err)
+	}
+	defer func() {
+		_ = point.Unadvise(cookie)
+		point.Release()
+	}()
+
+	// Create the thread message queue before exposing the thread to Cancel.
+	var msg winMsg
+	_, _, _ = procPeekMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0, 0) // PM_NOREMOVE
+	threadID, _, _ := procGetCurrentThreadID.Call()
+	q.threadID = uint32(threadID)
+
+	res, err := oleutil.CallMethod(conn.sWbemServices, "ExecQueryAsync", sink, query)
+	if err != nil {
+		return fmt.Errorf("ExecQueryAsync error; %w", newWMIError(err))
+	}
+	_ = res.Clear()
+	started()
+
+	for !completed {
+		ret, _, callErr := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+		switch int32(ret) {
+		case -1:
+			cancel()
+			return fmt.Errorf("GetMessage error; %v", callErr)
+		case 0: // WM_QUIT
+			cancel()
+			return errors.New("async query thread received WM_QUIT")
+		}
+		if msg.hwnd == 0 && msg.message == wmAsyncCancel {
+			cancel()
+			continue
+		}
+		_, _, _ = procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
+	}
+	if err == nil {
+		err = errFieldMismatch
+	}
+	return err
+}
+
+// winMsg is the MSG structure of the thread message queue.
+type winMsg struct {
+	hwnd    uintptr
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	pt      struct{ x, y int32 }
+	private uint32
+}
+
+// advise connects @events to the connection point @iid of @disp.
+func advise(disp *ole.IDispatch, iid *ole.GUID, events *asyncSink) (cookie uint32, point *ole.IConnectionPoint, err error) {
+	unknown, err := disp.QueryInterface(ole.IID_IConnectionPointContainer)
+	if err != nil {
+		return 0, nil, err
+	}
+	container := (*ole.IConnectionPointContainer)(unsafe.Pointer(unknown))
+	defer container.Release()
+	if err := container.FindConnectionPoint(iid, &point); err != nil {
+		return 0, nil, err
+	}
+	cookie, err = point.Advise((*ole.IUnknown)(unsafe.Pointer(events)))
+	if err != nil {
+		point.Release()
+		return 0, nil, err
+	}
+	return cookie, point, nil
+}
+
+// asyncSink is a minimal Go implementation of the `ISWbemSinkEvents`
+// dispinterface. Every event is passed to onEvent with the arguments in the
+// declaration order.
+type asyncSink struct {
+	vtbl    *asyncSinkVtbl // Should be the first field.
+	ref     int32
+	onEvent func(dispID int32, args []*ole.VARIANT)
+}
+
+type asyncSinkVtbl struct {
+	queryInterface   uintptr
+	addRef           uintptr
+	release          uintptr
+	getTypeInfoCount uintptr
+	getTypeInfo      uintptr
+	getIDsOfNames    uintptr
+	invoke           uintptr
+}
+
+// dispParams is the DISPPARAMS structure with the accessible arguments.
+type dispParams struct {
+	args           *ole.VARIANT
+	namedArgs      *int32
+	argsCount      uint32
+	namedArgsCount uint32
+}
+
+var (
+	// The callbacks are never released, so they are created once.
+	asyncSinkVtblOnce     sync.Once
+	asyncSinkVtblInstance *asyncSinkVtbl
+
+	// asyncSinks keeps the sinks referenced by COM alive.
+	asyncSinks sync.Map
+)
+
+func newAsyncSink(onEvent func(dispID int32, args []*ole.VARIANT)) *asyncSink {
+	asyncSinkVtblOnce.Do(func() {
+		asyncSinkVtblInstance = &asyncSinkVtbl{
+			queryInterface:   syscall.NewCallback(sinkQueryInterface),
+			addRef:           syscall.NewCallback(sinkAddRef),
+			release:          syscall.NewCallback(sinkRelease),
+			getTypeInfoCount: syscall.NewCallback(sinkGetTypeInfoCount),
+			getTypeInfo:      syscall.NewCallback(sinkGetTypeInfo),
+			getIDsOfNames:    syscall.NewCallback(sinkGetIDsOfNames),
+			invoke:           syscall.NewCallback(sinkInvoke),
+		}
+	})
+	s := &asyncSink{vtbl: asyncSinkVtblInstance, ref: 1, onEvent: onEvent}
+	asyncSinks.Store(s, struct{}{})
+	return s
+}
+
+func (s *asyncSink) release() int32 {
+	ref := atomic.AddInt32(&s.ref, -1)
+	if ref == 0 {
+		asyncSinks.Delete(s)
+	}
+	return ref
+}
+
+func sinkQueryInterface(this *asyncSink, iid *ole.GUID, ppv *unsafe.Pointer) uintptr {
+	if ole.IsEqualGUID(iid, ole.IID_IUnknown) || ole.IsEqualGUID(iid, ole.IID_IDispatch) ||
+		ole.IsEqualGUID(iid, iidISWbemSinkEvents) {
+		atomic.AddInt32(&this.ref, 1)
+		*ppv = unsafe.Pointer(this)
+		return sOK
+	}
+	*ppv = nil
+	return ole.E_NOINTERFACE
+}
+
+func sinkAddRef(this *asyncSink) uintptr {
+	return uintptr(atomic.AddInt32(&this.ref, 1))
+}
+
+func sinkRelease(this *asyncSink) uintptr {
+	return uintptr(this.release())
+}
+
+func sinkGetTypeInfoCount(this *asyncSink, count *uint32) uintptr {
+	*count = 0
+	return sOK
+}
+
+func sinkGetTypeInfo(this *asyncSink, index, lcid uintptr, info *uintptr) uintptr {
+	return ole.E_NOTIMPL
+}
+
+func sinkGetIDsOfNames(this *asyncSink, iid *ole.GUID, names, count, lcid uintptr, ids *int32) uintptr {
+	return ole.E_NOTIMPL
+}
+
+func sinkInvoke(this *asyncSink, dispID int32, iid *ole.GUID, lcid, flags uintptr, params *dispParams,
+	result *ole.VARIANT, excepInfo, argErr uintptr) (hr uintptr) {
+	// Panics can't cross the COM boundary.
+	defer func() {
+		if r := recover(); r != nil {
+			hr = ole.E_UNEXPECTED
+		}
+	}()
+
+	var args []*ole.VARIANT
+	if params != nil && params.argsCount > 0 {
+		raw := unsafe.Slice(params.args, params.argsCount)
+		args = make([]*ole.VARIANT, len(raw))
+		for i := range raw {
+			args[i] = &raw[len(raw)-1-i] // Arguments are passed in the reverse order.
+		}
+	}
+	this.onEvent(dispID, args)
+	return sOK
+}
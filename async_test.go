@@ -0,0 +1,55 @@
+// +build windows
+
+package wmi
+
+import (
+	"testing"
+)
+
+func TestClient_QueryAsync(t *testing.T) {
+	c := &Client{}
+	out := make(chan *Win32_Process)
+	q, err := c.QueryAsync("SELECT * FROM Win32_Process", out)
+	if err != nil {
+		t.Fatalf("Failed to start async query; %s", err)
+	}
+	var system *Win32_Process
+	for p := range out {
+		if p.ProcessId == 4 {
+			system = p
+		}
+	}
+	if err := q.Wait(); err != nil {
+		t.Errorf("Unexpected async query error; %s", err)
+	}
+	if system == nil || system.Name != "System" {
+		t.Errorf("Failed to find System (PID=4) process; got %+v", system)
+	}
+	q.Cancel() // Noop after completion.
+
+	// Cancellation.
+	services := make(chan struct{ Name string })
+	q, err = c.QueryAsync("SELECT Name FROM Win32_Service", services)
+	if err != nil {
+		t.Fatalf("Failed to start async query; %s", err)
+	}
+	<-services
+	q.Cancel()
+	for range services {
+	}
+	if err := q.Wait(); err != nil {
+		t.Errorf("Unexpected error of cancelled query; %s", err)
+	}
+
+	// Errors.
+	if _, err := c.QueryAsync("SELECT * FROM Win32_Process", make(chan int)); err != ErrInvalidEntityType {
+		t.Errorf("Unexpected error for invalid channel; %v", err)
+	}
+	q, err = c.QueryAsync("SELECT * FROM Win32_Unknown", make(chan Win32_Process))
+	if err == nil {
+		err = q.Wait()
+	}
+	if err == nil {
+		t.Errorf("Expected error for unknown class")
+	}
+}
@@ -2,7 +2,13 @@
 
 package wmi
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
 
 //Run all benchmarks (should run for at least 60s to get a stable number):
 //go test -run=NONE -bench=. -benchtime=120s
@@ -77,3 +83,111 @@ func BenchmarkQuery_SWbemConnection(b *testing.B) {
 		b.Fatalf("Close: %s", errClose)
 	}
 }
+
+// Repeated polls into the same slice reuse its backing array (and the element
+// values with ReuseElements), compare the allocations:
+// go test -run=NONE -bench=Query_Reuse -benchtime=1000x
+func BenchmarkQuery_Reuse(b *testing.B) {
+	s, err := ConnectSWbemServices()
+	if err != nil {
+		b.Fatalf("InitializeSWbemServices: %s", err)
+	}
+	defer s.Close()
+
+	q := CreateQuery(&[]Win32_Process{}, "")
+	for _, mode := range []string{"Fresh", "Slice", "Elements"} {
+		b.Run(mode, func(b *testing.B) {
+			s.ReuseElements = mode == "Elements"
+			defer func() { s.ReuseElements = false }()
+			b.ReportAllocs()
+			var dst []Win32_Process
+			for n := 0; n < b.N; n++ {
+				if mode == "Fresh" {
+					dst = nil
+				}
+				if err := s.Query(q, &dst); err != nil {
+					b.Fatalf("Query%d: %s", n, err)
+				}
+			}
+		})
+	}
+}
+
+// Peak memory benchmarks report the peak working set of the process, so run
+// them separately:
+// go test -run=NONE -bench=Query_PeakMemory/Default -benchtime=20x
+// go test -run=NONE -bench=Query_PeakMemory/ForwardOnly -benchtime=20x
+func BenchmarkQuery_PeakMemory(b *testing.B) {
+	for _, opts := range []struct {
+		name string
+		QueryOptions
+	}{
+		{"Default", QueryOptions{}},
+		{"ForwardOnly", QueryOptions{ForwardOnly: true}},
+	} {
+		b.Run(opts.name, func(b *testing.B) {
+			s, err := ConnectSWbemServices()
+			if err != nil {
+				b.Fatalf("InitializeSWbemServices: %s", err)
+			}
+			defer s.Close()
+
+			for n := 0; n < b.N; n++ {
+				var dst []struct {
+					Name string
+				}
+				res, err := s.QueryWith(context.Background(), `SELECT Name FROM CIM_DataFile WHERE Drive = 'C:' AND Path = '\\Windows\\System32\\'`, &dst, opts.QueryOptions)
+				if err != nil {
+					b.Fatalf("Query%d: %s", n, err)
+				}
+				if res.Rows < 1 {
+					b.Fatalf("Query%d: no results found for CIM_DataFile", n)
+				}
+			}
+			b.StopTimer()
+
+			var self []Win32_Process
+			if err := s.Query(fmt.Sprintf("SELECT * FROM Win32_Process WHERE ProcessId = %d", os.Getpid()), &self); err != nil {
+				b.Fatalf("Failed to query own process; %s", err)
+			}
+			b.ReportMetric(float64(self[0].PeakWorkingSetSize)/1024, "peak-MB")
+		})
+	}
+}
+
+// Compares unmarshalling with the cached structure fields and re-parsing the
+// tags on every call:
+// go test -run=NONE -bench=Decoder_Unmarshal -benchtime=10000x
+func BenchmarkDecoder_Unmarshal(b *testing.B) {
+	s, err := ConnectSWbemServices()
+	if err != nil {
+		b.Fatalf("InitializeSWbemServices: %s", err)
+	}
+	defer s.Close()
+
+	v, err := s.Dereference(`Win32_Process.Handle="4"`)
+	if err != nil {
+		b.Fatalf("Failed to get System process; %s", err)
+	}
+	defer v.Clear()
+	obj := v.ToIDispatch()
+
+	for _, cached := range []bool{true, false} {
+		name := "Cached"
+		if !cached {
+			name = "Uncached"
+		}
+		b.Run(name, func(b *testing.B) {
+			var dst Win32_Process
+			typ := reflect.TypeOf(dst)
+			for n := 0; n < b.N; n++ {
+				if !cached {
+					structFieldsCache.Delete(typ)
+				}
+				if err := s.Unmarshal(obj, &dst); err != nil {
+					b.Fatalf("Unmarshal%d: %s", n, err)
+				}
+			}
+		})
+	}
+}
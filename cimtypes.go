@@ -0,0 +1,72 @@
+// +build windows
+
+package wmi
+
+import (
+	"reflect"
+	"time"
+)
+
+// CIMType is a type of WMI property value.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/wbemdisp/ne-wbemdisp-wbemcimtypeenum
+type CIMType int
+
+// CIM types of WMI property values.
+const (
+	CIMTypeSint16    CIMType = 2
+	CIMTypeSint32    CIMType = 3
+	CIMTypeReal32    CIMType = 4
+	CIMTypeReal64    CIMType = 5
+	CIMTypeString    CIMType = 8
+	CIMTypeBoolean   CIMType = 11
+	CIMTypeObject    CIMType = 13
+	CIMTypeSint8     CIMType = 16
+	CIMTypeUint8     CIMType = 17
+	CIMTypeUint16    CIMType = 18
+	CIMTypeUint32    CIMType = 19
+	CIMTypeSint64    CIMType = 20
+	CIMTypeUint64    CIMType = 21
+	CIMTypeDatetime  CIMType = 101
+	CIMTypeReference CIMType = 102
+	CIMTypeChar16    CIMType = 103
+)
+
+var cimGoTypes = map[CIMType]reflect.Type{
+	CIMTypeSint8:     reflect.TypeOf(int8(0)),
+	CIMTypeSint16:    reflect.TypeOf(int16(0)),
+	CIMTypeSint32:    reflect.TypeOf(int32(0)),
+	CIMTypeSint64:    reflect.TypeOf(int64(0)),
+	CIMTypeUint8:     reflect.TypeOf(uint8(0)),
+	CIMTypeUint16:    reflect.TypeOf(uint16(0)),
+	CIMTypeUint32:    reflect.TypeOf(uint32(0)),
+	CIMTypeUint64:    reflect.TypeOf(uint64(0)),
+	CIMTypeReal32:    reflect.TypeOf(float32(0)),
+	CIMTypeReal64:    reflect.TypeOf(float64(0)),
+	CIMTypeBoolean:   reflect.TypeOf(false),
+	CIMTypeString:    reflect.TypeOf(""),
+	CIMTypeReference: reflect.TypeOf(""),
+	CIMTypeChar16:    reflect.TypeOf(uint16(0)),
+	CIMTypeDatetime:  reflect.TypeOf(time.Time{}),
+}
+
+// GoType returns a Go type the values of CIM type are converted into or nil
+// for embedded objects.
+func (t CIMType) GoType() reflect.Type {
+	return cimGoTypes[t]
+}
+
+// convert converts the simple VARIANT value @v into the Go type of the CIM
+// type. Values that can't be converted are returned as is (e.g. CIM_DATETIME
+// intervals are left as strings).
+func (t CIMType) convert(v interface{}) interface{} {
+	goType := t.GoType()
+	if goType == nil || v == nil {
+		return v
+	}
+	dst := reflect.New(goType).Elem()
+	if err := unmarshalSimpleValue(dst, v); err != nil {
+		return v
+	}
+	return dst.Interface()
+}
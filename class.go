@@ -0,0 +1,354 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+)
+
+// wbemFlagUseAmendedQualifiers makes WMI return the localized qualifiers.
+const wbemFlagUseAmendedQualifiers = 0x20000
+
+// PropertyInfo describes the WMI class property.
+type PropertyInfo struct {
+	Name    string
+	CIMType CIMType
+	IsArray bool
+	IsKey   bool // Has `key` qualifier, so identifies the instance.
+
+	// Qualifiers holds all the property qualifiers keyed by name, e.g.
+	// `CIMTYPE` ("uint32", "ref:Win32_Process"), `Units` or `Description`.
+	// Array qualifiers are returned as []interface{}.
+	Qualifiers map[string]interface{}
+}
+
+// ClassProperties returns the descriptions of all the @className class
+// properties in the order WMI returns them for the class definition. That is
+// enough to generate the Go structure for the class, see `DescribeClass` if
+// only the names are needed.
+//
+// The class is retrieved with WBEM_FLAG_USE_AMENDED_QUALIFIERS, so the
+// localized qualifiers (e.g. `Description`) are returned too.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemqualifier
+func (s *SWbemServicesConnection) ClassProperties(className string) (props []PropertyInfo, err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return nil, ErrConnectionClosed
+	}
+	s.Unlock()
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	if className == "" {
+		return nil, ErrNoObjectPath
+	}
+	classRaw, err := oleutil.CallMethod(s.sWbemServices, "Get", className, wbemFlagUseAmendedQualifiers)
+	if err != nil {
+		return nil, newWMIError(err)
+	}
+	defer func() {
+		if clErr := classRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	propsRaw, err := oleutil.GetProperty(classRaw.ToIDispatch(), "Properties_")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := propsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	err = forEach(propsRaw.ToIDispatch(), func(prop *ole.IDispatch) error {
+		var p PropertyInfo
+		name, err := oleutil.GetProperty(prop, "Name")
+		if err != nil {
+			return err
+		}
+		p.Name = name.ToString()
+		if err := name.Clear(); err != nil {
+			return err
+		}
+		cimType, err := oleInt64(prop, "CIMType")
+		if err != nil {
+			return err
+		}
+		p.CIMType = CIMType(cimType)
+		isArray, err := oleutil.GetProperty(prop, "IsArray")
+		if err != nil {
+			return err
+		}
+		p.IsArray = isArray.Value() == true
+
+		if p.Qualifiers, err = qualifiers(prop); err != nil {
+			return fmt.Errorf("can't get qualifiers of %q; %v", p.Name, err)
+		}
+		for name, value := range p.Qualifiers {
+			if strings.EqualFold(name, "key") && value == true {
+				p.IsKey = true
+			}
+		}
+		props = append(props, p)
+		return nil
+	})
+	return props, err
+}
+
+// qualifierSource is implemented by the Dereferencer which can provide the
+// amended qualifiers of the class properties, see `Decoder.propertyQualifier`.
+type qualifierSource interface {
+	propertyQualifier(className, property, qualifier string) (interface{}, error)
+}
+
+// propertyQualifier returns the value of the amended @qualifier of the
+// @className class @property. The qualifiers of all the class properties are
+// fetched by `ClassProperties` once and cached by the connection.
+func (s *SWbemServicesConnection) propertyQualifier(className, property, qualifier string) (interface{}, error) {
+	key := strings.ToLower(className)
+	cached, ok := s.classQualifiers.Load(key)
+	if !ok {
+		props, err := s.ClassProperties(className)
+		if err != nil {
+			return nil, err
+		}
+		classQualifiers := make(map[string]map[string]interface{}, len(props))
+		for _, p := range props {
+			classQualifiers[strings.ToLower(p.Name)] = p.Qualifiers
+		}
+		cached, _ = s.classQualifiers.LoadOrStore(key, classQualifiers)
+	}
+	propQualifiers, ok := cached.(map[string]map[string]interface{})[strings.ToLower(property)]
+	if !ok {
+		return nil, fmt.Errorf("class %q has no property %q", className, property)
+	}
+	value, ok := propQualifiers[qualifier]
+	if !ok {
+		return nil, fmt.Errorf("class %q property %q has no %s qualifier", className, property, qualifier)
+	}
+	return value, nil
+}
+
+// qualifiers returns all the qualifiers of the @obj (SWbemObject, SWbemProperty
+// or SWbemMethod) keyed by name.
+func qualifiers(obj *ole.IDispatch) (values map[string]interface{}, err error) {
+	qualifiersRaw, err := oleutil.GetProperty(obj, "Qualifiers_")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := qualifiersRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	values = make(map[string]interface{})
+	err = forEach(qualifiersRaw.ToIDispatch(), func(q *ole.IDispatch) (err error) {
+		name, err := oleutil.GetProperty(q, "Name")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if clErr := name.Clear(); clErr != nil {
+				err = multierror.Append(err, clErr)
+			}
+		}()
+		v, err := oleutil.GetProperty(q, "Value")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if clErr := v.Clear(); clErr != nil {
+				err = multierror.Append(err, clErr)
+			}
+		}()
+		value, err := variantValue(v, 0)
+		if err != nil {
+			return err
+		}
+		values[name.ToString()] = value
+		return nil
+	})
+	return values, err
+}
+
+// ValidateStruct checks that every field of the @dst struct (or a pointer to
+// struct) could be unmarshalled from the @className objects: the property
+// exists and its CIM type is assignable to the field type, e.g. to catch the
+// typos that otherwise lead to "no result field" errors or zero values
+// (with `Decoder.AllowMissingFields`). All the mismatches are returned as
+// a combined error, e.g.
+//   field Foo: no such property "Foo"
+//   field Bar: CIMTYPE uint16 not assignable to string
+//
+// Fields are resolved in the same way as in `Decoder.Unmarshal`. Skipped and
+// composite fields, system properties (like `__PATH`), method parameters and
+// the fields implementing Unmarshaler are not checked. Only the existence is
+// checked for the fields with conversion tag options (e.g. `ref` or `enum`).
+func (s *SWbemServicesConnection) ValidateStruct(className string, dst interface{}) error {
+	t := reflect.TypeOf(dst)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return ErrInvalidEntityType
+	}
+	props, err := s.ClassProperties(className)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]PropertyInfo, len(props))
+	for _, p := range props {
+		if s.CaseSensitive {
+			byName[p.Name] = p
+		} else {
+			byName[strings.ToLower(p.Name)] = p
+		}
+	}
+	lookup := func(name string) (PropertyInfo, bool) {
+		if !s.CaseSensitive {
+			name = strings.ToLower(name)
+		}
+		p, ok := byName[name]
+		return p, ok
+	}
+
+	var result error
+	for _, field := range cachedFields(t) {
+		if field.skipped() {
+			continue
+		}
+		if _, ok := s.composites[field.Name]; ok {
+			continue
+		}
+		if _, ok := field.options.Value("paramid"); ok || strings.HasPrefix(field.name, "__") {
+			continue
+		}
+		prop, ok := lookup(field.name)
+		if alts, hasAlts := field.options.Value("alt"); !ok && hasAlts {
+			for _, alt := range strings.Split(alts, "|") {
+				if prop, ok = lookup(alt); ok {
+					break
+				}
+			}
+		}
+		if !ok {
+			result = multierror.Append(result, fmt.Errorf("field %s: no such property %q", field.Name, field.name))
+			continue
+		}
+		if hasConversionOption(field.options) || isCustomUnmarshaler(field.Type) {
+			continue
+		}
+		if !isAssignableCIMType(prop, field.Type) {
+			cimType, _ := prop.Qualifiers["CIMTYPE"].(string)
+			if cimType == "" {
+				cimType = fmt.Sprint(int(prop.CIMType))
+			}
+			if prop.IsArray {
+				cimType += " array"
+			}
+			result = multierror.Append(result, fmt.Errorf("field %s: CIMTYPE %s not assignable to %s", field.Name, cimType, field.Type))
+		}
+	}
+	return result
+}
+
+var (
+	unmarshalerType        = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	contextUnmarshalerType = reflect.TypeOf((*ContextUnmarshaler)(nil)).Elem()
+)
+
+// isCustomUnmarshaler checks if the pointer to @t implements Unmarshaler or
+// ContextUnmarshaler.
+func isCustomUnmarshaler(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return pt.Implements(unmarshalerType) || pt.Implements(contextUnmarshalerType)
+}
+
+// hasConversionOption checks if the field tag @options change the way the
+// property value is converted, so the CIM type can't be checked.
+func hasConversionOption(options tagOptions) bool {
+	for _, opt := range []string{"ref", "intbool", "flags", "enum", "unix", "unixms", "unixns", "utf16"} {
+		if options.Contains(opt) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAssignableCIMType checks if the values of the @prop could be
+// unmarshalled into the field of type @t.
+func isAssignableCIMType(prop PropertyInfo, t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr && !prop.IsArray {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		return true
+	}
+	if prop.IsArray {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Map:
+			t = t.Elem() // Sparse arrays could be unmarshalled into maps.
+		case reflect.String:
+			return prop.CIMType == CIMTypeUint16 // UTF-16 strings.
+		case reflect.Ptr:
+			if t.Elem().Kind() == reflect.String {
+				return prop.CIMType == CIMTypeUint16
+			}
+			if t.Elem().Kind() != reflect.Slice {
+				return false
+			}
+			t = t.Elem().Elem()
+		default:
+			return false
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Interface {
+			return true
+		}
+	} else if t.Kind() == reflect.Slice {
+		return prop.CIMType == CIMTypeObject && isStructSlice(t) // Objects collections.
+	}
+
+	kind := t.Kind()
+	isInt := kind >= reflect.Int && kind <= reflect.Uintptr
+	isFloat := kind == reflect.Float32 || kind == reflect.Float64
+	switch prop.CIMType {
+	case CIMTypeSint8, CIMTypeSint16, CIMTypeSint32, CIMTypeUint8, CIMTypeUint16, CIMTypeUint32:
+		return isInt || isFloat || kind == reflect.Bool
+	case CIMTypeChar16:
+		return isInt || isFloat || kind == reflect.Bool || (kind == reflect.String && !prop.IsArray)
+	case CIMTypeSint64, CIMTypeUint64: // Passed as strings.
+		return isInt || isFloat || kind == reflect.String
+	case CIMTypeReal32, CIMTypeReal64:
+		return isFloat
+	case CIMTypeBoolean:
+		return kind == reflect.Bool || isInt
+	case CIMTypeString:
+		return kind == reflect.String || isInt || isFloat || t == timeType
+	case CIMTypeDatetime:
+		return t == timeType || t == durationType || kind == reflect.String
+	case CIMTypeReference:
+		return kind == reflect.String
+	case CIMTypeObject:
+		return kind == reflect.Struct || t == objectMapType
+	}
+	return false
+}
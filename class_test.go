@@ -0,0 +1,80 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+func TestClient_ClassProperties(t *testing.T) {
+	props, err := DefaultClient.ClassProperties("Win32_Process")
+	if err != nil {
+		t.Fatalf("Failed to get Win32_Process properties; %s", err)
+	}
+	byName := make(map[string]PropertyInfo, len(props))
+	for _, p := range props {
+		byName[p.Name] = p
+	}
+
+	handle, ok := byName["Handle"]
+	if !ok || !handle.IsKey || handle.CIMType != CIMTypeString {
+		t.Errorf("Unexpected Handle property; %+v", handle)
+	}
+	if cimType := handle.Qualifiers["CIMTYPE"]; cimType != "string" {
+		t.Errorf("Unexpected CIMTYPE qualifier of Handle; %v", cimType)
+	}
+	if p := byName["ProcessId"]; p.IsKey || p.CIMType != CIMTypeUint32 || p.IsArray {
+		t.Errorf("Unexpected ProcessId property; %+v", p)
+	}
+	if p := byName["WorkingSetSize"]; p.Qualifiers["Units"] != "bytes" {
+		t.Errorf("Unexpected Units qualifier of WorkingSetSize; %v", p.Qualifiers["Units"])
+	}
+	if p := byName["Name"]; p.Qualifiers["Description"] == nil {
+		t.Errorf("Amended Description qualifier isn't returned; %v", p.Qualifiers)
+	}
+
+	if _, err := DefaultClient.ClassProperties("Win32_NoSuchClass"); !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrInvalidClass) {
+		t.Errorf("Unexpected error for unknown class; %v", err)
+	}
+}
+
+func TestClient_ValidateStruct(t *testing.T) {
+	if err := DefaultClient.ValidateStruct("Win32_Process", &Win32_Process{}); err != nil {
+		t.Errorf("Unexpected Win32_Process validation error; %s", err)
+	}
+
+	var invalid struct {
+		Name          string
+		ProcessID     uint32 // Case-insensitive.
+		Nmae          string
+		Priority      string
+		CreationDate  CIMDateTime
+		Caption       *string  `wmi:",alt=Title"`
+		CommandLines  []string `wmi:"CommandLine"`
+		ParentPath    string   `wmi:"__PATH"`
+		ignored       int
+		WorkingSetMiB uint64 `wmi:"-"`
+	}
+	err := DefaultClient.ValidateStruct("Win32_Process", invalid)
+	var mErr *multierror.Error
+	if !errors.As(err, &mErr) || len(mErr.Errors) != 3 {
+		t.Fatalf("Unexpected validation error; %v", err)
+	}
+	expected := []string{
+		`field Nmae: no such property "Nmae"`,
+		`field Priority: CIMTYPE uint32 not assignable to string`,
+		`field CommandLines: CIMTYPE string not assignable to []string`,
+	}
+	for i, e := range mErr.Errors {
+		if e.Error() != expected[i] {
+			t.Errorf("Unexpected validation error #%d; got %q, expected %q", i, e, expected[i])
+		}
+	}
+
+	if err := DefaultClient.ValidateStruct("Win32_Process", 42); err != ErrInvalidEntityType {
+		t.Errorf("Unexpected error for non-struct; %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+// +build windows
+
+package wmi
+
+import "strings"
+
+// SplitCommandLine splits the command line (e.g. `Win32_Process.CommandLine`)
+// into arguments using the CommandLineToArgvW rules:
+//   - the first argument (program name) is either everything up to the
+//     first whitespace or a quoted string, backslashes aren't special there;
+//   - arguments are separated by spaces and tabs outside of quotes;
+//   - 2n backslashes followed by a quote produce n backslashes and the quote
+//     starts or ends a quoted part;
+//   - 2n+1 backslashes followed by a quote produce n backslashes and
+//     a literal quote;
+//   - backslashes not followed by a quote are literal;
+//   - two quotes inside a quoted part produce a literal quote and end the
+//     quoted part.
+//
+// Empty command line results in nil.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/shellapi/nf-shellapi-commandlinetoargvw
+func SplitCommandLine(cmd string) []string {
+	if cmd == "" {
+		return nil
+	}
+
+	// The first argument is the program name which follows special rules.
+	var args []string
+	if cmd[0] == '"' {
+		end := strings.IndexByte(cmd[1:], '"')
+		if end == -1 {
+			return []string{cmd[1:]}
+		}
+		args = append(args, cmd[1:end+1])
+		cmd = cmd[end+2:]
+	} else {
+		end := strings.IndexAny(cmd, " \t")
+		if end == -1 {
+			return []string{cmd}
+		}
+		args = append(args, cmd[:end])
+		cmd = cmd[end:]
+	}
+	cmd = strings.TrimLeft(cmd, " \t")
+
+	var arg strings.Builder
+	backslashes := 0 // Number of consecutive backslashes.
+	quotes := 0      // Quotes state; 1 means we are inside a quoted part.
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case (c == ' ' || c == '\t') && quotes == 0:
+			args = append(args, arg.String())
+			arg.Reset()
+			backslashes = 0
+			for i+1 < len(cmd) && (cmd[i+1] == ' ' || cmd[i+1] == '\t') {
+				i++
+			}
+		case c == '\\':
+			arg.WriteByte(c)
+			backslashes++
+		case c == '"':
+			s := arg.String()[:arg.Len()-backslashes/2-backslashes%2]
+			arg.Reset()
+			arg.WriteString(s)
+			if backslashes%2 == 0 {
+				quotes++
+			} else {
+				arg.WriteByte('"')
+			}
+			backslashes = 0
+			// Count consecutive quotes; every third one is a literal.
+			for i+1 < len(cmd) && cmd[i+1] == '"' {
+				i++
+				if quotes++; quotes == 3 {
+					arg.WriteByte('"')
+					quotes = 0
+				}
+			}
+			if quotes == 2 {
+				quotes = 0
+			}
+		default:
+			arg.WriteByte(c)
+			backslashes = 0
+		}
+	}
+	if cmd != "" && !(quotes == 0 && isSpace(cmd[len(cmd)-1])) {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
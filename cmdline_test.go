@@ -0,0 +1,37 @@
+// +build windows
+
+package wmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		expected []string
+	}{
+		{``, nil},
+		{`prog`, []string{`prog`}},
+		{`prog  `, []string{`prog`}},
+		{`"C:\Program Files\prog.exe" -a`, []string{`C:\Program Files\prog.exe`, `-a`}},
+		{`C:\dir\"prog.exe a`, []string{`C:\dir\"prog.exe`, `a`}},
+		{`"C:\dir\" a`, []string{`C:\dir\`, `a`}},
+		{`prog "a b c" d e`, []string{`prog`, `a b c`, `d`, `e`}},
+		{`prog "ab\"c" "\\" d`, []string{`prog`, `ab"c`, `\`, `d`}},
+		{`prog a\\\b d"e f"g h`, []string{`prog`, `a\\\b`, `de fg`, `h`}},
+		{`prog a\\\"b c d`, []string{`prog`, `a\"b`, `c`, `d`}},
+		{`prog a\\\\"b c" d e`, []string{`prog`, `a\\b c`, `d`, `e`}},
+		{`prog a"b"" c d`, []string{`prog`, `ab"`, `c`, `d`}},
+		{`prog "" "a"`, []string{`prog`, ``, `a`}},
+		{"prog\ta\t\t b", []string{`prog`, `a`, `b`}},
+		{`prog "unterminated quote`, []string{`prog`, `unterminated quote`}},
+		{`prog """a""" b`, []string{`prog`, `"a"`, `b`}},
+	}
+	for _, tt := range tests {
+		if args := SplitCommandLine(tt.cmd); !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("Unexpected split of %s; got %q, expected %q", tt.cmd, args, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+)
+
+// CompositeFunc derives a field value from the values of several properties.
+// @values are in the order of properties passed to `Decoder.Composite`, see
+// `SWbemServicesConnection.QueryTable` for the details on their types.
+type CompositeFunc func(values []interface{}) (interface{}, error)
+
+type compositeField struct {
+	props []string
+	fn    CompositeFunc
+}
+
+// Composite registers a handler for the structure fields named @fieldName.
+// Instead of the regular decoding, values of @props properties are fetched
+// and passed into @fn. The result of @fn is assigned to the field (or
+// converted into the field type if possible), nil result leaves the field
+// zero. E.g.
+//   var d Decoder
+//   d.Composite("Title", []string{"Name", "ProcessId"}, func(v []interface{}) (interface{}, error) {
+//       return fmt.Sprintf("%s (%d)", v[0], v[1]), nil
+//   })
+//
+// The handler is used for the fields with the given name of any structure
+// decoded by the Decoder (including the embedded ones).
+func (d *Decoder) Composite(fieldName string, props []string, fn CompositeFunc) {
+	composites := make(map[string]compositeField, len(d.composites)+1)
+	for name, c := range d.composites {
+		composites[name] = c
+	}
+	composites[fieldName] = compositeField{props: props, fn: fn}
+	// Copy the map to not modify the handlers of Decoder copies.
+	d.composites = composites
+}
+
+// unmarshal fills @f with the composite value of @src properties.
+func (c compositeField) unmarshal(src *ole.IDispatch, f reflect.Value) error {
+	values := make([]interface{}, len(c.props))
+	for i, name := range c.props {
+		v, err := propertyValue(src, name)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+
+	result, err := c.fn(values)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		f.Set(reflect.Zero(f.Type()))
+		return nil
+	}
+	v := reflect.ValueOf(result)
+	switch {
+	case v.Type().AssignableTo(f.Type()):
+		f.Set(v)
+	case v.Type().ConvertibleTo(f.Type()):
+		f.Set(v.Convert(f.Type()))
+	default:
+		return fmt.Errorf("can't assign composite value of type %s", v.Type())
+	}
+	return nil
+}
+
+// propertyValue returns the converted value of @obj property @name. See
+// `SWbemServicesConnection.QueryTable` for conversion details.
+func propertyValue(obj *ole.IDispatch, name string) (value interface{}, err error) {
+	propsRaw, err := oleutil.GetProperty(obj, "Properties_")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := propsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	propRaw, err := oleutil.CallMethod(propsRaw.ToIDispatch(), "Item", name)
+	if err != nil {
+		return nil, fmt.Errorf("no result field %q", name)
+	}
+	defer func() {
+		if clErr := propRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	return sWbemPropertyValue(propRaw.ToIDispatch())
+}
@@ -0,0 +1,71 @@
+// +build windows
+
+package wmi
+
+// ConnectOptions is a typed form of `SWbemLocator.ConnectServer` arguments.
+// All the fields are optional, empty fields are omitted.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemlocator-connectserver
+type ConnectOptions struct {
+	Server    string // Computer name or IP address, local computer if empty.
+	Namespace string // E.g. `root\cimv2`, the default namespace if empty.
+	User      string // E.g. `DOMAIN\user`, current user if empty.
+	Password  string // Password of the User.
+	Authority string // E.g. `ntlmdomain:DOMAIN` or `kerberos:DOMAIN\server`.
+	Locale    string // E.g. `MS_409` for English strings, the server default if empty.
+
+	// Security levels of the established connection. ImpersonationImpersonate
+	// and AuthenticationPkt are used if not set.
+	ImpersonationLevel  ImpersonationLevel
+	AuthenticationLevel AuthenticationLevel
+}
+
+// ImpersonationLevel is a COM impersonation level of the connection.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/setting-the-default-process-security-level-using-vbscript
+type ImpersonationLevel int32
+
+// WbemImpersonationLevelEnum values.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/wbemdisp/ne-wbemdisp-wbemimpersonationlevelenum
+const (
+	ImpersonationAnonymous   ImpersonationLevel = 1
+	ImpersonationIdentify    ImpersonationLevel = 2
+	ImpersonationImpersonate ImpersonationLevel = 3 // RPC_C_IMP_LEVEL_IMPERSONATE
+	ImpersonationDelegate    ImpersonationLevel = 4
+)
+
+// AuthenticationLevel is a COM authentication level of the connection.
+type AuthenticationLevel int32
+
+// WbemAuthenticationLevelEnum values.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/wbemdisp/ne-wbemdisp-wbemauthenticationlevelenum
+const (
+	AuthenticationNone         AuthenticationLevel = 1
+	AuthenticationConnect      AuthenticationLevel = 2
+	AuthenticationCall         AuthenticationLevel = 3
+	AuthenticationPkt          AuthenticationLevel = 4 // RPC_C_AUTHN_LEVEL_PKT
+	AuthenticationPktIntegrity AuthenticationLevel = 5
+	AuthenticationPktPrivacy   AuthenticationLevel = 6
+)
+
+// ConnectServerArgs returns the options as a `SWbemLocator.ConnectServer`
+// args list which could be passed as `connectServerArgs` to any function of
+// the package. Empty options are passed as nil, trailing ones are omitted.
+func (o ConnectOptions) ConnectServerArgs() []interface{} {
+	// Order of SWbemLocator.ConnectServer parameters.
+	values := []string{o.Server, o.Namespace, o.User, o.Password, o.Locale, o.Authority}
+
+	var args []interface{}
+	for i, v := range values {
+		if v == "" {
+			continue
+		}
+		for len(args) < i {
+			args = append(args, nil)
+		}
+		args = append(args, v)
+	}
+	return args
+}
@@ -0,0 +1,70 @@
+// +build windows
+
+package wmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConnectOptions_ConnectServerArgs(t *testing.T) {
+	tests := []struct {
+		opts     ConnectOptions
+		expected []interface{}
+	}{
+		{ConnectOptions{}, nil},
+		{ConnectOptions{Server: "host"}, []interface{}{"host"}},
+		{ConnectOptions{Namespace: `root\default`}, []interface{}{nil, `root\default`}},
+		{
+			ConnectOptions{Server: "host", User: `DOMAIN\user`, Password: "pass", Authority: "ntlmdomain:DOMAIN"},
+			[]interface{}{"host", nil, `DOMAIN\user`, "pass", nil, "ntlmdomain:DOMAIN"},
+		},
+		{ConnectOptions{Locale: "MS_409"}, []interface{}{nil, nil, nil, nil, "MS_409"}},
+	}
+	for _, tt := range tests {
+		if args := tt.opts.ConnectServerArgs(); !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("Unexpected args for %+v; got %#v, expected %#v", tt.opts, args, tt.expected)
+		}
+	}
+}
+
+func TestClient_ConnectOptions(t *testing.T) {
+	c := &Client{
+		ConnectOptions: &ConnectOptions{Server: ".", Locale: "MS_409"},
+		Namespace:      `root\default`,
+	}
+	var providers []struct{ Name string }
+	if err := c.Query("SELECT Name FROM __Win32Provider", &providers); err != nil {
+		t.Fatalf("Failed to query with ConnectOptions; %s", err)
+	}
+	if len(providers) == 0 {
+		t.Errorf("Expected providers of root\\default namespace")
+	}
+
+	// Locale is forwarded, so the unknown one is rejected by WMI.
+	c.ConnectOptions.Locale = "MS_XYZ"
+	if err := c.Query("SELECT Name FROM __Win32Provider", &providers); err == nil {
+		t.Errorf("Expected error for unknown locale")
+	}
+}
+
+func TestSWbemServicesConnection_SetSecurity(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("Failed to connect; %s", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetSecurity(ImpersonationImpersonate, AuthenticationPktPrivacy); err != nil {
+		t.Fatalf("Failed to set security; %s", err)
+	}
+	var processes []Win32_Process
+	if err := conn.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Errorf("Failed to query with packet privacy; %s", err)
+	}
+
+	_ = conn.Close()
+	if err := conn.SetSecurity(0, 0); err != ErrConnectionClosed {
+		t.Errorf("Unexpected error for closed connection; %v", err)
+	}
+}
@@ -3,10 +3,16 @@
 package wmi
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+	"unsafe"
 
 	"github.com/bi-zone/go-ole"
 	"github.com/bi-zone/go-ole/oleutil"
@@ -29,6 +35,13 @@ type SWbemServicesConnection struct {
 	Decoder
 
 	sWbemServices *ole.IDispatch
+	rowTimeout    time.Duration // Default QueryOptions.RowTimeout, see `Client.Timeout`.
+	maxResults    int           // See `Client.MaxResults`.
+	retryPolicy   *RetryPolicy  // See `Client.RetryPolicy`.
+	logger        Logger        // See `Client.Logger`.
+	observer      QueryObserver // See `Client.Observer`.
+
+	classQualifiers sync.Map // Class name -> amended property qualifiers, see `propertyQualifier`.
 }
 
 // ConnectSWbemServices creates SWbemServices connection to the server defined
@@ -52,8 +65,62 @@ func ConnectSWbemServices(connectServerArgs ...interface{}) (conn *SWbemServices
 // ConnectSWbemServices creates SWbemServices connection to the server defined
 // by @args.
 //
+// Errors caused by disabled or failed WMI service wrap ErrWMIUnavailable.
+// Authentication failures wrap ErrAccessDenied and failures to reach the
+// remote server wrap ErrHostUnreachable.
+// If the namespace passed in @args doesn't exist ErrNamespaceNotFound is
+// returned.
+//
 // Ref: https://docs.microsoft.com/en-us/windows/desktop/wmisdk/swbemlocator-connectserver
 func (s *SWbemServices) ConnectServer(args ...interface{}) (c *SWbemServicesConnection, err error) {
+	c, err = s.connectServer(args...)
+	if err != nil && len(args) > 1 {
+		if code, _ := oleErrorCode(err); code == wbemEInvalidNamespace {
+			if namespace, ok := args[1].(string); ok {
+				return nil, s.namespaceNotFound(args, namespace)
+			}
+		}
+	}
+	return c, err
+}
+
+// namespaceNotFound builds ErrNamespaceNotFound for the @namespace finding
+// its closest existing parent using the same connection @args.
+func (s *SWbemServices) namespaceNotFound(args []interface{}, namespace string) error {
+	notFound := ErrNamespaceNotFound{Namespace: namespace}
+	parentArgs := append([]interface{}{}, args...)
+	parent := namespace
+	for {
+		idx := strings.LastIndexAny(parent, `\/`)
+		if idx <= 0 {
+			return notFound
+		}
+		parent = parent[:idx]
+		parentArgs[1] = parent
+
+		conn, err := s.connectServer(parentArgs...)
+		if err != nil {
+			continue
+		}
+		var children []struct {
+			Name string
+		}
+		err = conn.Query("SELECT Name FROM __NAMESPACE", &children)
+		_ = conn.Close()
+		if err != nil {
+			return notFound
+		}
+
+		notFound.Existing = parent
+		for _, c := range children {
+			notFound.Available = append(notFound.Available, c.Name)
+		}
+		sort.Strings(notFound.Available)
+		return notFound
+	}
+}
+
+func (s *SWbemServices) connectServer(args ...interface{}) (c *SWbemServicesConnection, err error) {
 	//  Be aware of reflections and COM usage.
 	defer func() {
 		if r := recover(); r != nil {
@@ -72,7 +139,16 @@ func (s *SWbemServices) ConnectServer(args ...interface{}) (c *SWbemServicesConn
 
 	serviceRaw, err := oleutil.CallMethod(s.sWbemLocator, "ConnectServer", args...)
 	if err != nil {
-		return nil, fmt.Errorf("SWbemServices ConnectServer error; %v", err)
+		if isUnavailableError(err) {
+			return nil, fmt.Errorf("%w; SWbemServices ConnectServer error; %v", ErrWMIUnavailable, err)
+		}
+		if isAccessDeniedError(err) {
+			return nil, fmt.Errorf("%w; SWbemServices ConnectServer error; %v", ErrAccessDenied, err)
+		}
+		if isUnreachableError(err) {
+			return nil, fmt.Errorf("%w; SWbemServices ConnectServer error; %v", ErrHostUnreachable, err)
+		}
+		return nil, fmt.Errorf("SWbemServices ConnectServer error; %w", err)
 	}
 	service := serviceRaw.ToIDispatch()
 	if service == nil {
@@ -91,6 +167,45 @@ func (s *SWbemServices) ConnectServer(args ...interface{}) (c *SWbemServicesConn
 	return conn, nil
 }
 
+// SetSecurity sets the @impersonation and @authentication levels of the
+// connection. The levels are inherited by all the objects obtained through
+// the connection. Zero levels are replaced with ImpersonationImpersonate and
+// AuthenticationPkt.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemsecurity
+func (s *SWbemServicesConnection) SetSecurity(impersonation ImpersonationLevel, authentication AuthenticationLevel) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.sWbemServices == nil {
+		return ErrConnectionClosed
+	}
+	if impersonation == 0 {
+		impersonation = ImpersonationImpersonate
+	}
+	if authentication == 0 {
+		authentication = AuthenticationPkt
+	}
+
+	securityRaw, err := oleutil.GetProperty(s.sWbemServices, "Security_")
+	if err != nil {
+		return fmt.Errorf("SWbemServices Security_ error; %v", err)
+	}
+	defer func() {
+		if clErr := securityRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	security := securityRaw.ToIDispatch()
+
+	if _, err := oleutil.PutProperty(security, "ImpersonationLevel", int32(impersonation)); err != nil {
+		return fmt.Errorf("can't set impersonation level %d; %v", impersonation, err)
+	}
+	if _, err := oleutil.PutProperty(security, "AuthenticationLevel", int32(authentication)); err != nil {
+		return fmt.Errorf("can't set authentication level %d; %v", authentication, err)
+	}
+	return nil
+}
+
 // Close will clear and release all of the SWbemServicesConnection resources.
 func (s *SWbemServicesConnection) Close() error {
 	s.Lock()
@@ -104,15 +219,68 @@ func (s *SWbemServicesConnection) Close() error {
 	return nil
 }
 
+// Services returns the underlying `SWbemServices` object for the operations
+// the package doesn't wrap (e.g. `SWbemObject.GetObjectText_`). The object is
+// owned by the connection: it must not be released and is valid until
+// `SWbemServicesConnection.Close`. Nil is returned for the closed connection.
+func (s *SWbemServicesConnection) Services() *ole.IDispatch {
+	s.Lock()
+	defer s.Unlock()
+	return s.sWbemServices
+}
+
 // Query runs the WQL query using a SWbemServicesConnection instance and appends
 // the values to dst.
 //
 // More info about result unmarshalling is available in `Decoder.Unmarshal` doc.
 //
+// @dst could also be a pointer to struct. In such case the query should
+// return exactly one object, otherwise ErrNoResults or ErrMultipleResults is
+// returned. Set `Decoder.FirstRowOnly` to take the first object of many.
+//
+// For the schema-less use @dst could be a pointer to `[]map[string]interface{}`
+// (or to `map[string]interface{}` for a single object), see `Decoder.Unmarshal`.
+//
+// Slice elements could be pointers (`[]*S` or `[]*map[string]interface{}`),
+// then every element is freshly allocated before decoding. Skipped objects
+// (see `Decoder.OnError`) are not appended.
+//
+// The slice @dst is truncated and its backing array is reused if it has
+// enough capacity for the result, so the polling loops querying into the same
+// slice don't reallocate it every time. Hence the slices retained from the
+// previous query into the same @dst are overwritten; copy them (or query into
+// a nil slice) to keep them. Set `Decoder.ReuseElements` to also decode the
+// objects into the existing element values. `Client.QueryContext` with a
+// cancellable context doesn't reuse anything.
+//
+// @dst could also be a pointer to `map[K]V` where V is a struct (or a pointer
+// to struct) with a field tagged as `key`. The objects are keyed by that
+// field property in the same way as in `SWbemServicesConnection.QueryMap`,
+// e.g. to diff the successive polls:
+//   type process struct {
+//       ProcessId uint32 `wmi:",key"`
+//       Name      string
+//   }
+//   var processes map[uint32]process
+//   err := conn.Query("SELECT ProcessId, Name FROM Win32_Process", &processes)
+//
 // Query is performed using `SWbemServices.ExecQuery` method.
 //
 // Ref: https://docs.microsoft.com/en-us/windows/desktop/wmisdk/swbemservices-execquery
 func (s *SWbemServicesConnection) Query(query string, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return ErrInvalidEntityType
+	}
+	return s.QueryReflect(query, v.Elem())
+}
+
+// QueryReflect runs the WQL query like `SWbemServicesConnection.Query` does,
+// but loads the results into the value @dst itself instead of the value
+// pointed by the argument. It's intended for the callers that have only
+// reflect.Value of the destination. @dst should be settable (e.g. obtained
+// via `reflect.New(t).Elem()`) slice, map or struct.
+func (s *SWbemServicesConnection) QueryReflect(query string, dst reflect.Value) error {
 	s.Lock()
 	if s.sWbemServices == nil {
 		s.Unlock()
@@ -120,30 +288,129 @@ func (s *SWbemServicesConnection) Query(query string, dst interface{}) error {
 	}
 	s.Unlock()
 
-	sliceRefl := reflect.ValueOf(dst) // TODO: Double argument check?
-	if sliceRefl.Kind() != reflect.Ptr || sliceRefl.IsNil() {
-		return ErrInvalidEntityType
+	qDst, err := newQueryDstValue(dst)
+	if err != nil {
+		return err
+	}
+	return s.query(query, qDst)
+}
+
+// newQueryDst checks the Query @dst argument and prepares it for loading.
+func newQueryDst(dst interface{}) (*queryDst, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, ErrInvalidEntityType
+	}
+	return newQueryDstValue(v.Elem()) // "Dereference" pointer.
+}
+
+// newQueryDstValue checks the QueryReflect @sliceRefl argument and prepares
+// it for loading.
+func newQueryDstValue(sliceRefl reflect.Value) (*queryDst, error) {
+	if !sliceRefl.IsValid() || !sliceRefl.CanSet() {
+		return nil, fmt.Errorf("%w; destination value isn't settable", ErrInvalidEntityType)
+	}
+
+	if sliceRefl.Kind() == reflect.Struct || sliceRefl.Type() == objectMapType {
+		// Single object destination.
+		return &queryDst{
+			dst:         sliceRefl,
+			dsArgType:   multiArgTypeStruct,
+			dstElemType: sliceRefl.Type(),
+			single:      true,
+		}, nil
+	}
+
+	if sliceRefl.Kind() == reflect.Map {
+		argType, elemType := checkMapArg(sliceRefl)
+		if argType == multiArgTypeInvalid || elemType.Kind() != reflect.Struct {
+			return nil, ErrInvalidEntityType
+		}
+		keyField, ok := structKeyField(elemType)
+		if !ok {
+			return nil, fmt.Errorf("%w; no field tagged as key in %s", ErrInvalidEntityType, elemType)
+		}
+		return &queryDst{
+			dst:         sliceRefl,
+			dsArgType:   argType,
+			dstElemType: elemType,
+			keyField:    keyField,
+		}, nil
 	}
-	sliceRefl = sliceRefl.Elem() // "Dereference" pointer.
 
 	argType, elemType := checkMultiArg(sliceRefl)
+	if argType == multiArgTypeInvalid {
+		return nil, ErrInvalidEntityType
+	}
+
+	return &queryDst{
+		dst:         sliceRefl,
+		dsArgType:   argType,
+		dstElemType: elemType,
+	}, nil
+}
+
+// structKeyField returns the property name of the first @t field tagged as
+// `key`, e.g. `wmi:",key"`.
+func structKeyField(t reflect.Type) (name string, ok bool) {
+	for _, f := range cachedFields(t) {
+		if !f.skipped() && f.options.Contains("key") {
+			return f.name, true
+		}
+	}
+	return "", false
+}
+
+// QueryMap runs the WQL query using a SWbemServicesConnection instance and
+// loads the values into @dst map keyed by the @keyField property of the
+// resulting objects. @dst should be a pointer to `map[K]V`, where V is a
+// struct or a pointer to struct and K is a type @keyField property could be
+// unmarshalled into, e.g.
+//   var services map[string]Win32_Service
+//   err := conn.QueryMap("SELECT * FROM Win32_Service", "Name", &services)
+//
+// Objects with duplicate keys lead to the error unless
+// `Decoder.AllowDuplicateKeys` is set, in which case the last object wins.
+//
+// More info about result unmarshalling is available in `Decoder.Unmarshal` doc.
+func (s *SWbemServicesConnection) QueryMap(query string, keyField string, dst interface{}) error {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return ErrConnectionClosed
+	}
+	s.Unlock()
+
+	mapRefl := reflect.ValueOf(dst)
+	if mapRefl.Kind() != reflect.Ptr || mapRefl.IsNil() {
+		return ErrInvalidEntityType
+	}
+	mapRefl = mapRefl.Elem() // "Dereference" pointer.
+
+	argType, elemType := checkMapArg(mapRefl)
 	if argType == multiArgTypeInvalid {
 		return ErrInvalidEntityType
 	}
 
 	return s.query(query, &queryDst{
-		dst:         sliceRefl,
+		dst:         mapRefl,
 		dsArgType:   argType,
 		dstElemType: elemType,
+		keyField:    keyField,
 	})
 }
 
 // Get retrieves a single instance of a managed resource (or class definition)
 // based on an object @path. The result is unmarshalled into @dst. @dst should
-// be a pointer to the structure type.
+// be a pointer to the structure type or to map[string]interface{}.
 //
 // More info about result unmarshalling is available in `Decoder.Unmarshal` doc.
 //
+// Key values in the @path should be escaped, consider using `ObjectPath` to
+// build paths. Empty @path leads to ErrNoObjectPath. If the @path doesn't
+// resolve the returned WMIError matches ErrNotFound (or ErrInvalidClass for
+// the unknown class).
+//
 // Get method reference:
 // https://docs.microsoft.com/en-us/windows/desktop/wmisdk/swbemservices-get
 func (s *SWbemServicesConnection) Get(path string, dst interface{}) (err error) {
@@ -162,7 +429,8 @@ func (s *SWbemServicesConnection) Get(path string, dst interface{}) (err error)
 	}()
 
 	dstRef := reflect.ValueOf(dst)
-	if dstRef.Kind() != reflect.Ptr && dstRef.Elem().Kind() != reflect.Struct {
+	if dstRef.Kind() != reflect.Ptr || dstRef.IsNil() ||
+		(dstRef.Elem().Kind() != reflect.Struct && dstRef.Elem().Type() != objectMapType) {
 		return fmt.Errorf("dst should be a pointer to struct")
 	}
 
@@ -180,6 +448,33 @@ func (s *SWbemServicesConnection) Get(path string, dst interface{}) (err error)
 	return s.Unmarshal(result, dst)
 }
 
+// ClassExists checks if the @class exists in the connection namespace.
+func (s *SWbemServicesConnection) ClassExists(class string) (bool, error) {
+	return s.exists(class)
+}
+
+// InstanceExists checks if the object identified by @objectPath exists. See
+// `ObjectPath` to build the path.
+func (s *SWbemServicesConnection) InstanceExists(objectPath string) (bool, error) {
+	return s.exists(objectPath)
+}
+
+// exists performs `SWbemServices.Get` on the @path and reports if it doesn't
+// fail with not found error.
+func (s *SWbemServicesConnection) exists(path string) (ok bool, err error) {
+	v, err := s.Dereference(path)
+	if err != nil {
+		if isNotFoundError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if clErr := v.Clear(); clErr != nil {
+		return true, clErr
+	}
+	return true, nil
+}
+
 // Dereference performs `SWbemServices.Get` on the given path, but returns the
 // low level result itself not performing unmarshalling.
 func (s *SWbemServicesConnection) Dereference(referencePath string) (v *ole.VARIANT, err error) {
@@ -200,17 +495,226 @@ func (s *SWbemServicesConnection) Dereference(referencePath string) (v *ole.VARI
 	return s.dereference(referencePath)
 }
 
+// dereference performs `SWbemServices.Get` on a non-empty @referencePath.
+// Empty path (e.g. `__PATH` of the object without keys or of the event
+// object) leads to ErrNoObjectPath rather than to getting an empty object.
 func (s *SWbemServicesConnection) dereference(referencePath string) (v *ole.VARIANT, err error) {
-	return oleutil.CallMethod(s.sWbemServices, "Get", referencePath)
+	if referencePath == "" {
+		return nil, ErrNoObjectPath
+	}
+	v, err = oleutil.CallMethod(s.sWbemServices, "Get", referencePath)
+	if err != nil {
+		return nil, newWMIError(err)
+	}
+	return v, nil
+}
+
+// Ping checks that the connection and its namespace are usable by getting the
+// `__SystemClass` class definition, which exists in every namespace. That is
+// much cheaper than any query, so it's suitable for the health checks of
+// the remote hosts. ErrHostUnreachable is returned (wrapped) if the server
+// has gone, the other failures are returned as WMIError, e.g. matching
+// ErrAccessDenied.
+func (s *SWbemServicesConnection) Ping() (err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return ErrConnectionClosed
+	}
+	s.Unlock()
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	classRaw, err := oleutil.CallMethod(s.sWbemServices, "Get", "__SystemClass")
+	if err != nil {
+		if isUnreachableError(err) {
+			return fmt.Errorf("%w; SWbemServices Get error; %v", ErrHostUnreachable, err)
+		}
+		return newWMIError(err)
+	}
+	return classRaw.Clear()
+}
+
+// DescribeClass returns names of all the properties of the @className class
+// in the order WMI returns them for the class definition. Unlike properties
+// of the query result objects the list is complete and doesn't depend on the
+// property values, so it's suitable e.g. for building table headers.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemobject-properties-
+func (s *SWbemServicesConnection) DescribeClass(className string) (names []string, err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return nil, ErrConnectionClosed
+	}
+	s.Unlock()
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	classRaw, err := s.dereference(className)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := classRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	return propertyNames(classRaw.ToIDispatch())
+}
+
+// propertyNames returns names of all the @obj properties.
+func propertyNames(obj *ole.IDispatch) (names []string, err error) {
+	propsRaw, err := oleutil.GetProperty(obj, "Properties_")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := propsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	err = forEach(propsRaw.ToIDispatch(), func(prop *ole.IDispatch) error {
+		name, err := oleutil.GetProperty(prop, "Name")
+		if err != nil {
+			return err
+		}
+		names = append(names, name.ToString())
+		return name.Clear()
+	})
+	return names, err
+}
+
+// forEach calls @f for every object of the @collection enumerated using its
+// `_NewEnum` property. Items are released after @f returns.
+func forEach(collection *ole.IDispatch, f func(item *ole.IDispatch) error) (err error) {
+	enumProperty, err := collection.GetProperty("_NewEnum")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if clErr := enumProperty.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	enum, err := enumProperty.ToIUnknown().IEnumVARIANT(ole.IID_IEnumVariant)
+	if err != nil {
+		return err
+	}
+	if enum == nil {
+		return fmt.Errorf("can't get IEnumVARIANT, enum is nil")
+	}
+	defer enum.Release()
+
+	for itemRaw, length, _ := enum.Next(1); length > 0; itemRaw, length, _ = enum.Next(1) {
+		err := func() error {
+			defer func() { _ = itemRaw.Clear() }()
+			item := itemRaw.ToIDispatch()
+			if item == nil {
+				return fmt.Errorf("unexpected collection item type %d", itemRaw.VT)
+			}
+			return f(item)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var procSafeArrayGetElement = syscall.NewLazyDLL("oleaut32.dll").NewProc("SafeArrayGetElement")
+
+// forEachArrayObject calls @f for every object of the SAFEARRAY of
+// VT_DISPATCH (or VT_UNKNOWN) @arr. `SafeArrayConversion.ToValueArray`
+// doesn't support such arrays, so the elements are fetched directly. Items
+// are released after @f returns.
+func forEachArrayObject(arr *ole.SafeArrayConversion, f func(item *ole.IDispatch) error) error {
+	if arr == nil {
+		return fmt.Errorf("can't get objects array")
+	}
+	vt, err := arr.GetType()
+	if err != nil {
+		return err
+	}
+	count, err := arr.TotalElements(0)
+	if err != nil {
+		return err
+	}
+	for i := int32(0); i < count; i++ {
+		var item *ole.IDispatch
+		hr, _, _ := procSafeArrayGetElement.Call(
+			uintptr(unsafe.Pointer(arr.Array)),
+			uintptr(unsafe.Pointer(&i)),
+			uintptr(unsafe.Pointer(&item)))
+		if hr != 0 {
+			return ole.NewError(hr)
+		}
+		if item == nil {
+			continue // Empty element.
+		}
+		if ole.VT(vt) == ole.VT_UNKNOWN {
+			unknown := (*ole.IUnknown)(unsafe.Pointer(item))
+			item, err = unknown.QueryInterface(ole.IID_IDispatch)
+			unknown.Release()
+			if err != nil {
+				return fmt.Errorf("embedded object has no IDispatch; %v", err)
+			}
+		}
+		err := func() error {
+			defer item.Release()
+			return f(item)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type queryDst struct {
 	dst         reflect.Value
 	dsArgType   multiArgType
 	dstElemType reflect.Type
+	keyField    string // Property used as a key if dst is a map.
+	single      bool   // Is dst a single object (struct or object map).
+
+	// Optional parameters and statistics of `SWbemServicesConnection.QueryWith`.
+	ctx        context.Context // Checked between the objects if set.
+	limit      int             // Max objects to load into a slice or a map, 0 is unlimited.
+	rowTimeout time.Duration   // Max time to wait for a single object, 0 is infinite.
+	decoded    int             // Number of objects loaded into dst (or counted).
+	truncated  bool            // Is any object skipped due to the limit.
+	warnings   []error         // All ErrFieldMismatch and non-failure ErrIncompleteResult occurred.
+
+	// each is called for every object instead of loading it into dst (which
+	// is unused then). The COM object is released before the call.
+	each func(ev reflect.Value) error
+	// forwardOnly makes the query use a forward-only enumerator, so the
+	// provider could free the objects as they are fetched.
+	forwardOnly bool
+	// countOnly makes the query just count the objects, dst is unused.
+	countOnly bool
 }
 
 func (s *SWbemServicesConnection) query(query string, dst *queryDst) (err error) {
+	if s.observer != nil {
+		start := time.Now()
+		defer func() { s.observer(query, time.Since(start), dst.decoded, err) }()
+	}
+
 	//  Be aware of reflections and COM usage.
 	defer func() {
 		if r := recover(); r != nil {
@@ -218,10 +722,45 @@ func (s *SWbemServicesConnection) query(query string, dst *queryDst) (err error)
 		}
 	}()
 
+	if s.logger != nil {
+		defer func() { logQuery(s.logger, query, dst, err) }()
+	}
+
+	// Registered after the logger to log the final error.
+	if dst.rowTimeout == 0 && s.rowTimeout > 0 {
+		dst.rowTimeout = s.rowTimeout
+		defer func() {
+			if err != ErrRowTimeout {
+				return
+			}
+			err = ErrTimeout
+			if dst.dst.IsValid() && dst.dst.CanSet() && !dst.single {
+				dst.dst.Set(reflect.Zero(dst.dst.Type())) // Partial results are discarded.
+			}
+		}()
+	}
+
+	if s.retryPolicy == nil || dst.each != nil {
+		return s.execQuery(query, dst) // Objects passed to each can't be taken back.
+	}
+	return s.retryPolicy.do(dst.ctx, func() error {
+		dst.decoded, dst.truncated, dst.warnings = 0, false, nil
+		return s.execQuery(query, dst)
+	})
+}
+
+// execQuery performs the WQL @query once and loads the results into @dst.
+func (s *SWbemServicesConnection) execQuery(query string, dst *queryDst) (err error) {
+	flags := wbemFlagReturnImmediately | s.QueryFlags
+	if dst.forwardOnly {
+		flags |= QueryFlagForwardOnly
+	}
+	forwardOnly := flags&QueryFlagForwardOnly != 0
+
 	// result is a SWBemObjectSet
-	resultRaw, err := oleutil.CallMethod(s.sWbemServices, "ExecQuery", query)
+	resultRaw, err := oleutil.CallMethod(s.sWbemServices, "ExecQuery", query, "WQL", flags)
 	if err != nil {
-		return err
+		return newWMIError(err)
 	}
 	result := resultRaw.ToIDispatch()
 	defer func() {
@@ -230,9 +769,13 @@ func (s *SWbemServicesConnection) query(query string, dst *queryDst) (err error)
 		}
 	}()
 
-	count, err := oleInt64(result, "Count")
-	if err != nil {
-		return err
+	// Count of the forward-only set is unavailable, it's unknown until the
+	// enumeration ends.
+	var count int64
+	if !forwardOnly {
+		if count, err = oleInt64(result, "Count"); err != nil {
+			return newWMIError(err)
+		}
 	}
 
 	enumProperty, err := result.GetProperty("_NewEnum")
@@ -254,22 +797,134 @@ func (s *SWbemServicesConnection) query(query string, dst *queryDst) (err error)
 	}
 	defer enum.Release()
 
-	// Initialize a slice with Count capacity
-	dst.dst.Set(reflect.MakeSlice(dst.dst.Type(), 0, int(count)))
+	return s.enumerate(enum, int(count), dst)
+}
+
+// enumerator is a part of IEnumVARIANT used for the query results enumeration.
+type enumerator interface {
+	Next(celt uint) (ole.VARIANT, uint, error)
+}
+
+// enumerate loads all the objects from @enum into @dst. @count is the objects
+// count reported by the `SWbemObjectSet`. See ErrIncompleteResult for the
+// details on how incomplete enumeration is detected. Every fetched object is
+// released, even if the unmarshaling returns an error or panics.
+func (s *SWbemServicesConnection) enumerate(enum enumerator, count int, dst *queryDst) error {
+	if dst.rowTimeout > 0 && isSTAThread() {
+		return ErrRowTimeoutSTA // The enumerator can't be called from other threads.
+	}
+
+	// Initialize a slice or a map with Count capacity
+	single := dst.single
+	switch {
+	case single:
+	case dst.dst.Kind() == reflect.Map:
+		dst.dst.Set(reflect.MakeMapWithSize(dst.dst.Type(), count))
+	case dst.dst.Kind() == reflect.Slice:
+		if dst.dst.IsNil() || dst.dst.Cap() < count {
+			dst.dst.Set(reflect.MakeSlice(dst.dst.Type(), 0, count))
+		} else {
+			dst.dst.SetLen(0) // Reuse the backing array.
+		}
+	}
 
 	var errFieldMismatch error
-	for itemRaw, length, err := enum.Next(1); length > 0; itemRaw, length, err = enum.Next(1) {
-		if err != nil {
+	received := 0
+	for {
+		if dst.ctx != nil {
+			if err := dst.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		itemRaw, length, err := nextItem(enum, dst.rowTimeout)
+		if err == ErrRowTimeout {
 			return err
 		}
+		if length == 0 {
+			// IEnumVARIANT.Next returns S_FALSE when there is no more items.
+			code := uint32(sOK)
+			if err != nil {
+				var ok bool
+				if code, ok = oleErrorCode(err); !ok {
+					return partialResult(dst, err)
+				}
+			}
+			if (code != sOK && code != sFalse) || received < count {
+				incomplete := ErrIncompleteResult{
+					Expected: count,
+					Received: received,
+					Code:     code,
+				}
+				if code&0x80000000 != 0 { // FAILED(code)
+					return partialResult(dst, incomplete)
+				}
+				dst.warnings = append(dst.warnings, incomplete)
+				return incomplete
+			}
+			switch {
+			case single && received == 0:
+				return ErrNoResults
+			case single && received > 1:
+				return ErrMultipleResults{Count: received}
+			}
+			return errFieldMismatch
+		}
+		if err != nil {
+			// Don't leak the item fetched along with the error.
+			_ = itemRaw.Clear()
+			return partialResult(dst, err)
+		}
+		received++
+
+		if dst.countOnly {
+			dst.decoded++
+			if err := itemRaw.Clear(); err != nil {
+				return err
+			}
+			continue
+		}
+		if !single && dst.limit > 0 && received > dst.limit {
+			dst.truncated = true
+			if err := itemRaw.Clear(); err != nil {
+				return err
+			}
+			return errFieldMismatch
+		}
+		if !single && s.maxResults > 0 && received > s.maxResults {
+			if err := itemRaw.Clear(); err != nil {
+				return err
+			}
+			return ErrResultLimitExceeded{Limit: s.maxResults}
+		}
+		if single && received > 1 {
+			// Just count the rest of the objects.
+			if err := itemRaw.Clear(); err != nil {
+				return err
+			}
+			if s.FirstRowOnly {
+				return errFieldMismatch
+			}
+			continue
+		}
 
 		// Closure for defer in the loop.
-		err := func() error {
+		var next reflect.Value
+		index := received - 1
+		err = func() error {
+			// The item is released even if the unmarshaler panics.
+			defer func() { _ = itemRaw.Clear() }()
 			item := itemRaw.ToIDispatch()
-			defer item.Release()
+			if item == nil {
+				return fmt.Errorf("unexpected query result item type %d", itemRaw.VT)
+			}
 
 			ev := reflect.New(dst.dstElemType)
-			if err = s.Unmarshal(item, ev.Interface()); err != nil {
+			if single {
+				ev = dst.dst.Addr()
+			} else if reused, ok := s.reusedElem(dst); ok {
+				ev = reused
+			}
+			if err := s.UnmarshalContext(dst.ctx, item, ev.Interface()); err != nil {
 				if _, ok := err.(ErrFieldMismatch); ok {
 					// We continue loading entities even in the face of field mismatch errors.
 					// If we encounter any other error, that other error is returned. Otherwise,
@@ -278,14 +933,28 @@ func (s *SWbemServicesConnection) query(query string, dst *queryDst) (err error)
 					// Note that we are unmarshalling into the slice, so every element of the
 					// result will have the same error thus we can save the only error occurred.
 					errFieldMismatch = err
+					dst.warnings = append(dst.warnings, err)
+				} else if !single && s.OnError != nil && s.OnError(index, err) {
+					return nil // Object is skipped.
 				} else {
 					return err
 				}
 			}
 
+			dst.decoded++
+			if single {
+				return nil
+			}
 			if dst.dsArgType != multiArgTypeStructPtr {
 				ev = ev.Elem()
 			}
+			if dst.each != nil {
+				next = ev // Passed after the item release.
+				return nil
+			}
+			if dst.dst.Kind() == reflect.Map {
+				return s.setMapIndex(dst, item, ev)
+			}
 			dst.dst.Set(reflect.Append(dst.dst, ev))
 
 			return nil
@@ -293,8 +962,142 @@ func (s *SWbemServicesConnection) query(query string, dst *queryDst) (err error)
 		if err != nil {
 			return err
 		}
+		if next.IsValid() {
+			if err := dst.each(next); err != nil {
+				return err
+			}
+		}
 	}
-	return errFieldMismatch
+}
+
+// reusedElem returns the pointer to the element value of the slice @dst
+// beyond its length (but within the capacity) to decode the next object into
+// if `Decoder.ReuseElements` is set. Nil pointer elements aren't reused.
+func (s *SWbemServicesConnection) reusedElem(dst *queryDst) (reflect.Value, bool) {
+	if !s.ReuseElements || dst.each != nil || dst.dst.Kind() != reflect.Slice {
+		return reflect.Value{}, false
+	}
+	n := dst.dst.Len()
+	if n >= dst.dst.Cap() {
+		return reflect.Value{}, false
+	}
+	elem := dst.dst.Slice(0, n+1).Index(n)
+	if dst.dsArgType == multiArgTypeStructPtr {
+		return elem, !elem.IsNil()
+	}
+	return elem.Addr(), true
+}
+
+// partialResult wraps the enumeration failure @err into PartialResultError if
+// some objects have already been loaded into the multi-object @dst.
+func partialResult(dst *queryDst, err error) error {
+	if dst.single || dst.decoded == 0 {
+		return err
+	}
+	return PartialResultError{Decoded: dst.decoded, Err: err}
+}
+
+// nextItem fetches the next item from the @enum waiting at most @timeout for
+// it. If @timeout is 0 it waits infinitely, otherwise the fetch is performed
+// in the separate goroutine and ErrRowTimeout is returned if it takes too
+// long. The item fetched after timeout is released.
+//
+// The goroutine could run on any OS thread, so the @enum should belong to the
+// multithreaded apartment, see `isSTAThread`.
+//
+// Unlike `IEnumWbemClassObject.Next`, `IEnumVARIANT.Next` used by the
+// scripting API has no timeout parameter, so it's emulated.
+func nextItem(enum enumerator, timeout time.Duration) (ole.VARIANT, uint, error) {
+	if timeout <= 0 {
+		return enum.Next(1)
+	}
+
+	type item struct {
+		v      ole.VARIANT
+		length uint
+		err    error
+	}
+	res := make(chan item, 1)
+
+	// Keep the enumerator alive until the fetch is done even if we don't
+	// wait for it.
+	unknown, refCounted := enum.(interface {
+		AddRef() int32
+		Release() int32
+	})
+	if refCounted {
+		unknown.AddRef()
+	}
+	go func() {
+		v, length, err := enum.Next(1)
+		if refCounted {
+			unknown.Release()
+		}
+		res <- item{v, length, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case it := <-res:
+		return it.v, it.length, it.err
+	case <-timer.C:
+		go func() {
+			if it := <-res; it.length > 0 {
+				_ = it.v.Clear()
+			}
+		}()
+		return ole.VARIANT{}, 0, ErrRowTimeout
+	}
+}
+
+// Apartment types returned by `CoGetApartmentType`.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/api/objidl/ne-objidl-apttype
+const (
+	aptTypeSTA     = 0
+	aptTypeMainSTA = 3
+)
+
+var procCoGetApartmentType = syscall.NewLazyDLL("ole32.dll").NewProc("CoGetApartmentType")
+
+// isSTAThread reports if the current OS thread is initialized as (the main)
+// single-threaded COM apartment, e.g. by the caller before the query. Threads
+// not initialized explicitly belong to the multithreaded apartment kept by
+// comshim.
+func isSTAThread() bool {
+	var aptType, qualifier int32
+	hr, _, _ := procCoGetApartmentType.Call(uintptr(unsafe.Pointer(&aptType)), uintptr(unsafe.Pointer(&qualifier)))
+	if hr != 0 {
+		return false // Not initialized at all.
+	}
+	return aptType == aptTypeSTA || aptType == aptTypeMainSTA
+}
+
+// setMapIndex puts @ev into the @dst map using @item key property as a key.
+func (s *SWbemServicesConnection) setMapIndex(dst *queryDst, item *ole.IDispatch, ev reflect.Value) (err error) {
+	prop, err := oleutil.GetProperty(item, dst.keyField)
+	if err != nil {
+		return fmt.Errorf("no key field %q", dst.keyField)
+	}
+	defer func() {
+		if clErr := prop.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	if prop.VT == ole.VT_NULL {
+		return fmt.Errorf("key field %q is NULL", dst.keyField)
+	}
+
+	key := reflect.New(dst.dst.Type().Key()).Elem()
+	if err := unmarshalSimpleValue(key, prop.Value()); err != nil {
+		return fmt.Errorf("can't unmarshal key field %q; %v", dst.keyField, err)
+	}
+	if !s.AllowDuplicateKeys && dst.dst.MapIndex(key).IsValid() {
+		return fmt.Errorf("duplicate key %v of field %q", key, dst.keyField)
+	}
+	dst.dst.SetMapIndex(key, ev)
+	return nil
 }
 
 type multiArgType int
@@ -305,7 +1108,8 @@ const (
 	multiArgTypeStructPtr
 )
 
-// checkMultiArg checks that v has type []S, []*S for some struct type S.
+// checkMultiArg checks that v has type []S, []*S for some struct type S or
+// an object map.
 //
 // It returns what category the slice's elements are, and the reflect.Type
 // that represents S.
@@ -313,13 +1117,33 @@ func checkMultiArg(v reflect.Value) (m multiArgType, elemType reflect.Type) {
 	if v.Kind() != reflect.Slice {
 		return multiArgTypeInvalid, nil
 	}
-	elemType = v.Type().Elem()
+	return checkElemType(v.Type().Elem())
+}
+
+// checkMapArg checks that v has type map[K]S, map[K]*S for some struct type S.
+//
+// It returns what category the map's elements are, and the reflect.Type
+// that represents S.
+func checkMapArg(v reflect.Value) (m multiArgType, elemType reflect.Type) {
+	if v.Kind() != reflect.Map {
+		return multiArgTypeInvalid, nil
+	}
+	return checkElemType(v.Type().Elem())
+}
+
+// checkElemType checks that elemType is S or *S for some struct type S or
+// an object map (`map[string]interface{}`) or a pointer to it.
+func checkElemType(elemType reflect.Type) (multiArgType, reflect.Type) {
 	switch elemType.Kind() {
 	case reflect.Struct:
 		return multiArgTypeStruct, elemType
+	case reflect.Map:
+		if elemType == objectMapType {
+			return multiArgTypeStruct, elemType
+		}
 	case reflect.Ptr:
 		elemType = elemType.Elem()
-		if elemType.Kind() == reflect.Struct {
+		if elemType.Kind() == reflect.Struct || elemType == objectMapType {
 			return multiArgTypeStructPtr, elemType
 		}
 	}
@@ -3,9 +3,17 @@
 package wmi
 
 import (
+	"errors"
+	"fmt"
 	"os/user"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
 )
 
 // Just a smoke test of SWbemServicesConnection API. More detailed ones has
@@ -86,3 +94,355 @@ func TestSWbemServicesConnection_Get(t *testing.T) {
 		t.Errorf("Got unexpected user Domain; got %q, expected %q", currentUserAccount.Domain, osUserDomain)
 	}
 }
+
+// Enumerator mock that doesn't return any object, but terminates with the
+// given HRESULT.
+type emptyEnumerator uint32
+
+func (e emptyEnumerator) Next(uint) (ole.VARIANT, uint, error) {
+	return ole.VARIANT{}, 0, ole.NewError(uintptr(e))
+}
+
+func TestSWbemServicesConnection_IncompleteResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		enum     emptyEnumerator
+		count    int
+		expected error
+	}{
+		{"clean", sFalse, 0, nil},
+		{"no more data", wbemSNoMoreData, 0, ErrIncompleteResult{Code: wbemSNoMoreData}},
+		{"less than count", sFalse, 3, ErrIncompleteResult{Expected: 3, Code: sFalse}},
+		{"failure", wbemErrTimedOut, 0, ErrIncompleteResult{Code: wbemErrTimedOut}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s SWbemServicesConnection
+			var dst []Win32_Process
+			qDst := queryDst{
+				dst:         reflect.ValueOf(&dst).Elem(),
+				dsArgType:   multiArgTypeStruct,
+				dstElemType: reflect.TypeOf(Win32_Process{}),
+			}
+			err := s.enumerate(tt.enum, tt.count, &qDst)
+			if err != tt.expected {
+				t.Errorf("Unexpected enumeration result; got %v, expected %v", err, tt.expected)
+			}
+			isWarning := err != nil && tt.enum&0x80000000 == 0
+			if isWarning != (len(qDst.warnings) == 1) {
+				t.Errorf("Unexpected enumeration warnings; got %v", qDst.warnings)
+			}
+			if dst == nil {
+				t.Errorf("Destination isn't initialized")
+			}
+		})
+	}
+}
+
+func TestSWbemServicesConnection_NoObjectPath(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	// Instance without keys has no path.
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "Name", "pathless.exe"); err != nil {
+		t.Fatalf("Failed to set property; %s", err)
+	}
+	var pathless struct {
+		Name string
+		Path string `wmi:"__PATH"`
+	}
+	if err := conn.Unmarshal(instance, &pathless); err != nil {
+		t.Fatalf("Failed to unmarshal pathless object; %s", err)
+	}
+	if pathless.Name != "pathless.exe" || pathless.Path != "" {
+		t.Errorf("Unexpected pathless object; %+v", pathless)
+	}
+
+	var dst Win32_Process
+	if err := conn.Get(pathless.Path, &dst); err != ErrNoObjectPath {
+		t.Errorf("Unexpected Get error; got %v, expected %v", err, ErrNoObjectPath)
+	}
+	if _, err := conn.Exec(pathless.Path, "GetOwner", nil); err != ErrNoObjectPath {
+		t.Errorf("Unexpected Exec error; got %v, expected %v", err, ErrNoObjectPath)
+	}
+	r, err := conn.NewRefresher()
+	if err != nil {
+		t.Fatalf("Failed to create refresher; %s", err)
+	}
+	defer r.Close()
+	if _, err := r.Add(pathless.Path); err != ErrNoObjectPath {
+		t.Errorf("Unexpected Refresher.Add error; got %v, expected %v", err, ErrNoObjectPath)
+	}
+}
+
+// blockingEnumerator is an enumerator hanging on Next for the given duration.
+type blockingEnumerator time.Duration
+
+func (e blockingEnumerator) Next(uint) (ole.VARIANT, uint, error) {
+	time.Sleep(time.Duration(e))
+	return ole.VARIANT{}, 0, ole.NewError(sFalse)
+}
+
+func TestSWbemServicesConnection_RowTimeout(t *testing.T) {
+	var s SWbemServicesConnection
+	var dst []Win32_Process
+	qDst := queryDst{
+		dst:         reflect.ValueOf(&dst).Elem(),
+		dsArgType:   multiArgTypeStruct,
+		dstElemType: reflect.TypeOf(Win32_Process{}),
+		rowTimeout:  50 * time.Millisecond,
+	}
+
+	start := time.Now()
+	if err := s.enumerate(blockingEnumerator(time.Second), 0, &qDst); err != ErrRowTimeout {
+		t.Errorf("Unexpected enumeration result; got %v, expected %v", err, ErrRowTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Row timeout isn't respected; enumeration took %s", elapsed)
+	}
+
+	// Fast enough enumerator.
+	if err := s.enumerate(blockingEnumerator(0), 0, &qDst); err != nil {
+		t.Errorf("Unexpected enumeration result; got %v", err)
+	}
+}
+
+func TestSWbemServicesConnection_DescribeClass(t *testing.T) {
+	s, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer s.Close()
+
+	names, err := s.DescribeClass("Win32_Process")
+	if err != nil {
+		t.Fatalf("DescribeClass: %s", err)
+	}
+	described := make(map[string]bool)
+	for _, name := range names {
+		described[name] = true
+	}
+
+	// All the properties should be described, including the always NULL ones
+	// like `TerminationDate`.
+	fields := reflect.TypeOf(Win32_Process{})
+	for i := 0; i < fields.NumField(); i++ {
+		if name := fields.Field(i).Name; !described[name] {
+			t.Errorf("Property %q isn't described; got %v", name, names)
+		}
+	}
+	if len(names) != fields.NumField() {
+		t.Errorf("Unexpected properties count; got %d, expected %d", len(names), fields.NumField())
+	}
+
+	if _, err := s.DescribeClass("Win32_NoSuchClass"); err == nil {
+		t.Errorf("Expected error for unknown class")
+	}
+}
+
+func TestConnectSWbemServices_NamespaceNotFound(t *testing.T) {
+	_, err := ConnectSWbemServices(nil, `root\NoSuchNamespace\Nested`)
+	var notFound ErrNamespaceNotFound
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Unexpected error; got %v, expected %T", err, notFound)
+	}
+	if notFound.Existing != "root" {
+		t.Errorf("Unexpected closest existing namespace; got %q, expected %q", notFound.Existing, "root")
+	}
+	var cimv2Found bool
+	for _, n := range notFound.Available {
+		cimv2Found = cimv2Found || strings.EqualFold(n, "cimv2")
+	}
+	if !cimv2Found {
+		t.Errorf("Failed to find cimv2 in available namespaces; %s", err)
+	}
+}
+
+// objectEnumerator is an enumerator returning the same object @n times and
+// terminating with @end HRESULT (S_FALSE if zero). Every returned item holds
+// its own reference to the object.
+type objectEnumerator struct {
+	obj *ole.IDispatch
+	n   int
+	end uint32
+}
+
+func (e *objectEnumerator) Next(uint) (ole.VARIANT, uint, error) {
+	if e.n == 0 {
+		if e.end == 0 {
+			return ole.VARIANT{}, 0, ole.NewError(sFalse)
+		}
+		return ole.VARIANT{}, 0, ole.NewError(uintptr(e.end))
+	}
+	e.n--
+	e.obj.AddRef()
+	return ole.NewVariant(ole.VT_DISPATCH, int64(uintptr(unsafe.Pointer(e.obj)))), 1, nil
+}
+
+type panickingUnmarshaller struct{}
+
+func (panickingUnmarshaller) UnmarshalOLE(d Decoder, src *ole.IDispatch) error {
+	panic("always panic")
+}
+
+func TestSWbemServicesConnection_EnumerateRelease(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+	refs := func() int32 {
+		instance.AddRef()
+		return instance.Release()
+	}
+	before := refs()
+
+	enumerate := func(dst interface{}) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("runtime panic; %v", r)
+			}
+		}()
+		qDst, err := newQueryDst(dst)
+		if err != nil {
+			return err
+		}
+		return conn.enumerate(&objectEnumerator{obj: instance, n: 3}, 3, qDst)
+	}
+
+	var failers []dumbUnmarshaller
+	if err := enumerate(&failers); err == nil {
+		t.Errorf("Unmarshaler error isn't returned")
+	}
+	if after := refs(); after != before {
+		t.Errorf("Objects leaked after the unmarshaler error; refs before %d, after %d", before, after)
+	}
+
+	var panickers []panickingUnmarshaller
+	if err := enumerate(&panickers); err == nil || !strings.Contains(err.Error(), "always panic") {
+		t.Errorf("Unexpected panic result; %v", err)
+	}
+	if after := refs(); after != before {
+		t.Errorf("Objects leaked after the unmarshaler panic; refs before %d, after %d", before, after)
+	}
+
+	var processes []Win32_Process
+	if err := enumerate(&processes); err != nil {
+		t.Errorf("Unexpected enumeration error; %s", err)
+	}
+	if after := refs(); after != before {
+		t.Errorf("Objects leaked after the enumeration; refs before %d, after %d", before, after)
+	}
+}
+
+func TestSWbemServicesConnection_PartialResult(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+
+	var dst []Win32_Process
+	qDst, err := newQueryDst(&dst)
+	if err != nil {
+		t.Fatalf("Failed to prepare destination; %s", err)
+	}
+	err = conn.enumerate(&objectEnumerator{obj: instance, n: 2, end: wbemErrTimedOut}, 0, qDst)
+	var partial PartialResultError
+	if !errors.As(err, &partial) || partial.Decoded != 2 {
+		t.Fatalf("Unexpected partial enumeration result; %v", err)
+	}
+	var incomplete ErrIncompleteResult
+	if !errors.As(err, &incomplete) || incomplete.Code != wbemErrTimedOut || incomplete.Received != 2 {
+		t.Errorf("Enumeration failure isn't wrapped; %v", err)
+	}
+	if len(dst) != 2 {
+		t.Errorf("Decoded objects aren't loaded; got %d objects", len(dst))
+	}
+
+	// Nothing decoded, nothing partial.
+	qDst, _ = newQueryDst(&dst)
+	err = conn.enumerate(&objectEnumerator{obj: instance, end: wbemErrTimedOut}, 0, qDst)
+	if errors.As(err, &partial) || !errors.As(err, &incomplete) {
+		t.Errorf("Unexpected empty enumeration result; %v", err)
+	}
+
+	// Single destination gets the failure as is.
+	var one Win32_Process
+	qDst, _ = newQueryDst(&one)
+	err = conn.enumerate(&objectEnumerator{obj: instance, n: 1, end: wbemErrTimedOut}, 0, qDst)
+	if _, ok := err.(ErrIncompleteResult); !ok {
+		t.Errorf("Unexpected single destination result; %v", err)
+	}
+}
+
+func TestSWbemServicesConnection_ReuseSlice(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "Name", "test.exe"); err != nil {
+		t.Fatalf("Failed to set Name; %s", err)
+	}
+
+	type process struct {
+		Name  string
+		Extra string `wmi:"-"`
+	}
+	enumerate := func(dst interface{}, n int) {
+		t.Helper()
+		qDst, err := newQueryDst(dst)
+		if err != nil {
+			t.Fatalf("Failed to prepare destination; %s", err)
+		}
+		if err := conn.enumerate(&objectEnumerator{obj: instance, n: n}, n, qDst); err != nil {
+			t.Fatalf("Unexpected enumeration error; %s", err)
+		}
+	}
+
+	dst := make([]process, 3)
+	dst[0].Extra = "stale"
+	backing := &dst[:1][0]
+	enumerate(&dst, 2)
+	if len(dst) != 2 || &dst[0] != backing {
+		t.Errorf("Backing array isn't reused; len %d", len(dst))
+	}
+	if dst[0].Extra != "" {
+		t.Errorf("Element is reused without ReuseElements; %+v", dst[0])
+	}
+	enumerate(&dst, 4)
+	if len(dst) != 4 || &dst[0] == backing {
+		t.Errorf("Destination isn't grown; len %d", len(dst))
+	}
+
+	conn.ReuseElements = true
+	defer func() { conn.ReuseElements = false }()
+	dst[0].Extra = "kept"
+	backing = &dst[0]
+	enumerate(&dst, 1)
+	if len(dst) != 1 || &dst[0] != backing || dst[0].Extra != "kept" || dst[0].Name == "" {
+		t.Errorf("Element isn't reused; %+v", dst)
+	}
+
+	ptrs := []*process{{Extra: "kept"}, nil}
+	first := ptrs[0]
+	enumerate(&ptrs, 2)
+	if ptrs[0] != first || ptrs[0].Name == "" || ptrs[1] == nil || ptrs[1].Name == "" {
+		t.Errorf("Pointer elements aren't reused or allocated; %+v, %+v", ptrs[0], ptrs[1])
+	}
+}
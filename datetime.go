@@ -0,0 +1,126 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// cimDateTimeLayout is a layout of CIM_DATETIME without a time zone offset.
+const cimDateTimeLayout = "20060102150405.000000"
+
+// FormatCIMDateTime formats @t as CIM_DATETIME string, e.g. for using in WQL
+// queries:
+//   q := fmt.Sprintf("SELECT * FROM Win32_Process WHERE CreationDate > '%s'",
+//       wmi.FormatCIMDateTime(since))
+//
+// The format is "yyyymmddHHMMSS.mmmmmmsUUU", where "mmmmmm" is microseconds,
+// "s" is the sign of the time zone offset and "UUU" is the offset in minutes.
+// Time is truncated to microseconds.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/desktop/wmisdk/cim-datetime
+func FormatCIMDateTime(t time.Time) string {
+	_, offset := t.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%c%03d", t.Format(cimDateTimeLayout), sign, offset/60)
+}
+
+// ParseCIMDateTime parses CIM_DATETIME string @s into time.Time with the
+// fixed time zone of the offset specified in @s. See `FormatCIMDateTime` for
+// the format details.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/desktop/wmisdk/cim-datetime
+func ParseCIMDateTime(s string) (time.Time, error) {
+	const signPos = len(cimDateTimeLayout)
+	if len(s) != signPos+4 {
+		return time.Time{}, fmt.Errorf("invalid CIM_DATETIME %q; unexpected length", s)
+	}
+	sign := s[signPos]
+	if sign != '+' && sign != '-' {
+		return time.Time{}, fmt.Errorf("invalid CIM_DATETIME %q; no time zone offset", s)
+	}
+	// golang can't understand such timezone offset, so parse minute offset
+	// separately.
+	minOffset, err := strconv.Atoi(s[signPos+1:])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid CIM_DATETIME %q; %v", s, err)
+	}
+	if sign == '-' {
+		minOffset = -minOffset
+	}
+
+	t, err := time.ParseInLocation(cimDateTimeLayout, s[:signPos], time.UTC)
+	if err != nil {
+		return time.Time{}, err
+	}
+	zone := time.FixedZone("", minOffset*60)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), zone), nil
+}
+
+// CIMDateTime is a raw CIM_DATETIME string. Unlike time.Time fields,
+// CIMDateTime fields keep the values exactly as received (including the
+// time zone offset and the intervals), so they could be passed back into
+// `SWbemServicesConnection.PutInstance` or the method calls without the lossy
+// parse-format round trips:
+//   type Win32_Process struct {
+//       Name         string
+//       CreationDate wmi.CIMDateTime
+//   }
+type CIMDateTime string
+
+// FromTime formats @t as CIMDateTime, see `FormatCIMDateTime`.
+func FromTime(t time.Time) CIMDateTime {
+	return CIMDateTime(FormatCIMDateTime(t))
+}
+
+// Time parses the datetime into time.Time, see `ParseCIMDateTime`.
+func (d CIMDateTime) Time() (time.Time, error) {
+	return ParseCIMDateTime(string(d))
+}
+
+// ParseCIMInterval parses CIM_DATETIME interval string @s (e.g. the uptime
+// or the timeout values) into time.Duration. The format is
+// "ddddddddHHMMSS.mmmmmm:000", where "dddddddd" is the number of days and
+// "mmmmmm" is microseconds.
+//
+// time.Duration holds up to about 106751 days, so the longer intervals (e.g.
+// "99999999235959.000000:000" often used as the infinite one) lead to the
+// error.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/desktop/wmisdk/cim-datetime
+func ParseCIMInterval(s string) (time.Duration, error) {
+	const layoutLen = len("ddddddddHHMMSS.mmmmmm:000")
+	if len(s) != layoutLen || s[14] != '.' || s[21:] != ":000" {
+		return 0, fmt.Errorf("invalid CIM_DATETIME interval %q", s)
+	}
+	parts := []struct {
+		digits string
+		unit   time.Duration
+		max    int64
+	}{
+		{s[0:8], 24 * time.Hour, 99999999},
+		{s[8:10], time.Hour, 23},
+		{s[10:12], time.Minute, 59},
+		{s[12:14], time.Second, 59},
+		{s[15:21], time.Microsecond, 999999},
+	}
+	var d time.Duration
+	for _, p := range parts {
+		v, err := strconv.ParseUint(p.digits, 10, 64)
+		if err != nil || int64(v) > p.max {
+			return 0, fmt.Errorf("invalid CIM_DATETIME interval %q", s)
+		}
+		if int64(v) > (math.MaxInt64-int64(d))/int64(p.unit) {
+			return 0, fmt.Errorf("CIM_DATETIME interval %q overflows time.Duration", s)
+		}
+		d += time.Duration(v) * p.unit
+	}
+	return d, nil
+}
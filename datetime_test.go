@@ -0,0 +1,125 @@
+// +build windows
+
+package wmi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCIMDateTime(t *testing.T) {
+	tests := []struct {
+		t   time.Time
+		str string
+	}{
+		{time.Date(2020, 3, 4, 5, 6, 7, 123456000, time.UTC), "20200304050607.123456+000"},
+		{time.Date(2020, 3, 4, 5, 6, 7, 0, time.FixedZone("", 3*60*60)), "20200304050607.000000+180"},
+		{time.Date(1999, 12, 31, 23, 59, 59, 1000, time.FixedZone("", -(5*60+30)*60)), "19991231235959.000001-330"},
+	}
+	for _, tt := range tests {
+		str := FormatCIMDateTime(tt.t)
+		if str != tt.str {
+			t.Errorf("Unexpected format of %s; got %q, expected %q", tt.t, str, tt.str)
+		}
+		parsed, err := ParseCIMDateTime(str)
+		if err != nil {
+			t.Errorf("Failed to parse %q; %s", str, err)
+			continue
+		}
+		if !parsed.Equal(tt.t) {
+			t.Errorf("Unexpected round trip of %s; got %s", tt.t, parsed)
+		}
+		if _, offset := parsed.Zone(); offset != func() int { _, o := tt.t.Zone(); return o }() {
+			t.Errorf("Time zone offset of %q isn't kept; got %d", str, offset)
+		}
+	}
+
+	// Truncated to microseconds.
+	if str := FormatCIMDateTime(time.Date(2020, 1, 1, 0, 0, 0, 999, time.UTC)); str != "20200101000000.000000+000" {
+		t.Errorf("Unexpected format of nanoseconds; got %q", str)
+	}
+
+	for _, invalid := range []string{"", "20200304050607", "20200304050607.123456*000", "2020030405060x.123456+000"} {
+		if _, err := ParseCIMDateTime(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestParseCIMInterval(t *testing.T) {
+	tests := []struct {
+		str string
+		d   time.Duration
+	}{
+		{"00000000000000.000000:000", 0},
+		{"00000000001122.000000:000", 11*time.Minute + 22*time.Second},
+		{"00000012030405.678901:000", 12*24*time.Hour + 3*time.Hour + 4*time.Minute + 5*time.Second + 678901*time.Microsecond},
+		{"00106751000000.000000:000", 106751 * 24 * time.Hour}, // Max days fitting time.Duration.
+	}
+	for _, tt := range tests {
+		d, err := ParseCIMInterval(tt.str)
+		if err != nil || d != tt.d {
+			t.Errorf("Unexpected interval of %q; got %s, %v, expected %s", tt.str, d, err, tt.d)
+		}
+	}
+	for _, invalid := range []string{"", "20200304050607.123456+000", "00000000001199.000000:000", "0000000000112x.000000:000",
+		"00106751235959.000000:000", "99999999235959.000000:000"} { // Overflows.
+		if _, err := ParseCIMInterval(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+
+	// Intervals are decoded into time.Duration, datetimes of any offset into time.Time.
+	var dst struct {
+		Timeout  time.Duration
+		Positive time.Time
+		Negative time.Time
+	}
+	v := reflect.ValueOf(&dst).Elem()
+	if err := unmarshalSimpleValue(v.Field(0), "00000000000130.000000:000"); err != nil || dst.Timeout != 90*time.Second {
+		t.Errorf("Unexpected interval decoding; got %s, %v", dst.Timeout, err)
+	}
+	if err := unmarshalSimpleValue(v.Field(1), "20200304050607.000000+060"); err != nil {
+		t.Errorf("Failed to decode positive offset; %s", err)
+	} else if _, offset := dst.Positive.Zone(); offset != 3600 || dst.Positive.UTC().Hour() != 4 {
+		t.Errorf("Unexpected positive offset decoding; %s", dst.Positive)
+	}
+	if err := unmarshalSimpleValue(v.Field(2), "20200304050607.000000-120"); err != nil {
+		t.Errorf("Failed to decode negative offset; %s", err)
+	} else if _, offset := dst.Negative.Zone(); offset != -7200 || dst.Negative.UTC().Hour() != 7 {
+		t.Errorf("Unexpected negative offset decoding; %s", dst.Negative)
+	}
+	if err := unmarshalSimpleValue(v.Field(1), "2020030405"); err == nil {
+		t.Errorf("Expected error for malformed datetime")
+	}
+}
+
+func TestCIMDateTime_Raw(t *testing.T) {
+	var dst struct {
+		Created CIMDateTime
+		Dates   []CIMDateTime
+	}
+	v := reflect.ValueOf(&dst).Elem()
+	const raw = "20200304050607.123456+060"
+	if err := unmarshalSimpleValue(v.Field(0), raw); err != nil || dst.Created != raw {
+		t.Fatalf("Raw datetime isn't kept; got %q, %v", dst.Created, err)
+	}
+	parsed, err := dst.Created.Time()
+	if err != nil {
+		t.Fatalf("Failed to parse %q; %s", dst.Created, err)
+	}
+	if FromTime(parsed) != raw {
+		t.Errorf("Unexpected round trip of %q; got %q", raw, FromTime(parsed))
+	}
+	if _, err := CIMDateTime("00000000000130.000000:000").Time(); err == nil {
+		t.Errorf("Expected error for interval")
+	}
+
+	dst.Dates = []CIMDateTime{raw, FromTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))}
+	for i, expected := range []interface{}{raw, []string{raw, "20200101000000.000000+000"}} {
+		if got, err := marshalValue(v.Field(i)); err != nil || !reflect.DeepEqual(got, expected) {
+			t.Errorf("Unexpected marshalled value of field %d; got %v, %v, expected %v", i, got, err, expected)
+		}
+	}
+}
@@ -3,12 +3,18 @@
 package wmi
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/bi-zone/go-ole"
 	"github.com/bi-zone/go-ole/oleutil"
@@ -19,10 +25,25 @@ import (
 // object of themselves.
 //
 // N.B. Unmarshaler currently can't be implemented to non structure types!
+//
+// Implementations could use `Properties` to get all the @src property values
+// at once instead of fetching them one by one.
 type Unmarshaler interface {
 	UnmarshalOLE(d Decoder, src *ole.IDispatch) error
 }
 
+// ContextUnmarshaler is the richer variant of Unmarshaler getting the context
+// of the query the object is decoded for, e.g. to stop the expensive per-object
+// work once the `SWbemServicesConnection.QueryContext` is cancelled. It's
+// preferred over Unmarshaler if a type implements both.
+//
+// @ctx is `context.Background()` if the decoding isn't bound to any context.
+// @d is the Decoder with the caller settings (e.g. `Decoder.TimeLocation`),
+// its `Unmarshal` calls for the nested objects get the same @ctx.
+type ContextUnmarshaler interface {
+	UnmarshalOLEContext(ctx context.Context, d Decoder, src *ole.IDispatch) error
+}
+
 // Dereferencer is anything that can fetch WMI objects using its object path.
 // Used to retrieve object from CIM reference strings, e.g. from
 // `Win32_LoggedOnUser`.
@@ -41,14 +62,22 @@ type Decoder struct {
 	// should be returned as the field types zero value.
 	//
 	// Setting this to true allows structs without pointer fields to be used
-	// without the risk failure should a nil value returned from WMI.
+	// without the risk failure should a nil value returned from WMI. The
+	// fields are reset to zero for NULL values and, if `AllowMissingFields`
+	// is set, for the missing properties, so nothing is left from the
+	// previous contents of the reused destination struct. Otherwise such
+	// fields are left untouched.
 	NonePtrZero bool
 
 	// PtrNil specifies if nil values for pointer fields should be returned
 	// as nil.
 	//
-	// Setting this to true will set pointer fields to nil where WMI
-	// returned nil, otherwise the types zero value will be returned.
+	// NULL (VT_NULL or VT_EMPTY) values never allocate the pointee, so the
+	// pointer fields of a fresh destination are always left nil. Setting
+	// this to true also resets the pointer fields of the reused destination
+	// struct to nil where WMI returned nil, otherwise they are left
+	// untouched. Empty strings aren't NULL: `*string` field receives
+	// a pointer to "", so "present but empty" differs from nil.
 	PtrNil bool
 
 	// AllowMissingFields specifies that struct fields not present in the
@@ -58,6 +87,90 @@ type Decoder struct {
 	// struct definitions instead of having to define multiple structs.
 	AllowMissingFields bool
 
+	// RequireAllFields specifies that every struct field should have a
+	// property in the unmarshalled object, e.g. to catch the typos and the
+	// schema drift between Windows versions. Unlike the default behaviour,
+	// all the unmatched fields are reported by a single ErrMissingFields
+	// error, which isn't a "soft" ErrFieldMismatch. Takes precedence over
+	// AllowMissingFields. Fields skipped with `wmi:"-"` and composite fields
+	// aren't checked.
+	RequireAllFields bool
+
+	// AllowDuplicateKeys specifies that objects with the same key loaded by
+	// `QueryMap` (or by `Query` into a map keyed by the `key` field) should
+	// overwrite each other instead of resulting in an error.
+	AllowDuplicateKeys bool
+
+	// FirstRowOnly specifies that a query into a single structure should
+	// take the first object if multiple objects are returned instead of
+	// resulting in ErrMultipleResults.
+	FirstRowOnly bool
+
+	// ReuseElements specifies that a query into the slice reusing its backing
+	// array (see `SWbemServicesConnection.Query`) should decode the objects
+	// into the existing element values (or the structs pointed by non-nil
+	// pointer elements) instead of the freshly allocated ones.
+	//
+	// Notice that the fields missing in the object (with AllowMissingFields)
+	// and the pointer fields of the reused values keep the previous contents
+	// unless NonePtrZero and PtrNil are set. Pointee structs are shared with
+	// the results of the previous query.
+	//
+	// Neither the backing array nor the elements are reused by
+	// `Client.QueryContext` with a cancellable context (including the
+	// queries with `WithContext` option), see its doc for the reason.
+	ReuseElements bool
+
+	// QueryFlags are OR-ed into the flags of `SWbemServices.ExecQuery` call
+	// made by the queries, see the QueryFlag constants. The queries always
+	// use WBEM_FLAG_RETURN_IMMEDIATELY (and WBEM_FLAG_FORWARD_ONLY if
+	// `QueryOptions.ForwardOnly` is set), zero means no extra flags.
+	QueryFlags int32
+
+	// MaxPropertySize specifies the maximum size in bytes of string and slice
+	// values. Longer values are truncated and ErrFieldMismatch is reported
+	// after the whole object is decoded. Zero means no limit. Could be
+	// overridden for the specific field using `maxsize` tag option, e.g.
+	//   Message string `wmi:",maxsize=4096"`
+	//
+	// Notice that the original property value is still received from WMI,
+	// the option only limits the memory retained by the results.
+	MaxPropertySize int
+
+	// EmptyArrayAsNil specifies that empty arrays should be returned as nil
+	// slices (or nil pointers for slice pointer fields) same as NULL values.
+	// By default empty arrays are returned as empty non-nil slices.
+	EmptyArrayAsNil bool
+
+	// WarnLossy specifies that numeric conversions with a loss of data (e.g.
+	// a large uint64 into float64) should be reported as ErrFieldMismatch.
+	// The value is set anyway and ErrFieldMismatch is reported after the
+	// whole object is decoded. Integer overflows are always reported, see
+	// `Decoder.Unmarshal`.
+	WarnLossy bool
+
+	// EnumValues registers the labels of the coded integer properties (e.g.
+	// `Win32_LogicalDisk.DriveType`) by the field type. Fields of the
+	// registered types (which should be string kinds) are unmarshalled into
+	// the label of the property code, unknown codes are unmarshalled as
+	// decimal strings. Fields of other string types could use `enum` tag
+	// option to take the labels from the property qualifiers, see
+	// `Decoder.Unmarshal`.
+	//
+	// Labels of the `enum` fields are taken from the amended class definition
+	// (query results don't hold the localized qualifiers), registering them
+	// here avoids that lookup and makes the labels locale-independent.
+	EnumValues map[reflect.Type]map[int64]string
+
+	// OnError is an optional callback invoked by the queries for every object
+	// that fails to decode (ErrFieldMismatch is not a failure) with its
+	// @index in the query result and the decoding error. Returning true skips
+	// the object and continues the query, false aborts it with the @err. By
+	// default the query is aborted on the first failure. OnError isn't used
+	// for the single struct destinations and isn't called for the failures
+	// of the enumeration itself, see PartialResultError.
+	OnError func(index int, err error) bool
+
 	// Dereferencer specifies an interface to resolve reference fields.
 	// Dereferencer will be invoked on the fields tagged with ",ref" tag, e.g.
 	//     Field Type `wmi:"FieldName,ref"
@@ -69,6 +182,82 @@ type Decoder struct {
 	// Dereferencer is automatically set by all query calls. Setting it to nil
 	// will cause all fields tagged as references to return resolution error.
 	Dereferencer Dereferencer
+
+	// DecimalSeparator specifies the decimal separator of the floats received
+	// as strings (e.g. "," for the values formatted with German locale). By
+	// default strings are parsed in a locale-independent format with "."
+	// separator regardless of the OS locale.
+	DecimalSeparator string
+
+	// DisallowCaseConflicts specifies that fields mapped to the properties
+	// which names differ only in case (e.g. `Name` and `name` exposed by some
+	// misbehaving providers) should result in ErrFieldMismatch.
+	//
+	// By default the property is resolved case-insensitively by the COM
+	// object itself, so it's up to the provider which of the variants is
+	// used. Enabling the option requires to fetch the names of all the
+	// properties of every object.
+	DisallowCaseConflicts bool
+
+	// TimeLocation specifies the location all the decoded time.Time values
+	// (including *time.Time and []time.Time fields) are converted into, e.g.
+	// time.UTC or time.Local. By default the time zone offset of the
+	// CIM_DATETIME value is preserved as a fixed zone.
+	TimeLocation *time.Location
+
+	// CaseSensitive specifies that the property name should match the field
+	// name (or the name from the "wmi" tag) exactly, otherwise the property
+	// is considered missing (see `AllowMissingFields`). System properties
+	// (e.g. `__PATH`) are always matched case-insensitively.
+	//
+	// By default WMI property names are case-insensitive and any casing of
+	// the name is accepted, e.g. `Processid` field is loaded from the
+	// `ProcessId` property. The name is resolved in the order: explicit name
+	// from the "wmi" tag, the field name (with an optional prefix), the
+	// alternate names from the `alt` option. Enabling the option requires to
+	// fetch the names of all the properties of every object.
+	CaseSensitive bool
+
+	// ClassTypes specifies the types embedded objects should be unmarshalled
+	// into if the field type is an interface. Keys are the class names, the
+	// type registered for the object class (or for its closest parent) is
+	// used. The field is assigned with a pointer to the new value of the type
+	// if the pointer implements the interface or with the value itself, e.g.
+	//   d.ClassTypes = map[string]reflect.Type{
+	//       "Win32_Process": reflect.TypeOf(Process{}),
+	//       "Win32_Service": reflect.TypeOf(Service{}),
+	//   }
+	//   type Event struct {
+	//       Instance Resource `wmi:"TargetInstance"` // Either *Process or *Service.
+	//   }
+	ClassTypes map[string]reflect.Type
+
+	// RawTypes is called after an object is unmarshalled into the struct
+	// @dst with the VARIANT types of its decoded properties keyed by the
+	// property names, e.g. to re-encode the values of interface{} fields with
+	// the same types for the method calls. It's called for the embedded
+	// objects as well.
+	RawTypes func(dst interface{}, types map[string]ole.VT)
+
+	// composites holds handlers of the fields registered using
+	// `Decoder.Composite`.
+	composites map[string]compositeField
+
+	// caseConflicts holds properties conflicting by case of the object being
+	// unmarshalled. See `DisallowCaseConflicts`.
+	caseConflicts map[string][]string
+	// exactNames holds the property names of the object being unmarshalled
+	// if `CaseSensitive` is set.
+	exactNames map[string]bool
+	// rawTypes collects the property types of the object being unmarshalled
+	// if `RawTypes` is set.
+	rawTypes map[string]ole.VT
+	// uint32Array is set if the property being unmarshalled is a CIM uint32
+	// array, see `isUint32Array`.
+	uint32Array bool
+	// ctx is the context passed to ContextUnmarshaler implementations, see
+	// `Decoder.UnmarshalContext`.
+	ctx context.Context
 }
 
 // ErrFieldMismatch is returned when a field is to be loaded into a different
@@ -86,7 +275,11 @@ func (e ErrFieldMismatch) Error() string {
 		e.FieldName, e.FieldType, e.Reason)
 }
 
-var timeType = reflect.TypeOf(time.Time{})
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	objectMapType = reflect.TypeOf(map[string]interface{}{})
+)
 
 // Unmarshal loads `ole.IDispatch` into a struct pointer.
 // N.B. Unmarshal supports only limited subset of structure field
@@ -94,15 +287,36 @@ var timeType = reflect.TypeOf(time.Time{})
 //   - all signed and unsigned integers
 //   - uintptr
 //   - time.Time
+//   - time.Duration for CIM_DATETIME intervals, see `ParseCIMInterval`
+//   - `CIMDateTime` for the raw CIM_DATETIME strings
 //   - string
 //   - bool
-//   - float32
+//   - float32, float64
 //   - a pointer to one of types above
 //   - a slice of one of thus types
-//   - structure types.
+//   - a slice of structures (or pointers to them) for arrays or collections
+//     of embedded objects, e.g. `SWbemObjectSet` method out parameters
+//   - a map[int]T of one of thus types (for arrays, see below)
+//   - map[string]interface{} for schema-less objects (as @dst itself), see
+//     below
+//   - structure types (or pointers to them) for embedded objects, e.g. the
+//     event `TargetInstance`. Embedded objects are unmarshalled recursively,
+//     nil objects leave the field zero (or nil for pointers).
+//   - interface{} for any simple value or array. Values are converted by the
+//     property CIM type into int64 (uint64 if it doesn't fit), float64,
+//     bool, string, time.Time, []string or []interface{}. Use
+//     `Decoder.RawTypes` to get the original VARIANT types. Embedded objects
+//     are unmarshalled into interfaces using `Decoder.ClassTypes`.
+//
+// If @dst is a pointer to `map[string]interface{}` it's replaced with the map
+// of all the object properties keyed by their names. Values are converted
+// according to their CIM types in the same way as in
+// `SWbemServicesConnection.QueryTable`, NULL values are mapped to nil.
 //
 // To unmarshal more complex struct consider implementing `wmi.Unmarshaler`.
 // For such types Unmarshal just calls `.UnmarshalOLE` on the @src object .
+// `wmi.ContextUnmarshaler` is preferred if implemented, see
+// `Decoder.UnmarshalContext`.
 //
 // To unmarshal COM-object into a struct, Unmarshal tries to fetch COM-object
 // properties for each public struct field using as a property name either
@@ -113,7 +327,15 @@ var timeType = reflect.TypeOf(time.Time{})
 //
 // Unmarshal does some "smart" type conversions between integer types (including
 // unsigned ones), so you could receive e.g. `uint32` into `uint` if you don't
-// care about the size.
+// care about the size. Integers could also be received into float fields.
+// Integers that don't fit into the field type (e.g. 300 into `int8` or -1
+// into `uint`) are never wrapped: the field is left zero and ErrFieldMismatch
+// is reported. Set `.WarnLossy` to get notified about the other conversions
+// loosing data (e.g. into floats). CIM uint32 values keep their magnitude in
+// the signed fields, e.g. ProcessId 3000000000 is received into `int` as is
+// and into `int32` as an overflow. The CIM type is checked for that, so the
+// negative sint32 values (e.g. `Win32_TimeZone.Bias`) overflow the unsigned
+// fields.
 //
 // Unmarshal allows to specify special COM-object property name or skip a field
 // using structure field tags, e.g.
@@ -128,6 +350,74 @@ var timeType = reflect.TypeOf(time.Time{})
 //	 Field  Type `wmi:"FieldName,ref"
 //	 Field2 Type `wmi:",ref"
 //
+//   // Will be filled from property `Win32_Name`. Prefix could also be set for
+//   // all the structure fields using a blank field `_ struct{} wmi:",prefix=Win32_"`.
+//   Name string `wmi:",prefix=Win32_"`
+//
+//   // Integer property will be unmarshalled into bool (0 is false, anything
+//   // else is true), boolean property into integer (0 or 1).
+//   Enabled bool `wmi:",intbool"`
+//
+//   // Numeric property (or every element of numeric array) will be
+//   // multiplied by the scale, e.g. to convert kilobytes into bytes.
+//   FreePhysicalMemory uint64 `wmi:",scale=1024"`
+//
+//   // Method parameter with `ID` qualifier equal to 3 (useful for method
+//   // parameters objects when names are ambiguous).
+//   PID uint32 `wmi:",paramid=3"`
+//
+//   // Array property will be loaded into the map keyed by the element
+//   // index. Zero elements are skipped to save memory on sparse arrays,
+//   // use `keepzero` to load all of them.
+//   Metrics  map[int]uint64 `wmi:"GatewayCostMetric"`
+//   Metrics2 map[int]uint64 `wmi:"GatewayCostMetric,keepzero"`
+//
+//   // Will be filled from property `NewName` if it exists, otherwise from
+//   // alternate properties `OldName` or `OlderName` in the order specified.
+//   Value string `wmi:"NewName,alt=OldName|OlderName"`
+//
+//   // Integer property will be unmarshalled as Unix time in seconds,
+//   // milliseconds (`unixms`) or nanoseconds (`unixns`).
+//   LastBootUpTime time.Time `wmi:",unix"`
+//
+//   // Integer property will be unmarshalled into the labels of the set bits
+//   // taken from `BitValues` and `BitMap` qualifiers of the class property.
+//   Suites []string `wmi:"SuiteMask,flags"`
+//
+//   // Coded integer property will be unmarshalled into the label of the code
+//   // taken from `Values` and `ValueMap` qualifiers of the class property
+//   // (or from `Decoder.EnumValues` if the field type is registered there).
+//   DriveType string `wmi:"DriveType,enum"`
+//
+//   // Embedded object which class (or one of its parents) should be
+//   // `Win32_Process`, otherwise an error is returned.
+//   Instance Win32_Process `wmi:"TargetInstance,class=Win32_Process"`
+//
+//   // NULL (or missing with `AllowMissingFields`) property will be
+//   // unmarshalled as the default value parsed according to the field type
+//   // (strings are taken as is, bools are parsed by strconv.ParseBool). The
+//   // value can't contain commas.
+//   ExecutablePath string `wmi:"ExecutablePath,default=unknown"`
+//
+//   // Integer array property will be unmarshalled as the string of UTF-16
+//   // code units (trailing NULs are dropped). The option isn't required for
+//   // string fields of CIM uint16 arrays, but forces the conversion for other
+//   // integer arrays and interface{} fields. []uint16 fields get the raw code
+//   // units.
+//   Name string `wmi:"Name,utf16"`
+//
+// Fields of the embedded structs (or struct pointers of exported types) are
+// unmarshalled as the fields of the outer struct unless the property name is
+// set in the tag of the embedded field. Nil struct pointers are allocated.
+//   type Base struct {
+//       Path  string `wmi:"__PATH"`
+//       Class string `wmi:"__CLASS"`
+//   }
+//   type process struct {
+//       Base
+//       Name string
+//   }
+//
 // Unmarshal prefers tag value over the field name, but ignores any name collisions.
 // So for example all the following fields will be resolved to the same value.
 //   Field  int
@@ -135,77 +425,1383 @@ var timeType = reflect.TypeOf(time.Time{})
 //   Field2 int `wmi:"Field"`
 func (d Decoder) Unmarshal(src *ole.IDispatch, dst interface{}) (err error) {
 	defer func() {
-		// We use lots of reflection, so always be alert!
-		if r := recover(); r != nil {
-			err = fmt.Errorf("runtime panic: %v", r)
+		// We use lots of reflection, so always be alert!
+		if r := recover(); r != nil {
+			err = fmt.Errorf("runtime panic: %v", r)
+		}
+	}()
+
+	// Checks whether the type can handle unmarshalling of himself.
+	if ok, err := d.unmarshalCustom(src, dst); ok {
+		return err
+	}
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("%w; expected non-nil pointer, got %T", ErrInvalidEntityType, dst)
+	}
+	return d.UnmarshalValue(src, v.Elem())
+}
+
+// UnmarshalContext unmarshals @src into @dst like `Decoder.Unmarshal` does,
+// passing @ctx to the ContextUnmarshaler implementations (including the ones
+// of the embedded objects).
+func (d Decoder) UnmarshalContext(ctx context.Context, src *ole.IDispatch, dst interface{}) error {
+	d.ctx = ctx
+	return d.Unmarshal(src, dst)
+}
+
+// unmarshalCustom calls the custom unmarshaler of @dst preferring
+// ContextUnmarshaler over Unmarshaler. False is returned if @dst implements
+// neither.
+func (d Decoder) unmarshalCustom(src *ole.IDispatch, dst interface{}) (ok bool, err error) {
+	switch u := dst.(type) {
+	case ContextUnmarshaler:
+		ctx := d.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return true, u.UnmarshalOLEContext(ctx, d, src)
+	case Unmarshaler:
+		return true, u.UnmarshalOLE(d, src)
+	}
+	return false, nil
+}
+
+// UnmarshalValue loads OLE object @src into the value @v itself like
+// `Decoder.Unmarshal` does for the value pointed by its argument. It's
+// intended for the callers that have only reflect.Value of the destination.
+// @v should be a settable (e.g. obtained via `reflect.New(t).Elem()`)
+// struct or `map[string]interface{}`.
+func (d Decoder) UnmarshalValue(src *ole.IDispatch, v reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("runtime panic: %v", r)
+		}
+	}()
+
+	if !v.IsValid() || !v.CanSet() {
+		return fmt.Errorf("%w; destination value isn't settable", ErrInvalidEntityType)
+	}
+	dst := v.Addr().Interface()
+	if ok, err := d.unmarshalCustom(src, dst); ok {
+		return err
+	}
+	if m, ok := dst.(*map[string]interface{}); ok {
+		return unmarshalObjectMap(src, m)
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("%w; can't unmarshal object into %s", ErrInvalidEntityType, v.Type())
+	}
+
+	fields := cachedFields(v.Type())
+	if d.DisallowCaseConflicts || d.CaseSensitive {
+		names, err := propertyNames(src)
+		if err != nil {
+			return err
+		}
+		if d.DisallowCaseConflicts {
+			d.caseConflicts = caseConflicts(names)
+		}
+		if d.CaseSensitive {
+			d.exactNames = make(map[string]bool, len(names))
+			for _, name := range names {
+				d.exactNames[name] = true
+			}
+		}
+	}
+	if d.RawTypes != nil {
+		d.rawTypes = make(map[string]ole.VT, len(fields))
+	}
+	var warning error
+	var missing []string
+	for i := range fields {
+		field := &fields[i]
+		f, ok := fieldByIndex(v, field.index, true)
+		if !ok {
+			continue // Unexported nil embedded struct pointer.
+		}
+		if c, ok := d.composites[field.Name]; ok && f.CanSet() {
+			err = c.unmarshal(src, f)
+		} else {
+			err = d.unmarshalField(src, f, field)
+		}
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(errMissingField); ok {
+			missing = append(missing, field.Name)
+			continue
+		}
+		mismatch := ErrFieldMismatch{
+			FieldType: field.Type,
+			FieldName: field.Name,
+			Reason:    err.Error(),
+		}
+		if _, ok := err.(fieldWarning); !ok {
+			return mismatch
+		}
+		if warning == nil {
+			warning = mismatch
+		}
+	}
+
+	if d.RawTypes != nil {
+		d.RawTypes(dst, d.rawTypes)
+	}
+	if len(missing) > 0 {
+		return ErrMissingFields{Fields: missing}
+	}
+	return warning
+}
+
+// errMissingField is returned by `Decoder.unmarshalField` if there is no
+// property for the field and `Decoder.RequireAllFields` is set.
+type errMissingField string
+
+func (e errMissingField) Error() string {
+	return fmt.Sprintf("no result field %q", string(e))
+}
+
+// fieldWarning is returned by `Decoder.unmarshalField` if the field value has
+// been set, but with a loss of data. In such case Unmarshal continues to
+// decode other fields and returns ErrFieldMismatch describing the first
+// warning at the end.
+type fieldWarning string
+
+func (w fieldWarning) Error() string {
+	return string(w)
+}
+
+// structField describes the structure field unmarshalled by
+// `Decoder.Unmarshal`.
+type structField struct {
+	reflect.StructField
+	index   []int  // Index sequence for the fields of the embedded structs.
+	name    string // COM-object property name, see `getFieldName`.
+	options tagOptions
+
+	// defaultValue is the parsed `default` option value of the field type
+	// (or of the pointee type for pointers), if any.
+	defaultValue reflect.Value
+	defaultErr   error // The `default` option parse error.
+}
+
+// skipped reports whether the field isn't mapped to any property, i.e. it's
+// unexported (even if tagged), blank or tagged as `wmi:"-"` (with any
+// options). Composite fields (see `Decoder.Composite`) are filled by name
+// regardless of the tag.
+func (f structField) skipped() bool {
+	return f.PkgPath != "" || f.Name == "_" || f.name == "-"
+}
+
+// structFieldsCache caches `[]structField` of the unmarshalled structure
+// types keyed by their reflect.Type, so the tags are parsed only once.
+var structFieldsCache sync.Map
+
+// cachedFields returns descriptions of all the fields of the structure type
+// @t in order of their declaration. Fields of the embedded structs are
+// flattened, see `collectFields`.
+func cachedFields(t reflect.Type) []structField {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]structField)
+	}
+	fields := collectFields(t, nil)
+	cached, _ := structFieldsCache.LoadOrStore(t, fields)
+	return cached.([]structField)
+}
+
+// collectFields describes the fields of the structure type @t replacing the
+// anonymous struct (or struct pointer) fields without explicit property name
+// by the fields of the embedded structs. Like in Go, the fields of the outer
+// struct shadow the promoted fields with the same name. @parents are the
+// embedding struct types, they aren't flattened again to break the cycles.
+func collectFields(t reflect.Type, parents []reflect.Type) []structField {
+	structOpts := structOptions(t)
+	fields := make([]structField, 0, t.NumField())
+	declared := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		declared[t.Field(i).Name] = true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		fType := t.Field(i)
+		if embedded, ok := embeddedStruct(fType); ok && !containsType(parents, embedded) {
+			for _, field := range collectFields(embedded, append(parents, t)) {
+				if declared[field.Name] {
+					continue // Shadowed.
+				}
+				field.index = append([]int{i}, field.index...)
+				fields = append(fields, field)
+			}
+			continue
+		}
+		name, options := getFieldName(fType, structOpts)
+		field := structField{StructField: fType, index: []int{i}, name: name, options: options}
+		if s, ok := options.Value("default"); ok {
+			field.defaultValue, field.defaultErr = parseDefault(fType.Type, s)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// embeddedStruct returns the struct type of the anonymous field @f if its
+// fields should be flattened, i.e. the field is a struct (or a struct
+// pointer) without the property name in the tag.
+func embeddedStruct(f reflect.StructField) (reflect.Type, bool) {
+	if !f.Anonymous {
+		return nil, false
+	}
+	if name := strings.SplitN(f.Tag.Get("wmi"), ",", 2)[0]; name != "" {
+		return nil, false
+	}
+	t := f.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil, false
+	}
+	return t, true
+}
+
+// containsType checks if @t is one of @types.
+func containsType(types []reflect.Type, t reflect.Type) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldByIndex returns the (possibly embedded) field of the struct @v by the
+// @index sequence. Nil embedded struct pointers on the way are allocated if
+// @alloc is set, otherwise (or if the pointer can't be set) false is
+// returned.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func (d Decoder) unmarshalField(src *ole.IDispatch, f reflect.Value, field *structField) (err error) {
+	fieldName, options := field.name, field.options
+	if !f.CanSet() || field.skipped() {
+		return nil
+	}
+
+	// Method parameters could be resolved by ID qualifier.
+	if v, ok := options.Value("paramid"); ok {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid paramid option %q", v)
+		}
+		if fieldName, err = parameterName(src, id); err != nil {
+			return err
+		}
+	}
+
+	if variants, ok := d.caseConflicts[strings.ToLower(fieldName)]; ok {
+		return fmt.Errorf("ambiguous property %q; object has properties %q", fieldName, variants)
+	}
+
+	clearVariant := func(p *ole.VARIANT) {
+		if clErr := p.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}
+
+	// Fetch property from the COM object trying alternate names if needed.
+	prop, err := d.getProperty(src, fieldName)
+	propName := fieldName
+	if alts, ok := options.Value("alt"); ok && err != nil {
+		for _, alt := range strings.Split(alts, "|") {
+			if prop, err = d.getProperty(src, alt); err == nil {
+				propName = alt
+				break
+			}
+		}
+	}
+	if err != nil {
+		if d.RequireAllFields {
+			return errMissingField(fieldName)
+		}
+		if d.AllowMissingFields {
+			return d.unmarshalDefault(f, field)
+		}
+		return fmt.Errorf("no result field %q", fieldName)
+	}
+	defer clearVariant(prop)
+	if d.rawTypes != nil {
+		d.rawTypes[propName] = prop.VT
+	}
+	d.uint32Array = isUint32Array(src, propName, prop, f)
+	prop = unsignedVariant(src, propName, prop, f) // The original VARIANT is cleared.
+	prop, isChar := char16Variant(src, propName, prop, f)
+
+	if isNullVariant(prop) {
+		return d.unmarshalDefault(f, field)
+	}
+
+	// If it's a reference field and we have Dereferencer - resolve it.
+	if options.Contains("ref") {
+		if d.Dereferencer == nil {
+			return errors.New("failed to dereference ref field; no Decoder.Dereferencer set")
+		}
+		refPath := prop.ToString()
+		prop, err = d.Dereferencer.Dereference(refPath)
+		if err != nil {
+			return err
+		}
+		defer clearVariant(prop)
+	}
+
+	// Coerce integers to booleans and vice versa if asked.
+	if options.Contains("intbool") {
+		prop = intBoolVariant(prop, f.Type())
+	}
+
+	// If embedded object class is specified - ensure it matches.
+	if class, ok := options.Value("class"); ok {
+		if err := checkObjectClass(prop, class); err != nil {
+			return err
+		}
+	}
+
+	if unit, ok := unixTimeUnit(options); ok {
+		t, err := unixTime(prop.Value(), unit)
+		if err != nil {
+			return err
+		}
+		if err := setTime(f, t); err != nil {
+			return err
+		}
+	} else if options.Contains("flags") {
+		labels, err := d.flagLabels(src, propName, prop.Value())
+		if err != nil {
+			return err
+		}
+		if f.Type() != reflect.TypeOf(labels) {
+			return fmt.Errorf("can't unmarshal flags into %s", f.Type())
+		}
+		f.Set(reflect.ValueOf(labels))
+	} else if values, ok := d.EnumValues[f.Type()]; ok || options.Contains("enum") {
+		if f.Kind() != reflect.String {
+			return fmt.Errorf("can't unmarshal enum into %s", f.Type())
+		}
+		label, err := d.enumLabel(src, propName, prop.Value(), values)
+		if err != nil {
+			return err
+		}
+		f.SetString(label)
+	} else if isChar && kindOf(f.Type()) == reflect.String {
+		setString(f, string(rune(prop.Val)))
+	} else if isUTF16Array(src, propName, prop, f, options.Contains("utf16")) {
+		str, err := utf16String(prop)
+		if err != nil {
+			return fmt.Errorf("property %q; %v", fieldName, err)
+		}
+		setString(f, str)
+	} else if f.Kind() == reflect.Interface && !isObjectVariant(prop) {
+		v, err := interfaceValue(src, propName, prop)
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			f.Set(reflect.ValueOf(v))
+		}
+	} else if f.Kind() == reflect.Map {
+		// Sparse array.
+		safeArray := prop.ToArray()
+		if safeArray == nil {
+			return fmt.Errorf("can't unmarshal %s into map", prop.VT)
+		}
+		arr := safeArray.ToValueArray()
+		if d.uint32Array {
+			for i, v := range arr {
+				if i32, ok := v.(int32); ok {
+					arr[i] = uint32(i32)
+				}
+			}
+		}
+		if err := unmarshalSparse(f, arr, options.Contains("keepzero")); err != nil {
+			return err
+		}
+	} else if err := d.unmarshalValue(f, prop); err != nil {
+		if overflow, ok := err.(errIntOverflow); ok {
+			return fieldWarning(overflow.Error()) // Reported as ErrFieldMismatch.
+		}
+		return fmt.Errorf("property %q; %v", fieldName, err)
+	}
+
+	var warning error
+	if d.WarnLossy && isLossyConversion(prop.Value(), f) {
+		warning = fieldWarning(fmt.Sprintf("lossy conversion of %v into %s", prop.Value(), f.Type()))
+	}
+
+	// Scale numeric value if asked.
+	if v, ok := options.Value("scale"); ok {
+		scale, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || scale == 0 {
+			return fmt.Errorf("invalid scale option %q", v)
+		}
+		if err := scaleValue(f, scale); err != nil {
+			return err
+		}
+	}
+
+	// Normalize time zone if asked.
+	if d.TimeLocation != nil {
+		timeInLocation(f, d.TimeLocation)
+	}
+
+	// Cap the size of the value if needed.
+	maxSize := d.MaxPropertySize
+	if v, ok := options.Value("maxsize"); ok {
+		if maxSize, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid maxsize option %q", v)
+		}
+	}
+	if maxSize > 0 && truncateValue(f, maxSize) {
+		return fieldWarning(fmt.Sprintf("value truncated to %d bytes", maxSize))
+	}
+	return warning
+}
+
+// timeInLocation converts time.Time value of @f (or the values of *time.Time
+// and []time.Time) into @loc. Values of other types are left untouched.
+func timeInLocation(f reflect.Value, loc *time.Location) {
+	switch {
+	case f.Type() == timeType:
+		f.Set(reflect.ValueOf(f.Interface().(time.Time).In(loc)))
+	case f.Kind() == reflect.Ptr && f.Type().Elem() == timeType:
+		if !f.IsNil() {
+			timeInLocation(f.Elem(), loc)
+		}
+	case f.Kind() == reflect.Slice && f.Type().Elem() == timeType:
+		for i := 0; i < f.Len(); i++ {
+			timeInLocation(f.Index(i), loc)
+		}
+	}
+}
+
+// unsignedVariant returns the negative VT_I4 @prop of CIM uint32 @property
+// as VT_UI4, so it's neither sign-extended when unmarshalled into a signed
+// integer @f nor taken as an overflow of an unsigned one. The scripting API
+// passes CIM uint32 values above math.MaxInt32 as negative VT_I4. Other
+// values (including the negative sint32 ones) are returned as is.
+func unsignedVariant(src *ole.IDispatch, property string, prop *ole.VARIANT, f reflect.Value) *ole.VARIANT {
+	if prop.VT != ole.VT_I4 || int32(prop.Val) >= 0 {
+		return prop
+	}
+	switch kindOf(f.Type()) {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Interface,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return prop
+	}
+	if !isCIMUint32(src, property) {
+		return prop
+	}
+	unsigned := ole.NewVariant(ole.VT_UI4, int64(uint32(prop.Val)))
+	return &unsigned
+}
+
+// isUint32Array reports if @prop is the VT_I4 array of CIM uint32 @property
+// unmarshalled into the slice (or sparse map) of unsigned integers @f, so the
+// negative elements should be taken as uint32, see `unsignedVariant`.
+func isUint32Array(src *ole.IDispatch, property string, prop *ole.VARIANT, f reflect.Value) bool {
+	if prop.VT != ole.VT_ARRAY|ole.VT_I4 {
+		return false
+	}
+	t := f.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Map {
+		return false
+	}
+	switch t.Elem().Kind() {
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return isCIMUint32(src, property)
+	}
+	return false
+}
+
+// isCIMUint32 reports if the CIM type of @property is uint32.
+func isCIMUint32(src *ole.IDispatch, property string) bool {
+	var cimType int64
+	err := withProperty(src, property, func(p *ole.IDispatch) (err error) {
+		cimType, err = oleInt64(p, "CIMType")
+		return err
+	})
+	return err == nil && CIMType(cimType) == CIMTypeUint32
+}
+
+// char16Variant returns the VT_I2 @prop of CIM char16 @property as VT_UI2
+// and reports if the property is char16. The scripting API passes char16
+// values as VT_I2, so the code units above math.MaxInt16 would be taken as
+// negative numbers otherwise. The CIM type is checked only for the negative
+// values and for the string @f, other values are returned as is.
+func char16Variant(src *ole.IDispatch, property string, prop *ole.VARIANT, f reflect.Value) (*ole.VARIANT, bool) {
+	if prop.VT != ole.VT_I2 || (int16(prop.Val) >= 0 && kindOf(f.Type()) != reflect.String) {
+		return prop, false
+	}
+	var cimType int64
+	err := withProperty(src, property, func(p *ole.IDispatch) (err error) {
+		cimType, err = oleInt64(p, "CIMType")
+		return err
+	})
+	if err != nil || CIMType(cimType) != CIMTypeChar16 {
+		return prop, false
+	}
+	unsigned := ole.NewVariant(ole.VT_UI2, int64(uint16(prop.Val)))
+	return &unsigned, true
+}
+
+// kindOf returns the kind of @t or of its element for pointers.
+func kindOf(t reflect.Type) reflect.Kind {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem().Kind()
+	}
+	return t.Kind()
+}
+
+// isUTF16Array checks if the array @prop of the @property should be
+// unmarshalled into @f as UTF-16 string. That is done for the string (or
+// string pointer) fields of CIM uint16 arrays, @force (`utf16` tag option)
+// allows any integer array and interface{} fields.
+func isUTF16Array(src *ole.IDispatch, property string, prop *ole.VARIANT, f reflect.Value, force bool) bool {
+	if prop.VT&ole.VT_ARRAY == 0 {
+		return false
+	}
+	t := f.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.String:
+	case t.Kind() == reflect.Interface && force:
+	default:
+		return false
+	}
+	if force || prop.VT == ole.VT_ARRAY|ole.VT_UI2 {
+		return true
+	}
+	// The scripting API passes CIM uint16 arrays as VT_I4 ones.
+	var cimType int64
+	err := withProperty(src, property, func(p *ole.IDispatch) (err error) {
+		cimType, err = oleInt64(p, "CIMType")
+		return err
+	})
+	return err == nil && CIMType(cimType) == CIMTypeUint16
+}
+
+// utf16String decodes the integer array @prop as UTF-16 code units.
+// Trailing NULs are dropped.
+func utf16String(prop *ole.VARIANT) (string, error) {
+	arr := prop.ToArray()
+	if arr == nil {
+		return "", fmt.Errorf("can't unmarshal %s into string", prop.VT)
+	}
+	values := arr.ToValueArray()
+	units := make([]uint16, len(values))
+	for i, v := range values {
+		rv := reflect.ValueOf(v)
+		var unit uint64
+		switch {
+		case v == nil:
+			return "", fmt.Errorf("NULL UTF-16 code unit at %d", i)
+		case isUnsignedKind(rv.Kind()):
+			unit = rv.Uint()
+		case rv.Kind() >= reflect.Int && rv.Kind() <= reflect.Int64 && rv.Int() >= 0:
+			unit = uint64(rv.Int())
+		default:
+			return "", fmt.Errorf("invalid UTF-16 code unit %v at %d", v, i)
+		}
+		if unit > math.MaxUint16 {
+			return "", fmt.Errorf("invalid UTF-16 code unit %v at %d", v, i)
+		}
+		units[i] = uint16(unit)
+	}
+	for len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// setString sets the string @s into the string, string pointer or
+// interface{} @f.
+func setString(f reflect.Value, s string) {
+	switch f.Kind() {
+	case reflect.Ptr:
+		v := reflect.New(f.Type().Elem())
+		v.Elem().SetString(s)
+		f.Set(v)
+	case reflect.Interface:
+		f.Set(reflect.ValueOf(s))
+	default:
+		f.SetString(s)
+	}
+}
+
+// interfaceValue converts the simple value (or array) @prop of the @property
+// into the value for interface{} field: integers become int64 (or uint64 if
+// they don't fit), floats become float64, CIM_DATETIME values become
+// time.Time and string arrays become []string. Other arrays are returned as
+// []interface{} of the converted values.
+func interfaceValue(src *ole.IDispatch, property string, prop *ole.VARIANT) (interface{}, error) {
+	var cimType int64
+	err := withProperty(src, property, func(p *ole.IDispatch) (err error) {
+		cimType, err = oleInt64(p, "CIMType")
+		return err
+	})
+	if err != nil {
+		cimType = 0 // E.g. system properties, the values are taken as is.
+	}
+	v, err := variantValue(prop, CIMType(cimType))
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := v.([]interface{})
+	if !ok {
+		return naturalValue(v), nil
+	}
+	strs := make([]string, 0, len(arr))
+	for i := range arr {
+		arr[i] = naturalValue(arr[i])
+		if s, ok := arr[i].(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	if len(strs) == len(arr) {
+		return strs, nil
+	}
+	return arr, nil
+}
+
+// naturalValue widens the integer and float values @v into int64 (uint64
+// if it doesn't fit) and float64. Other values are returned as is.
+func naturalValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case int8, int16, int32, int64, int:
+		return reflect.ValueOf(val).Int()
+	case uint8, uint16, uint32, uint64, uint:
+		u := reflect.ValueOf(val).Uint()
+		if u > math.MaxInt64 {
+			return u
+		}
+		return int64(u)
+	case float32:
+		return float64(val)
+	}
+	return v
+}
+
+// isUnsignedKind checks if @k is an unsigned integer kind.
+func isUnsignedKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// isLossyConversion checks if the numeric @src value has been converted into
+// @dst with a loss of data, e.g. a large integer into a float or an integer
+// into a narrower integer type.
+func isLossyConversion(src interface{}, dst reflect.Value) bool {
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			return false
+		}
+		dst = dst.Elem()
+	}
+
+	// Get the exact source value. Notice that 64-bit integers are
+	// represented as strings.
+	var exact big.Float
+	switch v := src.(type) {
+	case int8, int16, int32, int64, int:
+		exact.SetInt64(reflect.ValueOf(v).Int())
+		if i32, ok := v.(int32); ok && i32 < 0 && isUnsignedKind(dst.Kind()) {
+			exact.SetUint64(uint64(uint32(i32))) // CIM uint32 passed as VT_I4.
+		}
+	case uint8, uint16, uint32, uint64:
+		exact.SetUint64(reflect.ValueOf(v).Uint())
+	case float32:
+		exact.SetFloat64(float64(v))
+	case float64:
+		exact.SetFloat64(v)
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			exact.SetInt64(i)
+		} else if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+			exact.SetUint64(u)
+		} else {
+			return false
+		}
+	default:
+		return false
+	}
+
+	var got big.Float
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		got.SetInt64(dst.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		got.SetUint64(dst.Uint())
+	case reflect.Float32, reflect.Float64:
+		got.SetFloat64(dst.Float())
+	default:
+		return false
+	}
+	return exact.Cmp(&got) != 0
+}
+
+// scaleValue multiplies numeric @v (or every element of numeric slice @v) by
+// the @scale. Returns error if the result overflows @v type.
+func scaleValue(v reflect.Value, scale uint64) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		orig := v.Int()
+		scaled := orig * int64(scale)
+		if int64(scale) < 0 || (orig != 0 && scaled/orig != int64(scale)) || v.OverflowInt(scaled) {
+			return fmt.Errorf("value %d overflows %s when scaled by %d", orig, v.Type(), scale)
+		}
+		v.SetInt(scaled)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		orig := v.Uint()
+		scaled := orig * scale
+		if (orig != 0 && scaled/orig != scale) || v.OverflowUint(scaled) {
+			return fmt.Errorf("value %d overflows %s when scaled by %d", orig, v.Type(), scale)
+		}
+		v.SetUint(scaled)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(v.Float() * float64(scale))
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := scaleValue(v.Index(i), scale); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("can't scale %s value", v.Type())
+	}
+	return nil
+}
+
+// truncateValue truncates string or slice @v to fit into @maxSize bytes and
+// reports if @v has been truncated. The size of a slice is a sum of its
+// element sizes (string lengths for slices of strings). Other values are left
+// as is.
+func truncateValue(v reflect.Value, maxSize int) (truncated bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		str := v.String()
+		if len(str) <= maxSize {
+			return false
+		}
+		// Don't leave a broken rune at the end.
+		end := maxSize
+		for end > 0 && !utf8.RuneStart(str[end]) {
+			end--
+		}
+		// Copy the string to release the original one.
+		v.SetString(string([]byte(str[:end])))
+		return true
+	case reflect.Slice:
+		size, n := 0, 0
+		for ; n < v.Len(); n++ {
+			elem := v.Index(n)
+			elemSize := int(elem.Type().Size())
+			if elem.Kind() == reflect.String {
+				elemSize = elem.Len()
+			}
+			if size+elemSize > maxSize {
+				break
+			}
+			size += elemSize
+		}
+		if n == v.Len() {
+			return false
+		}
+		// Copy the elements to release the original array.
+		truncatedSlice := reflect.MakeSlice(v.Type(), n, n)
+		reflect.Copy(truncatedSlice, v)
+		v.Set(truncatedSlice)
+		return true
+	}
+	return false
+}
+
+// unmarshalObjectMap loads all the @src properties into the map @dst keyed by
+// the property names. Values are converted in the same way as in
+// `SWbemServicesConnection.QueryTable`.
+func unmarshalObjectMap(src *ole.IDispatch, dst *map[string]interface{}) error {
+	names, values, err := propertyValues(src)
+	if err != nil {
+		return err
+	}
+	m := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		m[name] = values[i]
+	}
+	*dst = m
+	return nil
+}
+
+// Properties returns all the properties of the COM object @src (e.g. passed
+// to `Unmarshaler.UnmarshalOLE`) keyed by their names, so custom unmarshalers
+// could discover the properties unknown in advance. Values are converted in
+// the same way as for `map[string]interface{}` destinations of
+// `Decoder.Unmarshal`, NULL values are mapped to nil.
+func Properties(src *ole.IDispatch) (props map[string]interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("runtime panic: %v", r)
+		}
+	}()
+
+	err = unmarshalObjectMap(src, &props)
+	return props, err
+}
+
+// unmarshalDefault handles the NULL (or missing) value of the @field setting
+// @f to the `default` option value if it's set, see `unmarshalNull` otherwise.
+func (d Decoder) unmarshalDefault(f reflect.Value, field *structField) error {
+	if field.defaultErr != nil {
+		return field.defaultErr
+	}
+	if !field.defaultValue.IsValid() {
+		d.unmarshalNull(f)
+		return nil
+	}
+	if f.Kind() == reflect.Ptr {
+		f.Set(reflect.New(f.Type().Elem()))
+		f = f.Elem()
+	}
+	f.Set(field.defaultValue)
+	return nil
+}
+
+// parseDefault parses the `default` option value @s into the value of type
+// @t (or of its pointee type).
+func parseDefault(t reflect.Type, s string) (reflect.Value, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	v := reflect.New(t).Elem()
+	var err error
+	if t.Kind() == reflect.Bool {
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v.SetBool(b)
+	} else {
+		err = smartUnmarshalString(v, s)
+	}
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("invalid default %q for %s; %v", s, t, err)
+	}
+	return v, nil
+}
+
+// unmarshalNull handles the NULL (or missing) value of the field @f resetting
+// it according to `NonePtrZero` and `PtrNil` options.
+func (d Decoder) unmarshalNull(f reflect.Value) {
+	isPtr := f.Kind() == reflect.Ptr
+	if (isPtr && d.PtrNil) || (!isPtr && d.NonePtrZero) {
+		f.Set(reflect.Zero(f.Type()))
+	}
+}
+
+// getProperty fetches the property @name of the @src object. If
+// `CaseSensitive` is set properties not matching the @name exactly are
+// reported as missing. System properties (e.g. `__PATH`, `__RELPATH`) are
+// read from `SWbemObject.SystemProperties_` if the object doesn't expose them
+// directly.
+func (d Decoder) getProperty(src *ole.IDispatch, name string) (*ole.VARIANT, error) {
+	if !strings.HasPrefix(name, "__") {
+		if d.CaseSensitive && !d.exactNames[name] {
+			return nil, fmt.Errorf("no property %q", name)
+		}
+		return oleutil.GetProperty(src, name)
+	}
+	if prop, err := oleutil.GetProperty(src, name); err == nil {
+		return prop, nil
+	}
+	return systemProperty(src, name)
+}
+
+// caseConflicts returns the lists of property @names differing only in case
+// keyed by the lowercase name.
+func caseConflicts(names []string) map[string][]string {
+	variants := make(map[string][]string, len(names))
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		variants[lower] = append(variants[lower], name)
+	}
+	for lower, v := range variants {
+		if len(v) < 2 {
+			delete(variants, lower)
+		}
+	}
+	return variants
+}
+
+// unmarshalInterface unmarshals the embedded object @src into a new value of
+// the type registered for its class in `Decoder.ClassTypes` and assigns it to
+// the interface @dst.
+func (d Decoder) unmarshalInterface(src *ole.IDispatch, dst reflect.Value) (err error) {
+	t, err := d.classType(src)
+	if err != nil {
+		return err
+	}
+	v := reflect.New(t)
+	if err := d.Unmarshal(src, v.Interface()); err != nil {
+		return err
+	}
+	switch {
+	case v.Type().AssignableTo(dst.Type()):
+		dst.Set(v)
+	case t.AssignableTo(dst.Type()):
+		dst.Set(v.Elem())
+	default:
+		return fmt.Errorf("type %s doesn't implement %s", t, dst.Type())
+	}
+	return nil
+}
+
+// classType returns a type registered in `Decoder.ClassTypes` for the class
+// of @src object or for its closest parent.
+func (d Decoder) classType(src *ole.IDispatch) (t reflect.Type, err error) {
+	lookup := func(class string) reflect.Type {
+		if t, ok := d.ClassTypes[class]; ok {
+			return t
+		}
+		for name, t := range d.ClassTypes {
+			if strings.EqualFold(name, class) {
+				return t
+			}
+		}
+		return nil
+	}
+
+	class, err := systemProperty(src, "__CLASS")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := class.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	if t := lookup(class.ToString()); t != nil {
+		return t, nil
+	}
+
+	derivation, err := systemProperty(src, "__DERIVATION")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := derivation.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	if arr := derivation.ToArray(); arr != nil {
+		for _, parent := range arr.ToStringArray() {
+			if t := lookup(parent); t != nil {
+				return t, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no type registered for class %q", class.ToString())
+}
+
+// parameterName returns the name of the @params object property with the
+// `ID` qualifier equal to @id. Method parameters objects have such qualifiers
+// set to parameter positions.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/standard-qualifiers
+func parameterName(params *ole.IDispatch, id int) (name string, err error) {
+	propsRaw, err := oleutil.GetProperty(params, "Properties_")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if clErr := propsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	errFound := errors.New("found")
+	err = forEach(propsRaw.ToIDispatch(), func(prop *ole.IDispatch) error {
+		qualifierID, err := qualifierValue(prop, "ID")
+		if err != nil || qualifierID == nil {
+			return nil // No such qualifier.
+		}
+		if i, ok := qualifierID.(int32); !ok || int(i) != id {
+			return nil
+		}
+		nameRaw, err := oleutil.GetProperty(prop, "Name")
+		if err != nil {
+			return err
+		}
+		name = nameRaw.ToString()
+		if err := nameRaw.Clear(); err != nil {
+			return err
+		}
+		return errFound
+	})
+	switch {
+	case err == errFound:
+		return name, nil
+	case err != nil:
+		return "", err
+	}
+	return "", fmt.Errorf("no parameter with ID %d", id)
+}
+
+// qualifierValue returns the value of @name qualifier of the WMI object,
+// property or method @obj.
+func qualifierValue(obj *ole.IDispatch, name string) (value interface{}, err error) {
+	qualifiersRaw, err := oleutil.GetProperty(obj, "Qualifiers_")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := qualifiersRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	qualifierRaw, err := oleutil.CallMethod(qualifiersRaw.ToIDispatch(), "Item", name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := qualifierRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	v, err := oleutil.GetProperty(qualifierRaw.ToIDispatch(), "Value")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := v.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
 		}
 	}()
+	if arr := v.ToArray(); arr != nil {
+		return arr.ToValueArray(), nil
+	}
+	return v.Value(), nil
+}
 
-	// Checks whether the type can handle unmarshalling of himself.
-	if u, ok := dst.(Unmarshaler); ok {
-		return u.UnmarshalOLE(d, src)
-	}
-
-	v := reflect.ValueOf(dst).Elem()
-	vType := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		f := v.Field(i)
-		fType := vType.Field(i)
-		if err = d.unmarshalField(src, f, fType); err != nil {
-			return ErrFieldMismatch{
-				FieldType: fType.Type,
-				FieldName: fType.Name,
-				Reason:    err.Error(),
+// flagLabels returns the labels of the bits set in the @value of the @obj
+// @property. Labels are taken from `BitValues` qualifier of the property, bit
+// positions are taken from `BitMap` qualifier if it's set. See
+// `Decoder.propertyQualifier` for where the qualifiers come from.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/standard-qualifiers
+func (d *Decoder) flagLabels(obj *ole.IDispatch, property string, value interface{}) (labels []string, err error) {
+	var mask uint64
+	if v, ok := value.(int32); ok {
+		value = uint32(v) // Bit mask, the sign bit is a flag as well.
+	}
+	if err := unmarshalSimpleValue(reflect.ValueOf(&mask).Elem(), value); err != nil {
+		return nil, fmt.Errorf("can't unmarshal %T as flags", value)
+	}
+
+	bitValues, err := d.propertyQualifier(obj, property, "BitValues")
+	if err != nil {
+		return nil, fmt.Errorf("no BitValues qualifier of property %q; %v", property, err)
+	}
+	bitMap, _ := d.propertyQualifier(obj, property, "BitMap") // Optional.
+	return setBitLabels(mask, bitValues, bitMap)
+}
+
+// propertyQualifier returns the value of @qualifier of the @obj @property.
+// Localized qualifiers like `BitValues` or `Values` are amended to the class
+// definition and aren't held by the query results, so if the Dereferencer is
+// a connection (as set by all query calls) the qualifier is taken from the
+// amended class of @obj. Otherwise it's taken from @obj itself.
+func (d *Decoder) propertyQualifier(obj *ole.IDispatch, property, qualifier string) (value interface{}, err error) {
+	if source, ok := d.Dereferencer.(qualifierSource); ok {
+		class, err := systemProperty(obj, "__CLASS")
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if clErr := class.Clear(); clErr != nil {
+				err = multierror.Append(err, clErr)
 			}
+		}()
+		return source.propertyQualifier(class.ToString(), property, qualifier)
+	}
+
+	err = withProperty(obj, property, func(prop *ole.IDispatch) error {
+		value, err = qualifierValue(prop, qualifier)
+		return err
+	})
+	return value, err
+}
+
+// enumLabel returns the label of the coded @value of the @obj @property.
+// Labels are taken from the @values map if it's not nil, otherwise from
+// `Values` qualifier of the property, codes of the labels are taken from
+// `ValueMap` qualifier if it's set. Codes without labels are returned as is.
+// See `Decoder.propertyQualifier` for where the qualifiers come from.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/standard-qualifiers
+func (d *Decoder) enumLabel(obj *ole.IDispatch, property string, value interface{}, values map[int64]string) (label string, err error) {
+	var code int64
+	if err := unmarshalSimpleValue(reflect.ValueOf(&code).Elem(), value); err != nil {
+		return "", fmt.Errorf("can't unmarshal %T as enum", value)
+	}
+	if values != nil {
+		if label, ok := values[code]; ok {
+			return label, nil
 		}
+		return strconv.FormatInt(code, 10), nil
 	}
 
-	return nil
+	labels, err := d.propertyQualifier(obj, property, "Values")
+	if err != nil {
+		return "", fmt.Errorf("no Values qualifier of property %q; %v", property, err)
+	}
+	valueMap, _ := d.propertyQualifier(obj, property, "ValueMap") // Optional.
+	return codeLabel(code, labels, valueMap)
 }
 
-func (d Decoder) unmarshalField(src *ole.IDispatch, f reflect.Value, fType reflect.StructField) (err error) {
-	fieldName, options := getFieldName(fType)
-	if !f.CanSet() || fieldName == "-" {
-		return nil
+// codeLabel returns the label of the @code from @labels. @valueMap holds the
+// codes of the labels as strings, if it's nil the label index is used as
+// a code.
+func codeLabel(code int64, labels, valueMap interface{}) (string, error) {
+	values, ok := labels.([]interface{})
+	if !ok {
+		return "", fmt.Errorf("unexpected Values qualifier %v", labels)
 	}
+	codes, _ := valueMap.([]interface{})
+	if codes != nil && len(codes) != len(values) {
+		return "", fmt.Errorf("ValueMap and Values qualifiers lengths differ")
+	}
+	for i, v := range values {
+		if codes == nil && int64(i) == code {
+			return fmt.Sprint(v), nil
+		}
+		if codes != nil && fmt.Sprint(codes[i]) == strconv.FormatInt(code, 10) {
+			return fmt.Sprint(v), nil
+		}
+	}
+	return strconv.FormatInt(code, 10), nil // Unknown or reserved code.
+}
 
-	clearVariant := func(p *ole.VARIANT) {
-		if clErr := p.Clear(); clErr != nil {
+// withProperty calls @f with the SWbemProperty object of the @obj @property.
+func withProperty(obj *ole.IDispatch, property string, f func(prop *ole.IDispatch) error) (err error) {
+	propsRaw, err := oleutil.GetProperty(obj, "Properties_")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if clErr := propsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	propRaw, err := oleutil.CallMethod(propsRaw.ToIDispatch(), "Item", property)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if clErr := propRaw.Clear(); clErr != nil {
 			err = multierror.Append(err, clErr)
 		}
+	}()
+	return f(propRaw.ToIDispatch())
+}
+
+// setBitLabels returns the @bitValues labels of the bits set in the @mask.
+// @bitMap holds the bit positions of the labels as strings, if it's nil the
+// label index is used as a position.
+func setBitLabels(mask uint64, bitValues, bitMap interface{}) ([]string, error) {
+	values, ok := bitValues.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected BitValues qualifier %v", bitValues)
+	}
+	positions, _ := bitMap.([]interface{})
+	if positions != nil && len(positions) != len(values) {
+		return nil, fmt.Errorf("BitMap and BitValues qualifiers lengths differ")
 	}
 
-	// Fetch property from the COM object.
-	prop, err := oleutil.GetProperty(src, fieldName)
-	if err != nil {
-		if d.AllowMissingFields {
-			return nil
+	labels := make([]string, 0)
+	for i, v := range values {
+		pos := i
+		if positions != nil {
+			p, err := strconv.Atoi(fmt.Sprint(positions[i]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid BitMap position %v", positions[i])
+			}
+			pos = p
+		}
+		if pos < 64 && mask&(1<<uint(pos)) != 0 {
+			labels = append(labels, fmt.Sprint(v))
 		}
-		return fmt.Errorf("no result field %q", fieldName)
 	}
-	defer clearVariant(prop)
+	return labels, nil
+}
+
+// intBoolVariant converts integer @prop into a boolean one if @dstType is
+// a bool (or pointer to bool) and boolean @prop into integer if @dstType is
+// an integer. Otherwise @prop is returned as is.
+func intBoolVariant(prop *ole.VARIANT, dstType reflect.Type) *ole.VARIANT {
+	if dstType.Kind() == reflect.Ptr {
+		dstType = dstType.Elem()
+	}
+	if v, ok := prop.Value().(bool); ok {
+		if dstType.Kind() == reflect.Bool {
+			return prop
+		}
+		converted := ole.NewVariant(ole.VT_I4, 0)
+		if v {
+			converted.Val = 1
+		}
+		return &converted
+	}
+	if dstType.Kind() != reflect.Bool {
+		return prop
+	}
+
+	var isTrue bool
+	switch v := prop.Value().(type) {
+	case int8, int16, int32, int64, int:
+		isTrue = reflect.ValueOf(v).Int() != 0
+	case uint8, uint16, uint32, uint64:
+		isTrue = reflect.ValueOf(v).Uint() != 0
+	case string:
+		// uint64 and sint64 values are represented as strings.
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return prop
+		}
+		isTrue = i != 0
+	default:
+		return prop
+	}
+	converted := ole.NewVariant(ole.VT_BOOL, 0)
+	if isTrue {
+		converted.Val = -1 // VARIANT_TRUE
+	}
+	return &converted
+}
 
-	if prop.VT == ole.VT_NULL {
+// checkObjectClass checks that @prop is an embedded object of @class or of
+// a class derived from it. NULL objects pass the check, so they are left
+// zero as any other NULL value.
+func checkObjectClass(prop *ole.VARIANT, class string) (err error) {
+	if isNullVariant(prop) {
+		return nil
+	}
+	if !isObjectVariant(prop) {
+		return fmt.Errorf("can't check class of %s; not an object", prop.VT)
+	}
+	obj, err := objectDispatch(prop)
+	if err != nil {
+		return err
+	} else if obj == nil {
 		return nil
 	}
+	defer obj.Release()
 
-	// If it's a reference field and we have Dereferencer - resolve it.
-	if options == "ref" {
-		if d.Dereferencer == nil {
-			return errors.New("failed to dereference ref field; no Decoder.Dereferencer set")
+	objClass, err := systemProperty(obj, "__CLASS")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if clErr := objClass.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
 		}
-		refPath := prop.ToString()
-		prop, err = d.Dereferencer.Dereference(refPath)
-		if err != nil {
-			return err
+	}()
+	if strings.EqualFold(objClass.ToString(), class) {
+		return nil
+	}
+
+	derivation, err := systemProperty(obj, "__DERIVATION")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if clErr := derivation.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
 		}
-		defer clearVariant(prop)
+	}()
+	if arr := derivation.ToArray(); arr != nil {
+		for _, parent := range arr.ToStringArray() {
+			if strings.EqualFold(parent, class) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unexpected object class %q; expected %q", objClass.ToString(), class)
+}
+
+// systemProperty returns a value of WMI system property (e.g. `__CLASS`)
+// of the @obj.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/wmi-system-properties
+func systemProperty(obj *ole.IDispatch, name string) (v *ole.VARIANT, err error) {
+	propsRaw, err := oleutil.GetProperty(obj, "SystemProperties_")
+	if err != nil {
+		return nil, err
 	}
+	defer func() {
+		if clErr := propsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
 
-	return d.unmarshalValue(f, prop)
+	propRaw, err := oleutil.CallMethod(propsRaw.ToIDispatch(), "Item", name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := propRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	return oleutil.GetProperty(propRaw.ToIDispatch(), "Value")
 }
 
 func (d Decoder) unmarshalValue(dst reflect.Value, prop *ole.VARIANT) error {
+	if isNullVariant(prop) {
+		// Never allocate the pointee for NULL.
+		d.unmarshalNull(dst)
+		return nil
+	}
 	isPtr := dst.Kind() == reflect.Ptr
 	fieldDstOrig := dst
 	if isPtr { // Create empty object for pointer receiver.
@@ -214,8 +1810,15 @@ func (d Decoder) unmarshalValue(dst reflect.Value, prop *ole.VARIANT) error {
 		dst = dst.Elem()
 	}
 
+	value := prop.Value()
+	if str, ok := value.(string); ok && d.DecimalSeparator != "" {
+		if k := dst.Kind(); k == reflect.Float32 || k == reflect.Float64 {
+			value = strings.Replace(str, d.DecimalSeparator, ".", 1)
+		}
+	}
+
 	// First of all try to unmarshal it as a simple type.
-	err := unmarshalSimpleValue(dst, prop.Value())
+	err := unmarshalSimpleValue(dst, value)
 	if err != errSimpleVariantsExceeded {
 		return err // Either nil and value set or unexpected error.
 	}
@@ -223,18 +1826,55 @@ func (d Decoder) unmarshalValue(dst reflect.Value, prop *ole.VARIANT) error {
 	// Or we faced not so simple type. Do our best.
 	switch dst.Kind() {
 	case reflect.Slice:
+		if isObjectsVariant(prop) && isStructSlice(dst.Type()) {
+			// Embedded objects collection (e.g. a method out parameter).
+			if err := d.unmarshalObjects(dst, prop); err != nil {
+				return err
+			}
+			if d.EmptyArrayAsNil && dst.Len() == 0 {
+				fieldDstOrig.Set(reflect.Zero(fieldDstOrig.Type()))
+			}
+			return nil
+		}
 		safeArray := prop.ToArray()
 		if safeArray == nil {
 			return fmt.Errorf("can't unmarshal %s into slice", prop.VT)
 		}
-		return unmarshalSlice(dst, safeArray)
+		if err := unmarshalSlice(dst, safeArray, d.uint32Array); err != nil {
+			return err
+		}
+		if d.EmptyArrayAsNil && dst.Len() == 0 {
+			fieldDstOrig.Set(reflect.Zero(fieldDstOrig.Type()))
+		}
+		return nil
 	case reflect.Struct:
-		dispatch := prop.ToIDispatch()
-		if dispatch == nil {
+		if !isObjectVariant(prop) {
 			return fmt.Errorf("can't unmarshal %s into struct", prop.VT)
 		}
+		dispatch, err := objectDispatch(prop)
+		if err != nil {
+			return err
+		}
+		if dispatch == nil {
+			fieldDstOrig.Set(reflect.Zero(fieldDstOrig.Type())) // Nil object.
+			return nil
+		}
+		defer dispatch.Release()
 		fieldPointer := dst.Addr().Interface()
 		return d.Unmarshal(dispatch, fieldPointer)
+	case reflect.Interface:
+		if !isObjectVariant(prop) {
+			return fmt.Errorf("can't unmarshal %s into interface", prop.VT)
+		}
+		dispatch, err := objectDispatch(prop)
+		if err != nil {
+			return err
+		}
+		if dispatch == nil {
+			return nil // Nil object.
+		}
+		defer dispatch.Release()
+		return d.unmarshalInterface(dispatch, dst)
 	default:
 		// If we got nil value - handle it with magic config fields.
 		gotNilProp := reflect.TypeOf(prop.Value()) == nil
@@ -250,6 +1890,96 @@ func (d Decoder) unmarshalValue(dst reflect.Value, prop *ole.VARIANT) error {
 	}
 }
 
+// isNullVariant checks if @prop holds no value (VT_NULL or VT_EMPTY).
+func isNullVariant(prop *ole.VARIANT) bool {
+	return prop.VT == ole.VT_NULL || prop.VT == ole.VT_EMPTY
+}
+
+// isObjectVariant checks if @prop holds a single embedded object.
+func isObjectVariant(prop *ole.VARIANT) bool {
+	return prop.VT == ole.VT_DISPATCH || prop.VT == ole.VT_UNKNOWN
+}
+
+// objectDispatch returns IDispatch of the embedded object held by @prop
+// (either VT_DISPATCH or VT_UNKNOWN). The returned object should be released
+// by the caller, nil is returned for the nil object.
+func objectDispatch(prop *ole.VARIANT) (*ole.IDispatch, error) {
+	if prop.VT == ole.VT_DISPATCH {
+		dispatch := prop.ToIDispatch()
+		if dispatch != nil {
+			dispatch.AddRef()
+		}
+		return dispatch, nil
+	}
+	unknown := prop.ToIUnknown()
+	if unknown == nil {
+		return nil, nil
+	}
+	dispatch, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return nil, fmt.Errorf("embedded object has no IDispatch; %v", err)
+	}
+	return dispatch, nil
+}
+
+// isObjectsVariant checks if @prop holds either an array of objects or an
+// objects collection (like `SWbemObjectSet`).
+func isObjectsVariant(prop *ole.VARIANT) bool {
+	switch prop.VT {
+	case ole.VT_DISPATCH, ole.VT_ARRAY | ole.VT_DISPATCH, ole.VT_ARRAY | ole.VT_UNKNOWN:
+		return true
+	}
+	return false
+}
+
+// isStructSlice checks if @t is []S or []*S for some struct type S.
+func isStructSlice(t reflect.Type) bool {
+	elem := t.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	return elem.Kind() == reflect.Struct && elem != timeType
+}
+
+// unmarshalObjects unmarshals every object of the @prop objects array or
+// collection into the element of the @dst slice of structs.
+func (d Decoder) unmarshalObjects(dst reflect.Value, prop *ole.VARIANT) error {
+	elemType := dst.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	result := reflect.MakeSlice(dst.Type(), 0, 0)
+	var errFieldMismatch error
+	unmarshalItem := func(item *ole.IDispatch) error {
+		ev := reflect.New(elemType)
+		if err := d.Unmarshal(item, ev.Interface()); err != nil {
+			if _, ok := err.(ErrFieldMismatch); !ok {
+				return err
+			}
+			errFieldMismatch = err
+		}
+		if !isPtr {
+			ev = ev.Elem()
+		}
+		result = reflect.Append(result, ev)
+		return nil
+	}
+
+	var err error
+	if prop.VT == ole.VT_DISPATCH {
+		err = forEach(prop.ToIDispatch(), unmarshalItem)
+	} else {
+		err = forEachArrayObject(prop.ToArray(), unmarshalItem)
+	}
+	if err != nil {
+		return err
+	}
+	dst.Set(result)
+	return errFieldMismatch
+}
+
 var (
 	errSimpleVariantsExceeded = errors.New("unknown simple type")
 )
@@ -268,49 +1998,68 @@ func unmarshalSimpleValue(dst reflect.Value, value interface{}) error {
 		v := reflect.ValueOf(val).Int()
 		switch dst.Kind() {
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			dst.SetInt(v)
+			return setInt(dst, val, v)
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			dst.SetUint(uint64(v))
+			if v < 0 {
+				// CIM uint32 values passed as negative VT_I4 are converted
+				// by `unsignedVariant` beforehand.
+				return intOverflow(dst, val)
+			}
+			return setUint(dst, val, uint64(v))
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(v))
 		default:
-			return errors.New("not an integer class")
+			return fmt.Errorf("cannot assign WMI integer to %s", dst.Type())
 		}
 	case uint8, uint16, uint32, uint64:
 		v := reflect.ValueOf(val).Uint()
 		switch dst.Kind() {
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			dst.SetInt(int64(v))
+			if v > math.MaxInt64 {
+				return intOverflow(dst, val)
+			}
+			return setInt(dst, val, int64(v))
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			dst.SetUint(v)
+			return setUint(dst, val, v)
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(v))
 		default:
-			return errors.New("not an integer class")
+			return fmt.Errorf("cannot assign WMI integer to %s", dst.Type())
 		}
 	case bool:
 		switch dst.Kind() {
 		case reflect.Bool:
 			dst.SetBool(val)
 		default:
-			return errors.New("not a bool")
+			return fmt.Errorf("cannot assign WMI bool to %s", dst.Type())
 		}
 	case float32:
 		switch dst.Kind() {
-		case reflect.Float32:
+		case reflect.Float32, reflect.Float64:
 			dst.SetFloat(float64(val))
 		default:
-			return errors.New("not a float32")
+			return fmt.Errorf("cannot assign WMI float32 to %s", dst.Type())
+		}
+	case float64:
+		switch dst.Kind() {
+		case reflect.Float64:
+			dst.SetFloat(val)
+		default:
+			return fmt.Errorf("cannot assign WMI float64 to %s", dst.Type())
 		}
 	case time.Time:
 		switch dst.Type() {
 		case timeType:
 			dst.Set(reflect.ValueOf(val))
 		default:
-			return errors.New("not a time")
+			return fmt.Errorf("cannot assign WMI time to %s", dst.Type())
 		}
 	case uintptr:
 		switch dst.Kind() {
 		case reflect.Uintptr:
 			dst.Set(reflect.ValueOf(val))
 		default:
-			return errors.New("not an uintptr")
+			return fmt.Errorf("cannot assign WMI uintptr to %s", dst.Type())
 		}
 	case string:
 		return smartUnmarshalString(dst, val)
@@ -320,36 +2069,133 @@ func unmarshalSimpleValue(dst reflect.Value, value interface{}) error {
 	return nil
 }
 
-func unmarshalSlice(fieldDst reflect.Value, safeArray *ole.SafeArrayConversion) error {
+// errIntOverflow is returned if the integer @value doesn't fit into the
+// integer destination of @dstType.
+type errIntOverflow struct {
+	value   interface{}
+	dstType reflect.Type
+}
+
+func (e errIntOverflow) Error() string {
+	return fmt.Sprintf("value %v overflows %s", e.value, e.dstType)
+}
+
+// setInt sets @v converted from the original @value into the signed integer
+// @dst checking the range.
+func setInt(dst reflect.Value, value interface{}, v int64) error {
+	if dst.OverflowInt(v) {
+		return intOverflow(dst, value)
+	}
+	dst.SetInt(v)
+	return nil
+}
+
+// intOverflow zeroes @dst (so the value of the reused destination isn't kept)
+// and returns errIntOverflow of the original @value.
+func intOverflow(dst reflect.Value, value interface{}) error {
+	dst.Set(reflect.Zero(dst.Type()))
+	return errIntOverflow{value: value, dstType: dst.Type()}
+}
+
+// setUint sets @v converted from the original @value into the unsigned
+// integer @dst checking the range.
+func setUint(dst reflect.Value, value interface{}, v uint64) error {
+	if dst.OverflowUint(v) {
+		return intOverflow(dst, value)
+	}
+	dst.SetUint(v)
+	return nil
+}
+
+// unmarshalSlice unmarshals SAFEARRAY @safeArray into the slice @fieldDst
+// converting every element in the same way as the single values. Empty (and
+// nil) arrays produce an empty non-nil slice, see `Decoder.EmptyArrayAsNil`.
+// Negative int32 elements are taken as uint32 if @uint32Elems is set, see
+// `isUint32Array`.
+func unmarshalSlice(fieldDst reflect.Value, safeArray *ole.SafeArrayConversion, uint32Elems bool) error {
 	arr := safeArray.ToValueArray()
 	resultArr := reflect.MakeSlice(fieldDst.Type(), len(arr), len(arr))
 	for i, v := range arr {
 		s := resultArr.Index(i)
+		if i32, ok := v.(int32); ok && uint32Elems {
+			v = uint32(i32)
+		}
 		err := unmarshalSimpleValue(s, v)
 		if err != nil {
-			return fmt.Errorf("can't put %T into []%s", v, fieldDst.Type().Elem().Kind())
+			return fmt.Errorf("can't put element %d (%T) into %s; %v", i, v, fieldDst.Type(), err)
 		}
 	}
 	fieldDst.Set(resultArr)
 	return nil
 }
 
+// unmarshalSparse loads the array @arr into @fieldDst map of `map[int]T` kind
+// keyed by the element index. Zero elements are skipped unless @keepZero is
+// set.
+func unmarshalSparse(fieldDst reflect.Value, arr []interface{}, keepZero bool) error {
+	mapType := fieldDst.Type()
+	switch mapType.Key().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return fmt.Errorf("can't unmarshal array into %s; key should be an integer", mapType)
+	}
+
+	resultMap := reflect.MakeMap(mapType)
+	for i, v := range arr {
+		elem := reflect.New(mapType.Elem()).Elem()
+		if err := unmarshalSimpleValue(elem, v); err != nil {
+			return fmt.Errorf("can't put %T into %s", v, mapType)
+		}
+		if !keepZero && elem.IsZero() {
+			continue
+		}
+		key := reflect.New(mapType.Key()).Elem()
+		if err := unmarshalSimpleValue(key, i); err != nil {
+			return err
+		}
+		resultMap.SetMapIndex(key, elem)
+	}
+	fieldDst.Set(resultMap)
+	return nil
+}
+
 func smartUnmarshalString(fieldDst reflect.Value, val string) error {
+	if fieldDst.Type() == durationType {
+		d, err := ParseCIMInterval(val)
+		if err != nil {
+			return err
+		}
+		fieldDst.SetInt(int64(d))
+		return nil
+	}
 	switch fieldDst.Kind() {
 	case reflect.String:
 		fieldDst.SetString(val)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// 64-bit integers (e.g. the performance counters) are passed as
+		// strings, since VARIANT has no room for them.
 		iv, err := strconv.ParseInt(val, 10, 64)
-		if err != nil {
-			return err
+		if errors.Is(err, strconv.ErrRange) {
+			return intOverflow(fieldDst, val)
+		} else if err != nil {
+			return fmt.Errorf("can't unmarshal string %q into %s; not an integer", val, fieldDst.Type())
 		}
-		fieldDst.SetInt(iv)
+		return setInt(fieldDst, val, iv)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		uv, err := strconv.ParseUint(val, 10, 64)
+		if errors.Is(err, strconv.ErrRange) || (err != nil && strings.HasPrefix(val, "-")) {
+			return intOverflow(fieldDst, val)
+		} else if err != nil {
+			return fmt.Errorf("can't unmarshal string %q into %s; not an unsigned integer", val, fieldDst.Type())
+		}
+		return setUint(fieldDst, val, uv)
+	case reflect.Float32, reflect.Float64:
+		fv, err := strconv.ParseFloat(val, 64)
 		if err != nil {
 			return err
 		}
-		fieldDst.SetUint(uv)
+		fieldDst.SetFloat(fv)
 	case reflect.Struct:
 		switch t := fieldDst.Type(); t {
 		case timeType:
@@ -363,30 +2209,47 @@ func smartUnmarshalString(fieldDst reflect.Value, val string) error {
 	return nil
 }
 
-// parses CIM_DATETIME from string format "yyyymmddHHMMSS.mmmmmmsUUU"
-// where
-//		"mmmmmm"	Six-digit number of microseconds in the second.
-//		"s"			Plus sign (+) or minus sign (-) to indicate a positive or
-//					negative offset from UTC.
-// 		"UUU" 	 	Three-digit offset indicating the number of minutes that the
-// 					originating time zone deviates from UTC.
-// 		(other are obvious)
-// ref: https://docs.microsoft.com/en-us/windows/desktop/wmisdk/cim-datetime
-func unmarshalTime(fieldDst reflect.Value, val string) error {
-	const signPos = 21
-	if sign := val[signPos]; sign == '+' || sign == '-' {
-		// golang can't understand such timezone offset, so transform minute
-		// offset to the "HHMM" tz offset.
-		timeZonePart := val[signPos+1:]
-		minOffset, err := strconv.Atoi(timeZonePart)
-		if err != nil {
-			return err
-		}
-		isoTzOffset := fmt.Sprintf("%02d%02d", minOffset/60, minOffset%60)
-		val = val[:signPos+1] + isoTzOffset
+// unixTimeUnit returns the duration of a Unix time unit set by one of the
+// `unix`, `unixms` or `unixns` @options.
+func unixTimeUnit(options tagOptions) (unit time.Duration, ok bool) {
+	switch {
+	case options.Contains("unix"):
+		return time.Second, true
+	case options.Contains("unixms"):
+		return time.Millisecond, true
+	case options.Contains("unixns"):
+		return time.Nanosecond, true
+	}
+	return 0, false
+}
+
+// unixTime converts the integer @value (or a string with an integer) of Unix
+// time @unit into time.Time.
+func unixTime(value interface{}, unit time.Duration) (time.Time, error) {
+	var v int64
+	if err := unmarshalSimpleValue(reflect.ValueOf(&v).Elem(), value); err != nil {
+		return time.Time{}, fmt.Errorf("can't unmarshal %T as Unix time; %v", value, err)
+	}
+	sec, frac := v/int64(time.Second/unit), v%int64(time.Second/unit)
+	return time.Unix(sec, frac*int64(unit)), nil
+}
+
+// setTime sets time.Time or *time.Time @fieldDst to @t.
+func setTime(fieldDst reflect.Value, t time.Time) error {
+	if fieldDst.Kind() == reflect.Ptr && fieldDst.Type().Elem() == timeType {
+		fieldDst.Set(reflect.New(timeType))
+		fieldDst = fieldDst.Elem()
 	}
-	// Parsing format: "yyyymmddHHMMSS.mmmmmmsHHMM"
-	t, err := time.Parse("20060102150405.000000-0700", val)
+	if fieldDst.Type() != timeType {
+		return fmt.Errorf("can't unmarshal time into %s", fieldDst.Type())
+	}
+	fieldDst.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// unmarshalTime parses CIM_DATETIME @val into time.Time @fieldDst.
+func unmarshalTime(fieldDst reflect.Value, val string) error {
+	t, err := ParseCIMDateTime(val)
 	if err != nil {
 		return err
 	}
@@ -394,16 +2257,77 @@ func unmarshalTime(fieldDst reflect.Value, val string) error {
 	return nil
 }
 
-func getFieldName(fType reflect.StructField) (name, options string) {
+// getFieldName returns a COM-object property name the field @fType should be
+// unmarshalled from and all the options specified in a field tag.
+//
+// The tag name is the part before the first comma, the rest are options, e.g.
+// `wmi:"Foo,omitempty"` is the Foo name with the omitempty option. Name "-"
+// means the field is skipped regardless of the options, see
+// `structField.skipped` (unexported fields are skipped even if tagged).
+//
+// The name is taken from the "wmi" tag if it's set, otherwise the field name
+// is used with an optional prefix. The prefix could be set either for the
+// field itself or for all the structure fields using the blank field @structOpts
+// (see `structOptions`), e.g.
+//   _       struct{} `wmi:",prefix=Win32_"`
+//   Name    string   // Will be resolved to `Win32_Name`.
+//   Caption string   `wmi:",prefix=CIM_"` // Will be resolved to `CIM_Caption`.
+//   Status  string   `wmi:"Status"` // Explicit name, prefix isn't used.
+func getFieldName(fType reflect.StructField, structOpts tagOptions) (name string, options tagOptions) {
 	tag := fType.Tag.Get("wmi")
 	if idx := strings.Index(tag, ","); idx != -1 {
 		name = tag[:idx]
-		options = tag[idx+1:]
+		options = tagOptions(tag[idx+1:])
 	} else {
 		name = tag
 	}
 	if name == "" {
-		name = fType.Name
+		prefix, ok := options.Value("prefix")
+		if !ok {
+			prefix, _ = structOpts.Value("prefix")
+		}
+		name = prefix + fType.Name
 	}
 	return
 }
+
+// structOptions returns options set for the whole structure @t using a tag of
+// the blank field, e.g.
+//   type Win32_Process struct {
+//       _ struct{} `wmi:",prefix=Win32_"`
+//       ...
+//   }
+func structOptions(t reflect.Type) tagOptions {
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Name == "_" {
+			_, options := getFieldName(f, "")
+			return options
+		}
+	}
+	return ""
+}
+
+// tagOptions is the string following a comma in a "wmi" struct field's tag,
+// or the empty string. It does not include the leading comma.
+type tagOptions string
+
+// Contains reports whether a comma-separated list of options contains a
+// particular @name flag.
+func (o tagOptions) Contains(name string) bool {
+	for _, opt := range strings.Split(string(o), ",") {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns the value of the first `@name=value` option.
+func (o tagOptions) Value(name string) (value string, ok bool) {
+	for _, opt := range strings.Split(string(o), ",") {
+		if strings.HasPrefix(opt, name+"=") {
+			return opt[len(name)+1:], true
+		}
+	}
+	return "", false
+}
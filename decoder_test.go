@@ -3,13 +3,22 @@
 package wmi
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
 	"os/user"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
+	"unicode/utf16"
+	"unsafe"
 
 	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
 )
 
 var (
@@ -123,6 +132,40 @@ func TestDecoder_Unmarshal_OmitUnneeded(t *testing.T) {
 	}
 }
 
+func TestDecoder_Unmarshal_PointerElements(t *testing.T) {
+	var client Client
+	client.Decoder.AllowMissingFields = true
+
+	var processes []*miniProcess
+	err := client.Query(`SELECT * FROM Win32_Process WHERE ProcessId = 4 OR ProcessId = 0`, &processes)
+	if err != nil {
+		t.Fatalf("Failed to query running processes; %s", err)
+	}
+	if len(processes) != 2 {
+		t.Fatalf("Unexpected processes count; got %d, expected 2", len(processes))
+	}
+	for i, p := range processes {
+		if p == nil {
+			t.Fatalf("Element %d isn't allocated", i)
+		}
+		if p.ProcessId == 4 && p.Name != "System" {
+			t.Errorf("Unexpected System process; got %+v", p)
+		}
+	}
+	if processes[0] == processes[1] {
+		t.Errorf("Elements share the same value")
+	}
+
+	var maps []*map[string]interface{}
+	err = client.Query(`SELECT Name FROM Win32_Process WHERE ProcessId = 4`, &maps)
+	if err != nil {
+		t.Fatalf("Failed to query into object maps; %s", err)
+	}
+	if len(maps) != 1 || maps[0] == nil || (*maps[0])["Name"] != "System" {
+		t.Errorf("Unexpected object maps; %v", maps)
+	}
+}
+
 // A few Win32_Process fields with tags.
 type taggedMiniProcess struct {
 	Name      string // Same as real.
@@ -130,56 +173,1321 @@ type taggedMiniProcess struct {
 	UserField string `wmi:"-"`         // Any non-property field.
 }
 
-func TestDecoder_Unmarshal_Tags(t *testing.T) {
-	// Query with all fields having receiver with not all.
-	var processes []taggedMiniProcess
-	err := Query(`	SELECT * FROM Win32_Process WHERE ProcessId = 4`, &processes)
-	if err != nil {
-		t.Fatalf("Failed to query running processes; %s", err)
+func TestDecoder_Unmarshal_Tags(t *testing.T) {
+	// Query with all fields having receiver with not all.
+	var processes []taggedMiniProcess
+	err := Query(`	SELECT * FROM Win32_Process WHERE ProcessId = 4`, &processes)
+	if err != nil {
+		t.Fatalf("Failed to query running processes; %s", err)
+	}
+	// Get System process (always exists with PID=4)
+	if len(processes) != 1 {
+		t.Fatalf("Failed to find System (PID=4) process in running processes")
+	}
+	system := processes[0]
+
+	// Check the fields.
+	if system.PID != 4 {
+		t.Fatalf("Unexpected System process PID; got %v, expected %v", system.PID, 4)
+	}
+	if system.Name != "System" {
+		t.Errorf("Unexpected System process name; got %v, expected %v", system.Name, "System")
+	}
+	if system.UserField != "" {
+		t.Errorf("Spoiled field marked to skip; content %q", system.UserField)
+	}
+}
+
+type precedenceEmbedded struct {
+	Caption string
+}
+
+// Win32_Process fields covering the tag precedence.
+type precedenceProcess struct {
+	_                  struct{} `wmi:",prefix=Win32_"`
+	precedenceEmbedded          // Flattened.
+	Name               string   `wmi:""`
+	PID                uint32   `wmi:"ProcessId,intbool"`
+	Handle             string   `wmi:",prefix="`
+	Skipped            string   `wmi:"-"`
+	SkippedWithOptions string   `wmi:"-,ref"`
+	unexported         string
+	unexportedTagged   string `wmi:"Name"`
+}
+
+func TestDecoder_TagPrecedence(t *testing.T) {
+	tests := []struct {
+		field    string
+		property string
+		skipped  bool
+	}{
+		{"Caption", "Win32_Caption", false},
+		{"Name", "Win32_Name", false},            // Empty tag uses the field name.
+		{"PID", "ProcessId", false},              // Name before the first comma.
+		{"Handle", "Handle", false},              // Empty field prefix overrides the struct one.
+		{"Skipped", "-", true},                   // Skipped.
+		{"SkippedWithOptions", "-", true},        // Skipped regardless of options.
+		{"unexported", "Win32_unexported", true}, // Unexported.
+		{"unexportedTagged", "Name", true},       // Unexported even if tagged.
+	}
+	fields := make(map[string]structField)
+	for _, f := range cachedFields(reflect.TypeOf(precedenceProcess{})) {
+		fields[f.Name] = f
+	}
+	if f, ok := fields["_"]; !ok || !f.skipped() {
+		t.Errorf("Blank field isn't skipped; %+v", f)
+	}
+	if _, ok := fields["precedenceEmbedded"]; ok {
+		t.Errorf("Embedded struct isn't flattened")
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			f, ok := fields[tt.field]
+			if !ok {
+				t.Fatalf("No field %q", tt.field)
+			}
+			if f.name != tt.property || f.skipped() != tt.skipped {
+				t.Errorf("Unexpected property %q (skipped %v); expected %q (skipped %v)",
+					f.name, f.skipped(), tt.property, tt.skipped)
+			}
+		})
+	}
+
+	q := CreateQueryFrom(&[]precedenceProcess{}, "Win32_Process", "")
+	expected := "SELECT Win32_Caption, Win32_Name, ProcessId, Handle FROM Win32_Process"
+	if q != expected {
+		t.Errorf("Unexpected query; got %q, expected %q", q, expected)
+	}
+}
+
+// A few Win32_PerfRawData_PerfDisk_LogicalDisk fields with stripped prefixes.
+type logicalDiskFrequencies struct {
+	_        struct{} `wmi:",prefix=Frequency_"`
+	Object   uint64   // Resolved to `Frequency_Object`.
+	PerfTime uint64   // Resolved to `Frequency_PerfTime`.
+	Sys100NS uint64   `wmi:",prefix=Timestamp_"` // Field prefix overrides structure one.
+	Name     string   `wmi:"Name"`               // Explicit name overrides prefix.
+}
+
+func TestDecoder_Unmarshal_Prefix(t *testing.T) {
+	var disks []logicalDiskFrequencies
+	q := CreateQueryFrom(&disks, "Win32_PerfRawData_PerfDisk_LogicalDisk", "")
+	expected := "SELECT Frequency_Object, Frequency_PerfTime, Timestamp_Sys100NS, Name " +
+		"FROM Win32_PerfRawData_PerfDisk_LogicalDisk"
+	if q != expected {
+		t.Fatalf("Unexpected query; got %q, expected %q", q, expected)
+	}
+
+	if err := Query(q, &disks); err != nil {
+		t.Fatalf("Failed to query logical disks; %s", err)
+	}
+	if len(disks) == 0 {
+		t.Fatalf("No logical disks found")
+	}
+	for _, d := range disks {
+		if d.Name == "" {
+			t.Errorf("Failed to fetch Name")
+		}
+		if d.PerfTime == 0 {
+			t.Errorf("Failed to fetch Frequency_PerfTime of %q", d.Name)
+		}
+		if d.Sys100NS == 0 {
+			t.Errorf("Failed to fetch Timestamp_Sys100NS of %q", d.Name)
+		}
+	}
+}
+
+func TestDecoder_Unmarshal_IntBool(t *testing.T) {
+	var processes []struct {
+		HasPID    bool  `wmi:"ProcessId,intbool"`
+		HasParent *bool `wmi:"ParentProcessId,intbool"`
+	}
+	if err := Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Fatalf("Failed to query running processes; %s", err)
+	}
+	if len(processes) != 1 {
+		t.Fatalf("Failed to find System (PID=4) process in running processes")
+	}
+	// System process has PID 4 and no parent process.
+	if p := processes[0]; !p.HasPID || p.HasParent == nil || *p.HasParent {
+		t.Errorf("Unexpected int to bool coercion; got %v, %v", p.HasPID, p.HasParent)
+	}
+
+	var systems []struct {
+		Primary    int  `wmi:"Primary,intbool"`
+		PrimaryPtr *int `wmi:"Primary,intbool"`
+	}
+	if err := Query("SELECT Primary FROM Win32_OperatingSystem", &systems); err != nil {
+		t.Fatalf("Failed to query OS; %s", err)
+	}
+	if len(systems) == 0 {
+		t.Fatalf("No OS found")
+	}
+	// Exactly one primary system should exist.
+	if s := systems[0]; s.Primary != 1 || s.PrimaryPtr == nil || *s.PrimaryPtr != 1 {
+		t.Errorf("Unexpected bool to int coercion; got %v, %v", s.Primary, s.PrimaryPtr)
+	}
+}
+
+func TestDecoder_Unmarshal_MaxPropertySize(t *testing.T) {
+	type process struct {
+		Name           string
+		ExecutablePath string  `wmi:",maxsize=0"` // Not limited.
+		CommandLine    *string `wmi:",maxsize=4"`
+	}
+	c := Client{Decoder: Decoder{MaxPropertySize: 6}}
+	var processes []process
+	q := fmt.Sprintf("SELECT * FROM Win32_Process WHERE ProcessId = %d", os.Getpid())
+	err := c.Query(q, &processes)
+	mismatch, ok := err.(ErrFieldMismatch)
+	if !ok {
+		t.Fatalf("Unexpected error; got %v, expected %T", err, mismatch)
+	}
+	if mismatch.FieldName != "Name" {
+		t.Errorf("Unexpected field truncation reported; %s", mismatch)
+	}
+	if len(processes) != 1 {
+		t.Fatalf("Failed to find current process")
+	}
+
+	p := processes[0]
+	if p.Name != "wmi.te" {
+		t.Errorf("Unexpected truncated Name; got %q", p.Name)
+	}
+	if !strings.HasSuffix(p.ExecutablePath, ".exe") {
+		t.Errorf("Unexpected not truncated ExecutablePath; got %q", p.ExecutablePath)
+	}
+	if p.CommandLine == nil || len(*p.CommandLine) != 4 {
+		t.Errorf("Unexpected truncated CommandLine; got %v", p.CommandLine)
+	}
+
+	// Slices are truncated by the elements.
+	slice := []string{"abc", "de", "f"}
+	if !truncateValue(reflect.ValueOf(&slice).Elem(), 5) || !reflect.DeepEqual(slice, []string{"abc", "de"}) {
+		t.Errorf("Unexpected truncated slice; got %v", slice)
+	}
+}
+
+func TestDecoder_Unmarshal_Scale(t *testing.T) {
+	var systems []struct {
+		FreeKB    uint64  `wmi:"FreePhysicalMemory"`
+		FreeBytes *uint64 `wmi:"FreePhysicalMemory,scale=1024"`
+	}
+	if err := Query("SELECT FreePhysicalMemory FROM Win32_OperatingSystem", &systems); err != nil {
+		t.Fatalf("Failed to query OS; %s", err)
+	}
+	if len(systems) == 0 {
+		t.Fatalf("No OS found")
+	}
+	s := systems[0]
+	if s.FreeKB == 0 || s.FreeBytes == nil || *s.FreeBytes != s.FreeKB*1024 {
+		t.Errorf("Unexpected scaled value; got %v KB and %v bytes", s.FreeKB, s.FreeBytes)
+	}
+
+	// Overflows are reported.
+	var overflows []struct {
+		FreePhysicalMemory uint32 `wmi:",scale=4294967296"`
+	}
+	err := Query("SELECT FreePhysicalMemory FROM Win32_OperatingSystem", &overflows)
+	if _, ok := err.(ErrFieldMismatch); !ok {
+		t.Errorf("Expected ErrFieldMismatch on overflow; got %v", err)
+	}
+}
+
+func TestUnmarshalSimpleValue_Integers(t *testing.T) {
+	var dst struct {
+		I8  int8
+		I16 int16
+		I32 int32
+		I64 int64
+		U8  uint8
+		U16 uint16
+		U32 uint32
+		U64 uint64
+		I   int
+	}
+	fields := reflect.ValueOf(&dst).Elem()
+	field := func(name string) reflect.Value { return fields.FieldByName(name) }
+
+	tests := []struct {
+		value    interface{}
+		field    string
+		expected interface{} // nil for overflow.
+	}{
+		{int32(127), "I8", int8(127)},
+		{int32(128), "I8", nil},
+		{int32(-128), "I8", int8(-128)},
+		{int16(-129), "I8", nil},
+		{uint8(255), "I8", nil},
+		{int32(65535), "U16", uint16(65535)},
+		{int32(65536), "U16", nil},
+		{int16(-1), "U16", nil},
+		{int32(-1), "U32", nil}, // CIM uint32 is converted by unsignedVariant beforehand.
+		{int32(-2147483648), "U64", nil},
+		{int32(-1), "U8", nil},
+		{int32(-1), "I64", int64(-1)},
+		{uint32(4294967295), "I32", nil},
+		{uint32(4294967295), "I64", int64(4294967295)},
+		{uint64(18446744073709551615), "I64", nil},
+		{uint64(18446744073709551615), "U64", uint64(18446744073709551615)},
+		{"18446744073709551615", "U64", uint64(18446744073709551615)},
+		{"18446744073709551615", "I64", nil},
+		{"-9223372036854775808", "I64", int64(-9223372036854775808)},
+		{"-1", "U64", nil},
+		{"4294967296", "U32", nil},
+		{"4294967296", "I", int(4294967296)},
+		{uint16(300), "U8", nil},
+		{int8(-5), "I32", int32(-5)},
+	}
+	for _, tt := range tests {
+		f := field(tt.field)
+		f.Set(reflect.Zero(f.Type()))
+		err := unmarshalSimpleValue(f, tt.value)
+		if tt.expected == nil {
+			if _, ok := err.(errIntOverflow); !ok {
+				t.Errorf("Expected overflow of %T(%v) into %s; got %v, %v", tt.value, tt.value, f.Type(), f.Interface(), err)
+			} else if !f.IsZero() {
+				t.Errorf("Overflowed %T(%v) is set into %s; %v", tt.value, tt.value, f.Type(), f.Interface())
+			}
+			continue
+		}
+		if err != nil || f.Interface() != tt.expected {
+			t.Errorf("Unexpected %T(%v) into %s; got %v, %v, expected %v", tt.value, tt.value, f.Type(), f.Interface(), err, tt.expected)
+		}
+	}
+
+	// uint16 and uint32 properties into small and pointer integers.
+	var systems []struct {
+		OSType        *int8
+		ProductType   uint8
+		NumberOfUsers *uint16
+	}
+	if err := Query("SELECT * FROM Win32_OperatingSystem", &systems); err != nil {
+		t.Fatalf("Failed to query small integers; %s", err)
+	}
+	if len(systems) != 1 || systems[0].OSType == nil || *systems[0].OSType != 18 || systems[0].ProductType == 0 {
+		t.Errorf("Unexpected small integers; %+v", systems)
+	}
+
+	// PID of the test process usually doesn't fit int8.
+	var processes []struct{ ProcessId *int8 }
+	err := Query(fmt.Sprintf("SELECT ProcessId FROM Win32_Process WHERE ProcessId = %d", os.Getpid()), &processes)
+	if mismatch, ok := err.(ErrFieldMismatch); os.Getpid() > 127 && (!ok || mismatch.FieldName != "ProcessId") {
+		t.Errorf("Expected ProcessId overflow; got %v", err)
+	}
+}
+
+func TestDecoder_Unmarshal_WarnLossy(t *testing.T) {
+	type process struct {
+		ProcessId      uint8   // Narrowing uint32 -> uint8 (PID is > 255 most of the time).
+		WorkingSetSize float64 // uint64 -> float64.
+	}
+	if os.Getpid() < 256 {
+		t.Skipf("Test process PID is too small")
+	}
+	c := Client{Decoder: Decoder{WarnLossy: true}}
+	var processes []process
+	q := fmt.Sprintf("SELECT * FROM Win32_Process WHERE ProcessId = %d", os.Getpid())
+	err := c.Query(q, &processes)
+	mismatch, ok := err.(ErrFieldMismatch)
+	if !ok || mismatch.FieldName != "ProcessId" {
+		t.Fatalf("Expected lossy conversion of ProcessId; got %v", err)
+	}
+	if len(processes) != 1 || processes[0].WorkingSetSize == 0 {
+		t.Errorf("Unexpected result; %+v", processes)
+	}
+
+	// Lossless conversions aren't reported.
+	var lossless []struct {
+		ProcessId      float64
+		WorkingSetSize int64
+	}
+	if err := c.Query(q, &lossless); err != nil {
+		t.Errorf("Unexpected error on lossless conversions; %s", err)
+	}
+
+	// Large integers into floats.
+	f32 := float32(16777217)
+	if !isLossyConversion(int32(16777217), reflect.ValueOf(&f32).Elem()) {
+		t.Errorf("Lossy int32 to float32 conversion isn't detected")
+	}
+	f64 := float64(9007199254740993)
+	if !isLossyConversion("9007199254740993", reflect.ValueOf(&f64).Elem()) {
+		t.Errorf("Lossy uint64 to float64 conversion isn't detected")
+	}
+	u := uint64(18446744073709551615)
+	if isLossyConversion("18446744073709551615", reflect.ValueOf(&u).Elem()) {
+		t.Errorf("Lossless uint64 conversion is reported")
+	}
+}
+
+func TestDecoder_Composite(t *testing.T) {
+	type process struct {
+		Name  string
+		Title string // Composite of Name and ProcessId.
+	}
+	var c Client
+	c.Composite("Title", []string{"Name", "ProcessId"}, func(v []interface{}) (interface{}, error) {
+		return fmt.Sprintf("%s (%d)", v[0], v[1]), nil
+	})
+	var processes []process
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Fatalf("Failed to query running processes; %s", err)
+	}
+	if len(processes) != 1 {
+		t.Fatalf("Failed to find System (PID=4) process in running processes")
+	}
+	if title := processes[0].Title; title != "System (4)" {
+		t.Errorf("Unexpected composite field value; got %q, expected %q", title, "System (4)")
+	}
+
+	// Errors are reported as field mismatch.
+	c.Composite("Title", []string{"NoSuchProperty"}, func(v []interface{}) (interface{}, error) {
+		return "", nil
+	})
+	err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes)
+	if mismatch, ok := err.(ErrFieldMismatch); !ok || mismatch.FieldName != "Title" {
+		t.Errorf("Expected field mismatch for Title; got %v", err)
+	}
+}
+
+// spawnInstance creates a new not saved instance of the @class.
+func spawnInstance(t *testing.T, conn *SWbemServicesConnection, class string) *ole.IDispatch {
+	classRaw, err := conn.Dereference(class)
+	if err != nil {
+		t.Fatalf("Failed to get %s class; %s", class, err)
+	}
+	defer classRaw.Clear()
+	instanceRaw, err := oleutil.CallMethod(classRaw.ToIDispatch(), "SpawnInstance_")
+	if err != nil {
+		t.Fatalf("Failed to spawn %s instance; %s", class, err)
+	}
+	return instanceRaw.ToIDispatch()
+}
+
+func TestDecoder_Unmarshal_EmptyArrayAsNil(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_NetworkAdapterConfiguration")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "DNSDomainSuffixSearchOrder", []string{}); err != nil {
+		t.Fatalf("Failed to set empty array; %s", err)
+	}
+
+	type config struct {
+		DNSDomainSuffixSearchOrder    []string
+		DNSDomainSuffixSearchOrderPtr *[]string `wmi:"DNSDomainSuffixSearchOrder"`
+	}
+	var withEmpty config
+	if err := (Decoder{}).Unmarshal(instance, &withEmpty); err != nil {
+		t.Fatalf("Failed to unmarshal; %s", err)
+	}
+	if withEmpty.DNSDomainSuffixSearchOrder == nil || withEmpty.DNSDomainSuffixSearchOrderPtr == nil {
+		t.Errorf("Empty array unmarshalled as nil by default; %+v", withEmpty)
+	}
+
+	var withNil config
+	if err := (Decoder{EmptyArrayAsNil: true}).Unmarshal(instance, &withNil); err != nil {
+		t.Fatalf("Failed to unmarshal; %s", err)
+	}
+	if withNil.DNSDomainSuffixSearchOrder != nil || withNil.DNSDomainSuffixSearchOrderPtr != nil {
+		t.Errorf("Empty array isn't unmarshalled as nil; %+v", withNil)
+	}
+}
+
+func TestDecoder_Unmarshal_Sparse(t *testing.T) {
+	sparse := make(map[int]uint64)
+	dst := reflect.ValueOf(&sparse).Elem()
+	if err := unmarshalSparse(dst, []interface{}{uint64(0), uint64(5), uint64(0), uint64(7)}, false); err != nil {
+		t.Fatalf("Failed to unmarshal sparse array; %s", err)
+	}
+	if expected := map[int]uint64{1: 5, 3: 7}; !reflect.DeepEqual(sparse, expected) {
+		t.Errorf("Unexpected sparse map; got %v, expected %v", sparse, expected)
+	}
+
+	// Real object.
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_NetworkAdapterConfiguration")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "GatewayCostMetric", []byte{0, 5, 0, 0, 7}); err != nil {
+		t.Fatalf("Failed to set array; %s", err)
+	}
+
+	var config struct {
+		Sparse map[int]uint64  `wmi:"GatewayCostMetric"`
+		All    map[uint8]int32 `wmi:"GatewayCostMetric,keepzero"`
+	}
+	if err := (Decoder{}).Unmarshal(instance, &config); err != nil {
+		t.Fatalf("Failed to unmarshal; %s", err)
+	}
+	if expected := map[int]uint64{1: 5, 4: 7}; !reflect.DeepEqual(config.Sparse, expected) {
+		t.Errorf("Unexpected sparse map; got %v, expected %v", config.Sparse, expected)
+	}
+	if expected := map[uint8]int32{0: 0, 1: 5, 2: 0, 3: 0, 4: 7}; !reflect.DeepEqual(config.All, expected) {
+		t.Errorf("Unexpected keepzero map; got %v, expected %v", config.All, expected)
+	}
+}
+
+func TestDecoder_Unmarshal_DecimalSeparator(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "Caption", "3,25"); err != nil {
+		t.Fatalf("Failed to set property; %s", err)
+	}
+
+	var dst struct{ Caption float64 }
+	if err := (Decoder{}).Unmarshal(instance, &dst); err == nil {
+		t.Errorf("Comma decimal parsed with invariant format; got %v", dst.Caption)
+	}
+	if err := (Decoder{DecimalSeparator: ","}).Unmarshal(instance, &dst); err != nil {
+		t.Fatalf("Failed to unmarshal; %s", err)
+	}
+	if dst.Caption != 3.25 {
+		t.Errorf("Unexpected value; got %v, expected 3.25", dst.Caption)
+	}
+}
+
+func TestDecoder_Unmarshal_Flags(t *testing.T) {
+	bitValues := []interface{}{"Read", "Write", "Execute", "Delete"}
+	tests := []struct {
+		name     string
+		mask     uint64
+		bitMap   interface{}
+		expected []string
+	}{
+		{"none", 0, nil, []string{}},
+		{"by index", 0x5, nil, []string{"Read", "Execute"}},
+		{"by bit map", 0x110, []interface{}{"0", "4", "6", "8"}, []string{"Write", "Delete"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, err := setBitLabels(tt.mask, bitValues, tt.bitMap)
+			if err != nil {
+				t.Fatalf("Failed to get labels; %s", err)
+			}
+			if !reflect.DeepEqual(labels, tt.expected) {
+				t.Errorf("Unexpected labels; got %v, expected %v", labels, tt.expected)
+			}
+		})
+	}
+
+	if _, err := setBitLabels(1, bitValues, []interface{}{"0"}); err == nil {
+		t.Errorf("Expected error for inconsistent qualifiers")
+	}
+
+	// BitValues are amended, so they should be taken from the class.
+	props, err := DefaultClient.ClassProperties("Win32_OperatingSystem")
+	if err != nil {
+		t.Fatalf("Failed to get class properties; %s", err)
+	}
+	var qualifiers map[string]interface{}
+	for _, p := range props {
+		if p.Name == "SuiteMask" {
+			qualifiers = p.Qualifiers
+		}
+	}
+	var systems []struct {
+		SuiteMask uint32
+		Suites    []string `wmi:"SuiteMask,flags"`
+	}
+	if err := DefaultClient.Query("SELECT SuiteMask FROM Win32_OperatingSystem", &systems); err != nil {
+		t.Fatalf("Failed to query suite mask flags; %s", err)
+	}
+	if len(systems) != 1 {
+		t.Fatalf("Unexpected systems count %d", len(systems))
+	}
+	expected, err := setBitLabels(uint64(systems[0].SuiteMask), qualifiers["BitValues"], qualifiers["BitMap"])
+	if err != nil {
+		t.Fatalf("Failed to get expected labels; %s", err)
+	}
+	if !reflect.DeepEqual(systems[0].Suites, expected) {
+		t.Errorf("Unexpected suites of mask %#x; got %v, expected %v", systems[0].SuiteMask, systems[0].Suites, expected)
+	}
+}
+
+type driveType string
+
+func TestDecoder_Unmarshal_Enum(t *testing.T) {
+	labels := []interface{}{"Unknown", "Running", "Stopped"}
+	tests := []struct {
+		code     int64
+		valueMap interface{}
+		expected string
+	}{
+		{1, nil, "Running"},
+		{3, nil, "3"},
+		{5, []interface{}{"0", "5", "7"}, "Running"},
+		{1, []interface{}{"0", "5", "7"}, "1"},
+	}
+	for _, tt := range tests {
+		label, err := codeLabel(tt.code, labels, tt.valueMap)
+		if err != nil || label != tt.expected {
+			t.Errorf("Unexpected label of %d; got %q, %v, expected %q", tt.code, label, err, tt.expected)
+		}
+	}
+	if _, err := codeLabel(1, labels, []interface{}{"0"}); err == nil {
+		t.Errorf("Expected error for inconsistent qualifiers")
+	}
+
+	c := Client{Decoder: Decoder{EnumValues: map[reflect.Type]map[int64]string{
+		reflect.TypeOf(driveType("")): {2: "Removable", 3: "Local"},
+	}}}
+	var disks []struct {
+		DriveType driveType
+		Code      uint32 `wmi:"DriveType"`
+	}
+	if err := c.Query("SELECT DriveType FROM Win32_LogicalDisk WHERE DeviceID = 'C:'", &disks); err != nil {
+		t.Fatalf("Failed to query drive type; %s", err)
+	}
+	if len(disks) != 1 || disks[0].DriveType != "Local" || disks[0].Code != 3 {
+		t.Errorf("Unexpected drive type; %+v", disks)
+	}
+
+	// Values are amended, so they should be taken from the class.
+	props, err := DefaultClient.ClassProperties("Win32_LogicalDisk")
+	if err != nil {
+		t.Fatalf("Failed to get class properties; %s", err)
+	}
+	var qualifiers map[string]interface{}
+	for _, p := range props {
+		if p.Name == "DriveType" {
+			qualifiers = p.Qualifiers
+		}
+	}
+	expected, err := codeLabel(3, qualifiers["Values"], qualifiers["ValueMap"])
+	if err != nil || expected == "3" {
+		t.Fatalf("Failed to get expected label; %q, %v", expected, err)
+	}
+	var labeled []struct {
+		DriveType string `wmi:",enum"`
+	}
+	if err := DefaultClient.Query("SELECT DriveType FROM Win32_LogicalDisk WHERE DeviceID = 'C:'", &labeled); err != nil {
+		t.Fatalf("Failed to query drive type label; %s", err)
+	}
+	if len(labeled) != 1 || labeled[0].DriveType != expected {
+		t.Errorf("Unexpected drive type label; got %+v, expected %q", labeled, expected)
+	}
+
+	var invalid []struct {
+		DriveType uint32 `wmi:",enum"`
+	}
+	if err := c.Query("SELECT DriveType FROM Win32_LogicalDisk WHERE DeviceID = 'C:'", &invalid); err == nil {
+		t.Errorf("Expected error for non-string enum field")
+	}
+}
+
+func TestDecoder_Unmarshal_UnixTime(t *testing.T) {
+	expected := time.Date(2020, 9, 13, 12, 26, 40, 123000000, time.UTC)
+	tests := []struct {
+		value interface{}
+		unit  time.Duration
+	}{
+		{int64(1600000000), time.Second},
+		{uint64(1600000000123), time.Millisecond},
+		{"1600000000123000000", time.Nanosecond},
+	}
+	for _, tt := range tests {
+		got, err := unixTime(tt.value, tt.unit)
+		if err != nil {
+			t.Errorf("Failed to convert %v; %s", tt.value, err)
+			continue
+		}
+		if want := expected.Truncate(tt.unit); !got.Equal(want) {
+			t.Errorf("Unexpected time of %v; got %s, expected %s", tt.value, got, want)
+		}
+	}
+
+	// Real object.
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "KernelModeTime", "1600000000123"); err != nil {
+		t.Fatalf("Failed to set property; %s", err)
+	}
+	var dst struct {
+		Seconds time.Time  `wmi:"KernelModeTime,unix"`
+		Millis  *time.Time `wmi:"KernelModeTime,unixms"`
+	}
+	if err := (Decoder{}).Unmarshal(instance, &dst); err != nil {
+		t.Fatalf("Failed to unmarshal; %s", err)
+	}
+	if !dst.Millis.Equal(expected) {
+		t.Errorf("Unexpected milliseconds time; got %s, expected %s", dst.Millis, expected)
+	}
+	if dst.Seconds.Year() < 50000 {
+		t.Errorf("Unexpected seconds time; got %s", dst.Seconds)
+	}
+}
+
+func TestDecoder_Unmarshal_Alt(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	var dst struct {
+		Name      string `wmi:"NoSuchName,alt=Name"`
+		ProcessID uint32 `wmi:"NoSuchID,alt=NoSuchPID|ProcessId"`
+		Caption   string `wmi:"Caption,alt=Name"`
+		Missing   string `wmi:"NoSuchName,alt=NoSuchCaption"`
+	}
+	err = conn.Get(`Win32_Process.Handle="4"`, &dst)
+	if _, ok := err.(ErrFieldMismatch); !ok {
+		t.Errorf("Expected ErrFieldMismatch for missing alternates; got %v", err)
+	}
+	if dst.Name != "System" || dst.ProcessID != 4 {
+		t.Errorf("Alternate properties aren't used; %+v", dst)
+	}
+	if dst.Caption != "System" {
+		t.Errorf("Primary property isn't used; %+v", dst)
+	}
+}
+
+func TestDecoder_Unmarshal_ObjectSet(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	// Use query result as an out parameter holding SWbemObjectSet.
+	set, err := oleutil.CallMethod(conn.sWbemServices, "ExecQuery", "SELECT * FROM Win32_Process WHERE ProcessId = 4")
+	if err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	defer set.Clear()
+
+	var out struct {
+		Processes    []miniProcess
+		ProcessesPtr []*miniProcess
+	}
+	dst := reflect.ValueOf(&out).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if err := conn.unmarshalValue(dst.Field(i), set); err != nil {
+			t.Fatalf("Failed to unmarshal objects set into %s; %s", dst.Field(i).Type(), err)
+		}
+	}
+	if len(out.Processes) != 1 || out.Processes[0].ProcessId != 4 {
+		t.Errorf("Unexpected processes; %+v", out.Processes)
+	}
+	if len(out.ProcessesPtr) != 1 || out.ProcessesPtr[0].ProcessId != 4 {
+		t.Errorf("Unexpected processes; %+v", out.ProcessesPtr)
+	}
+}
+
+func TestDecoder_Unmarshal_Arrays(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_NetworkAdapterConfiguration")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "DNSDomainSuffixSearchOrder", []string{"a.local", "b.local"}); err != nil {
+		t.Fatalf("Failed to set strings array; %s", err)
+	}
+	if _, err := oleutil.PutProperty(instance, "GatewayCostMetric", []byte{1, 2, 3}); err != nil {
+		t.Fatalf("Failed to set numeric array; %s", err)
+	}
+
+	var arrays struct {
+		Suffixes    []string `wmi:"DNSDomainSuffixSearchOrder"`
+		Metrics     []int    `wmi:"GatewayCostMetric"`
+		MetricsU32  []uint32 `wmi:"GatewayCostMetric"`
+		MetricsPtr  *[]int64 `wmi:"GatewayCostMetric"`
+		NullAddress []string `wmi:"IPAddress"`
+	}
+	if err := (Decoder{}).Unmarshal(instance, &arrays); err != nil {
+		t.Fatalf("Failed to unmarshal arrays; %s", err)
+	}
+	if !reflect.DeepEqual(arrays.Suffixes, []string{"a.local", "b.local"}) {
+		t.Errorf("Unexpected strings array; %v", arrays.Suffixes)
+	}
+	if !reflect.DeepEqual(arrays.Metrics, []int{1, 2, 3}) || !reflect.DeepEqual(arrays.MetricsU32, []uint32{1, 2, 3}) {
+		t.Errorf("Unexpected numeric arrays; %v, %v", arrays.Metrics, arrays.MetricsU32)
+	}
+	if arrays.MetricsPtr == nil || !reflect.DeepEqual(*arrays.MetricsPtr, []int64{1, 2, 3}) {
+		t.Errorf("Unexpected numeric array pointer; %v", arrays.MetricsPtr)
+	}
+	if arrays.NullAddress != nil {
+		t.Errorf("NULL array isn't unmarshalled as nil; %v", arrays.NullAddress)
+	}
+
+	// Elements type mismatch.
+	var mismatch struct {
+		Suffixes []bool `wmi:"DNSDomainSuffixSearchOrder"`
+	}
+	err = (Decoder{}).Unmarshal(instance, &mismatch)
+	if e, ok := err.(ErrFieldMismatch); !ok || !strings.Contains(e.Reason, "DNSDomainSuffixSearchOrder") {
+		t.Errorf("Expected ErrFieldMismatch naming the property; got %v", err)
+	}
+}
+
+func TestDecoder_Unmarshal_BoolFloat(t *testing.T) {
+	var systems []struct {
+		Primary     bool
+		PrimaryPtr  *bool `wmi:"Primary"`
+		Distributed bool
+	}
+	if err := Query("SELECT Primary, Distributed FROM Win32_OperatingSystem", &systems); err != nil {
+		t.Fatalf("Failed to query OS; %s", err)
+	}
+	if len(systems) != 1 || !systems[0].Primary || systems[0].PrimaryPtr == nil || !*systems[0].PrimaryPtr || systems[0].Distributed {
+		t.Errorf("Unexpected bool values; %+v", systems)
+	}
+
+	var mismatch []struct{ Primary int }
+	err := Query("SELECT Primary FROM Win32_OperatingSystem", &mismatch)
+	if e, ok := err.(ErrFieldMismatch); !ok || !strings.Contains(e.Reason, "cannot assign WMI bool to int") {
+		t.Errorf("Unexpected bool into int error; %v", err)
+	}
+
+	// Floats coercion.
+	var f32 float32
+	var f64 float64
+	var b bool
+	tests := []struct {
+		dst   interface{}
+		value interface{}
+		ok    bool
+	}{
+		{&f32, float32(1.5), true},
+		{&f64, float32(1.5), true},
+		{&f64, float64(2.5), true},
+		{&f32, float64(2.5), false},
+		{&b, float64(1), false},
+		{&f64, true, false},
+	}
+	for _, tt := range tests {
+		err := unmarshalSimpleValue(reflect.ValueOf(tt.dst).Elem(), tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("Unexpected result of %T into %T; %v", tt.value, tt.dst, err)
+		}
+	}
+	if f32 != 1.5 || f64 != 2.5 {
+		t.Errorf("Unexpected floats; %v, %v", f32, f64)
+	}
+}
+
+func TestDecoder_Unmarshal_ParamID(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	// Mock `Win32_Process.Create` out parameters.
+	classRaw, err := conn.Dereference("Win32_Process")
+	if err != nil {
+		t.Fatalf("Failed to get Win32_Process class; %s", err)
+	}
+	defer classRaw.Clear()
+	method, err := oleutil.CallMethod(oleutil.MustGetProperty(classRaw.ToIDispatch(), "Methods_").ToIDispatch(), "Item", "Create")
+	if err != nil {
+		t.Fatalf("Failed to get Create method; %s", err)
+	}
+	defer method.Clear()
+	outParamsClass := oleutil.MustGetProperty(method.ToIDispatch(), "OutParameters").ToIDispatch()
+	defer outParamsClass.Release()
+	outParams := oleutil.MustCallMethod(outParamsClass, "SpawnInstance_").ToIDispatch()
+	defer outParams.Release()
+	oleutil.MustPutProperty(outParams, "ProcessId", int32(42))
+	oleutil.MustPutProperty(outParams, "ReturnValue", int32(0))
+
+	var result struct {
+		ReturnValue uint32
+		PID         uint32 `wmi:",paramid=3"` // ProcessId.
+	}
+	if err := (Decoder{}).Unmarshal(outParams, &result); err != nil {
+		t.Fatalf("Failed to unmarshal out parameters; %s", err)
+	}
+	if result.PID != 42 || result.ReturnValue != 0 {
+		t.Errorf("Unexpected out parameters; %+v", result)
+	}
+
+	var wrong struct {
+		PID uint32 `wmi:",paramid=100"`
+	}
+	if err := (Decoder{}).Unmarshal(outParams, &wrong); err == nil {
+		t.Errorf("Expected error for unknown paramid")
+	}
+}
+
+type resource interface {
+	ResourceName() string
+}
+
+type processResource struct {
+	Name string
+}
+
+func (p *processResource) ResourceName() string { return p.Name }
+
+type systemResource struct {
+	Caption string
+}
+
+func (s systemResource) ResourceName() string { return s.Caption }
+
+func TestDecoder_Unmarshal_ClassTypes(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	d := Decoder{ClassTypes: map[string]reflect.Type{
+		"Win32_Process":       reflect.TypeOf(processResource{}),
+		"CIM_OperatingSystem": reflect.TypeOf(systemResource{}), // Parent of Win32_OperatingSystem.
+	}}
+	type event struct {
+		Instance resource `wmi:"TargetInstance"`
+	}
+
+	for _, tt := range []struct {
+		path     string
+		expected reflect.Type
+	}{
+		{`Win32_Process.Handle="4"`, reflect.TypeOf(&processResource{})},
+		{`Win32_OperatingSystem=@`, reflect.TypeOf(systemResource{})},
+	} {
+		obj, err := conn.Dereference(tt.path)
+		if err != nil {
+			t.Fatalf("Failed to get %s; %s", tt.path, err)
+		}
+		e := spawnInstance(t, conn, "__InstanceModificationEvent")
+		oleutil.MustPutProperty(e, "TargetInstance", obj.ToIDispatch())
+
+		var dst event
+		if err := d.Unmarshal(e, &dst); err != nil {
+			t.Errorf("Failed to unmarshal %s; %s", tt.path, err)
+		} else if reflect.TypeOf(dst.Instance) != tt.expected || dst.Instance.ResourceName() == "" {
+			t.Errorf("Unexpected %s resource; got %#v", tt.path, dst.Instance)
+		}
+		e.Release()
+		obj.Clear()
+	}
+
+	// Not registered class.
+	obj, err := conn.Dereference(`Win32_Service.Name="Winmgmt"`)
+	if err != nil {
+		t.Fatalf("Failed to get WMI service; %s", err)
+	}
+	defer obj.Clear()
+	e := spawnInstance(t, conn, "__InstanceModificationEvent")
+	defer e.Release()
+	oleutil.MustPutProperty(e, "TargetInstance", obj.ToIDispatch())
+	var dst event
+	if err := d.Unmarshal(e, &dst); err == nil {
+		t.Errorf("Expected error for not registered class")
+	}
+}
+
+func TestDecoder_Unmarshal_CaseConflicts(t *testing.T) {
+	// WMI itself doesn't allow case conflicts, so check names resolution.
+	conflicts := caseConflicts([]string{"Name", "name", "NAME", "Caption", "ProcessId"})
+	expected := map[string][]string{"name": {"Name", "name", "NAME"}}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("Unexpected conflicts; got %v, expected %v", conflicts, expected)
+	}
+
+	// And that no conflicts found for regular objects.
+	c := Client{Decoder: Decoder{DisallowCaseConflicts: true}}
+	var processes []miniProcess
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Errorf("Failed to query running processes; %s", err)
+	}
+
+	d := Decoder{caseConflicts: conflicts}
+	var dst struct{ Name string }
+	if err := d.unmarshalField(nil, reflect.ValueOf(&dst).Elem().Field(0), &cachedFields(reflect.TypeOf(dst))[0]); err == nil {
+		t.Errorf("Expected ambiguous property error")
+	}
+}
+
+// Very self-sufficient process struct that is able to handle unmarshalling of
+// itself.
+type selfMadeProcess struct {
+	HexPID          string // Just because.
+	CoolProcessName string // Plus some beautification.
+}
+
+// Example of `wmi.Unmarshaler` interface implementation.
+func (p *selfMadeProcess) UnmarshalOLE(d Decoder, src *ole.IDispatch) (err error) {
+	var dto struct {
+		Name      string
+		ProcessId uint32
+	}
+	if err := d.Unmarshal(src, &dto); err != nil {
+		return err
+	}
+	p.HexPID = fmt.Sprintf("0x%x", dto.ProcessId)
+	p.CoolProcessName = fmt.Sprintf("-=%s=-", dto.Name)
+	return nil
+}
+
+type (
+	ctxKey    struct{}
+	cancelKey struct{}
+)
+
+// contextProcess prefers `wmi.ContextUnmarshaler` over `wmi.Unmarshaler`.
+type contextProcess struct {
+	Tag     interface{} // Value of ctxKey in the context.
+	Created time.Time
+}
+
+func (p *contextProcess) UnmarshalOLE(d Decoder, src *ole.IDispatch) error {
+	return errors.New("UnmarshalOLEContext should be preferred")
+}
+
+func (p *contextProcess) UnmarshalOLEContext(ctx context.Context, d Decoder, src *ole.IDispatch) error {
+	var dto struct {
+		CreationDate time.Time
+	}
+	if err := d.Unmarshal(src, &dto); err != nil {
+		return err
+	}
+	p.Tag = ctx.Value(ctxKey{})
+	p.Created = dto.CreationDate
+	if cancel, ok := ctx.Value(cancelKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+	return nil
+}
+
+func TestDecoder_Unmarshal_ContextUnmarshaler(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+	conn.TimeLocation = time.UTC
+
+	query := fmt.Sprintf("SELECT CreationDate FROM Win32_Process WHERE ProcessId = %d", os.Getpid())
+	ctx := context.WithValue(context.Background(), ctxKey{}, "tag")
+	var processes []contextProcess
+	if err := conn.QueryContext(ctx, query, &processes); err != nil {
+		t.Fatalf("Failed to query with context; %s", err)
+	}
+	if len(processes) != 1 || processes[0].Tag != "tag" {
+		t.Fatalf("Context isn't passed; %+v", processes)
+	}
+	if processes[0].Created.IsZero() || processes[0].Created.Location() != time.UTC {
+		t.Errorf("Decoder settings aren't passed; %v", processes[0].Created)
+	}
+
+	// No context is bound to the plain query.
+	if err := conn.Query(query, &processes); err != nil {
+		t.Fatalf("Failed to query without context; %s", err)
+	}
+	if len(processes) != 1 || processes[0].Tag != nil {
+		t.Errorf("Unexpected context value; %+v", processes)
+	}
+
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+	var p contextProcess
+	if err := conn.UnmarshalContext(ctx, instance, &p); err != nil || p.Tag != "tag" {
+		t.Errorf("Unexpected UnmarshalContext result; %+v, %v", p, err)
+	}
+
+	// Cancellation from the unmarshaler stops the query.
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelCtx = context.WithValue(cancelCtx, cancelKey{}, cancel)
+	err = conn.QueryContext(cancelCtx, "SELECT CreationDate FROM Win32_Process", &processes)
+	if err != context.Canceled {
+		t.Errorf("Unexpected error of cancelled query; %v", err)
+	}
+}
+
+func TestProperties(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "Name", "test.exe"); err != nil {
+		t.Fatalf("Failed to set Name; %s", err)
+	}
+
+	props, err := Properties(instance)
+	if err != nil {
+		t.Fatalf("Failed to get properties; %s", err)
+	}
+	if props["Name"] != "test.exe" {
+		t.Errorf("Unexpected Name; got %v", props["Name"])
+	}
+	if v, ok := props["ExecutablePath"]; !ok || v != nil {
+		t.Errorf("Unexpected NULL property; got %v, present %v", v, ok)
+	}
+	if _, ok := props["__CLASS"]; ok {
+		t.Errorf("System properties are returned")
+	}
+}
+
+type dumbUnmarshaller struct{}
+
+func (dumbUnmarshaller) UnmarshalOLE(d Decoder, src *ole.IDispatch) error {
+	return errors.New("always fail")
+}
+
+func TestDecoder_Unmarshal_NonePtrZero(t *testing.T) {
+	type process struct {
+		Name           string
+		ExecutablePath string // NULL for the System process.
+		Missing        string
+		MissingPtr     *string
+	}
+	query := "SELECT Name, ExecutablePath FROM Win32_Process WHERE ProcessId = 4"
+	stale := "stale"
+
+	c := Client{Decoder: Decoder{AllowMissingFields: true}}
+	dst := process{ExecutablePath: stale, Missing: stale, MissingPtr: &stale}
+	if err := c.Query(query, &dst); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if dst.ExecutablePath != stale || dst.Missing != stale || dst.MissingPtr == nil {
+		t.Errorf("Fields are changed by default; %+v", dst)
+	}
+
+	c.NonePtrZero = true
+	c.PtrNil = true
+	if err := c.Query(query, &dst); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if dst.Name != "System" || dst.ExecutablePath != "" || dst.Missing != "" || dst.MissingPtr != nil {
+		t.Errorf("Fields aren't reset; %+v", dst)
+	}
+}
+
+func TestDecoder_Unmarshal_NullPointers(t *testing.T) {
+	var dst struct {
+		String   *string
+		Uint16   *uint16
+		Uint32   *uint32
+		Int64    *int64
+		Bool     *bool
+		Float    *float64
+		Time     *time.Time
+		Strings  *[]string
+		NonePtr  uint32
+		NoneTime time.Time
+	}
+	v := reflect.ValueOf(&dst).Elem()
+	for _, vt := range []ole.VT{ole.VT_NULL, ole.VT_EMPTY} {
+		for _, d := range []Decoder{{}, {PtrNil: true}, {NonePtrZero: true}} {
+			dst.NonePtr = 1
+			for i := 0; i < v.NumField(); i++ {
+				if err := d.unmarshalValue(v.Field(i), &ole.VARIANT{VT: vt}); err != nil {
+					t.Errorf("Failed to unmarshal %s into %s; %s", vt, v.Type().Field(i).Name, err)
+				}
+				if f := v.Field(i); f.Kind() == reflect.Ptr && !f.IsNil() {
+					t.Errorf("Pointee is allocated for %s in %s with %+v", vt, v.Type().Field(i).Name, d)
+				}
+			}
+			if expected := map[bool]uint32{false: 1, true: 0}[d.NonePtrZero]; dst.NonePtr != expected {
+				t.Errorf("Unexpected non-pointer field for %s with %+v; got %d, expected %d", vt, d, dst.NonePtr, expected)
+			}
+		}
+	}
+
+	// System process has no executable path and command line.
+	var processes []Win32_Process
+	if err := Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if len(processes) != 1 {
+		t.Fatalf("Unexpected result length; got %d, expected 1", len(processes))
+	}
+	if p := processes[0]; p.ExecutablePath != nil || p.CommandLine != nil || p.TerminationDate != nil || p.InstallDate != nil {
+		t.Errorf("NULL pointer fields aren't nil; %+v", p)
+	}
+}
+
+func TestDecoder_Unmarshal_ObjectMap(t *testing.T) {
+	var objects []map[string]interface{}
+	if err := Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &objects); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("Unexpected objects count; %d", len(objects))
+	}
+	system := objects[0]
+	if system["Name"] != "System" || system["ProcessId"] != uint32(4) {
+		t.Errorf("Unexpected properties; %v", system)
+	}
+	if _, ok := system["CreationDate"].(time.Time); !ok {
+		t.Errorf("Unexpected CreationDate; %#v", system["CreationDate"])
+	}
+	if v, ok := system["ExecutablePath"]; !ok || v != nil {
+		t.Errorf("NULL property isn't mapped to nil; %#v, %v", v, ok)
+	}
+
+	one, err := QueryOne[map[string]interface{}]("SELECT Name FROM Win32_OperatingSystem")
+	if err != nil {
+		t.Fatalf("Failed to query operating system; %s", err)
+	}
+	if name, ok := one["Name"].(string); !ok || name == "" {
+		t.Errorf("Unexpected operating system; %v", one)
+	}
+}
+
+func TestDecoder_Unmarshal_TimeLocation(t *testing.T) {
+	type process struct {
+		CreationDate time.Time
+		CreationPtr  *time.Time `wmi:"CreationDate"`
+		InstallDate  *time.Time // NULL.
+	}
+	query := "SELECT CreationDate, InstallDate FROM Win32_Process WHERE ProcessId = 4"
+
+	var orig, utc process
+	if err := Query(query, &orig); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	c := Client{Decoder: Decoder{TimeLocation: time.UTC}}
+	if err := c.Query(query, &utc); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if utc.CreationDate.Location() != time.UTC || !utc.CreationDate.Equal(orig.CreationDate) {
+		t.Errorf("Unexpected UTC time; got %s, original %s", utc.CreationDate, orig.CreationDate)
+	}
+	if utc.CreationPtr == nil || utc.CreationPtr.Location() != time.UTC || !utc.CreationPtr.Equal(orig.CreationDate) {
+		t.Errorf("Unexpected UTC time pointer; got %v", utc.CreationPtr)
+	}
+	if utc.InstallDate != nil {
+		t.Errorf("Unexpected NULL time; got %v", utc.InstallDate)
+	}
+}
+
+func TestDecoder_Unmarshal_CaseSensitive(t *testing.T) {
+	type mixedCase struct {
+		Processid uint32
+		NAME      string
+		Path      string `wmi:"__path"`
+	}
+	query := "SELECT * FROM Win32_Process WHERE ProcessId = 4"
+
+	// Case-insensitive by default.
+	var processes []mixedCase
+	if err := Query(query, &processes); err != nil {
+		t.Fatalf("Failed to query mixed case fields; %s", err)
+	}
+	if len(processes) != 1 || processes[0].Processid != 4 || processes[0].NAME != "System" || processes[0].Path == "" {
+		t.Errorf("Unexpected mixed case result; %+v", processes)
+	}
+
+	c := Client{Decoder: Decoder{CaseSensitive: true}}
+	if err := c.Query(query, &processes); err == nil {
+		t.Errorf("Expected error for mismatching case")
+	}
+	var exact []miniProcess
+	if err := c.Query(query, &exact); err != nil || len(exact) != 1 || exact[0].Name != "System" {
+		t.Errorf("Failed to query exact case fields; %+v, %v", exact, err)
+	}
+
+	c.AllowMissingFields = true
+	if err := c.Query(query, &processes); err != nil {
+		t.Fatalf("Failed to query with missing fields allowed; %s", err)
+	}
+	if p := processes[0]; p.Processid != 0 || p.NAME != "" || p.Path == "" {
+		t.Errorf("Mismatching case fields are loaded; %+v", p)
+	}
+}
+
+func TestDecoder_Unmarshal_SystemProperties(t *testing.T) {
+	var processes []struct {
+		Win32_Process
+		Path      string `wmi:"__PATH"`
+		RelPath   string `wmi:"__RELPATH"`
+		Class     string `wmi:"__CLASS"`
+		Namespace string `wmi:"__NAMESPACE"`
+	}
+	if err := Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Fatalf("Failed to query system properties; %s", err)
 	}
-	// Get System process (always exists with PID=4)
 	if len(processes) != 1 {
-		t.Fatalf("Failed to find System (PID=4) process in running processes")
+		t.Fatalf("Unexpected result length; got %d, expected 1", len(processes))
 	}
-	system := processes[0]
-
-	// Check the fields.
-	if system.PID != 4 {
-		t.Fatalf("Unexpected System process PID; got %v, expected %v", system.PID, 4)
+	p := processes[0]
+	if p.Path == "" || !strings.HasSuffix(p.Path, p.RelPath) {
+		t.Errorf("Unexpected object path; __PATH %q, __RELPATH %q", p.Path, p.RelPath)
 	}
-	if system.Name != "System" {
-		t.Errorf("Unexpected System process name; got %v, expected %v", system.Name, "System")
+	if p.RelPath != `Win32_Process.Handle="4"` {
+		t.Errorf("Unexpected relative path; got %q", p.RelPath)
 	}
-	if system.UserField != "" {
-		t.Errorf("Spoiled field marked to skip; content %q", system.UserField)
+	if p.Class != "Win32_Process" || !strings.EqualFold(p.Namespace, `root\cimv2`) {
+		t.Errorf("Unexpected class or namespace; %q, %q", p.Class, p.Namespace)
 	}
 }
 
-// Very self-sufficient process struct that is able to handle unmarshalling of
-// itself.
-type selfMadeProcess struct {
-	HexPID          string // Just because.
-	CoolProcessName string // Plus some beautification.
+// systemFailer fails to decode the System process.
+type systemFailer struct {
+	ProcessId uint32
 }
 
-// Example of `wmi.Unmarshaler` interface implementation.
-func (p *selfMadeProcess) UnmarshalOLE(d Decoder, src *ole.IDispatch) (err error) {
-	var dto struct {
-		Name      string
-		ProcessId uint32
-	}
-	if err := d.Unmarshal(src, &dto); err != nil {
+func (p *systemFailer) UnmarshalOLE(d Decoder, src *ole.IDispatch) error {
+	type plain systemFailer // Without UnmarshalOLE.
+	if err := d.Unmarshal(src, (*plain)(p)); err != nil {
 		return err
 	}
-	p.HexPID = fmt.Sprintf("0x%x", dto.ProcessId)
-	p.CoolProcessName = fmt.Sprintf("-=%s=-", dto.Name)
+	if p.ProcessId == 4 {
+		return errors.New("System process")
+	}
 	return nil
 }
 
-type dumbUnmarshaller struct{}
+func TestDecoder_Unmarshal_OnError(t *testing.T) {
+	query := "SELECT ProcessId FROM Win32_Process WHERE ProcessId = 0 OR ProcessId = 4"
 
-func (dumbUnmarshaller) UnmarshalOLE(d Decoder, src *ole.IDispatch) error {
-	return errors.New("always fail")
+	// Aborted by default.
+	var processes []systemFailer
+	if err := Query(query, &processes); err == nil {
+		t.Errorf("Expected error for failing object")
+	}
+
+	var failures []int
+	c := Client{Decoder: Decoder{OnError: func(index int, err error) bool {
+		failures = append(failures, index)
+		return true
+	}}}
+	if err := c.Query(query, &processes); err != nil {
+		t.Fatalf("Failed to skip failing object; %s", err)
+	}
+	if len(processes) != 1 || processes[0].ProcessId != 0 || len(failures) != 1 {
+		t.Errorf("Unexpected result; objects %+v, failures %v", processes, failures)
+	}
+
+	c.OnError = func(int, error) bool { return false }
+	if err := c.Query(query, &processes); err == nil || err.Error() != "System process" {
+		t.Errorf("Unexpected error for aborted query; %v", err)
+	}
 }
 
 func TestDecoder_Unmarshal_Unmarshaler(t *testing.T) {
@@ -296,6 +1604,50 @@ func TestDecoder_Unmarshal_EmbeddedStructures(t *testing.T) {
 	}
 }
 
+func TestDecoder_Unmarshal_NestedInstance(t *testing.T) {
+	type event struct {
+		Target   Win32_Process  `wmi:"TargetInstance"`
+		Previous *Win32_Process `wmi:"PreviousInstance"`
+	}
+	events := make(chan event)
+	query, err := NewNotificationQuery(events, fmt.Sprintf(
+		"SELECT * FROM __InstanceModificationEvent WITHIN 1 "+
+			"WHERE TargetInstance ISA 'Win32_Process' AND TargetInstance.ProcessId = %d", os.Getpid()))
+	if err != nil {
+		t.Fatalf("Failed to create NotificationQuery; %s", err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- query.StartNotifications()
+	}()
+	defer func() {
+		query.Stop()
+		if err := <-errCh; err != nil {
+			t.Errorf("Notification query failed; %s", err)
+		}
+	}()
+
+	// Change the process counters until the modification is noticed.
+	timeout := time.After(10 * time.Second)
+	var garbage [][]byte
+	for {
+		select {
+		case e := <-events:
+			if e.Target.ProcessId != uint32(os.Getpid()) || e.Target.Name == "" {
+				t.Errorf("Unexpected target instance; %+v", e.Target)
+			}
+			if e.Previous == nil || e.Previous.ProcessId != e.Target.ProcessId {
+				t.Errorf("Unexpected previous instance; %+v", e.Previous)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("No modification events received")
+		case <-time.After(50 * time.Millisecond):
+			garbage = append(garbage, make([]byte, 1<<20))
+		}
+	}
+}
+
 // Win32_LoggedOnUser
 // https://docs.microsoft.com/en-us/windows/desktop/cimwin32prov/win32-loggedonuser
 type loggedUser struct {
@@ -339,3 +1691,608 @@ func TestDecoder_Unmarshal_References(t *testing.T) {
 		t.Errorf("Unexpected LogonID of current user; got %q", current.Session.LogonId)
 	}
 }
+
+func TestDecoder_Unmarshal_RefPaths(t *testing.T) {
+	var logons []struct {
+		Antecedent Ref
+		Dependent  string
+	}
+	if err := Query(`SELECT * FROM Win32_LoggedOnUser`, &logons); err != nil {
+		t.Fatalf("Failed to query Win32_LoggedOnUser; %s", err)
+	}
+	if len(logons) < 1 {
+		t.Fatalf("No logged users found")
+	}
+
+	class, keys, err := logons[0].Antecedent.Parse()
+	if err != nil {
+		t.Fatalf("Failed to parse Antecedent %q; %s", logons[0].Antecedent, err)
+	}
+	if class != "Win32_Account" && class != "Win32_UserAccount" && class != "Win32_SystemAccount" {
+		t.Errorf("Unexpected Antecedent class %q", class)
+	}
+	if _, ok := keys["Name"]; !ok {
+		t.Errorf("No Name key in Antecedent %q", logons[0].Antecedent)
+	}
+
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	var session struct {
+		LogonId string
+	}
+	if err := conn.Get(logons[0].Dependent, &session); err != nil {
+		t.Errorf("Failed to get Dependent %q; %s", logons[0].Dependent, err)
+	} else if session.LogonId == "" {
+		t.Errorf("Empty LogonId of %q", logons[0].Dependent)
+	}
+}
+
+func TestDecoder_Unmarshal_LargeUint32(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	// The scripting API passes CIM uint32 values above math.MaxInt32 as
+	// negative VT_I4 values.
+	const pid = 3000000000
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "ProcessId", int32(-1294967296)); err != nil {
+		t.Fatalf("Failed to set ProcessId; %s", err)
+	}
+
+	var wide struct {
+		ProcessId    int
+		ProcessIdPtr *int64  `wmi:"ProcessId"`
+		ProcessIdU   uint32  `wmi:"ProcessId"`
+		ProcessIdU64 uint64  `wmi:"ProcessId"`
+		ProcessIdF   float64 `wmi:"ProcessId"`
+	}
+	if err := (Decoder{WarnLossy: true}).Unmarshal(instance, &wide); err != nil {
+		t.Fatalf("Failed to unmarshal large uint32; %s", err)
+	}
+	if wide.ProcessId != pid || wide.ProcessIdPtr == nil || *wide.ProcessIdPtr != pid || wide.ProcessIdU != pid || wide.ProcessIdU64 != pid || wide.ProcessIdF != pid {
+		t.Errorf("Large uint32 is sign-extended; %+v", wide)
+	}
+
+	narrow := struct {
+		ProcessId int32
+	}{ProcessId: 7} // Reused destination.
+	err = (Decoder{}).Unmarshal(instance, &narrow)
+	if mismatch, ok := err.(ErrFieldMismatch); !ok || mismatch.FieldName != "ProcessId" || narrow.ProcessId != 0 {
+		t.Errorf("Expected overflow of int32; got %v, %d", err, narrow.ProcessId)
+	}
+
+	// Negative CIM sint32 values are kept.
+	location := spawnInstance(t, conn, "Win32_TimeZone")
+	defer location.Release()
+	if _, err := oleutil.PutProperty(location, "Bias", int32(-300)); err != nil {
+		t.Fatalf("Failed to set Bias; %s", err)
+	}
+	var tz struct {
+		Bias int
+	}
+	if err := (Decoder{AllowMissingFields: true}).Unmarshal(location, &tz); err != nil || tz.Bias != -300 {
+		t.Errorf("Unexpected sint32 value; got %d, %v", tz.Bias, err)
+	}
+	unsignedTZ := struct {
+		Bias uint64
+	}{Bias: 7}
+	err = (Decoder{AllowMissingFields: true}).Unmarshal(location, &unsignedTZ)
+	if mismatch, ok := err.(ErrFieldMismatch); !ok || mismatch.FieldName != "Bias" || unsignedTZ.Bias != 0 {
+		t.Errorf("Expected overflow of negative sint32 into uint64; got %v, %d", err, unsignedTZ.Bias)
+	}
+}
+
+// objectsArray creates VT_ARRAY|VT_DISPATCH VARIANT holding @objects.
+func objectsArray(t *testing.T, objects ...*ole.IDispatch) *ole.VARIANT {
+	oleaut := syscall.NewLazyDLL("oleaut32.dll")
+	arr, _, err := oleaut.NewProc("SafeArrayCreateVector").Call(uintptr(ole.VT_DISPATCH), 0, uintptr(len(objects)))
+	if arr == 0 {
+		t.Fatalf("Failed to create objects array; %s", err)
+	}
+	for i, obj := range objects {
+		index := int32(i)
+		hr, _, _ := oleaut.NewProc("SafeArrayPutElement").Call(arr, uintptr(unsafe.Pointer(&index)), uintptr(unsafe.Pointer(obj)))
+		if hr != 0 {
+			t.Fatalf("Failed to put array element; %s", ole.NewError(hr))
+		}
+	}
+	return &ole.VARIANT{VT: ole.VT_ARRAY | ole.VT_DISPATCH, Val: int64(arr)}
+}
+
+func TestDecoder_Unmarshal_EmbeddedObjectsArray(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	descriptor := spawnInstance(t, conn, "Win32_SecurityDescriptor")
+	defer descriptor.Release()
+	var aces []*ole.IDispatch
+	for _, mask := range []int32{0x1F01FF, 0x120089} {
+		ace := spawnInstance(t, conn, "Win32_ACE")
+		defer ace.Release()
+		oleutil.MustPutProperty(ace, "AccessMask", mask)
+		oleutil.MustPutProperty(ace, "AceType", int32(0))
+		aces = append(aces, ace)
+	}
+	dacl := objectsArray(t, aces...)
+	defer dacl.Clear()
+	if _, err := oleutil.PutProperty(descriptor, "DACL", dacl); err != nil {
+		t.Fatalf("Failed to set DACL; %s", err)
+	}
+
+	type ace struct {
+		AccessMask uint32
+		AceType    uint32
+	}
+	var sd struct {
+		DACL    []ace
+		DACLPtr []*ace `wmi:"DACL"`
+		SACL    []ace
+	}
+	if err := (Decoder{}).Unmarshal(descriptor, &sd); err != nil {
+		t.Fatalf("Failed to unmarshal embedded objects array; %s", err)
+	}
+	expected := []ace{{AccessMask: 0x1F01FF}, {AccessMask: 0x120089}}
+	if !reflect.DeepEqual(sd.DACL, expected) {
+		t.Errorf("Unexpected DACL; got %+v, expected %+v", sd.DACL, expected)
+	}
+	if len(sd.DACLPtr) != 2 || *sd.DACLPtr[1] != expected[1] {
+		t.Errorf("Unexpected DACL pointers; got %+v", sd.DACLPtr)
+	}
+	if sd.SACL != nil {
+		t.Errorf("NULL array isn't unmarshalled as nil; got %+v", sd.SACL)
+	}
+
+	var d Decoder
+	var masks []interface{}
+	d.Composite("Masks", []string{"DACL"}, func(v []interface{}) (interface{}, error) {
+		aces, ok := v[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected DACL type %T", v[0])
+		}
+		for _, a := range aces {
+			object, ok := a.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("unexpected ACE type %T", a)
+			}
+			masks = append(masks, object["AccessMask"])
+		}
+		return len(aces), nil
+	})
+	var composite struct{ Masks int }
+	if err := d.Unmarshal(descriptor, &composite); err != nil {
+		t.Fatalf("Failed to unmarshal composite of embedded objects array; %s", err)
+	}
+	expectedMasks := []interface{}{uint32(0x1F01FF), uint32(0x120089)}
+	if composite.Masks != 2 || !reflect.DeepEqual(masks, expectedMasks) {
+		t.Errorf("Unexpected composite DACL; got %d %v, expected %v", composite.Masks, masks, expectedMasks)
+	}
+}
+
+func TestDecoder_Unmarshal_NullObjectClass(t *testing.T) {
+	for _, prop := range []*ole.VARIANT{{VT: ole.VT_NULL}, {VT: ole.VT_EMPTY}, {VT: ole.VT_DISPATCH}, {VT: ole.VT_UNKNOWN}} {
+		if err := checkObjectClass(prop, "Win32_Trustee"); err != nil {
+			t.Errorf("NULL %s object fails the class check; %s", prop.VT, err)
+		}
+	}
+	if err := checkObjectClass(&ole.VARIANT{VT: ole.VT_I4, Val: 1}, "Win32_Trustee"); err == nil {
+		t.Errorf("Non-object value passes the class check")
+	}
+
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	descriptor := spawnInstance(t, conn, "Win32_SecurityDescriptor")
+	defer descriptor.Release()
+	type trustee struct{ Name string }
+	sd := struct {
+		Owner    trustee  `wmi:",class=Win32_Trustee"`
+		OwnerPtr *trustee `wmi:"Owner,class=Win32_Trustee"`
+	}{Owner: trustee{Name: "stale"}, OwnerPtr: &trustee{}}
+	if err := (Decoder{NonePtrZero: true, PtrNil: true}).Unmarshal(descriptor, &sd); err != nil {
+		t.Fatalf("Failed to unmarshal NULL object with class; %s", err)
+	}
+	if sd.Owner != (trustee{}) || sd.OwnerPtr != nil {
+		t.Errorf("NULL object isn't unmarshalled as zero; got %+v", sd)
+	}
+}
+
+func TestDecoder_Unmarshal_Interface(t *testing.T) {
+	type operatingSystem struct {
+		Caption                interface{}
+		NumberOfProcesses      interface{}
+		TotalVisibleMemorySize interface{}
+		Primary                interface{}
+		LastBootUpTime         interface{}
+		MUILanguages           interface{}
+	}
+	var types map[string]ole.VT
+	c := &Client{Decoder: Decoder{RawTypes: func(dst interface{}, t map[string]ole.VT) {
+		if _, ok := dst.(*operatingSystem); ok {
+			types = t
+		}
+	}}}
+	var system operatingSystem
+	if err := c.QueryOne("SELECT * FROM Win32_OperatingSystem", &system); err != nil {
+		t.Fatalf("Failed to query operating system; %s", err)
+	}
+	checks := map[string]struct {
+		value interface{}
+		check func(interface{}) bool
+		vt    ole.VT
+	}{
+		"Caption":                {system.Caption, func(v interface{}) bool { _, ok := v.(string); return ok }, ole.VT_BSTR},
+		"NumberOfProcesses":      {system.NumberOfProcesses, func(v interface{}) bool { n, ok := v.(int64); return ok && n > 0 }, ole.VT_I4},
+		"TotalVisibleMemorySize": {system.TotalVisibleMemorySize, func(v interface{}) bool { n, ok := v.(int64); return ok && n > 0 }, ole.VT_BSTR},
+		"Primary":                {system.Primary, func(v interface{}) bool { _, ok := v.(bool); return ok }, ole.VT_BOOL},
+		"LastBootUpTime":         {system.LastBootUpTime, func(v interface{}) bool { tm, ok := v.(time.Time); return ok && tm.Before(time.Now()) }, ole.VT_BSTR},
+		"MUILanguages":           {system.MUILanguages, func(v interface{}) bool { l, ok := v.([]string); return ok && len(l) > 0 }, ole.VT_ARRAY | ole.VT_BSTR},
+	}
+	for name, c := range checks {
+		if !c.check(c.value) {
+			t.Errorf("Unexpected %s value; got %T %v", name, c.value, c.value)
+		}
+		if types[name] != c.vt {
+			t.Errorf("Unexpected %s raw type; got %s, expected %s", name, types[name], c.vt)
+		}
+	}
+}
+
+func TestDecoder_Unmarshal_Default(t *testing.T) {
+	var processes []struct {
+		ProcessId      uint32
+		ExecutablePath string  `wmi:",default=unknown"`
+		CommandLine    *string `wmi:",default=none"`
+		Missing        int     `wmi:",default=-1"`
+		MissingBool    bool    `wmi:",default=true"`
+	}
+	c := &Client{Decoder: Decoder{AllowMissingFields: true}}
+	query := "SELECT ProcessId, ExecutablePath, CommandLine FROM Win32_Process WHERE ProcessId = 4"
+	if err := c.Query(query, &processes); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if len(processes) != 1 {
+		t.Fatalf("Unexpected number of processes; %d", len(processes))
+	}
+	p := processes[0]
+	if p.ExecutablePath != "unknown" || p.CommandLine == nil || *p.CommandLine != "none" || p.Missing != -1 || !p.MissingBool {
+		t.Errorf("Defaults aren't set; %+v", p)
+	}
+
+	var invalid []struct {
+		ExecutablePath int `wmi:",default=unknown"`
+	}
+	err := c.Query(query, &invalid)
+	if mismatch, ok := err.(ErrFieldMismatch); !ok || !strings.Contains(mismatch.Reason, `invalid default "unknown"`) {
+		t.Errorf("Expected invalid default error; got %v", err)
+	}
+}
+
+func TestDecoder_QueryFlags(t *testing.T) {
+	type process struct {
+		Name string
+		Path string `wmi:"__PATH"`
+	}
+	query := "SELECT Name FROM Win32_Process WHERE ProcessId = 4"
+
+	var plain []process
+	if err := Query(query, &plain); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if len(plain) != 1 || plain[0].Path != "" {
+		t.Fatalf("Unexpected query result without flags; %+v", plain)
+	}
+
+	c := &Client{Decoder: Decoder{QueryFlags: QueryFlagEnsureLocatable | QueryFlagForwardOnly}}
+	var locatable []process
+	if err := c.Query(query, &locatable); err != nil {
+		t.Fatalf("Failed to query System process with flags; %s", err)
+	}
+	if len(locatable) != 1 || !strings.Contains(locatable[0].Path, `Win32_Process.Handle="4"`) {
+		t.Errorf("Unexpected query result with QueryFlagEnsureLocatable; %+v", locatable)
+	}
+}
+
+func TestDecoder_Unmarshal_UTF16(t *testing.T) {
+	var bioses []struct {
+		Raw     []uint16    `wmi:"BiosCharacteristics"`
+		Text    string      `wmi:"BiosCharacteristics"`
+		TextPtr *string     `wmi:"BiosCharacteristics"`
+		Forced  interface{} `wmi:"BiosCharacteristics,utf16"`
+	}
+	if err := Query("SELECT BiosCharacteristics FROM Win32_BIOS", &bioses); err != nil {
+		t.Fatalf("Failed to query Win32_BIOS; %s", err)
+	}
+	if len(bioses) == 0 {
+		t.Fatal("Win32_BIOS returned no objects")
+	}
+	bios := bioses[0]
+	if len(bios.Raw) == 0 {
+		t.Fatal("Unexpected empty BiosCharacteristics")
+	}
+	expected := string(utf16.Decode(bios.Raw))
+	if bios.Text != expected {
+		t.Errorf("Unexpected UTF-16 string; got %q, expected %q", bios.Text, expected)
+	}
+	if bios.TextPtr == nil || *bios.TextPtr != expected {
+		t.Errorf("Unexpected UTF-16 string pointer; %v", bios.TextPtr)
+	}
+	if bios.Forced != expected {
+		t.Errorf("Unexpected forced UTF-16 interface{}; got %#v, expected %q", bios.Forced, expected)
+	}
+}
+
+func TestDecoder_UnmarshalValue(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	instance := spawnInstance(t, conn, "Win32_Process")
+	defer instance.Release()
+	if _, err := oleutil.PutProperty(instance, "Name", "reflected.exe"); err != nil {
+		t.Fatalf("Failed to set property; %s", err)
+	}
+
+	type process struct {
+		Name string
+	}
+	v := reflect.New(reflect.TypeOf(process{})).Elem()
+	if err := conn.UnmarshalValue(instance, v); err != nil {
+		t.Fatalf("Failed to unmarshal into reflect.Value; %s", err)
+	}
+	if p := v.Interface().(process); p.Name != "reflected.exe" {
+		t.Errorf("Unexpected unmarshalled value; %+v", p)
+	}
+
+	m := reflect.New(reflect.TypeOf(map[string]interface{}{})).Elem()
+	if err := conn.UnmarshalValue(instance, m); err != nil {
+		t.Fatalf("Failed to unmarshal into map reflect.Value; %s", err)
+	}
+	if name := m.MapIndex(reflect.ValueOf("Name")); !name.IsValid() || name.Interface() != "reflected.exe" {
+		t.Errorf("Unexpected unmarshalled map; %v", m.Interface())
+	}
+
+	invalid := []reflect.Value{
+		reflect.ValueOf(process{}),            // Not settable.
+		reflect.New(reflect.TypeOf(0)).Elem(), // Not a struct.
+		{},
+	}
+	for _, v := range invalid {
+		if err := conn.UnmarshalValue(instance, v); !errors.Is(err, ErrInvalidEntityType) {
+			t.Errorf("Unexpected error for %v; %v", v, err)
+		}
+	}
+	if err := conn.Unmarshal(instance, process{}); !errors.Is(err, ErrInvalidEntityType) {
+		t.Errorf("Unexpected error for non-pointer destination; %v", err)
+	}
+}
+
+type processBase struct {
+	Name      string
+	ProcessId uint32
+}
+
+// Embedded struct pointers should be exported to be allocated.
+type SystemProps struct {
+	Class string `wmi:"__CLASS"`
+}
+
+func TestDecoder_Unmarshal_Embedded(t *testing.T) {
+	type process struct {
+		processBase
+		*SystemProps
+		Handle string
+		Name   string `wmi:"Caption"` // Shadows processBase.Name.
+	}
+	var processes []process
+	query := CreateQueryFrom(&processes, "Win32_Process", "WHERE ProcessId = 4")
+	if !strings.HasPrefix(query, "SELECT ProcessId, __CLASS, Handle, Caption FROM") {
+		t.Errorf("Unexpected query for embedded structs; %q", query)
+	}
+	if err := Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if len(processes) != 1 {
+		t.Fatalf("Unexpected number of processes; %d", len(processes))
+	}
+	p := processes[0]
+	if p.ProcessId != 4 || p.Handle != "4" || p.Name != "System" || p.processBase.Name != "" {
+		t.Errorf("Unexpected embedded fields; %+v", p)
+	}
+	if p.SystemProps == nil || p.Class != "Win32_Process" {
+		t.Errorf("Embedded struct pointer isn't allocated; %+v", p.SystemProps)
+	}
+}
+
+// syntheticObject creates a new not saved class with the only `Value`
+// property of @cimType set to @value.
+func syntheticObject(t *testing.T, conn *SWbemServicesConnection, cimType CIMType, value interface{}) *ole.IDispatch {
+	classRaw, err := conn.Dereference("")
+	if err != nil {
+		t.Fatalf("Failed to create empty class; %s", err)
+	}
+	class := classRaw.ToIDispatch()
+	pathRaw, err := oleutil.GetProperty(class, "Path_")
+	if err != nil {
+		t.Fatalf("Failed to get class path; %s", err)
+	}
+	defer pathRaw.Clear()
+	if _, err := oleutil.PutProperty(pathRaw.ToIDispatch(), "Class", "Go_SyntheticTypes"); err != nil {
+		t.Fatalf("Failed to set class name; %s", err)
+	}
+	propsRaw, err := oleutil.GetProperty(class, "Properties_")
+	if err != nil {
+		t.Fatalf("Failed to get properties; %s", err)
+	}
+	defer propsRaw.Clear()
+	propRaw, err := oleutil.CallMethod(propsRaw.ToIDispatch(), "Add", "Value", int32(cimType))
+	if err != nil {
+		t.Fatalf("Failed to add %d property; %s", cimType, err)
+	}
+	defer propRaw.Clear()
+	if _, err := oleutil.PutProperty(propRaw.ToIDispatch(), "Value", value); err != nil {
+		t.Fatalf("Failed to set %d property to %v; %s", cimType, value, err)
+	}
+	return class
+}
+
+func TestDecoder_Unmarshal_SmallTypes(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	fullwidthA := int16(-223) // U+FF21 passed as VT_I2.
+	wide := "Ａ"
+	interfaceType := reflect.TypeOf((*interface{})(nil)).Elem()
+	tests := []struct {
+		name     string
+		cimType  CIMType
+		value    interface{}
+		dstType  reflect.Type
+		expected interface{}
+	}{
+		{"sint8 into int8", CIMTypeSint8, int16(-5), reflect.TypeOf(int8(0)), int8(-5)},
+		{"sint8 into int64", CIMTypeSint8, int16(-5), reflect.TypeOf(int64(0)), int64(-5)},
+		{"sint8 into interface", CIMTypeSint8, int16(-5), interfaceType, int64(-5)},
+		{"sint16 into int16", CIMTypeSint16, int16(-223), reflect.TypeOf(int16(0)), int16(-223)},
+		{"char16 into string", CIMTypeChar16, int16('A'), reflect.TypeOf(""), "A"},
+		{"wide char16 into string", CIMTypeChar16, fullwidthA, reflect.TypeOf(""), wide},
+		{"wide char16 into string pointer", CIMTypeChar16, fullwidthA, reflect.TypeOf(&wide), &wide},
+		{"wide char16 into uint16", CIMTypeChar16, fullwidthA, reflect.TypeOf(uint16(0)), uint16(0xFF21)},
+		{"wide char16 into rune", CIMTypeChar16, fullwidthA, reflect.TypeOf(rune(0)), rune(0xFF21)},
+		{"wide char16 into interface", CIMTypeChar16, fullwidthA, interfaceType, int64(0xFF21)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := syntheticObject(t, conn, tt.cimType, tt.value)
+			defer obj.Release()
+
+			dst := reflect.New(reflect.StructOf([]reflect.StructField{{Name: "Value", Type: tt.dstType}})).Elem()
+			if err := conn.UnmarshalValue(obj, dst); err != nil {
+				t.Fatalf("Failed to unmarshal; %s", err)
+			}
+			if got := dst.Field(0).Interface(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Unexpected value; got %#v, expected %#v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDecoder_RequireAllFields(t *testing.T) {
+	var processes []struct {
+		Name       string
+		ProcesId   uint32 // Typo.
+		Missing    string
+		Skipped    string `wmi:"-"`
+		unexported string
+	}
+	c := &Client{Decoder: Decoder{RequireAllFields: true, AllowMissingFields: true}}
+	err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes)
+	missing, ok := err.(ErrMissingFields)
+	if !ok || !reflect.DeepEqual(missing.Fields, []string{"ProcesId", "Missing"}) {
+		t.Errorf("Unexpected error for missing fields; %v", err)
+	}
+
+	var complete []struct {
+		Name      string
+		ProcessId uint32
+	}
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &complete); err != nil || len(complete) != 1 {
+		t.Errorf("Failed to query with all fields present; %v", err)
+	}
+}
+
+func TestDecoder_Unmarshal_Int64Strings(t *testing.T) {
+	var memory []struct {
+		AvailableBytes uint64
+		CommittedBytes int64
+		CommitLimit    string // Keeps the raw string.
+	}
+	err := Query("SELECT AvailableBytes, CommittedBytes, CommitLimit FROM Win32_PerfRawData_PerfOS_Memory", &memory)
+	if err != nil {
+		t.Fatalf("Failed to query memory counters; %s", err)
+	}
+	if len(memory) != 1 {
+		t.Fatalf("Unexpected memory counters count %d", len(memory))
+	}
+	m := memory[0]
+	if m.AvailableBytes == 0 || m.CommittedBytes <= 0 {
+		t.Errorf("64-bit counters aren't decoded; %+v", m)
+	}
+	if limit, err := strconv.ParseUint(m.CommitLimit, 10, 64); err != nil || limit < uint64(m.CommittedBytes) {
+		t.Errorf("Unexpected raw CommitLimit %q", m.CommitLimit)
+	}
+
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+	obj := syntheticObject(t, conn, CIMTypeString, "12 bytes")
+	defer obj.Release()
+	var dst struct{ Value uint64 }
+	if err := conn.Unmarshal(obj, &dst); err == nil || !strings.Contains(err.Error(), "not an unsigned integer") {
+		t.Errorf("Unexpected error for non-numeric string; %v", err)
+	}
+}
+
+func TestDecoder_Unmarshal_EmptyStringPtr(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	empty := syntheticObject(t, conn, CIMTypeString, "")
+	defer empty.Release()
+	null := syntheticObject(t, conn, CIMTypeString, nil)
+	defer null.Release()
+
+	for _, ptrNil := range []bool{false, true} {
+		d := Decoder{PtrNil: ptrNil}
+		var dst struct{ Value *string }
+		if err := d.Unmarshal(empty, &dst); err != nil {
+			t.Fatalf("Failed to unmarshal empty string; %s", err)
+		}
+		if dst.Value == nil || *dst.Value != "" {
+			t.Errorf("Empty string isn't distinct from NULL (PtrNil=%v); got %v", ptrNil, dst.Value)
+		}
+
+		dst.Value = nil
+		if err := d.Unmarshal(null, &dst); err != nil {
+			t.Fatalf("Failed to unmarshal NULL; %s", err)
+		}
+		if dst.Value != nil {
+			t.Errorf("NULL is unmarshalled into non-nil pointer (PtrNil=%v); got %q", ptrNil, *dst.Value)
+		}
+	}
+
+	// NULL ExecutablePath of the System process.
+	var processes []struct {
+		Name           *string
+		ExecutablePath *string
+	}
+	if err := Query("SELECT Name, ExecutablePath FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if len(processes) != 1 || processes[0].Name == nil || processes[0].ExecutablePath != nil {
+		t.Errorf("Unexpected System process; %+v", processes)
+	}
+}
@@ -0,0 +1,126 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+)
+
+// FieldChange describes a change of the single structure field found by
+// `Diff`.
+type FieldChange struct {
+	Field    string // Go structure field name.
+	Property string // WMI property name the field is mapped to.
+	Old, New interface{}
+}
+
+// Diff compares two instances @old and @new of the same structure type (or
+// pointers to it) and returns the changes of the fields mapped to WMI
+// properties. Unexported fields and fields skipped with `wmi:"-"` tag are
+// ignored. Field values are compared using `reflect.DeepEqual`.
+func Diff(old, new interface{}) ([]FieldChange, error) {
+	oldV, newV := reflect.ValueOf(old), reflect.ValueOf(new)
+	if !oldV.IsValid() || !newV.IsValid() {
+		return nil, errors.New("can't diff nil values")
+	}
+	if oldV.Kind() == reflect.Ptr && newV.Kind() == reflect.Ptr {
+		if oldV.IsNil() || newV.IsNil() {
+			return nil, errors.New("can't diff nil values")
+		}
+		oldV, newV = oldV.Elem(), newV.Elem()
+	}
+	if oldV.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't diff %s; should be a struct", oldV.Type())
+	}
+	if oldV.Type() != newV.Type() {
+		return nil, fmt.Errorf("can't diff different types %s and %s", oldV.Type(), newV.Type())
+	}
+
+	var changes []FieldChange
+	for _, f := range cachedFields(oldV.Type()) {
+		if f.skipped() {
+			continue
+		}
+		o, n := embeddedValue(oldV, f.index), embeddedValue(newV, f.index)
+		if !reflect.DeepEqual(o, n) {
+			changes = append(changes, FieldChange{
+				Field:    f.Name,
+				Property: f.name,
+				Old:      o,
+				New:      n,
+			})
+		}
+	}
+	return changes, nil
+}
+
+// embeddedValue returns the value of the (possibly embedded) field of @v by
+// the @index sequence. Fields of the nil embedded structs are nil.
+func embeddedValue(v reflect.Value, index []int) interface{} {
+	if f, ok := fieldByIndex(v, index, false); ok {
+		return f.Interface()
+	}
+	return nil
+}
+
+// UnmarshalModification unmarshals `TargetInstance` of the
+// `__InstanceModificationEvent` @event into @dst and returns its changes
+// comparing to the `PreviousInstance` found by `Diff`. @dst should be a
+// pointer to struct.
+//
+// ErrFieldMismatch of any instance is returned along with the changes.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/--instancemodificationevent
+func (d Decoder) UnmarshalModification(event *ole.IDispatch, dst interface{}) (changes []FieldChange, err error) {
+	dstV := reflect.ValueOf(dst)
+	if dstV.Kind() != reflect.Ptr || dstV.IsNil() || dstV.Elem().Kind() != reflect.Struct {
+		return nil, ErrInvalidEntityType
+	}
+	previous := reflect.New(dstV.Elem().Type()).Interface()
+
+	var errFieldMismatch error
+	for _, instance := range []struct {
+		property string
+		dst      interface{}
+	}{
+		{"PreviousInstance", previous},
+		{"TargetInstance", dst},
+	} {
+		err := d.unmarshalEmbedded(event, instance.property, instance.dst)
+		if _, ok := err.(ErrFieldMismatch); ok {
+			errFieldMismatch = err
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if changes, err = Diff(previous, dst); err != nil {
+		return nil, err
+	}
+	return changes, errFieldMismatch
+}
+
+// unmarshalEmbedded unmarshals the embedded object of @src @property into
+// @dst.
+func (d Decoder) unmarshalEmbedded(src *ole.IDispatch, property string, dst interface{}) (err error) {
+	prop, err := oleutil.GetProperty(src, property)
+	if err != nil {
+		return fmt.Errorf("no property %q; %v", property, err)
+	}
+	defer func() {
+		if clErr := prop.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	obj := prop.ToIDispatch()
+	if obj == nil {
+		return fmt.Errorf("property %q is not an object", property)
+	}
+	return d.Unmarshal(obj, dst)
+}
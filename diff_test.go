@@ -0,0 +1,101 @@
+// +build windows
+
+package wmi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bi-zone/go-ole/oleutil"
+)
+
+func TestDiff(t *testing.T) {
+	type service struct {
+		Win32_Service
+		Status  string `wmi:"State"`
+		Checked bool   `wmi:"-"`
+	}
+	old := service{
+		Win32_Service: Win32_Service{Name: "Spooler", State: "Running", Started: true, ProcessId: 42},
+		Status:        "Running",
+	}
+	new := old
+	new.Win32_Service.State = "Stopped"
+	new.Status = "Stopped"
+	new.Checked = true
+
+	changes, err := Diff(old.Win32_Service, new.Win32_Service)
+	if err != nil {
+		t.Fatalf("Failed to diff services; %s", err)
+	}
+	expected := []FieldChange{{Field: "State", Property: "State", Old: "Running", New: "Stopped"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Unexpected changes; got %+v, expected %+v", changes, expected)
+	}
+
+	// Pointers, tags and skipped fields.
+	changes, err = Diff(&old, &new)
+	if err != nil {
+		t.Fatalf("Failed to diff services; %s", err)
+	}
+	if len(changes) != 2 || changes[0].Field != "Win32_Service" || changes[1].Property != "State" {
+		t.Errorf("Unexpected changes; %+v", changes)
+	}
+
+	if changes, err := Diff(old, old); err != nil || len(changes) != 0 {
+		t.Errorf("Unexpected diff of the same values; %v, %v", changes, err)
+	}
+	if _, err := Diff(old, new.Win32_Service); err == nil {
+		t.Errorf("Expected error diffing different types")
+	}
+	if _, err := Diff(nil, old); err == nil {
+		t.Errorf("Expected error diffing nil with value")
+	}
+	if _, err := Diff(old, nil); err == nil {
+		t.Errorf("Expected error diffing value with nil")
+	}
+}
+
+func TestDecoder_UnmarshalModification(t *testing.T) {
+	conn, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer conn.Close()
+
+	// Build the modification event manually.
+	event := spawnInstance(t, conn, "__InstanceModificationEvent")
+	defer event.Release()
+	for property, name := range map[string]string{
+		"PreviousInstance": "old.exe",
+		"TargetInstance":   "new.exe",
+	} {
+		instance := spawnInstance(t, conn, "Win32_Process")
+		defer instance.Release()
+		if _, err := oleutil.PutProperty(instance, "Name", name); err != nil {
+			t.Fatalf("Failed to set Name; %s", err)
+		}
+		if _, err := oleutil.PutProperty(instance, "Caption", "same"); err != nil {
+			t.Fatalf("Failed to set Caption; %s", err)
+		}
+		if _, err := oleutil.PutProperty(event, property, instance); err != nil {
+			t.Fatalf("Failed to set %s; %s", property, err)
+		}
+	}
+
+	var current struct {
+		Title   string `wmi:"Name"`
+		Caption string
+	}
+	changes, err := conn.UnmarshalModification(event, &current)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal modification; %s", err)
+	}
+	if current.Title != "new.exe" || current.Caption != "same" {
+		t.Errorf("Unexpected current instance; %+v", current)
+	}
+	expected := []FieldChange{{Field: "Title", Property: "Name", Old: "old.exe", New: "new.exe"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Unexpected changes; got %+v, expected %+v", changes, expected)
+	}
+}
@@ -11,6 +11,18 @@ one is "prefer SWbemServicesConnection if you bother about performance and just
 wmi.Query if not". More detailed benchmarks are available in the repo:
 https://github.com/bi-zone/wmi#benchmarks
 
+COM initialization is handled by the package itself: while any WMI object
+(SWbemServices, connection, running NotificationQuery, etc.) is alive, the
+package keeps a multithreaded COM apartment initialized using
+https://github.com/scjalliance/comshim. So all the calls could be done and
+the result channels could be read from any goroutine without locking OS threads
+or calling CoInitialize manually. Threads the caller has already initialized
+(in either apartment model, e.g. by other COM libraries) are used as is: the
+package never calls CoUninitialize for them. Row timeouts (QueryOptions.RowTimeout
+and Client.Timeout) aren't supported on the STA threads though, since the objects
+are fetched from another thread then. Use Client.Connect to perform all the
+Client calls on a single OS thread owned by the Client.
+
 More reference about WMI is available in Microsoft Docs:
 https://docs.microsoft.com/en-us/windows/win32/wmisdk/wmi-reference)
 */
@@ -0,0 +1,376 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bi-zone/go-ole"
+)
+
+var (
+	// ErrWMIUnavailable is returned when WMI COM objects can't be created or
+	// WMI service refuses to serve the connection. Usually it means that WMI
+	// service is disabled or not installed on the host.
+	ErrWMIUnavailable = errors.New("wmi: WMI service disabled or not installed")
+
+	// ErrAccessDenied is returned when WMI service refuses the connection
+	// because of the invalid credentials or the lack of permissions. WMIError
+	// of the access denied codes matches it with `errors.Is` too.
+	ErrAccessDenied = errors.New("wmi: access denied")
+
+	// ErrInvalidClass is matched by WMIError with WBEM_E_INVALID_CLASS code,
+	// e.g. `errors.Is(err, wmi.ErrInvalidClass)`.
+	ErrInvalidClass = errors.New("wmi: invalid class")
+
+	// ErrInvalidQuery is matched by WMIError with WBEM_E_INVALID_QUERY code.
+	ErrInvalidQuery = errors.New("wmi: invalid query")
+
+	// ErrProviderNotFound is matched by WMIError with WBEM_E_PROVIDER_NOT_FOUND
+	// code, i.e. the class exists, but its provider isn't registered. Notice
+	// that a query of the class without instances succeeds with no objects.
+	ErrProviderNotFound = errors.New("wmi: provider not found")
+
+	// ErrHostUnreachable is returned when the remote WMI server can't be
+	// reached, e.g. it doesn't exist or RPC is blocked by a firewall.
+	ErrHostUnreachable = errors.New("wmi: host unreachable")
+
+	// ErrNoResults is returned when a query into a single structure returned
+	// no objects.
+	ErrNoResults = errors.New("wmi: query returned no results")
+
+	// ErrNotFound is matched by WMIError with WBEM_E_NOT_FOUND code, e.g. when
+	// the object path passed to `Client.Get` doesn't resolve.
+	ErrNotFound = errors.New("wmi: object not found")
+
+	// ErrNoObjectPath is returned by the operations requiring an object path
+	// for the empty one. Usually it's a `__PATH` of the object that has no
+	// path (e.g. an event object or an object without keys).
+	ErrNoObjectPath = errors.New("wmi: object has no path")
+
+	// ErrRowTimeout is returned when fetching of a single query result object
+	// took longer than `QueryOptions.RowTimeout`.
+	ErrRowTimeout = errors.New("wmi: query result object fetch timed out")
+
+	// ErrRowTimeoutSTA is returned for the queries with a row timeout made on
+	// an OS thread initialized as a single-threaded COM apartment. The timeout
+	// requires fetching the objects from another thread, which isn't allowed
+	// for the STA-bound enumerators.
+	ErrRowTimeoutSTA = errors.New("wmi: row timeout isn't supported on STA threads")
+
+	// ErrTimeout is returned when fetching of a single query result object
+	// took longer than `Client.Timeout`. It matches ErrRowTimeout as well, so
+	// `errors.Is(err, ErrRowTimeout)` holds for both timeouts while
+	// `errors.Is(err, ErrTimeout)` holds only for the Client one.
+	ErrTimeout error = clientTimeoutError{}
+)
+
+// clientTimeoutError is the type of ErrTimeout.
+type clientTimeoutError struct{}
+
+func (clientTimeoutError) Error() string {
+	return "wmi: query timed out"
+}
+
+func (clientTimeoutError) Is(target error) bool {
+	return target == ErrRowTimeout
+}
+
+// ErrMultipleResults is returned when a query into a single structure
+// returned more than one object. The first one is loaded into the destination
+// anyway.
+type ErrMultipleResults struct {
+	Count int // Number of objects returned by the query.
+}
+
+func (e ErrMultipleResults) Error() string {
+	return fmt.Sprintf("wmi: query returned %d results, expected one", e.Count)
+}
+
+// ErrMissingFields is returned by the Decoder with `Decoder.RequireAllFields`
+// set if some struct fields have no properties in the unmarshalled object.
+type ErrMissingFields struct {
+	Fields []string // Names of the unmatched struct fields.
+}
+
+func (e ErrMissingFields) Error() string {
+	return fmt.Sprintf("wmi: no properties for the struct fields: %s", strings.Join(e.Fields, ", "))
+}
+
+// ErrMethodFailed is returned when WMI method returned non-zero
+// `ReturnValue`. Meaning of the value is method specific.
+type ErrMethodFailed struct {
+	Method      string
+	ReturnValue uint32
+}
+
+func (e ErrMethodFailed) Error() string {
+	return fmt.Sprintf("wmi: method %s failed with return value %d", e.Method, e.ReturnValue)
+}
+
+// WMIError is a WMI failure of the COM call (e.g. `ExecQuery` or `Get`) with
+// the HRESULT and the message reported by the provider. Use `errors.As` to
+// get it or `errors.Is` with ErrInvalidClass, ErrInvalidQuery, ErrNotFound,
+// ErrProviderNotFound or ErrAccessDenied to check the failure kind.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/wmi-error-constants
+type WMIError struct {
+	HResult int32
+	Code    string // Symbolic name of the HResult, e.g. WBEM_E_INVALID_CLASS.
+	Message string // Description of the error, if any.
+
+	err error // Original COM error.
+}
+
+func (e WMIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("wmi: %s (0x%08X)", e.Code, uint32(e.HResult))
+	}
+	return fmt.Sprintf("wmi: %s (0x%08X): %s", e.Code, uint32(e.HResult), e.Message)
+}
+
+// Unwrap returns the original COM error.
+func (e WMIError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether the error matches the @target sentinel error.
+func (e WMIError) Is(target error) bool {
+	switch uint32(e.HResult) {
+	case wbemEInvalidClass:
+		return target == ErrInvalidClass
+	case wbemEInvalidQuery:
+		return target == ErrInvalidQuery
+	case wbemEProviderNotFound:
+		return target == ErrProviderNotFound
+	case wbemENotFound:
+		return target == ErrNotFound
+	case eAccessDenied, wbemEAccessDenied:
+		return target == ErrAccessDenied
+	}
+	return false
+}
+
+// newWMIError wraps the COM call error @err into WMIError if it has HRESULT,
+// other errors are returned as is.
+func newWMIError(err error) error {
+	code, ok := oleErrorCode(err)
+	if !ok {
+		return err
+	}
+	e := WMIError{HResult: int32(code), Code: hresultNames[code], err: err}
+	if e.Code == "" {
+		e.Code = fmt.Sprintf("0x%08X", code)
+	}
+	var oleErr *ole.OleError
+	if errors.As(err, &oleErr) {
+		// EXCEPINFO is filled from the provider IErrorInfo.
+		if exception, ok := oleErr.SubError().(ole.EXCEPINFO); ok {
+			e.Message = exception.Error()
+		} else {
+			e.Message = oleErr.Error()
+		}
+	}
+	return e
+}
+
+// ErrIncompleteResult is returned when the query results enumeration ended
+// not in a regular way. That happens when a provider signals the end of
+// enumeration too early. The warning is reported if either:
+//   - the enumeration terminated with a code other than S_FALSE (e.g. with
+//     WBEM_S_NO_MORE_DATA or some failure code);
+//   - less objects than reported by `SWbemObjectSet.Count` were received
+//     (not checked for the forward-only queries, see
+//     `QueryOptions.ForwardOnly`).
+//
+// Like the ErrFieldMismatch it's a "soft" error: all the received objects are
+// loaded into the destination, so the caller could either use them or retry
+// the query. If both errors occurred ErrIncompleteResult is returned.
+// `SWbemServicesConnection.QueryWith` reports it in `QueryResult.Warnings`
+// instead of returning it.
+//
+// If the enumeration terminated with a failure code (e.g. when the queried
+// class doesn't exist or its provider isn't registered), the WMIError of the
+// code is available via `errors.As` and `errors.Is`. If that happened after
+// some objects have been decoded, ErrIncompleteResult is wrapped into
+// PartialResultError.
+type ErrIncompleteResult struct {
+	Expected int    // Objects count reported by SWbemObjectSet.
+	Received int    // Objects received during the enumeration.
+	Code     uint32 // HRESULT the enumeration terminated with.
+}
+
+func (e ErrIncompleteResult) Error() string {
+	return fmt.Sprintf("wmi: incomplete query result: received %d of %d objects, enumeration ended with 0x%08X",
+		e.Received, e.Expected, e.Code)
+}
+
+// Unwrap returns WMIError of the failure Code, if any.
+func (e ErrIncompleteResult) Unwrap() error {
+	if e.Code&0x80000000 == 0 {
+		return nil // Not FAILED(Code).
+	}
+	return newWMIError(ole.NewError(uintptr(e.Code)))
+}
+
+// PartialResultError is returned when the query results enumeration failed
+// after some objects have been decoded, e.g. when a provider fails partway
+// through. The decoded objects are loaded into the destination, so the caller
+// could decide whether the partial data is acceptable. Use `errors.As` to get
+// it, the enumeration failure is available via `errors.As` too.
+//
+// Objects skipped by `Decoder.OnError` aren't counted in Decoded, and the
+// enumeration failure itself is never passed to OnError. PartialResultError
+// isn't returned for the single struct destinations.
+type PartialResultError struct {
+	Decoded int   // Objects loaded into the destination.
+	Err     error // Enumeration failure, e.g. ErrIncompleteResult.
+}
+
+func (e PartialResultError) Error() string {
+	return fmt.Sprintf("wmi: query failed after %d decoded objects; %v", e.Decoded, e.Err)
+}
+
+// Unwrap returns the enumeration failure.
+func (e PartialResultError) Unwrap() error {
+	return e.Err
+}
+
+// ErrResultLimitExceeded is returned if the query result has more objects
+// than `Client.MaxResults`. The first Limit objects are loaded anyway.
+type ErrResultLimitExceeded struct {
+	Limit int
+}
+
+func (e ErrResultLimitExceeded) Error() string {
+	return fmt.Sprintf("wmi: query result exceeds the limit of %d objects", e.Limit)
+}
+
+// ErrNamespaceNotFound is returned on connection to the non-existing WMI
+// namespace. If any parent of the namespace exists, the closest one is
+// reported along with its child namespaces.
+type ErrNamespaceNotFound struct {
+	Namespace string   // Requested namespace.
+	Existing  string   // The closest existing parent namespace, if any.
+	Available []string // Child namespaces of the Existing one.
+}
+
+func (e ErrNamespaceNotFound) Error() string {
+	if e.Existing == "" {
+		return fmt.Sprintf("wmi: namespace %q not found", e.Namespace)
+	}
+	return fmt.Sprintf("wmi: namespace %q not found; namespace %q has children: %s",
+		e.Namespace, e.Existing, strings.Join(e.Available, ", "))
+}
+
+// HRESULT codes the package cares about.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/wmi-error-constants
+const (
+	sOK                        = 0x00000000
+	eAccessDenied              = 0x80070005
+	wbemEAccessDenied          = 0x80041003
+	sFalse                     = 0x00000001
+	wbemSNoMoreData            = 0x00040005
+	wbemENotFound              = 0x80041002
+	wbemEInvalidNamespace      = 0x8004100E
+	wbemEInvalidClass          = 0x80041010
+	wbemEInvalidQuery          = 0x80041017
+	wbemEProviderNotFound      = 0x80041011
+	wbemErrTimedOut            = 0x80043001
+	wbemEProviderLoadFailure   = 0x80041013
+	coEServerExecFailure       = 0x80080005
+	regdbEClassNotReg          = 0x80040154
+	coEClassString             = 0x800401F3
+	errorServiceDisabledResult = 0x80070422 // HRESULT_FROM_WIN32(ERROR_SERVICE_DISABLED)
+	rpcSServerUnavailable      = 0x800706BA // HRESULT_FROM_WIN32(RPC_S_SERVER_UNAVAILABLE)
+	wbemECallCancelled         = 0x80041032
+	wbemEServerTooBusy         = 0x80041045
+	wbemEQuotaViolation        = 0x8004106C
+	rpcSServerTooBusy          = 0x800706BB // HRESULT_FROM_WIN32(RPC_S_SERVER_TOO_BUSY)
+	rpcSCallFailed             = 0x800706BE // HRESULT_FROM_WIN32(RPC_S_CALL_FAILED)
+	dispEUnknownName           = 0x80020006
+)
+
+// hresultNames are the symbolic names of the common HRESULT codes used in
+// WMIError.
+var hresultNames = map[uint32]string{
+	eAccessDenied:            "E_ACCESSDENIED",
+	wbemEAccessDenied:        "WBEM_E_ACCESS_DENIED",
+	wbemENotFound:            "WBEM_E_NOT_FOUND",
+	wbemEInvalidNamespace:    "WBEM_E_INVALID_NAMESPACE",
+	wbemEInvalidClass:        "WBEM_E_INVALID_CLASS",
+	wbemEInvalidQuery:        "WBEM_E_INVALID_QUERY",
+	wbemEProviderNotFound:    "WBEM_E_PROVIDER_NOT_FOUND",
+	wbemEProviderLoadFailure: "WBEM_E_PROVIDER_LOAD_FAILURE",
+	wbemECallCancelled:       "WBEM_E_CALL_CANCELLED",
+	wbemEServerTooBusy:       "WBEM_E_SERVER_TOO_BUSY",
+	wbemEQuotaViolation:      "WBEM_E_QUOTA_VIOLATION",
+	rpcSServerTooBusy:        "RPC_S_SERVER_TOO_BUSY",
+	rpcSCallFailed:           "RPC_S_CALL_FAILED",
+}
+
+// oleErrorCode extracts HRESULT from the COM call error. For errors caused by
+// the exceptions in IDispatch calls SCODE of the exception is returned.
+func oleErrorCode(err error) (code uint32, ok bool) {
+	var oleErr *ole.OleError
+	if !errors.As(err, &oleErr) {
+		return 0, false
+	}
+	if exception, ok := oleErr.SubError().(ole.EXCEPINFO); ok && exception.SCODE() != 0 {
+		return exception.SCODE(), true
+	}
+	return uint32(oleErr.Code()), true
+}
+
+// isUnavailableError checks if the error @err means that WMI service is not
+// able to serve requests at all.
+func isUnavailableError(err error) bool {
+	code, ok := oleErrorCode(err)
+	if !ok {
+		return false
+	}
+	switch code {
+	case wbemEProviderLoadFailure, coEServerExecFailure, regdbEClassNotReg, errorServiceDisabledResult:
+		return true
+	}
+	return false
+}
+
+// isClassStringError checks if the error @err means that the ProgID isn't
+// registered. For the standard locator that means WMI scripting isn't
+// installed, for the custom ones it's likely a mistyped ProgID.
+func isClassStringError(err error) bool {
+	code, ok := oleErrorCode(err)
+	return ok && code == coEClassString
+}
+
+// isAccessDeniedError checks if the error @err means that the caller has no
+// rights for the operation.
+func isAccessDeniedError(err error) bool {
+	code, ok := oleErrorCode(err)
+	return ok && (code == eAccessDenied || code == wbemEAccessDenied)
+}
+
+// isUnreachableError checks if the error @err means that the remote server
+// can't be reached.
+func isUnreachableError(err error) bool {
+	code, ok := oleErrorCode(err)
+	return ok && code == rpcSServerUnavailable
+}
+
+// isMissingPropertyError checks if the error @err of a property get means that
+// the object has no such property.
+func isMissingPropertyError(err error) bool {
+	code, ok := oleErrorCode(err)
+	return ok && (code == dispEUnknownName || code == wbemENotFound)
+}
+
+// isNotFoundError checks if the error @err means that the requested object or
+// class doesn't exist.
+func isNotFoundError(err error) bool {
+	code, ok := oleErrorCode(err)
+	return ok && (code == wbemENotFound || code == wbemEInvalidClass)
+}
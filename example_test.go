@@ -5,6 +5,7 @@ package wmi_test
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/bi-zone/wmi"
 )
@@ -30,3 +31,31 @@ func Example_enumerateRunningProcesses() {
 		fmt.Printf("%6d\t%s\n", v.PID, v.Name)
 	}
 }
+
+func ExampleFormatCIMDateTime() {
+	var dst []win32Process
+
+	since := time.Now().Add(-time.Hour)
+	q := wmi.CreateQueryFrom(&dst, "Win32_Process",
+		fmt.Sprintf("WHERE CreationDate >= '%s'", wmi.FormatCIMDateTime(since)))
+
+	if err := wmi.Query(q, &dst); err != nil {
+		log.Fatal(err)
+	}
+	for _, v := range dst {
+		fmt.Printf("%6d\t%s\n", v.PID, v.Name)
+	}
+}
+
+func ExampleClient_ExecMethod_static() {
+	// Win32_Process.Create is static, so it's called on the class path.
+	var created struct {
+		ProcessId   uint32
+		ReturnValue uint32
+	}
+	in := struct{ CommandLine string }{"notepad.exe"}
+	if err := wmi.DefaultClient.ExecMethod("Win32_Process", "Create", in, &created); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Started notepad.exe with PID %d\n", created.ProcessId)
+}
@@ -0,0 +1,123 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+// StructFromClass generates the Go structure definition for the @className
+// class. See `Client.StructFromClass` for the details.
+//
+// StructFromClass is a wrapper around DefaultClient.StructFromClass.
+func StructFromClass(className string, connectServerArgs ...interface{}) (string, error) {
+	return defaultClient().StructFromClass(className, connectServerArgs...)
+}
+
+// StructFromClass generates the gofmt-ed Go structure definition for the
+// @className class using its property descriptions (see
+// `Client.ClassProperties`). The structure could be used as a query
+// destination as is, e.g. for `Win32_Process`:
+//   type Win32_Process struct {
+//       Caption      *string
+//       CreationDate *time.Time
+//       Handle       string
+//       ...
+//   }
+//
+// Field types are inferred from the CIM types: arrays become slices, embedded
+// objects become interface{} and the properties which may be NULL (all except
+// those with `key`, `Not_Null` or `Required` qualifiers) become pointers.
+// Properties with names that aren't valid exported Go identifiers get the
+// `wmi` tag with the original name. Importing "time" package is up to the
+// caller.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) StructFromClass(className string, connectServerArgs ...interface{}) (string, error) {
+	props, err := c.ClassProperties(className, connectServerArgs...)
+	if err != nil {
+		return "", err
+	}
+	return structFromProperties(className, props)
+}
+
+// structFromProperties generates the structure definition of the @className
+// class with @props.
+func structFromProperties(className string, props []PropertyInfo) (string, error) {
+	var b strings.Builder
+	typeName, _ := goIdentifier(className)
+	fmt.Fprintf(&b, "type %s struct {\n", typeName)
+	for _, p := range props {
+		fieldType := cimGoTypeName(p.CIMType)
+		switch {
+		case p.IsArray:
+			fieldType = "[]" + fieldType
+		case p.CIMType != CIMTypeObject && isNullable(p):
+			fieldType = "*" + fieldType
+		}
+		fieldName, changed := goIdentifier(p.Name)
+		fmt.Fprintf(&b, "\t%s %s", fieldName, fieldType)
+		if changed {
+			fmt.Fprintf(&b, " `wmi:%q`", p.Name)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n")
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", fmt.Errorf("can't format generated structure; %v", err)
+	}
+	return string(src), nil
+}
+
+// cimGoTypeName returns the name of the Go type the values of @t are decoded
+// into by the Decoder.
+func cimGoTypeName(t CIMType) string {
+	switch t {
+	case CIMTypeObject:
+		return "interface{}"
+	case CIMTypeDatetime:
+		return "time.Time"
+	}
+	if goType := t.GoType(); goType != nil {
+		return goType.String()
+	}
+	return "interface{}"
+}
+
+// isNullable checks if the property @p could be NULL.
+func isNullable(p PropertyInfo) bool {
+	if p.IsKey {
+		return false
+	}
+	for name, value := range p.Qualifiers {
+		if (strings.EqualFold(name, "Not_Null") || strings.EqualFold(name, "Required")) && value == true {
+			return false
+		}
+	}
+	return true
+}
+
+// goIdentifier converts @name into a valid exported Go identifier. @changed
+// is true if the identifier differs from @name.
+func goIdentifier(name string) (ident string, changed bool) {
+	runes := []rune(name)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			runes[i] = '_'
+		}
+	}
+	if len(runes) == 0 || !unicode.IsUpper(runes[0]) {
+		if len(runes) > 0 && unicode.IsLower(runes[0]) {
+			runes[0] = unicode.ToUpper(runes[0])
+		} else {
+			runes = append([]rune("X"), runes...)
+		}
+	}
+	ident = string(runes)
+	return ident, ident != name
+}
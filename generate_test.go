@@ -0,0 +1,58 @@
+// +build windows
+
+package wmi
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestStructFromProperties(t *testing.T) {
+	props := []PropertyInfo{
+		{Name: "Handle", CIMType: CIMTypeString, IsKey: true},
+		{Name: "Count", CIMType: CIMTypeUint32, Qualifiers: map[string]interface{}{"Not_Null": true}},
+		{Name: "Size", CIMType: CIMTypeUint64},
+		{Name: "InstallDate", CIMType: CIMTypeDatetime},
+		{Name: "Names", CIMType: CIMTypeString, IsArray: true},
+		{Name: "Info", CIMType: CIMTypeObject},
+		{Name: "lower", CIMType: CIMTypeBoolean},
+		{Name: "Bad-Name", CIMType: CIMTypeSint16},
+	}
+	src, err := structFromProperties("Test_Class", props)
+	if err != nil {
+		t.Fatalf("Failed to generate structure; %s", err)
+	}
+	expected := "type Test_Class struct {\n" +
+		"\tHandle      string\n" +
+		"\tCount       uint32\n" +
+		"\tSize        *uint64\n" +
+		"\tInstallDate *time.Time\n" +
+		"\tNames       []string\n" +
+		"\tInfo        interface{}\n" +
+		"\tLower       *bool  `wmi:\"lower\"`\n" +
+		"\tBad_Name    *int16 `wmi:\"Bad-Name\"`\n" +
+		"}\n"
+	if src != expected {
+		t.Errorf("Unexpected generated structure; got\n%s\nexpected\n%s", src, expected)
+	}
+}
+
+func TestStructFromClass(t *testing.T) {
+	src, err := StructFromClass("Win32_Process")
+	if err != nil {
+		t.Fatalf("Failed to generate Win32_Process structure; %s", err)
+	}
+	for _, field := range []string{"\tHandle ", "\tCreationDate ", "\tProcessId "} {
+		if !strings.Contains(src, field) {
+			t.Errorf("Field %q is missing in\n%s", field, src)
+		}
+	}
+	if !strings.Contains(src, "*time.Time") {
+		t.Errorf("Datetime fields aren't generated in\n%s", src)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+src, 0); err != nil {
+		t.Errorf("Generated structure doesn't compile; %s", err)
+	}
+}
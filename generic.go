@@ -0,0 +1,152 @@
+// +build windows
+
+package wmi
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+)
+
+// QueryAll runs the WQL query and returns the result as a slice of T, e.g.
+//   processes, err := wmi.QueryAll[Win32_Process]("SELECT * FROM Win32_Process")
+//
+// The slice of the loaded objects is returned along with the "soft" errors:
+// ErrFieldMismatch, ErrIncompleteResult, PartialResultError and
+// ErrResultLimitExceeded. More info about result unmarshalling is available
+// in `Decoder.Unmarshal` doc.
+//
+// QueryAll is a wrapper around `ClientQueryAll` using DefaultClient.
+func QueryAll[T any](query string, connectServerArgs ...interface{}) ([]T, error) {
+	return ClientQueryAll[T](defaultClient(), query, connectServerArgs...)
+}
+
+// ClientQueryAll runs the WQL query using the Client @c and returns the result
+// as a slice of T. See `QueryAll` for the details.
+func ClientQueryAll[T any](c *Client, query string, connectServerArgs ...interface{}) ([]T, error) {
+	var dst []T
+	err := c.Query(query, &dst, connectServerArgs...)
+	if err != nil && !isPartialResult(err) {
+		return nil, err
+	}
+	return dst, err
+}
+
+// isPartialResult checks if the query error @err leaves the loaded objects
+// usable, so they should be returned along with it.
+func isPartialResult(err error) bool {
+	if _, ok := err.(ErrFieldMismatch); ok {
+		return true
+	}
+	var incomplete ErrIncompleteResult
+	var partial PartialResultError
+	var limit ErrResultLimitExceeded
+	return errors.As(err, &incomplete) || errors.As(err, &partial) || errors.As(err, &limit)
+}
+
+// Associators returns the objects associated with the object at @sourcePath
+// filtered by @opts as a slice of T, e.g. to walk from a disk to its
+// partitions:
+//   partitions, err := wmi.Associators[Win32_DiskPartition](disk.Path,
+//       wmi.AssocOpts{ResultClass: "Win32_DiskPartition"})
+//
+// @sourcePath is `__PATH` or `__RELPATH` of an already decoded object, or one
+// built by ObjectPath. Empty path leads to ErrNoObjectPath, malformed ones
+// (e.g. with unbalanced quotes) are rejected without calling WMI. The results
+// are returned in the same way as by `QueryAll`.
+//
+// Associators is a wrapper around `ClientAssociators` using DefaultClient.
+func Associators[T any](sourcePath string, opts AssocOpts, connectServerArgs ...interface{}) ([]T, error) {
+	return ClientAssociators[T](defaultClient(), sourcePath, opts, connectServerArgs...)
+}
+
+// ClientAssociators returns the objects associated with the object at
+// @sourcePath using the Client @c. See `Associators` for the details.
+func ClientAssociators[T any](c *Client, sourcePath string, opts AssocOpts, connectServerArgs ...interface{}) ([]T, error) {
+	if err := checkObjectPath(sourcePath); err != nil {
+		return nil, err
+	}
+	return ClientQueryAll[T](c, associatorsQuery(sourcePath, opts), connectServerArgs...)
+}
+
+// QueryOne runs the WQL query expected to return exactly one object and
+// returns it as T, e.g.
+//   system, err := wmi.QueryOne[Win32_Process]("SELECT * FROM Win32_Process WHERE ProcessId = 4")
+//
+// T should be a struct. ErrNoResults and ErrMultipleResults are returned in
+// the same way as in `Client.QueryOne`. The object is returned along with
+// ErrMultipleResults and ErrFieldMismatch errors.
+//
+// QueryOne is a wrapper around `ClientQueryOne` using DefaultClient.
+func QueryOne[T any](query string, connectServerArgs ...interface{}) (T, error) {
+	return ClientQueryOne[T](defaultClient(), query, connectServerArgs...)
+}
+
+// ClientQueryOne runs the WQL query using the Client @c and returns the only
+// result object as T. See `QueryOne` for the details.
+func ClientQueryOne[T any](c *Client, query string, connectServerArgs ...interface{}) (T, error) {
+	var dst T
+	err := c.QueryOne(query, &dst, connectServerArgs...)
+	switch err.(type) {
+	case nil, ErrMultipleResults, ErrFieldMismatch:
+		return dst, err
+	}
+	var zero T
+	return zero, err
+}
+
+// QueryChan runs the WQL query and sends the result objects to the returned
+// channel one by one as they are fetched, so the memory usage doesn't depend
+// on the result size, e.g.
+//   events, errs := wmi.QueryChan[Win32_NTLogEvent](ctx, "SELECT * FROM Win32_NTLogEvent")
+//   for e := range events {
+//       ...
+//   }
+//   if err := <-errs; err != nil {
+//       ...
+//   }
+//
+// T should be a struct or a pointer to struct. The query uses a forward-only
+// enumerator and every COM object is released before the next one is
+// fetched. Both channels are closed when the enumeration ends; the error
+// channel receives at most one error (ErrFieldMismatch if no other errors
+// occurred). Cancel @ctx to stop the enumeration early, otherwise the
+// enumeration blocks until the objects channel is drained.
+//
+// QueryChan is a wrapper around `ClientQueryChan` using DefaultClient.
+func QueryChan[T any](ctx context.Context, query string, connectServerArgs ...interface{}) (<-chan T, <-chan error) {
+	return ClientQueryChan[T](defaultClient(), ctx, query, connectServerArgs...)
+}
+
+// ClientQueryChan runs the WQL query using the Client @c and sends the result
+// objects to the returned channel. See `QueryChan` for the details.
+//
+// The query is performed using its own connection even if @c is connected,
+// so the other calls of @c could be made while the results are consumed.
+func ClientQueryChan[T any](c *Client, ctx context.Context, query string, connectServerArgs ...interface{}) (<-chan T, <-chan error) {
+	objects := make(chan T)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(objects)
+		defer close(errs)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		elemType := reflect.TypeOf((*T)(nil)).Elem()
+		err := c.withOwnConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+			return conn.queryEach(ctx, query, elemType, func(ev reflect.Value) error {
+				select {
+				case objects <- ev.Interface().(T):
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return objects, errs
+}
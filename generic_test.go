@@ -0,0 +1,150 @@
+// +build windows
+
+package wmi
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQueryAll(t *testing.T) {
+	processes, err := QueryAll[Win32_Process]("SELECT * FROM Win32_Process WHERE ProcessId = 4")
+	if err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if len(processes) != 1 || processes[0].Name != "System" {
+		t.Errorf("Unexpected processes; %+v", processes)
+	}
+
+	// Client decoder is used.
+	c := &Client{Decoder: Decoder{AllowMissingFields: true}}
+	type process struct {
+		Name        string
+		NoSuchField string
+	}
+	missing, err := ClientQueryAll[process](c, "SELECT * FROM Win32_Process WHERE ProcessId = 4")
+	if err != nil {
+		t.Fatalf("Client decoder isn't used; %s", err)
+	}
+	if len(missing) != 1 || missing[0].Name != "System" {
+		t.Errorf("Unexpected processes; %+v", missing)
+	}
+
+	// Objects loaded before the limit was hit are returned.
+	limited, err := ClientQueryAll[Win32_Process](&Client{MaxResults: 1}, "SELECT * FROM Win32_Process")
+	var limitErr ErrResultLimitExceeded
+	if !errors.As(err, &limitErr) || len(limited) != 1 {
+		t.Errorf("Unexpected limited result; got %d objects, %v", len(limited), err)
+	}
+
+	if _, err := QueryAll[int]("SELECT * FROM Win32_Process"); err != ErrInvalidEntityType {
+		t.Errorf("Unexpected error for invalid type; got %v", err)
+	}
+}
+
+func TestQueryOne(t *testing.T) {
+	system, err := QueryOne[Win32_Process]("SELECT * FROM Win32_Process WHERE ProcessId = 4")
+	if err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if system.Name != "System" {
+		t.Errorf("Unexpected process; %+v", system)
+	}
+
+	_, err = QueryOne[Win32_Process]("SELECT * FROM Win32_Process WHERE ProcessId = 4294967295")
+	if err != ErrNoResults {
+		t.Errorf("Unexpected error for no results; got %v, expected %v", err, ErrNoResults)
+	}
+	first, err := QueryOne[Win32_Process]("SELECT * FROM Win32_Process")
+	if _, ok := err.(ErrMultipleResults); !ok || first.Name == "" {
+		t.Errorf("Unexpected result for multiple results; got %+v, %v", first, err)
+	}
+
+	var processes []Win32_Process
+	if err := DefaultClient.QueryOne("SELECT * FROM Win32_Process", &processes); err != ErrInvalidEntityType {
+		t.Errorf("Unexpected error for slice destination; %v", err)
+	}
+}
+
+func TestQueryChan(t *testing.T) {
+	processes, errs := QueryChan[*Win32_Process](context.Background(), "SELECT * FROM Win32_Process")
+	count := 0
+	system := false
+	for p := range processes {
+		count++
+		system = system || p.ProcessId == 4
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Failed to query processes; %s", err)
+	}
+	if count == 0 || !system {
+		t.Errorf("Unexpected processes; got %d, System found: %v", count, system)
+	}
+
+	// Consumer stops early.
+	ctx, cancel := context.WithCancel(context.Background())
+	processes, errs = QueryChan[*Win32_Process](ctx, "SELECT * FROM Win32_Process")
+	<-processes
+	cancel()
+	for range processes {
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("Unexpected error after cancel; got %v, expected %v", err, context.Canceled)
+	}
+
+	if _, errs := QueryChan[int](context.Background(), "SELECT * FROM Win32_Process"); <-errs != ErrInvalidEntityType {
+		t.Errorf("Expected ErrInvalidEntityType for invalid type")
+	}
+}
+
+func TestAssociators(t *testing.T) {
+	type disk struct {
+		DeviceID string
+		Path     string `wmi:"__PATH"`
+	}
+	system, err := QueryOne[disk]("SELECT DeviceID, __PATH FROM Win32_LogicalDisk WHERE DeviceID = 'C:'")
+	if err != nil {
+		t.Fatalf("Failed to query system disk; %s", err)
+	}
+
+	// Logical disk -> partitions -> physical disks.
+	type partition struct {
+		DeviceID string
+		Path     string `wmi:"__PATH"`
+	}
+	partitions, err := Associators[partition](system.Path, AssocOpts{
+		ResultClass: "Win32_DiskPartition",
+		AssocClass:  "Win32_LogicalDiskToPartition",
+	})
+	if err != nil || len(partitions) == 0 {
+		t.Fatalf("Failed to get system disk partitions; %d partitions, %v", len(partitions), err)
+	}
+	drives, err := Associators[struct{ DeviceID string }](partitions[0].Path, AssocOpts{
+		ResultClass: "Win32_DiskDrive",
+		Role:        "Dependent",
+	})
+	if err != nil || len(drives) != 1 {
+		t.Errorf("Failed to get partition drive; %+v, %v", drives, err)
+	}
+
+	if _, err := Associators[partition]("", AssocOpts{}); err != ErrNoObjectPath {
+		t.Errorf("Unexpected error for empty path; got %v, expected %v", err, ErrNoObjectPath)
+	}
+	if _, err := Associators[partition](`Win32_LogicalDisk.DeviceID="C:`, AssocOpts{}); err == nil {
+		t.Errorf("Expected error for unbalanced quotes")
+	}
+
+	expected := "ASSOCIATORS OF {Win32_DiskPartition.DeviceID='0'} WHERE ResultClass = Win32_DiskDrive " +
+		"AssocClass = Win32_DiskDriveToDiskPartition ResultRole = Antecedent Role = Dependent RequiredQualifier = Association"
+	query := associatorsQuery("Win32_DiskPartition.DeviceID='0'", AssocOpts{
+		ResultClass:       "Win32_DiskDrive",
+		AssocClass:        "Win32_DiskDriveToDiskPartition",
+		ResultRole:        "Antecedent",
+		Role:              "Dependent",
+		RequiredQualifier: "Association",
+	})
+	if query != expected {
+		t.Errorf("Unexpected associators query; got %q, expected %q", query, expected)
+	}
+}
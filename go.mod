@@ -1,9 +1,15 @@
 module github.com/bi-zone/wmi
 
+go 1.18
+
 require (
 	github.com/bi-zone/go-ole v1.2.5
-	github.com/go-ole/go-ole v1.2.4 // indirect
+	github.com/go-ole/go-ole v1.2.4
 	github.com/hashicorp/go-multierror v1.0.0
 	github.com/scjalliance/comshim v0.0.0-20190308082608-cf06d2532c4e
-	golang.org/x/sys v0.0.0-20200806060901-a37d78b92225
+)
+
+require (
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	golang.org/x/sys v0.0.0-20200806060901-a37d78b92225 // indirect
 )
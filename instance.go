@@ -0,0 +1,215 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+)
+
+// wbemFlagUpdateOnly makes `SWbemObject.Put_` fail instead of creating the
+// instance if it doesn't exist.
+const wbemFlagUpdateOnly = 0x1
+
+// PutInstance creates a new instance of the @className class with the
+// properties set from the @src struct fields and returns the object path of
+// the created instance. Fields are marshalled in the same way as the method in
+// parameters (see `SWbemServicesConnection.ExecMethod`), so @src could
+// implement Marshaler too. If the instance already exists it's updated.
+//
+// Provider failures (e.g. validation ones) are returned as WMIError.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemobject-put-
+func (s *SWbemServicesConnection) PutInstance(className string, src interface{}) (path string, err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return "", ErrConnectionClosed
+	}
+	s.Unlock()
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	classRaw, err := s.dereference(className)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if clErr := classRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	instanceRaw, err := oleutil.CallMethod(classRaw.ToIDispatch(), "SpawnInstance_")
+	if err != nil {
+		return "", fmt.Errorf("SpawnInstance_ error; %v", err)
+	}
+	defer func() {
+		if clErr := instanceRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	instance := instanceRaw.ToIDispatch()
+	if err := putProperties(instance, src); err != nil {
+		return "", err
+	}
+
+	// Returns SWbemObjectPath of the stored instance.
+	pathRaw, err := oleutil.CallMethod(instance, "Put_")
+	if err != nil {
+		return "", fmt.Errorf("Put_ error; %w", newWMIError(err))
+	}
+	defer func() {
+		if clErr := pathRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	pathProp, err := oleutil.GetProperty(pathRaw.ToIDispatch(), "Path")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if clErr := pathProp.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	return pathProp.ToString(), nil
+}
+
+// DeleteInstance deletes the instance identified by @objectPath (e.g. `__PATH`
+// of the decoded object or the path returned by `PutInstance`). Empty path
+// leads to ErrNoObjectPath, malformed ones and class paths are rejected
+// without calling WMI. Provider failures are returned as WMIError.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemservices-delete
+func (s *SWbemServicesConnection) DeleteInstance(objectPath string) (err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return ErrConnectionClosed
+	}
+	s.Unlock()
+	if objectPath == "" {
+		return ErrNoObjectPath
+	}
+	_, keys, err := ParseObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 && !strings.HasSuffix(objectPath, "=@") {
+		return fmt.Errorf("object path %q refers to a class, not an instance", objectPath)
+	}
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	res, err := oleutil.CallMethod(s.sWbemServices, "Delete", objectPath)
+	if err != nil {
+		return fmt.Errorf("Delete error; %w", newWMIError(err))
+	}
+	return res.Clear()
+}
+
+// UpdateInstance modifies the existing instance identified by @objectPath:
+// the instance is retrieved, its properties are set to the @changes values
+// (keyed by property names) and it's put back with WBEM_FLAG_UPDATE_ONLY, so
+// the missing instance is never created. Values are marshalled in the same
+// way as the `PutInstance` struct fields, nil values (including nil pointers)
+// set the properties to NULL.
+//
+// Empty path leads to ErrNoObjectPath, malformed ones and class paths are
+// rejected without calling WMI. Missing instance results in the WMIError
+// matching ErrNotFound, other provider failures are returned as WMIError too.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemobject-put-
+func (s *SWbemServicesConnection) UpdateInstance(objectPath string, changes map[string]interface{}) (err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return ErrConnectionClosed
+	}
+	s.Unlock()
+	if objectPath == "" {
+		return ErrNoObjectPath
+	}
+	_, keys, err := ParseObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 && !strings.HasSuffix(objectPath, "=@") {
+		return fmt.Errorf("object path %q refers to a class, not an instance", objectPath)
+	}
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	instanceRaw, err := s.dereference(objectPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if clErr := instanceRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	instance := instanceRaw.ToIDispatch()
+
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Deterministic order of the failures.
+	for _, name := range names {
+		value, err := marshalChange(changes[name])
+		if err != nil {
+			return fmt.Errorf("can't marshal property %q; %v", name, err)
+		}
+		prop, err := oleutil.PutProperty(instance, name, value)
+		if err != nil {
+			return fmt.Errorf("can't put property %q; %w", name, newWMIError(err))
+		}
+		if err := prop.Clear(); err != nil {
+			return err
+		}
+	}
+
+	pathRaw, err := oleutil.CallMethod(instance, "Put_", wbemFlagUpdateOnly)
+	if err != nil {
+		return fmt.Errorf("Put_ error; %w", newWMIError(err))
+	}
+	return pathRaw.Clear()
+}
+
+// marshalChange converts the `UpdateInstance` change value @v into a value
+// accepted by the WMI scripting API, nil values are passed as is.
+func marshalChange(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	return marshalValue(rv)
+}
@@ -0,0 +1,124 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"testing"
+)
+
+type win32Environment struct {
+	Name          string
+	UserName      string
+	VariableValue string
+}
+
+func TestClient_PutInstance(t *testing.T) {
+	c := &Client{}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect; %s", err)
+	}
+	defer c.Close()
+
+	env := win32Environment{Name: "WMI_TEST_PUT_INSTANCE", UserName: "<SYSTEM>", VariableValue: "value"}
+	path, err := c.PutInstance("Win32_Environment", env)
+	if err != nil {
+		t.Fatalf("Failed to put instance; %s", err)
+	}
+	defer func() {
+		if err := c.DeleteInstance(path); err != nil {
+			t.Errorf("Failed to delete instance %q; %s", path, err)
+		}
+	}()
+
+	var stored win32Environment
+	if err := c.Get(path, &stored); err != nil {
+		t.Fatalf("Failed to get instance %q; %s", path, err)
+	}
+	if stored != env {
+		t.Errorf("Unexpected stored instance; got %+v, expected %+v", stored, env)
+	}
+
+	// Key properties are required.
+	_, err = c.PutInstance("Win32_Environment", struct{ VariableValue string }{"value"})
+	var wmiErr WMIError
+	if !errors.As(err, &wmiErr) {
+		t.Errorf("Expected WMIError for invalid instance; got %v", err)
+	}
+	if _, err := c.PutInstance("Win32_NoSuchClass", env); !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrInvalidClass) {
+		t.Errorf("Unexpected error for unknown class; %v", err)
+	}
+}
+
+func TestClient_DeleteInstance(t *testing.T) {
+	c := &Client{}
+	env := win32Environment{Name: "WMI_TEST_DELETE_INSTANCE", UserName: "<SYSTEM>", VariableValue: "value"}
+	path, err := c.PutInstance("Win32_Environment", env)
+	if err != nil {
+		t.Fatalf("Failed to put instance; %s", err)
+	}
+	if err := c.DeleteInstance(path); err != nil {
+		t.Fatalf("Failed to delete instance %q; %s", path, err)
+	}
+	if ok, err := c.InstanceExists(path); err != nil || ok {
+		t.Errorf("Instance %q isn't deleted; exists %v, %v", path, ok, err)
+	}
+
+	// Deleted already.
+	if err := c.DeleteInstance(path); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error for deleted instance; got %v, expected %v", err, ErrNotFound)
+	}
+	if err := c.DeleteInstance(""); err != ErrNoObjectPath {
+		t.Errorf("Unexpected error for empty path; got %v, expected %v", err, ErrNoObjectPath)
+	}
+	for _, invalid := range []string{"Win32_Environment", `Win32_Environment.Name="x`, ".Name=1"} {
+		if err := c.DeleteInstance(invalid); err == nil {
+			t.Errorf("Expected error for path %q", invalid)
+		}
+	}
+}
+
+func TestClient_UpdateInstance(t *testing.T) {
+	c := &Client{}
+	env := win32Environment{Name: "WMI_TEST_UPDATE_INSTANCE", UserName: "<SYSTEM>", VariableValue: "value"}
+	path, err := c.PutInstance("Win32_Environment", env)
+	if err != nil {
+		t.Fatalf("Failed to put instance; %s", err)
+	}
+	defer func() {
+		if err := c.DeleteInstance(path); err != nil {
+			t.Errorf("Failed to delete instance %q; %s", path, err)
+		}
+	}()
+
+	if err := c.UpdateInstance(path, map[string]interface{}{"VariableValue": "updated"}); err != nil {
+		t.Fatalf("Failed to update instance %q; %s", path, err)
+	}
+	var stored win32Environment
+	if err := c.Get(path, &stored); err != nil {
+		t.Fatalf("Failed to get instance %q; %s", path, err)
+	}
+	if stored.VariableValue != "updated" || stored.Name != env.Name {
+		t.Errorf("Unexpected updated instance; %+v", stored)
+	}
+
+	// Update never creates the instance.
+	missing := `Win32_Environment.Name="WMI_TEST_UPDATE_MISSING",UserName="<SYSTEM>"`
+	if err := c.UpdateInstance(missing, map[string]interface{}{"VariableValue": "x"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error for missing instance; got %v, expected %v", err, ErrNotFound)
+	}
+	if ok, err := c.InstanceExists(missing); err != nil || ok {
+		t.Errorf("Missing instance is created; exists %v, %v", ok, err)
+	}
+
+	var wmiErr WMIError
+	if err := c.UpdateInstance(path, map[string]interface{}{"NoSuchProperty": "x"}); !errors.As(err, &wmiErr) {
+		t.Errorf("Expected WMIError for unknown property; got %v", err)
+	}
+	if err := c.UpdateInstance("", nil); err != ErrNoObjectPath {
+		t.Errorf("Unexpected error for empty path; got %v, expected %v", err, ErrNoObjectPath)
+	}
+	if err := c.UpdateInstance("Win32_Environment", nil); err == nil {
+		t.Errorf("Expected error for class path")
+	}
+}
@@ -0,0 +1,59 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"time"
+)
+
+// Logger receives the diagnostic messages of the Client, e.g. the connection
+// targets, the executed queries, the number of decoded objects and the
+// decoding warnings. Wrap any logging library to get them at debug level,
+// e.g. for the standard library:
+//   type stdLogger struct{ *log.Logger }
+//
+//   func (l stdLogger) Debugf(format string, args ...interface{}) {
+//       l.Printf("DEBUG "+format, args...)
+//   }
+//
+// Messages never include the credentials.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+}
+
+// QueryObserver is called after the WQL @query is finished with the @elapsed
+// time of the whole query (including the decoding and the retries), the
+// number of decoded @rows and the query error, if any.
+//
+// The observer is called on the query goroutine, so it should be fast.
+type QueryObserver func(query string, elapsed time.Duration, rows int, err error)
+
+// logConnect logs the connection to the server and the namespace taken from
+// `SWbemLocator.ConnectServer` @args. Other args (e.g. password) are omitted.
+func logConnect(l Logger, args []interface{}, err error) {
+	server, namespace := ".", "default"
+	if len(args) > 0 && args[0] != nil {
+		server = fmt.Sprint(args[0])
+	}
+	if len(args) > 1 && args[1] != nil {
+		namespace = fmt.Sprint(args[1])
+	}
+	if err != nil {
+		l.Debugf("wmi: connect to %q namespace %q failed; %v", server, namespace, err)
+		return
+	}
+	l.Debugf("wmi: connected to %q namespace %q", server, namespace)
+}
+
+// logQuery logs the @query results loaded into @dst.
+func logQuery(l Logger, query string, dst *queryDst, err error) {
+	for _, w := range dst.warnings {
+		l.Debugf("wmi: query %q warning; %v", query, w)
+	}
+	if err != nil {
+		l.Debugf("wmi: query %q failed after %d objects; %v", query, dst.decoded, err)
+		return
+	}
+	l.Debugf("wmi: query %q decoded %d objects", query, dst.decoded)
+}
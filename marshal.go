@@ -0,0 +1,114 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+)
+
+// Marshaler is the interface implemented by types that can marshal themselves
+// into a WMI object, e.g. into method in parameters. It's a counterpart of
+// the Unmarshaler.
+//
+// @dst is a freshly spawned instance (e.g. of method in parameters class)
+// with no properties set. MarshalOLE should populate it, e.g. with
+// `oleutil.PutProperty`, and must not release it.
+type Marshaler interface {
+	MarshalOLE(dst *ole.IDispatch) error
+}
+
+// putProperties sets the properties of the WMI object @obj to the values of
+// the @src struct (or pointer to struct) fields. Field names are resolved in
+// the same way as in `Decoder.Unmarshal`. Nil pointer fields are skipped.
+// If @src implements Marshaler it's used instead.
+func putProperties(obj *ole.IDispatch, src interface{}) error {
+	// Checks whether the type can handle marshalling of itself.
+	if m, ok := src.(Marshaler); ok {
+		return m.MarshalOLE(obj)
+	}
+
+	v := reflect.ValueOf(src)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("can't marshal %T; struct expected", src)
+	}
+
+	for _, fType := range cachedFields(v.Type()) {
+		name := fType.name
+		if fType.skipped() {
+			continue // Unexported, blank or skipped field.
+		}
+		f, ok := fieldByIndex(v, fType.index, false)
+		if !ok {
+			continue // Nil embedded struct.
+		}
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				continue
+			}
+			f = f.Elem()
+		}
+
+		value, err := marshalValue(f)
+		if err != nil {
+			return fmt.Errorf("can't marshal field %q; %v", fType.Name, err)
+		}
+		prop, err := oleutil.PutProperty(obj, name, value)
+		if err != nil {
+			return fmt.Errorf("can't put property %q; %v", name, err)
+		}
+		if err := prop.Clear(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalValue converts @v into a value accepted by the WMI scripting API.
+// Following the scripting API conventions 64-bit integers are passed as
+// strings and 32-bit unsigned ones as signed integers of the same bits.
+// time.Time is passed as CIM_DATETIME string, `CIMDateTime` is passed as is.
+func marshalValue(v reflect.Value) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return int32(v.Int()), nil
+	case reflect.Uint8, reflect.Uint16:
+		return int32(v.Uint()), nil
+	case reflect.Uint32:
+		return int32(uint32(v.Uint())), nil
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return FormatCIMDateTime(t), nil
+		}
+	case reflect.Slice:
+		if strs, ok := v.Interface().([]string); ok {
+			return strs, nil
+		}
+		if v.Type().Elem().Kind() == reflect.String { // E.g. []CIMDateTime.
+			strs := make([]string, v.Len())
+			for i := range strs {
+				strs[i] = v.Index(i).String()
+			}
+			return strs, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
@@ -0,0 +1,75 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+)
+
+func TestMarshalValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{"str", "str"},
+		{true, true},
+		{int8(-1), int32(-1)},
+		{uint16(65535), int32(65535)},
+		{uint32(4294967295), int32(-1)},
+		{int64(-4294967296), "-4294967296"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+		{float32(0.5), 0.5},
+		{[]string{"a", "b"}, []string{"a", "b"}},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), "20200102030405.000000+000"},
+	}
+	for _, tt := range tests {
+		got, err := marshalValue(reflect.ValueOf(tt.value))
+		if err != nil {
+			t.Errorf("Failed to marshal %T; %s", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Unexpected %T marshalling result; got %#v, expected %#v", tt.value, got, tt.expected)
+		}
+	}
+
+	if _, err := marshalValue(reflect.ValueOf([]int{1})); err == nil {
+		t.Errorf("Expected error for unsupported type")
+	}
+}
+
+// priorityParams is an example of `wmi.Marshaler` implementation.
+type priorityParams struct {
+	normal bool
+}
+
+func (p priorityParams) MarshalOLE(dst *ole.IDispatch) error {
+	if !p.normal {
+		return errors.New("only normal priority is allowed")
+	}
+	_, err := oleutil.PutProperty(dst, "Priority", int32(32))
+	return err
+}
+
+func TestMarshaler(t *testing.T) {
+	self := ObjectPath("Win32_Process", map[string]interface{}{"Handle": strconv.Itoa(os.Getpid())})
+	ret, err := DefaultClient.Exec(self, "SetPriority", priorityParams{normal: true})
+	if err != nil {
+		t.Fatalf("Failed to exec SetPriority; %s", err)
+	}
+	if ret != 0 {
+		t.Errorf("Unexpected SetPriority return code %d", ret)
+	}
+
+	if _, err := DefaultClient.Exec(self, "SetPriority", priorityParams{}); err == nil {
+		t.Errorf("Marshaler error isn't returned")
+	}
+}
@@ -0,0 +1,399 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+)
+
+// MethodInfo describes the WMI class method.
+type MethodInfo struct {
+	Name   string
+	Static bool            // Has `Static` qualifier, so is called on the class.
+	In     []ParameterInfo // Input parameters ordered by their IDs.
+	Out    []ParameterInfo // Output parameters ordered by their IDs, `ReturnValue` is the last.
+}
+
+// ParameterInfo describes the WMI method parameter.
+type ParameterInfo struct {
+	Name     string
+	CIMType  CIMType
+	IsArray  bool
+	Optional bool // Has `Optional` qualifier.
+	ID       int  // Value of `ID` qualifier, -1 if not set (e.g. for `ReturnValue`).
+}
+
+// DescribeMethod returns the description of the @class @method taken from the
+// class definition.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemmethod
+func (s *SWbemServicesConnection) DescribeMethod(class, method string) (info MethodInfo, err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return MethodInfo{}, ErrConnectionClosed
+	}
+	s.Unlock()
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	methodRaw, err := s.method(class, method)
+	if err != nil {
+		return MethodInfo{}, err
+	}
+	defer func() {
+		if clErr := methodRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	info.Name = method
+	if info.Static, err = isStaticMethod(methodRaw.ToIDispatch()); err != nil {
+		return MethodInfo{}, err
+	}
+	if info.In, err = describeParameters(methodRaw.ToIDispatch(), "InParameters"); err != nil {
+		return MethodInfo{}, err
+	}
+	if info.Out, err = describeParameters(methodRaw.ToIDispatch(), "OutParameters"); err != nil {
+		return MethodInfo{}, err
+	}
+	return info, nil
+}
+
+// Exec executes the @method of the object identified by @objectPath (or the
+// static method of the class if @objectPath is a class name, i.e. has no
+// keys) and returns the method return value. Out parameters other than
+// `ReturnValue` are ignored.
+//
+// @params is a struct (or pointer to struct) with the method in parameters,
+// fields are mapped to the parameters by name in the same way as in
+// `Decoder.Unmarshal`. Nil pointer fields are not set, so they could be used
+// for optional parameters. @params could also implement Marshaler to fill the
+// parameters itself. @params could be nil for the methods without
+// parameters.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemservices-execmethod
+func (s *SWbemServicesConnection) Exec(objectPath, method string, params interface{}) (returnValue uint32, err error) {
+	err = s.execMethod(objectPath, method, params, func(out *ole.IDispatch) (err error) {
+		returnValue, _, err = methodReturnValue(out)
+		return err
+	})
+	return returnValue, err
+}
+
+// ExecMethod executes the @method of the object identified by @objectPath (or
+// the static method of the class if @objectPath is a class name) with the @in
+// parameters and unmarshals the out parameters object into @out.
+//
+// Static methods (e.g. `Win32_Process.Create`) are called on the class path,
+// the in parameters are spawned from the class definition:
+//   var created struct {
+//       ProcessId   uint32
+//       ReturnValue uint32
+//   }
+//   err := conn.ExecMethod("Win32_Process", "Create",
+//       struct{ CommandLine string }{"notepad.exe"}, &created)
+// Calling a non-static method on the class path results in an error without
+// calling WMI.
+//
+// @in is handled in the same way as in `SWbemServicesConnection.Exec`. @out
+// should be a pointer to struct, the out parameters (including
+// `ReturnValue`) are unmarshalled in the same way as query results, see
+// `Decoder.Unmarshal`. Both @in and @out could be nil if the method has no
+// parameters or the caller doesn't care about them.
+//
+// If the method returned non-zero `ReturnValue` ErrMethodFailed is returned,
+// so the method failures could be distinguished from the COM ones. @out is
+// unmarshalled anyway. ErrFieldMismatch is returned if there is no other
+// errors.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemservices-execmethod
+func (s *SWbemServicesConnection) ExecMethod(objectPath, method string, in, out interface{}) error {
+	return s.execMethod(objectPath, method, in, func(outParams *ole.IDispatch) error {
+		var errFieldMismatch error
+		if out != nil && outParams != nil {
+			if err := s.Unmarshal(outParams, out); err != nil {
+				if _, ok := err.(ErrFieldMismatch); !ok {
+					return err
+				}
+				errFieldMismatch = err
+			}
+		}
+		if err := checkReturnValue(method, outParams); err != nil {
+			return err
+		}
+		return errFieldMismatch
+	})
+}
+
+// execMethod executes the @method of the object identified by @objectPath
+// with @params and calls @f with the out parameters object. The object could
+// be nil if the method returns nothing.
+func (s *SWbemServicesConnection) execMethod(objectPath, method string, params interface{}, f func(out *ole.IDispatch) error) (err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return ErrConnectionClosed
+	}
+	s.Unlock()
+	if objectPath == "" {
+		return ErrNoObjectPath
+	}
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	inParams, err := s.inParameters(objectPath, method, params)
+	if err != nil {
+		return err
+	}
+	if inParams != nil {
+		defer inParams.Release()
+	}
+
+	args := []interface{}{objectPath, method}
+	if inParams != nil {
+		args = append(args, inParams)
+	}
+	outRaw, err := oleutil.CallMethod(s.sWbemServices, "ExecMethod", args...)
+	if err != nil {
+		return fmt.Errorf("ExecMethod %s error; %w", method, newWMIError(err))
+	}
+	defer func() {
+		if clErr := outRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	return f(outRaw.ToIDispatch())
+}
+
+// methodReturnValue returns `ReturnValue` of the method @out parameters. @ok
+// is false if there is no return value. Any other failure to read the property
+// is returned as @err.
+func methodReturnValue(out *ole.IDispatch) (returnValue uint32, ok bool, err error) {
+	if out == nil {
+		return 0, false, nil // Method returns nothing.
+	}
+	ret, err := oleutil.GetProperty(out, "ReturnValue")
+	if isMissingPropertyError(err) {
+		return 0, false, nil // Method has no return value.
+	} else if err != nil {
+		return 0, false, fmt.Errorf("can't get return value; %w", newWMIError(err))
+	}
+	defer func() {
+		if clErr := ret.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	if ret.VT == ole.VT_NULL {
+		return 0, false, nil
+	}
+	if err := unmarshalSimpleValue(reflect.ValueOf(&returnValue).Elem(), ret.Value()); err != nil {
+		return 0, false, fmt.Errorf("can't unmarshal return value %v; %v", ret.Value(), err)
+	}
+	return returnValue, true, nil
+}
+
+// checkReturnValue returns ErrMethodFailed if the @method @out parameters
+// object has non-zero `ReturnValue`.
+func checkReturnValue(method string, out *ole.IDispatch) error {
+	returnValue, ok, err := methodReturnValue(out)
+	if err != nil {
+		return err
+	}
+	if ok && returnValue != 0 {
+		return ErrMethodFailed{Method: method, ReturnValue: returnValue}
+	}
+	return nil
+}
+
+// inParameters creates an in parameters object of the @method of the object
+// identified by @objectPath filled with @params. Returns nil if @params is
+// nil. If @objectPath is a class path (has no keys) the @method is checked
+// to be static.
+func (s *SWbemServicesConnection) inParameters(objectPath, method string, params interface{}) (in *ole.IDispatch, err error) {
+	class, keys, err := ParseObjectPath(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	isClass := len(keys) == 0 && !strings.HasSuffix(objectPath, "=@")
+	if params == nil && !isClass {
+		return nil, nil
+	}
+
+	methodRaw, err := s.method(class, method)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := methodRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	if isClass {
+		static, err := isStaticMethod(methodRaw.ToIDispatch())
+		if err != nil {
+			return nil, err
+		}
+		if !static {
+			return nil, fmt.Errorf("method %q of class %q isn't static; instance path is required", method, class)
+		}
+	}
+	if params == nil {
+		return nil, nil
+	}
+
+	paramsRaw, err := oleutil.GetProperty(methodRaw.ToIDispatch(), "InParameters")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := paramsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	if paramsRaw.VT == ole.VT_NULL {
+		return nil, fmt.Errorf("method %q of class %q has no in parameters", method, class)
+	}
+
+	inRaw, err := oleutil.CallMethod(paramsRaw.ToIDispatch(), "SpawnInstance_")
+	if err != nil {
+		return nil, err
+	}
+	in = inRaw.ToIDispatch()
+	if err := putProperties(in, params); err != nil {
+		in.Release()
+		return nil, err
+	}
+	return in, nil
+}
+
+// isStaticMethod checks if SWbemMethod @method has `Static` qualifier.
+func isStaticMethod(method *ole.IDispatch) (bool, error) {
+	quals, err := qualifiers(method)
+	if err != nil {
+		return false, err
+	}
+	for name, value := range quals {
+		if strings.EqualFold(name, "static") && value == true {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// method returns SWbemMethod object of the @class @method.
+func (s *SWbemServicesConnection) method(class, method string) (m *ole.VARIANT, err error) {
+	classRaw, err := s.dereference(class)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := classRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	methodsRaw, err := oleutil.GetProperty(classRaw.ToIDispatch(), "Methods_")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := methodsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	m, err = oleutil.CallMethod(methodsRaw.ToIDispatch(), "Item", method)
+	if err != nil {
+		return nil, fmt.Errorf("no method %q of class %q; %v", method, class, err)
+	}
+	return m, nil
+}
+
+// describeParameters describes parameters from the @method @paramsProperty
+// (either "InParameters" or "OutParameters").
+func describeParameters(method *ole.IDispatch, paramsProperty string) (params []ParameterInfo, err error) {
+	paramsRaw, err := oleutil.GetProperty(method, paramsProperty)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := paramsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	if paramsRaw.VT == ole.VT_NULL {
+		return nil, nil // Method has no such parameters.
+	}
+
+	propsRaw, err := oleutil.GetProperty(paramsRaw.ToIDispatch(), "Properties_")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := propsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	err = forEach(propsRaw.ToIDispatch(), func(prop *ole.IDispatch) error {
+		p := ParameterInfo{ID: -1}
+		name, err := oleutil.GetProperty(prop, "Name")
+		if err != nil {
+			return err
+		}
+		p.Name = name.ToString()
+		if err := name.Clear(); err != nil {
+			return err
+		}
+		cimType, err := oleInt64(prop, "CIMType")
+		if err != nil {
+			return err
+		}
+		p.CIMType = CIMType(cimType)
+		isArray, err := oleutil.GetProperty(prop, "IsArray")
+		if err != nil {
+			return err
+		}
+		p.IsArray = isArray.Value() == true
+
+		// Qualifiers are optional.
+		if optional, err := qualifierValue(prop, "Optional"); err == nil {
+			p.Optional = optional == true
+		}
+		if id, err := qualifierValue(prop, "ID"); err == nil {
+			if i, ok := id.(int32); ok {
+				p.ID = int(i)
+			}
+		}
+
+		params = append(params, p)
+		return nil
+	})
+
+	// Ordered by IDs, parameters without ID go last.
+	sort.SliceStable(params, func(i, j int) bool {
+		if params[i].ID == -1 || params[j].ID == -1 {
+			return params[j].ID == -1 && params[i].ID != -1
+		}
+		return params[i].ID < params[j].ID
+	})
+	return params, err
+}
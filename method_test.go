@@ -0,0 +1,237 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/bi-zone/go-ole"
+)
+
+func TestClient_DescribeMethod(t *testing.T) {
+	info, err := DefaultClient.DescribeMethod("Win32_Process", "Create")
+	if err != nil {
+		t.Fatalf("Failed to describe Win32_Process.Create; %s", err)
+	}
+
+	expectedIn := []ParameterInfo{
+		{Name: "CommandLine", CIMType: CIMTypeString, ID: 0},
+		{Name: "CurrentDirectory", CIMType: CIMTypeString, ID: 1},
+		{Name: "ProcessStartupInformation", CIMType: CIMTypeObject, ID: 2},
+	}
+	if len(info.In) != len(expectedIn) {
+		t.Fatalf("Unexpected in parameters; %+v", info.In)
+	}
+	for i, p := range expectedIn {
+		got := info.In[i]
+		if got.Name != p.Name || got.CIMType != p.CIMType || got.ID != p.ID || got.IsArray {
+			t.Errorf("Unexpected in parameter; got %+v, expected %+v", got, p)
+		}
+	}
+
+	if len(info.Out) != 2 {
+		t.Fatalf("Unexpected out parameters; %+v", info.Out)
+	}
+	if p := info.Out[0]; p.Name != "ProcessId" || p.CIMType != CIMTypeUint32 {
+		t.Errorf("Unexpected ProcessId parameter; %+v", p)
+	}
+	if p := info.Out[1]; p.Name != "ReturnValue" || p.ID != -1 {
+		t.Errorf("Unexpected ReturnValue parameter; %+v", p)
+	}
+
+	if !info.Static {
+		t.Errorf("Win32_Process.Create isn't static")
+	}
+	if info, err := DefaultClient.DescribeMethod("Win32_Process", "GetOwner"); err != nil || info.Static {
+		t.Errorf("Unexpected Win32_Process.GetOwner description; %+v, %v", info, err)
+	}
+
+	if _, err := DefaultClient.DescribeMethod("Win32_Process", "NoSuchMethod"); err == nil {
+		t.Errorf("Expected error for unknown method")
+	}
+}
+
+func TestClient_Exec(t *testing.T) {
+	self := ObjectPath("Win32_Process", map[string]interface{}{"Handle": strconv.Itoa(os.Getpid())})
+
+	// Set the normal priority to the current process.
+	params := struct{ Priority int32 }{32}
+	ret, err := DefaultClient.Exec(self, "SetPriority", params)
+	if err != nil {
+		t.Fatalf("Failed to exec SetPriority; %s", err)
+	}
+	if ret != 0 {
+		t.Errorf("Unexpected SetPriority return code %d", ret)
+	}
+
+	if _, err := DefaultClient.Exec(self, "NoSuchMethod", nil); err == nil {
+		t.Errorf("Expected error for unknown method")
+	}
+	if _, err := DefaultClient.Exec(self, "SetPriority", struct{ NoSuchParam int32 }{}); err == nil {
+		t.Errorf("Expected error for unknown parameter")
+	}
+}
+
+func TestClient_ExecMethod(t *testing.T) {
+	self := ObjectPath("Win32_Process", map[string]interface{}{"Handle": strconv.Itoa(os.Getpid())})
+
+	var owner struct {
+		User        string
+		Domain      string
+		ReturnValue uint32
+	}
+	if err := DefaultClient.ExecMethod(self, "GetOwner", nil, &owner); err != nil {
+		t.Fatalf("Failed to exec GetOwner; %s", err)
+	}
+	if owner.User == "" || owner.ReturnValue != 0 {
+		t.Errorf("Unexpected GetOwner result; %+v", owner)
+	}
+
+	// Nil out parameters.
+	if err := DefaultClient.ExecMethod(self, "SetPriority", struct{ Priority int32 }{32}, nil); err != nil {
+		t.Errorf("Failed to exec SetPriority; %s", err)
+	}
+
+	// Invalid priority leads to non-zero return value.
+	err := DefaultClient.ExecMethod(self, "SetPriority", struct{ Priority int32 }{12345}, nil)
+	if failed, ok := err.(ErrMethodFailed); !ok || failed.ReturnValue == 0 {
+		t.Errorf("Unexpected error for invalid priority; got %v", err)
+	}
+}
+
+func TestClient_ExecMethod_Static(t *testing.T) {
+	var created struct {
+		ProcessId   uint32
+		ReturnValue uint32
+	}
+	in := struct{ CommandLine string }{"cmd.exe /c exit 0"}
+	if err := DefaultClient.ExecMethod("Win32_Process", "Create", in, &created); err != nil {
+		t.Fatalf("Failed to exec Win32_Process.Create; %s", err)
+	}
+	if created.ProcessId == 0 || created.ReturnValue != 0 {
+		t.Errorf("Unexpected Create result; %+v", created)
+	}
+
+	// Non-static methods require the instance.
+	if err := DefaultClient.ExecMethod("Win32_Process", "GetOwner", nil, nil); err == nil || !strings.Contains(err.Error(), "isn't static") {
+		t.Errorf("Unexpected error for non-static method on class; %v", err)
+	}
+}
+
+// stubObject is a minimal IDispatch mock. Property gets and method calls of
+// the @names return the corresponding @results, other names are unknown.
+type stubObject struct {
+	vtbl    *ole.IDispatchVtbl
+	names   []string
+	results []stubResult
+}
+
+type stubResult struct {
+	hr    uintptr     // HRESULT of the call, @value is returned if it's S_OK.
+	value ole.VARIANT // Owned by the caller after the call.
+}
+
+const eNotImpl = 0x80004001
+
+var stubVtbl = &ole.IDispatchVtbl{
+	IUnknownVtbl: ole.IUnknownVtbl{
+		QueryInterface: syscall.NewCallback(func(this *stubObject, iid uintptr, obj **stubObject) uintptr {
+			*obj = this
+			return sOK
+		}),
+		AddRef:  syscall.NewCallback(func(this *stubObject) uintptr { return 1 }),
+		Release: syscall.NewCallback(func(this *stubObject) uintptr { return 1 }),
+	},
+	GetTypeInfoCount: syscall.NewCallback(func(this *stubObject, count uintptr) uintptr { return eNotImpl }),
+	GetTypeInfo:      syscall.NewCallback(func(this *stubObject, i, lcid, info uintptr) uintptr { return eNotImpl }),
+	GetIDsOfNames: syscall.NewCallback(func(this *stubObject, iid uintptr, names **uint16, count, lcid uintptr, ids *int32) uintptr {
+		name := ole.LpOleStrToString(*names)
+		for i, n := range this.names {
+			if strings.EqualFold(n, name) {
+				*ids = int32(i)
+				return sOK
+			}
+		}
+		return dispEUnknownName
+	}),
+	Invoke: syscall.NewCallback(func(this *stubObject, id, iid, lcid, flags, params uintptr, result *ole.VARIANT, excepInfo, argErr uintptr) uintptr {
+		r := this.results[int32(id)]
+		if r.hr == sOK {
+			*result = r.value
+		}
+		return r.hr
+	}),
+}
+
+func newStubObject(results map[string]stubResult) *stubObject {
+	obj := &stubObject{vtbl: stubVtbl}
+	for name, r := range results {
+		obj.names = append(obj.names, name)
+		obj.results = append(obj.results, r)
+	}
+	return obj
+}
+
+func (o *stubObject) dispatch() *ole.IDispatch {
+	return (*ole.IDispatch)(unsafe.Pointer(o))
+}
+
+func (o *stubObject) variant() ole.VARIANT {
+	return ole.NewVariant(ole.VT_DISPATCH, int64(uintptr(unsafe.Pointer(o))))
+}
+
+func TestSWbemServicesConnection_ExecMethod_Stub(t *testing.T) {
+	const rpcEDisconnected = 0x80010108
+	noReturnValue := newStubObject(nil)
+	succeeded := newStubObject(map[string]stubResult{"ReturnValue": {value: ole.NewVariant(ole.VT_I4, 0)}})
+	failed := newStubObject(map[string]stubResult{"ReturnValue": {value: ole.NewVariant(ole.VT_I4, 2)}})
+	broken := newStubObject(map[string]stubResult{"ReturnValue": {hr: rpcEDisconnected}})
+	defer runtime.KeepAlive([]*stubObject{noReturnValue, succeeded, failed, broken})
+
+	const path = `Win32_Process.Handle="1"`
+	isNil := func(err error) bool { return err == nil }
+	isDisconnected := func(err error) bool {
+		var wmiErr WMIError
+		return errors.As(err, &wmiErr) && uint32(wmiErr.HResult) == rpcEDisconnected
+	}
+	isFailed := func(err error) bool {
+		var failure ErrMethodFailed
+		return errors.As(err, &failure) && failure.ReturnValue == 2
+	}
+	tests := []struct {
+		name            string
+		out             stubResult // Result of `SWbemServices.ExecMethod`.
+		expected        uint32
+		checkExec       func(err error) bool
+		checkExecMethod func(err error) bool
+	}{
+		{"no output", stubResult{value: ole.NewVariant(ole.VT_EMPTY, 0)}, 0, isNil, isNil},
+		{"no return value", stubResult{value: noReturnValue.variant()}, 0, isNil, isNil},
+		{"success", stubResult{value: succeeded.variant()}, 0, isNil, isNil},
+		{"failure", stubResult{value: failed.variant()}, 2, isNil, isFailed},
+		{"broken return value", stubResult{value: broken.variant()}, 0, isDisconnected, isDisconnected},
+		{"call failure", stubResult{hr: rpcEDisconnected}, 0, isDisconnected, isDisconnected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := newStubObject(map[string]stubResult{"ExecMethod": tt.out})
+			defer runtime.KeepAlive(services)
+			conn := &SWbemServicesConnection{sWbemServices: services.dispatch()}
+
+			if err := conn.ExecMethod(path, "Terminate", nil, nil); !tt.checkExecMethod(err) {
+				t.Errorf("Unexpected ExecMethod result; %v", err)
+			}
+			ret, err := conn.Exec(path, "Terminate", nil)
+			if !tt.checkExec(err) || ret != tt.expected {
+				t.Errorf("Unexpected Exec result; got %d, %v, expected %d", ret, err, tt.expected)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@
 package wmi
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -21,7 +22,6 @@ var (
 )
 
 const (
-	wbemErrTimedOut            = 0x80043001
 	defaultNotificationTimeout = time.Second
 )
 
@@ -129,7 +129,7 @@ func (q *NotificationQuery) StartNotifications() (err error) {
 	// Connect to WMI service.
 	service, err := ConnectSWbemServices(q.connectServerArgs...)
 	if err != nil {
-		return fmt.Errorf("failed to connect WMI service; %s", err)
+		return fmt.Errorf("failed to connect WMI service; %w", err)
 	}
 	defer func() {
 		if clErr := service.Close(); clErr != nil {
@@ -148,7 +148,7 @@ func (q *NotificationQuery) StartNotifications() (err error) {
 		0x00000010|0x00000020, // WBEM_FLAG_RETURN_IMMEDIATELY | WBEM_FLAG_FORWARD_ONLY
 	)
 	if err != nil {
-		return fmt.Errorf("ExecNotificationQuery failed; %s", err)
+		return fmt.Errorf("ExecNotificationQuery failed; %w", newWMIError(err))
 	}
 	eventSource := sWbemEventSource.ToIDispatch()
 	defer eventSource.Release()
@@ -171,14 +171,14 @@ func (q *NotificationQuery) StartNotifications() (err error) {
 			if isTimeoutError(err) {
 				continue
 			}
-			return fmt.Errorf("unexpected NextEvent error; %s", err)
+			return fmt.Errorf("unexpected NextEvent error; %w", newWMIError(err))
 		}
 		event := eventIUnknown.ToIDispatch()
 
 		// Unmarshal event.
 		e := reflect.New(eventType)
 		if err := q.Unmarshal(event, e.Interface()); err != nil {
-			return fmt.Errorf("failed to unmarshal event; %s", err)
+			return fmt.Errorf("failed to unmarshal event; %w", err)
 		}
 		_ = eventIUnknown.Clear() // Nah. We can't handle it anyway.
 
@@ -202,6 +202,101 @@ func (q *NotificationQuery) Stop() {
 	q.state = stateStopped
 }
 
+// Notify subscribes to the events of the notification @query and sends them
+// to @out until @ctx is done, e.g.
+//   out := make(chan interface{})
+//   go c.Notify(ctx, `SELECT * FROM __InstanceCreationEvent WITHIN 1 WHERE TargetInstance ISA 'Win32_Process'`,
+//       out, reflect.TypeOf(Win32_Process{}))
+//
+// Every event `TargetInstance` is unmarshalled into a new value of @elemType
+// (S or *S for some struct type S) using the Client decoder. Events without
+// `TargetInstance` (e.g. `Win32_ProcessStartTrace`) are unmarshalled as is.
+//
+// Notify blocks until @ctx is done or an error occurs. It waits for the next
+// event no longer than the default NotificationTimeout (1s), so it takes up
+// to that time to react to @ctx cancellation. `ctx.Err()` is returned after
+// the event source and the connection are released.
+//
+// Notify always establishes its own connection (using the `Client.Connect`
+// args if @connectServerArgs are empty), so it doesn't block the other calls
+// of a connected Client.
+func (c *Client) Notify(ctx context.Context, query string, out chan<- interface{}, elemType reflect.Type, connectServerArgs ...interface{}) error {
+	argType, structType := checkElemType(elemType)
+	if argType == multiArgTypeInvalid {
+		return ErrInvalidEntityType
+	}
+	return c.withOwnConnection(connectServerArgs, func(conn *SWbemServicesConnection) (err error) {
+		//  Be aware of reflections and COM usage.
+		defer func() {
+			if r := recover(); r != nil {
+				err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+			}
+		}()
+
+		sWbemEventSource, err := oleutil.CallMethod(
+			conn.sWbemServices,
+			"ExecNotificationQuery",
+			query,
+			"WQL",
+			0x00000010|0x00000020, // WBEM_FLAG_RETURN_IMMEDIATELY | WBEM_FLAG_FORWARD_ONLY
+		)
+		if err != nil {
+			return fmt.Errorf("ExecNotificationQuery failed; %w", newWMIError(err))
+		}
+		eventSource := sWbemEventSource.ToIDispatch()
+		defer eventSource.Release()
+
+		timeoutMs := int64(defaultNotificationTimeout / time.Millisecond)
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			eventRaw, err := eventSource.CallMethod("NextEvent", timeoutMs)
+			if err != nil {
+				if isTimeoutError(err) {
+					continue
+				}
+				return fmt.Errorf("unexpected NextEvent error; %w", newWMIError(err))
+			}
+
+			ev := reflect.New(structType)
+			err = unmarshalTargetInstance(conn, eventRaw.ToIDispatch(), ev.Interface())
+			_ = eventRaw.Clear()
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal event; %w", err)
+			}
+			if argType != multiArgTypeStructPtr {
+				ev = ev.Elem()
+			}
+
+			select {
+			case out <- ev.Interface():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+}
+
+// unmarshalTargetInstance unmarshals `TargetInstance` of the @event into @dst
+// using @conn decoder. If the event has no `TargetInstance` the event itself
+// is unmarshalled.
+func unmarshalTargetInstance(conn *SWbemServicesConnection, event *ole.IDispatch, dst interface{}) (err error) {
+	instance, err := oleutil.GetProperty(event, "TargetInstance")
+	if err != nil {
+		return conn.Unmarshal(event, dst) // Extrinsic event.
+	}
+	defer func() {
+		if clErr := instance.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	if instance.VT != ole.VT_DISPATCH || instance.ToIDispatch() == nil {
+		return conn.Unmarshal(event, dst)
+	}
+	return conn.Unmarshal(instance.ToIDispatch(), dst)
+}
+
 type state int
 
 const (
@@ -211,12 +306,8 @@ const (
 )
 
 func isTimeoutError(err error) bool {
-	oleErr, ok := err.(*ole.OleError)
-	if !ok {
-		return false
-	}
-	exception, ok := oleErr.SubError().(ole.EXCEPINFO)
-	return ok && exception.SCODE() == wbemErrTimedOut
+	code, ok := oleErrorCode(err)
+	return ok && code == wbemErrTimedOut
 }
 
 func isChannelTypeOK(eventCh interface{}) bool {
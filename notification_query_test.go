@@ -3,6 +3,10 @@
 package wmi
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -222,3 +226,159 @@ func wgWaitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
 		return false
 	}
 }
+
+func TestNotificationQuery_EmbeddedClass(t *testing.T) {
+	type localTime struct {
+		Hour uint32
+	}
+	queryString := `SELECT * FROM __InstanceModificationEvent WHERE TargetInstance ISA 'Win32_LocalTime'`
+
+	// Matching class (of the class itself and of the parent one).
+	type event struct {
+		Instance localTime `wmi:"TargetInstance,class=Win32_LocalTime"`
+		Parent   localTime `wmi:"TargetInstance,class=Win32_CurrentTime"`
+	}
+	resultCh := make(chan event)
+	query, err := NewNotificationQuery(resultCh, queryString)
+	if err != nil {
+		t.Fatalf("Failed to create NotificationQuery; %s", err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- query.StartNotifications()
+	}()
+	select {
+	case <-resultCh:
+	case err := <-errCh:
+		t.Fatalf("Notification query with matching class failed; %v", err)
+	}
+	query.Stop()
+	<-errCh
+
+	// Mismatching class.
+	type wrongEvent struct {
+		Instance localTime `wmi:"TargetInstance,class=Win32_Process"`
+	}
+	wrongCh := make(chan wrongEvent)
+	wrongQuery, err := NewNotificationQuery(wrongCh, queryString)
+	if err != nil {
+		t.Fatalf("Failed to create NotificationQuery; %s", err)
+	}
+	go func() {
+		errCh <- wrongQuery.StartNotifications()
+	}()
+	select {
+	case e := <-wrongCh:
+		t.Errorf("Got event of unexpected class; %+v", e)
+		wrongQuery.Stop()
+		<-errCh
+	case err := <-errCh:
+		if err == nil || !strings.Contains(err.Error(), "Win32_Process") {
+			t.Errorf("Unexpected error for mismatching class; %v", err)
+		}
+	}
+}
+
+func TestClient_Notify(t *testing.T) {
+	type localTime struct {
+		Hour   uint32
+		Minute uint32
+	}
+	queryString := `SELECT * FROM __InstanceModificationEvent WHERE TargetInstance ISA 'Win32_LocalTime'`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan interface{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- DefaultClient.Notify(ctx, queryString, out, reflect.TypeOf(&localTime{}))
+	}()
+
+	select {
+	case e := <-out:
+		if lt, ok := e.(*localTime); !ok || lt.Hour > 23 || lt.Minute > 59 {
+			t.Errorf("Unexpected event; %#v", e)
+		}
+	case err := <-errCh:
+		t.Fatalf("Notify failed; %v", err)
+	}
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Errorf("Unexpected error after cancel; got %v, expected %v", err, context.Canceled)
+	}
+
+	err := DefaultClient.Notify(context.Background(), queryString, out, reflect.TypeOf(0))
+	if err != ErrInvalidEntityType {
+		t.Errorf("Unexpected error for invalid type; %v", err)
+	}
+
+	err = DefaultClient.Notify(context.Background(), "SELECT * FROM WHERE", out, reflect.TypeOf(&localTime{}))
+	var wmiErr WMIError
+	if !errors.As(err, &wmiErr) || !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Unexpected error for invalid query; %v", err)
+	}
+
+	query, err := NewNotificationQuery(make(chan localTime), "SELECT * FROM WHERE")
+	if err != nil {
+		t.Fatalf("Failed to create NotificationQuery; %s", err)
+	}
+	if err := query.StartNotifications(); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Unexpected StartNotifications error for invalid query; %v", err)
+	}
+}
+
+// Ensure that events could be received from the goroutines other than the
+// ones started the queries while other COM calls are performed concurrently.
+func TestNotificationQuery_Concurrent(t *testing.T) {
+	type event struct {
+		Instance struct {
+			Second uint32
+		} `wmi:"TargetInstance"`
+	}
+	const (
+		queriesCount = 8
+		eventsCount  = 2
+	)
+	queryString := `SELECT * FROM __InstanceModificationEvent WHERE TargetInstance ISA 'Win32_LocalTime'`
+
+	var queriesWg, readersWg sync.WaitGroup
+	for i := 0; i < queriesCount; i++ {
+		resultCh := make(chan event)
+		query, err := NewNotificationQuery(resultCh, queryString)
+		if err != nil {
+			t.Fatalf("Failed to create NotificationQuery; %s", err)
+		}
+		query.SetNotificationTimeout(100 * time.Millisecond)
+
+		queriesWg.Add(1)
+		go func() {
+			defer queriesWg.Done()
+			if err := query.StartNotifications(); err != nil {
+				t.Errorf("Notification query error; %s", err)
+			}
+		}()
+
+		readersWg.Add(1)
+		go func() {
+			defer readersWg.Done()
+			defer query.Stop()
+			for j := 0; j < eventsCount; j++ {
+				select {
+				case <-resultCh:
+				case <-time.After(5 * time.Second):
+					t.Errorf("Failed to receive event in time")
+					return
+				}
+				// Perform some COM calls from the reader as well.
+				var dst []Win32_OperatingSystem
+				if err := Query("SELECT * FROM Win32_OperatingSystem", &dst); err != nil {
+					t.Errorf("Query from reader goroutine failed; %s", err)
+				}
+			}
+		}()
+	}
+
+	readersWg.Wait()
+	if stopped := wgWaitTimeout(&queriesWg, 5*time.Second); !stopped {
+		t.Errorf("Failed to stop queries")
+	}
+}
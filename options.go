@@ -0,0 +1,98 @@
+// +build windows
+
+package wmi
+
+import (
+	"context"
+	"time"
+)
+
+// Option tweaks a single call of the package-level query functions (`Query`,
+// `QueryNamespace`, `QueryContext` and `QueryWith`), so the common changes of
+// the DefaultClient settings don't require a separate Client. Options are
+// passed along with connectServerArgs in any position, e.g.
+//   err := wmi.Query(query, &dst, wmi.WithAllowMissingFields(), wmi.WithNamespace(`root\wmi`))
+//
+// Options are applied in order to a copy of the DefaultClient, so they take
+// precedence over its fields and the later options override the earlier
+// ones. The DefaultClient itself is never modified. Namespace set by
+// connectServerArgs still takes precedence over WithNamespace, same as over
+// `Client.Namespace`.
+type Option func(o *callOptions)
+
+// callOptions are the parameters of the call modified by Options.
+type callOptions struct {
+	client Client
+	ctx    context.Context
+}
+
+// WithDecoder makes the call use the decoder @d instead of the DefaultClient
+// one.
+func WithDecoder(d Decoder) Option {
+	return func(o *callOptions) {
+		o.client.Decoder = d
+	}
+}
+
+// WithAllowMissingFields sets `Decoder.AllowMissingFields` for the call.
+func WithAllowMissingFields() Option {
+	return func(o *callOptions) {
+		o.client.AllowMissingFields = true
+	}
+}
+
+// WithNonePtrZero sets `Decoder.NonePtrZero` for the call.
+func WithNonePtrZero() Option {
+	return func(o *callOptions) {
+		o.client.NonePtrZero = true
+	}
+}
+
+// WithNamespace makes the call connect to the namespace @ns, see
+// `Client.Namespace`. The call doesn't use the connection established by
+// `Client.Connect` of the DefaultClient.
+func WithNamespace(ns string) Option {
+	return func(o *callOptions) {
+		o.client.Namespace = ns
+		o.client.conn, o.client.connectArgs, o.client.calls = nil, nil, nil
+	}
+}
+
+// WithTimeout sets `Client.Timeout` for the call.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *callOptions) {
+		o.client.Timeout = timeout
+	}
+}
+
+// WithContext makes the `Query` call respect the cancellation of @ctx like
+// `Client.QueryContext` does. It's ignored by the functions having an explicit
+// context argument.
+func WithContext(ctx context.Context) Option {
+	return func(o *callOptions) {
+		o.ctx = ctx
+	}
+}
+
+// withOptions separates Options from the @args of the package-level call and
+// returns the client and the context for the call along with the rest of
+// @args. DefaultClient is returned if there are no options.
+func withOptions(args []interface{}) (c *Client, ctx context.Context, connectServerArgs []interface{}) {
+	var opts []Option
+	for _, arg := range args {
+		if opt, ok := arg.(Option); ok {
+			opts = append(opts, opt)
+		} else {
+			connectServerArgs = append(connectServerArgs, arg)
+		}
+	}
+	if len(opts) == 0 {
+		return defaultClient(), context.Background(), args
+	}
+
+	o := callOptions{client: *defaultClient(), ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &o.client, o.ctx, connectServerArgs
+}
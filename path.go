@@ -0,0 +1,184 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ObjectPath builds a WMI object path of the @class instance identified by
+// @keys. The result is suitable for `SWbemServicesConnection.Get`, e.g.
+//   ObjectPath("Win32_Directory", map[string]interface{}{"Name": `C:\`})
+// returns `Win32_Directory.Name="C:\\"`.
+//
+// String key values are quoted with backslashes and quotes escaped, so values
+// with trailing backslashes (like directory paths) are handled properly.
+// Other values are formatted using their default format. Keys are sorted by
+// name to make the result stable. Empty @keys produce a singleton path
+// `Class=@`.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/describing-an-instance-object-path
+func ObjectPath(class string, keys map[string]interface{}) string {
+	if len(keys) == 0 {
+		return class + "=@"
+	}
+
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(class)
+	b.WriteByte('.')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		switch v := keys[name].(type) {
+		case string:
+			b.WriteString(quotePathValue(v))
+		case bool:
+			// Booleans are represented as integers in object paths.
+			if v {
+				b.WriteString("1")
+			} else {
+				b.WriteString("0")
+			}
+		default:
+			b.WriteString(fmt.Sprint(v))
+		}
+	}
+	return b.String()
+}
+
+// ParseObjectPath splits the object @path into a class name and key values.
+// Server and namespace part of the path (if any) is skipped. Quoted key values
+// are unescaped and returned as strings, unquoted ones are returned as int64
+// if possible or as strings otherwise. Singleton paths (`Class=@`) return
+// empty keys, the only unnamed key (`Class="value"`) is returned with an
+// empty name.
+func ParseObjectPath(path string) (class string, keys map[string]interface{}, err error) {
+	// Namespace is separated by a colon that can't appear before the first
+	// quote in any other place.
+	quote := strings.IndexByte(path, '"')
+	if quote == -1 {
+		quote = len(path)
+	}
+	rest := path[strings.LastIndexByte(path[:quote], ':')+1:]
+
+	keys = make(map[string]interface{})
+	end := strings.IndexAny(rest, ".=")
+	if end == -1 {
+		return rest, keys, nil // Class path.
+	}
+	class = rest[:end]
+	if class == "" {
+		return "", nil, fmt.Errorf("invalid object path %q; no class name", path)
+	}
+	if rest[end:] == "=@" {
+		return class, keys, nil
+	}
+	if rest[end] == '=' {
+		// `Class="value"` form with the only unnamed key.
+		value, tail, err := parsePathValue(rest[end+1:])
+		if err != nil || tail != "" {
+			return "", nil, fmt.Errorf("invalid object path %q; malformed key value", path)
+		}
+		keys[""] = value
+		return class, keys, nil
+	}
+
+	for rest = rest[end+1:]; ; rest = rest[1:] {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return "", nil, fmt.Errorf("invalid object path %q; malformed key", path)
+		}
+		name := rest[:eq]
+		var value interface{}
+		if value, rest, err = parsePathValue(rest[eq+1:]); err != nil {
+			return "", nil, fmt.Errorf("invalid object path %q; %v", path, err)
+		}
+		keys[name] = value
+
+		if rest == "" {
+			return class, keys, nil
+		}
+		if rest[0] != ',' {
+			return "", nil, fmt.Errorf("invalid object path %q; unexpected %q", path, rest[0])
+		}
+	}
+}
+
+// Ref is an object path held by a reference property (with CIM type
+// `ref:Class`), e.g. `Win32_LoggedOnUser.Antecedent`. Reference properties
+// are decoded into Ref (or plain string) fields as is, without the fetch done
+// for the fields tagged with ",ref" option. The path could be later passed to
+// `Client.Get` or `SWbemServicesConnection.Get`:
+//   var logon struct {
+//       Antecedent wmi.Ref
+//       Dependent  wmi.Ref
+//   }
+//   ...
+//   err := wmi.DefaultClient.Get(string(logon.Antecedent), &account)
+type Ref string
+
+// Parse splits the reference path into a class name and key values, see
+// `ParseObjectPath`.
+func (r Ref) Parse() (class string, keys map[string]interface{}, err error) {
+	return ParseObjectPath(string(r))
+}
+
+// quotePathValue quotes the string key value @s escaping backslashes and
+// quotes inside it.
+func quotePathValue(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if r == '\\' || r == '"' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// parsePathValue parses the key value at the start of @s and returns the
+// value and the rest of the string.
+func parsePathValue(s string) (value interface{}, rest string, err error) {
+	if s == "" || s[0] != '"' {
+		end := strings.IndexByte(s, ',')
+		if end == -1 {
+			end = len(s)
+		}
+		raw := s[:end]
+		if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return i, s[end:], nil
+		}
+		return raw, s[end:], nil
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i == len(s) {
+				return nil, "", fmt.Errorf("unterminated escape sequence")
+			}
+			b.WriteByte(s[i])
+		case '"':
+			return b.String(), s[i+1:], nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return nil, "", fmt.Errorf("unterminated quoted value")
+}
@@ -0,0 +1,52 @@
+// +build windows
+
+package wmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestObjectPath_RoundTrip(t *testing.T) {
+	keys := map[string]interface{}{
+		"Name":  `C:\Program Files\"quoted"\`,
+		"Drive": `C:`,
+		"Index": int64(42),
+	}
+	path := ObjectPath("CIM_Directory", keys)
+	expected := `CIM_Directory.Drive="C:",Index=42,Name="C:\\Program Files\\\"quoted\"\\"`
+	if path != expected {
+		t.Fatalf("Unexpected object path; got %s, expected %s", path, expected)
+	}
+
+	class, parsedKeys, err := ParseObjectPath(`\\.\root\cimv2:` + path)
+	if err != nil {
+		t.Fatalf("Failed to parse object path; %s", err)
+	}
+	if class != "CIM_Directory" {
+		t.Errorf("Unexpected class; got %q, expected %q", class, "CIM_Directory")
+	}
+	if !reflect.DeepEqual(parsedKeys, keys) {
+		t.Errorf("Unexpected keys; got %#v, expected %#v", parsedKeys, keys)
+	}
+}
+
+func TestObjectPath_Get(t *testing.T) {
+	s, err := ConnectSWbemServices()
+	if err != nil {
+		t.Fatalf("ConnectSWbemServices: %s", err)
+	}
+	defer s.Close()
+
+	// Root directory name has a trailing backslash.
+	var dir struct {
+		Name string
+	}
+	path := ObjectPath("Win32_Directory", map[string]interface{}{"Name": `C:\`})
+	if err := s.Get(path, &dir); err != nil {
+		t.Fatalf("Failed to get %s; %s", path, err)
+	}
+	if dir.Name != `c:\` && dir.Name != `C:\` {
+		t.Errorf("Unexpected directory name %q", dir.Name)
+	}
+}
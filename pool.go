@@ -0,0 +1,89 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Pool is a fixed set of connected Clients for performing many independent
+// queries in parallel, e.g. by a monitoring agent. Calls of a single connected
+// Client are serialized (see `Client.Connect`), while the calls of a Pool are
+// distributed over the idle Clients.
+//
+// Every pooled Client owns a connection on its own OS thread. WMI service
+// performs the queries of a single user by a limited number of threads and
+// enforces per-user quotas, so the pool larger than a few connections (e.g.
+// the number of logical CPUs) rarely gives any gain and only loads the
+// service. Start with a small size and measure.
+type Pool struct {
+	mu      sync.RWMutex // Write lock is taken by Close.
+	closed  bool
+	clients chan *Client
+	all     []*Client
+}
+
+// NewPool creates a Pool of @size Clients connected using @opts. If any
+// connection can't be established, all the established ones are closed and
+// the error is returned.
+func NewPool(size int, opts ConnectOptions) (*Pool, error) {
+	if size <= 0 {
+		return nil, errors.New("wmi: pool size should be positive")
+	}
+	p := &Pool{clients: make(chan *Client, size)}
+	for i := 0; i < size; i++ {
+		c := &Client{ConnectOptions: &opts}
+		if err := c.Connect(); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+		p.all = append(p.all, c)
+		p.clients <- c
+	}
+	return p, nil
+}
+
+// Query runs the WQL query using an idle Client of the Pool like
+// `Client.Query` does. It waits for an idle Client if all of them are busy.
+// ErrConnectionClosed is returned after the Pool is closed.
+//
+// A panic during the query is returned as an error and doesn't break the
+// Client, so it's returned to the Pool anyway.
+func (p *Pool) Query(query string, dst interface{}) error {
+	return p.do(func(c *Client) error {
+		return c.Query(query, dst)
+	})
+}
+
+// do calls @f with an idle Client and returns it to the Pool.
+func (p *Pool) do(f func(c *Client) error) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrConnectionClosed
+	}
+	c := <-p.clients
+	defer func() { p.clients <- c }()
+	return f(c)
+}
+
+// Close waits for the running queries and closes all the Clients of the Pool.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	var err error
+	for _, c := range p.all {
+		if closeErr := c.Close(); closeErr != nil {
+			err = multierror.Append(err, closeErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+// +build windows
+
+package wmi
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	if _, err := NewPool(0, ConnectOptions{}); err == nil {
+		t.Errorf("Expected error for zero pool size")
+	}
+
+	p, err := NewPool(3, ConnectOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create pool; %s", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var processes []Win32_Process
+			if err := p.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Pool query failed; %s", err)
+	}
+
+	// Panicking call doesn't poison the pooled Client.
+	for i := 0; i < 3; i++ {
+		err := p.do(func(c *Client) error {
+			return c.withConnection(nil, func(*SWbemServicesConnection) error {
+				panic("test panic")
+			})
+		})
+		if err == nil {
+			t.Errorf("Expected error for panicking call")
+		}
+	}
+	var processes []Win32_Process
+	if err := p.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil || len(processes) != 1 {
+		t.Errorf("Failed to query after panic; %d processes, %v", len(processes), err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Errorf("Failed to close pool; %s", err)
+	}
+	if err := p.Query("SELECT * FROM Win32_Process", &processes); err != ErrConnectionClosed {
+		t.Errorf("Unexpected error for closed pool; got %v, expected %v", err, ErrConnectionClosed)
+	}
+}
@@ -0,0 +1,579 @@
+// +build windows
+
+package wmi
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Flags of `SWbemServices.ExecQuery` that could be set in `Decoder.QueryFlags`.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemservices-execquery
+const (
+	// QueryFlagForwardOnly makes the query use a forward-only enumerator,
+	// same as `QueryOptions.ForwardOnly` does.
+	QueryFlagForwardOnly int32 = 0x20
+
+	// QueryFlagDirectRead makes WMI read the instances of the queried class
+	// only, ignoring the instances of its subclasses.
+	QueryFlagDirectRead int32 = 0x200
+
+	// QueryFlagPrototype makes the query return a single prototype object of
+	// the result (the class describing the result properties) instead of
+	// the result objects.
+	QueryFlagPrototype int32 = 0x2
+
+	// QueryFlagEnsureLocatable makes the result objects contain the system
+	// properties needed to locate them (`__PATH`, `__SERVER`, etc.) even if
+	// they aren't selected by the query.
+	QueryFlagEnsureLocatable int32 = 0x100
+
+	// QueryFlagUseAmendedQualifiers makes the result objects contain the
+	// localized (amended) qualifiers, e.g. the `Description` and the
+	// `Values` of the properties, in the connection locale.
+	QueryFlagUseAmendedQualifiers int32 = wbemFlagUseAmendedQualifiers
+)
+
+// wbemFlagReturnImmediately makes `SWbemServices.ExecQuery` semisynchronous.
+const wbemFlagReturnImmediately int32 = 0x10
+
+// QueryOptions are the optional parameters of `SWbemServicesConnection.QueryWith`.
+// Zero value means the same behaviour as `SWbemServicesConnection.Query` has.
+type QueryOptions struct {
+	// Limit is the max number of objects loaded into the slice destination.
+	// The rest of the objects is skipped and `QueryResult.Truncated` is set.
+	// Zero means no limit. Limit is ignored for the single struct destination.
+	Limit int
+
+	// RowTimeout is the max time to wait for a single result object. If the
+	// provider hangs on any object, ErrRowTimeout is returned. Zero means
+	// infinite wait. The objects are fetched on another thread then, so the
+	// timeout can't be used on the threads initialized as STA by the caller
+	// (ErrRowTimeoutSTA is returned).
+	RowTimeout time.Duration
+
+	// ForwardOnly makes the query use a forward-only enumerator
+	// (WBEM_FLAG_FORWARD_ONLY | WBEM_FLAG_RETURN_IMMEDIATELY), so the
+	// provider doesn't keep the whole result set and every object is released
+	// right after it is unmarshalled. That decreases the peak memory usage on
+	// large classes. Incomplete enumeration can't be detected by the objects
+	// count in this mode, see ErrIncompleteResult.
+	ForwardOnly bool
+}
+
+// QueryResult holds the details of the query performed by
+// `SWbemServicesConnection.QueryWith`.
+type QueryResult struct {
+	Query     string        // Executed query.
+	Rows      int           // Number of objects loaded into the destination.
+	Duration  time.Duration // Time spent on the query execution and unmarshalling.
+	Truncated bool          // Are any objects skipped due to `QueryOptions.Limit`.
+
+	// Warnings holds ErrFieldMismatch errors of all the objects and
+	// ErrIncompleteResult if the enumeration terminated early without
+	// a failure. Unlike the Query they are not returned as error.
+	Warnings []error
+}
+
+// QueryContext runs the WQL query like `SWbemServicesConnection.Query` does,
+// but checks @ctx before the query and between the result objects, returning
+// `ctx.Err()` if it's done. `SWbemServices.ExecQuery` call itself can't be
+// interrupted, see `Client.QueryContext` for the prompt cancellation.
+func (s *SWbemServicesConnection) QueryContext(ctx context.Context, query string, dst interface{}) error {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return ErrConnectionClosed
+	}
+	s.Unlock()
+
+	qDst, err := newQueryDst(dst)
+	if err != nil {
+		return err
+	}
+	qDst.ctx = ctx
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.query(query, qDst)
+}
+
+// queryEach runs the WQL query using a forward-only enumerator and calls @f
+// for every result object unmarshalled into a new value of @elemType (S or
+// *S for some struct type S). The COM object is released before @f call, so
+// only the current object is kept in memory.
+//
+// @ctx is checked before the query and between the result objects. An error
+// returned by @f stops the enumeration and is returned as is. ErrFieldMismatch
+// is returned after the enumeration if occurred for any object.
+func (s *SWbemServicesConnection) queryEach(ctx context.Context, query string, elemType reflect.Type, f func(ev reflect.Value) error) error {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return ErrConnectionClosed
+	}
+	s.Unlock()
+
+	argType, structType := checkElemType(elemType)
+	if argType == multiArgTypeInvalid {
+		return ErrInvalidEntityType
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.query(query, &queryDst{
+		dsArgType:   argType,
+		dstElemType: structType,
+		ctx:         ctx,
+		each:        f,
+		forwardOnly: true,
+	})
+}
+
+// Count returns the number of the @class instances matching the optional
+// @where condition (e.g. "WHERE State = 'Running'"). WQL has no COUNT, so
+// the query selects only `__PATH` of the objects and counts them using a
+// forward-only enumerator without any unmarshalling.
+//
+// If the @class doesn't exist the returned error wraps the WMI one.
+func (s *SWbemServicesConnection) Count(class, where string) (int, error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return 0, ErrConnectionClosed
+	}
+	s.Unlock()
+
+	query := "SELECT __PATH FROM " + class
+	if where != "" {
+		query += " " + where
+	}
+	dst := &queryDst{forwardOnly: true, countOnly: true}
+	err := s.query(query, dst)
+	if incomplete, ok := err.(ErrIncompleteResult); (ok && incomplete.Code == wbemEInvalidClass) || isNotFoundError(err) {
+		return 0, fmt.Errorf("class %q not found; %w", class, err)
+	}
+	return dst.decoded, err
+}
+
+// wbemQueryFlagShallow makes `SWbemServices.InstancesOf` skip the instances of
+// the subclasses.
+const wbemQueryFlagShallow int32 = 0x1
+
+// EnumInstancePaths returns `__PATH` of all the @className instances without
+// reading any other property data, so it's much cheaper than `SELECT *` when
+// only the identifiers are needed to `Get` the objects or to call their
+// methods later. Instances of the subclasses aren't included.
+//
+// Instances are enumerated using `SWbemServices.InstancesOf` (a wrapper
+// around `IWbemServices::CreateInstanceEnum`) with the shallow and
+// forward-only flags. WMIError is returned for the invalid class.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemservices-instancesof
+func (s *SWbemServicesConnection) EnumInstancePaths(className string) (paths []string, err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return nil, ErrConnectionClosed
+	}
+	s.Unlock()
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	flags := wbemFlagReturnImmediately | QueryFlagForwardOnly | wbemQueryFlagShallow
+	resultRaw, err := oleutil.CallMethod(s.sWbemServices, "InstancesOf", className, flags)
+	if err != nil {
+		return nil, newWMIError(err)
+	}
+	defer func() {
+		if clErr := resultRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	enumProperty, err := resultRaw.ToIDispatch().GetProperty("_NewEnum")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := enumProperty.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	enum, err := enumProperty.ToIUnknown().IEnumVARIANT(ole.IID_IEnumVariant)
+	if err != nil {
+		return nil, err
+	}
+	if enum == nil {
+		return nil, fmt.Errorf("can't get IEnumVARIANT, enum is nil")
+	}
+	defer enum.Release()
+
+	for {
+		itemRaw, length, err := enum.Next(1)
+		if length == 0 {
+			// Semisynchronous call reports the invalid class or the provider
+			// failure on the enumeration.
+			if code, ok := oleErrorCode(err); err != nil && (!ok || code&0x80000000 != 0) {
+				return paths, newWMIError(err)
+			}
+			return paths, nil
+		}
+		path, err := instancePath(itemRaw)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+}
+
+// instancePath returns `__PATH` of the enumerated object @itemRaw and
+// releases it.
+func instancePath(itemRaw ole.VARIANT) (path string, err error) {
+	defer func() { _ = itemRaw.Clear() }()
+	item := itemRaw.ToIDispatch()
+	if item == nil {
+		return "", fmt.Errorf("unexpected instance item type %d", itemRaw.VT)
+	}
+	prop, err := Decoder{}.getProperty(item, "__PATH")
+	if err != nil {
+		return "", newWMIError(err)
+	}
+	defer func() {
+		if clErr := prop.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	return prop.ToString(), nil
+}
+
+// Associators loads into @dst the objects associated with the object at
+// @objPath (`__PATH` or `__RELPATH` of an already decoded object, or one built
+// by ObjectPath). The optional @resultClass and @assocClass filter the
+// associated objects by their class and by the class of the association
+// linking them. @dst is the same as in `SWbemServicesConnection.Query`.
+//
+// Empty @objPath leads to ErrNoObjectPath, malformed ones (e.g. with
+// unbalanced quotes) are rejected without calling WMI.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/associators-of-statement
+func (s *SWbemServicesConnection) Associators(objPath, resultClass, assocClass string, dst interface{}) error {
+	if err := checkObjectPath(objPath); err != nil {
+		return err
+	}
+	return s.Query(associatorsQuery(objPath, AssocOpts{ResultClass: resultClass, AssocClass: assocClass}), dst)
+}
+
+// AssocOpts are the `ASSOCIATORS OF` query filters, empty ones are omitted.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/associators-of-statement
+type AssocOpts struct {
+	ResultClass       string // Class of the associated objects.
+	AssocClass        string // Class of the associations linking the objects.
+	ResultRole        string // Role of the associated objects in the associations.
+	Role              string // Role of the source object in the associations.
+	RequiredQualifier string // Qualifier the associated objects' class should have.
+}
+
+// References loads into @dst the association objects referring to the object
+// at @objPath. @dst is the same as in `SWbemServicesConnection.Query`. Empty
+// or malformed @objPath is rejected as in `SWbemServicesConnection.Associators`.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/references-of-statement
+func (s *SWbemServicesConnection) References(objPath string, dst interface{}) error {
+	if err := checkObjectPath(objPath); err != nil {
+		return err
+	}
+	return s.Query("REFERENCES OF {"+objPath+"}", dst)
+}
+
+// checkObjectPath returns ErrNoObjectPath for the empty @objPath and the
+// parsing error for the malformed one, so it isn't embedded into WQL.
+func checkObjectPath(objPath string) error {
+	if objPath == "" {
+		return ErrNoObjectPath
+	}
+	_, _, err := ParseObjectPath(objPath)
+	return err
+}
+
+// associatorsQuery builds `ASSOCIATORS OF` WQL query. Empty filters are omitted.
+func associatorsQuery(objPath string, opts AssocOpts) string {
+	query := "ASSOCIATORS OF {" + objPath + "}"
+	var where []string
+	for _, filter := range []struct{ keyword, value string }{
+		{"ResultClass", opts.ResultClass},
+		{"AssocClass", opts.AssocClass},
+		{"ResultRole", opts.ResultRole},
+		{"Role", opts.Role},
+		{"RequiredQualifier", opts.RequiredQualifier},
+	} {
+		if filter.value != "" {
+			where = append(where, filter.keyword+" = "+filter.value)
+		}
+	}
+	if len(where) > 0 {
+		// Subclauses are separated by spaces, not by AND.
+		query += " WHERE " + strings.Join(where, " ")
+	}
+	return query
+}
+
+// QueryWith runs the WQL query like `SWbemServicesConnection.Query` does, but
+// accepts additional @opts and returns the details of the performed query.
+//
+// @ctx is checked before the query and between the result objects, so the
+// cancellation interrupts the unmarshalling. `SWbemServices.ExecQuery` call
+// itself can't be interrupted. Objects loaded before the error are kept in
+// @dst.
+//
+// QueryResult is filled even if the error is returned.
+func (s *SWbemServicesConnection) QueryWith(ctx context.Context, query string, dst interface{}, opts QueryOptions) (res QueryResult, err error) {
+	start := time.Now()
+	res.Query = query
+
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return res, ErrConnectionClosed
+	}
+	s.Unlock()
+
+	qDst, err := newQueryDst(dst)
+	if err != nil {
+		return res, err
+	}
+	qDst.ctx = ctx
+	qDst.limit = opts.Limit
+	qDst.rowTimeout = opts.RowTimeout
+	qDst.forwardOnly = opts.ForwardOnly
+
+	if err = ctx.Err(); err == nil {
+		err = s.query(query, qDst)
+	}
+	switch e := err.(type) {
+	case ErrFieldMismatch:
+		err = nil // Reported in warnings.
+	case ErrIncompleteResult:
+		if e.Code&0x80000000 == 0 { // !FAILED(code)
+			err = nil // Reported in warnings.
+		}
+	}
+
+	res.Rows = qDst.decoded
+	res.Duration = time.Since(start)
+	res.Truncated = qDst.truncated
+	res.Warnings = qDst.warnings
+	return res, err
+}
+
+// QuerySpec pairs the WQL query with its destination for
+// `SWbemServicesConnection.QueryMulti`.
+type QuerySpec struct {
+	Query string
+	Dst   interface{} // See `SWbemServicesConnection.Query` for the supported types.
+}
+
+// QueryMulti runs the @specs queries one by one on the connection, e.g. to
+// collect the host inventory:
+//   var os []Win32_OperatingSystem
+//   var bios []Win32_BIOS
+//   err := conn.QueryMulti([]wmi.QuerySpec{
+//       {Query: "SELECT * FROM Win32_OperatingSystem", Dst: &os},
+//       {Query: "SELECT * FROM Win32_BIOS", Dst: &bios},
+//   })
+//
+// A failed query doesn't stop the others. The errors are combined into
+// `*multierror.Error` with every error prefixed by its query, the wrapped
+// errors (e.g. ErrFieldMismatch) could be checked using `errors.As` on
+// every one of its `Errors`.
+func (s *SWbemServicesConnection) QueryMulti(specs []QuerySpec) error {
+	var result error
+	for _, spec := range specs {
+		if err := s.Query(spec.Query, spec.Dst); err != nil {
+			if err == ErrConnectionClosed {
+				return err
+			}
+			result = multierror.Append(result, fmt.Errorf("query %q; %w", spec.Query, err))
+		}
+	}
+	return result
+}
+
+// Refresh re-runs the WQL query and merges the result objects into the
+// existing @dst slice matching them to the elements by the @keyField property
+// value, e.g. to keep the long-lived process list up to date:
+//   var processes []Win32_Process
+//   ...
+//   added, removed, err := conn.Refresh("SELECT * FROM Win32_Process", &processes, "ProcessId")
+//
+// @dst should be a pointer to []S or []*S for some struct type S with a field
+// mapped to the @keyField property, otherwise an error is returned before the
+// query. Empty @keyField stands for the field tagged as `key`. Key values of
+// pointer fields are compared by the pointees, NULL keys are equal.
+//
+// The matched elements are overwritten in place (pointer elements keep their
+// addresses), the unmatched ones are removed keeping the order of the rest,
+// and the new objects are appended in the query order. @removed are the
+// indices of the removed elements in the original slice, @added are the
+// indices of the appended ones in the resulting slice. Nil elements of @dst
+// are removed as well.
+//
+// Duplicate keys in @dst or in the result objects lead to the error unless
+// `Decoder.AllowDuplicateKeys` is set, in which case the last result object
+// wins and only the first of the duplicate elements is kept. @dst isn't
+// modified if any error except ErrFieldMismatch occurs, the latter is
+// returned after the merge.
+func (s *SWbemServicesConnection) Refresh(query string, dst interface{}, keyField string) (added, removed []int, err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return nil, nil, ErrConnectionClosed
+	}
+	s.Unlock()
+
+	sliceRefl := reflect.ValueOf(dst)
+	if sliceRefl.Kind() != reflect.Ptr || sliceRefl.IsNil() {
+		return nil, nil, ErrInvalidEntityType
+	}
+	sliceRefl = sliceRefl.Elem()
+	if sliceRefl.Kind() != reflect.Slice {
+		return nil, nil, ErrInvalidEntityType
+	}
+	elemType := sliceRefl.Type().Elem()
+	argType, structType := checkElemType(elemType)
+	if argType == multiArgTypeInvalid || structType.Kind() != reflect.Struct {
+		return nil, nil, ErrInvalidEntityType
+	}
+	key, err := refreshKeyField(structType, keyField)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Collect keys of the existing elements.
+	keys := make([]interface{}, sliceRefl.Len())
+	present := make(map[interface{}]bool, len(keys))
+	for i := range keys {
+		elem := sliceRefl.Index(i)
+		if argType == multiArgTypeStructPtr && elem.IsNil() {
+			continue
+		}
+		keys[i] = refreshKey(elem, key.index)
+		if present[keys[i]] && !s.AllowDuplicateKeys {
+			return nil, nil, fmt.Errorf("duplicate key %v of field %q in destination", keys[i], key.name)
+		}
+		present[keys[i]] = true
+	}
+
+	// Load all the objects first, so @dst is untouched on failure.
+	var objects []reflect.Value
+	index := make(map[interface{}]int)
+	err = s.queryEach(context.Background(), query, elemType, func(ev reflect.Value) error {
+		k := refreshKey(ev, key.index)
+		if i, ok := index[k]; ok {
+			if !s.AllowDuplicateKeys {
+				return fmt.Errorf("duplicate key %v of field %q", k, key.name)
+			}
+			objects[i] = ev
+			return nil
+		}
+		index[k] = len(objects)
+		objects = append(objects, ev)
+		return nil
+	})
+	if _, ok := err.(ErrFieldMismatch); err != nil && !ok {
+		return nil, nil, err
+	}
+
+	// Update the matched elements moving them over the removed ones.
+	matched := make([]bool, len(objects))
+	n, length := 0, sliceRefl.Len()
+	for i := 0; i < length; i++ {
+		elem := sliceRefl.Index(i)
+		j, ok := index[keys[i]]
+		if (argType == multiArgTypeStructPtr && elem.IsNil()) || !ok || matched[j] {
+			removed = append(removed, i)
+			continue
+		}
+		matched[j] = true
+		if argType == multiArgTypeStructPtr {
+			elem.Elem().Set(objects[j].Elem())
+		} else {
+			elem.Set(objects[j])
+		}
+		if n != i {
+			sliceRefl.Index(n).Set(elem)
+		}
+		n++
+	}
+	for i := n; i < length; i++ {
+		sliceRefl.Index(i).Set(reflect.Zero(elemType)) // Don't keep the removed ones.
+	}
+	sliceRefl.SetLen(n)
+
+	for j, ev := range objects {
+		if matched[j] {
+			continue
+		}
+		added = append(added, sliceRefl.Len())
+		sliceRefl.Set(reflect.Append(sliceRefl, ev))
+	}
+	return added, removed, err
+}
+
+// refreshKeyField returns the field of the struct type @t mapped to the
+// @keyField property, or the field tagged as `key` if @keyField is empty.
+func refreshKeyField(t reflect.Type, keyField string) (structField, error) {
+	for _, f := range cachedFields(t) {
+		if f.skipped() {
+			continue
+		}
+		if (keyField == "" && f.options.Contains("key")) || (keyField != "" && strings.EqualFold(f.name, keyField)) {
+			fType := f.Type
+			if fType.Kind() == reflect.Ptr {
+				fType = fType.Elem()
+			}
+			if !fType.Comparable() {
+				return structField{}, fmt.Errorf("key field %q of type %s isn't comparable", f.Name, f.Type)
+			}
+			return f, nil
+		}
+	}
+	if keyField == "" {
+		return structField{}, fmt.Errorf("no field of %s tagged as key", t)
+	}
+	return structField{}, fmt.Errorf("no field of %s for key property %q", t, keyField)
+}
+
+// refreshKey returns the key value of the struct (or non-nil struct pointer)
+// @v by the key field @index. Pointer keys are dereferenced, nil keys (also
+// of nil embedded structs) are returned as nil.
+func refreshKey(v reflect.Value, index []int) interface{} {
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	f, ok := fieldByIndex(v, index, false)
+	if !ok {
+		return nil
+	}
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return nil
+		}
+		f = f.Elem()
+	}
+	return f.Interface()
+}
@@ -0,0 +1,205 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+	"github.com/scjalliance/comshim"
+)
+
+var (
+	// ErrRefresherClosed is returned for methods called on the closed
+	// Refresher.
+	ErrRefresherClosed = errors.New("Refresher has been closed")
+)
+
+// Refresher is used to re-read the values of the same objects (e.g.
+// performance counters) without re-querying them. It wraps `SWbemRefresher`.
+//
+//   r, err := conn.NewRefresher()
+//   h, err := r.Add(`Win32_PerfRawData_PerfOS_Processor.Name="_Total"`)
+//   for {
+//       err = r.Refresh()
+//       err = r.Decode(h, &dst)
+//   }
+//
+// Refresher keeps its COM objects alive until `Refresher.Close` is called,
+// so it must always be closed. Objects added to the Refresher hold
+// references to the connection they were added through, so the connection
+// could be closed before the Refresher.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemrefresher
+type Refresher struct {
+	sync.Mutex
+	Decoder
+
+	conn      *SWbemServicesConnection
+	ownConn   bool // Is conn created for the Refresher only.
+	refresher *ole.IDispatch
+	items     []*ole.IDispatch // SWbemRefreshableItem objects.
+}
+
+// RefresherHandle identifies the object added to the Refresher.
+type RefresherHandle int
+
+// NewRefresher creates a Refresher for the objects available through the
+// connection. The connection should be alive while adding objects to the
+// Refresher.
+func (s *SWbemServicesConnection) NewRefresher() (r *Refresher, err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return nil, ErrConnectionClosed
+	}
+	s.Unlock()
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	comshim.Add(1)
+	defer func() {
+		if err != nil {
+			comshim.Done()
+		}
+	}()
+
+	refresherIUnknown, err := oleutil.CreateObject("WbemScripting.SWbemRefresher")
+	if err != nil {
+		return nil, fmt.Errorf("CreateObject SWbemRefresher error; %v", err)
+	} else if refresherIUnknown == nil {
+		return nil, ErrNilCreateObject
+	}
+	defer refresherIUnknown.Release()
+
+	refresher, err := refresherIUnknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return nil, fmt.Errorf("SWbemRefresher QueryInterface error; %v", err)
+	}
+
+	return &Refresher{
+		Decoder:   s.Decoder,
+		conn:      s,
+		refresher: refresher,
+	}, nil
+}
+
+// Add adds the object identified by @objectPath to the Refresher. Returned
+// handle should be used to decode the object data using `Refresher.Decode`.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemrefresher-add
+func (r *Refresher) Add(objectPath string) (h RefresherHandle, err error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.refresher == nil {
+		return 0, ErrRefresherClosed
+	}
+	if objectPath == "" {
+		return 0, ErrNoObjectPath
+	}
+
+	r.conn.Lock()
+	services := r.conn.sWbemServices
+	r.conn.Unlock()
+	if services == nil {
+		return 0, ErrConnectionClosed
+	}
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	itemRaw, err := oleutil.CallMethod(r.refresher, "Add", services, objectPath)
+	if err != nil {
+		return 0, fmt.Errorf("SWbemRefresher Add error; %v", err)
+	}
+	r.items = append(r.items, itemRaw.ToIDispatch())
+	return RefresherHandle(len(r.items) - 1), nil
+}
+
+// Refresh updates the data of all the objects added to the Refresher.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemrefresher-refresh
+func (r *Refresher) Refresh() (err error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.refresher == nil {
+		return ErrRefresherClosed
+	}
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	_, err = oleutil.CallMethod(r.refresher, "Refresh")
+	return err
+}
+
+// Decode unmarshals the data of the object identified by @h as of the last
+// `Refresher.Refresh` call into @dst. @dst should be a pointer to struct.
+//
+// More info about result unmarshalling is available in `Decoder.Unmarshal` doc.
+func (r *Refresher) Decode(h RefresherHandle, dst interface{}) (err error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.refresher == nil {
+		return ErrRefresherClosed
+	}
+	if h < 0 || int(h) >= len(r.items) {
+		return fmt.Errorf("unknown refresher handle %d", h)
+	}
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	objRaw, err := oleutil.GetProperty(r.items[h], "Object")
+	if err != nil {
+		return fmt.Errorf("SWbemRefreshableItem Object error; %v", err)
+	}
+	defer func() {
+		if clErr := objRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	return r.Unmarshal(objRaw.ToIDispatch(), dst)
+}
+
+// Close releases all the Refresher resources.
+func (r *Refresher) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.refresher == nil {
+		return nil // Already closed.
+	}
+	for _, item := range r.items {
+		item.Release()
+	}
+	r.items = nil
+	r.refresher.Release()
+	r.refresher = nil
+	comshim.Done()
+
+	if r.ownConn {
+		return r.conn.Close()
+	}
+	return nil
+}
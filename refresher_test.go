@@ -0,0 +1,50 @@
+// +build windows
+
+package wmi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefresher(t *testing.T) {
+	r, err := DefaultClient.NewRefresher()
+	if err != nil {
+		t.Fatalf("Failed to create Refresher; %s", err)
+	}
+	defer r.Close()
+
+	h, err := r.Add(`Win32_PerfRawData_PerfOS_Processor.Name="_Total"`)
+	if err != nil {
+		t.Fatalf("Failed to add object to Refresher; %s", err)
+	}
+
+	var samples [2]struct {
+		Timestamp_Sys100NS   uint64
+		PercentProcessorTime uint64
+	}
+	for i := range samples {
+		if i > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err := r.Refresh(); err != nil {
+			t.Fatalf("Failed to refresh; %s", err)
+		}
+		if err := r.Decode(h, &samples[i]); err != nil {
+			t.Fatalf("Failed to decode refreshed object; %s", err)
+		}
+	}
+	if samples[1].Timestamp_Sys100NS <= samples[0].Timestamp_Sys100NS {
+		t.Errorf("Refreshed values haven't changed; %+v", samples)
+	}
+
+	if err := r.Decode(h+1, &samples[0]); err == nil {
+		t.Errorf("Expected error for unknown handle")
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Failed to close Refresher; %s", err)
+	}
+	if err := r.Refresh(); err != ErrRefresherClosed {
+		t.Errorf("Unexpected error on closed Refresher; got %v, expected %v", err, ErrRefresherClosed)
+	}
+}
@@ -0,0 +1,87 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var classRegistry = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+}{types: make(map[string]reflect.Type)}
+
+// RegisterClass registers the struct type @t as a default destination for
+// the objects of the WMI @class, so they could be queried with `QueryClass`
+// without specifying the destination, e.g.
+//   wmi.RegisterClass("Win32_Process", reflect.TypeOf(Win32_Process{}))
+//   res, err := wmi.QueryClass("SELECT * FROM Win32_Process")
+//   processes := res.([]Win32_Process)
+//
+// Class names are case-insensitive. Registering the same class again
+// replaces the previous type. RegisterClass panics if @t is not a struct.
+func RegisterClass(class string, t reflect.Type) {
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("wmi: RegisterClass of non-struct type %v", t))
+	}
+	classRegistry.Lock()
+	defer classRegistry.Unlock()
+	classRegistry.types[strings.ToLower(class)] = t
+}
+
+// registeredClass returns the type registered for the @class with
+// `RegisterClass`.
+func registeredClass(class string) (t reflect.Type, ok bool) {
+	classRegistry.RLock()
+	defer classRegistry.RUnlock()
+	t, ok = classRegistry.types[strings.ToLower(class)]
+	return t, ok
+}
+
+// QueryClass runs the WQL query and returns the slice of the type registered
+// for the queried class with `RegisterClass`.
+//
+// QueryClass is a wrapper around DefaultClient.QueryClass.
+func QueryClass(query string, connectServerArgs ...interface{}) (interface{}, error) {
+	return defaultClient().QueryClass(query, connectServerArgs...)
+}
+
+// QueryClass runs the WQL query and returns the slice of the type registered
+// for the queried class with `RegisterClass`, e.g. `[]Win32_Process` for
+// `SELECT * FROM Win32_Process`. The class is taken from the `FROM` clause of
+// the @query, so queries like `ASSOCIATORS OF` are not supported.
+//
+// The result is returned even if ErrFieldMismatch occurred. Connection is
+// established in the same way as in `Client.Query`.
+func (c *Client) QueryClass(query string, connectServerArgs ...interface{}) (interface{}, error) {
+	class := queryClass(query)
+	if class == "" {
+		return nil, fmt.Errorf("can't find class name in query %q", query)
+	}
+	t, ok := registeredClass(class)
+	if !ok {
+		return nil, fmt.Errorf("class %q is not registered", class)
+	}
+
+	dst := reflect.New(reflect.SliceOf(t))
+	err := c.Query(query, dst.Interface(), connectServerArgs...)
+	if _, ok := err.(ErrFieldMismatch); err != nil && !ok {
+		return nil, err
+	}
+	return dst.Elem().Interface(), err
+}
+
+// queryClass returns the class name from the `FROM` clause of the WQL @query
+// or an empty string if there is no such clause.
+func queryClass(query string) string {
+	words := strings.Fields(query)
+	for i := 0; i < len(words)-1; i++ {
+		if strings.EqualFold(words[i], "FROM") {
+			return words[i+1]
+		}
+	}
+	return ""
+}
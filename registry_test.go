@@ -0,0 +1,45 @@
+// +build windows
+
+package wmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryClass(t *testing.T) {
+	RegisterClass("Win32_Process", reflect.TypeOf(Win32_Process{}))
+
+	res, err := QueryClass("SELECT * FROM win32_process WHERE ProcessId = 4")
+	if err != nil {
+		t.Fatalf("Failed to query registered class; %s", err)
+	}
+	processes, ok := res.([]Win32_Process)
+	if !ok {
+		t.Fatalf("Unexpected result type %T", res)
+	}
+	if len(processes) != 1 || processes[0].Name != "System" {
+		t.Errorf("Unexpected processes; %+v", processes)
+	}
+
+	if _, err := QueryClass("SELECT * FROM Win32_Service"); err == nil {
+		t.Errorf("Expected error for unregistered class")
+	}
+	if _, err := QueryClass("ASSOCIATORS OF {Win32_Process.Handle=4}"); err == nil {
+		t.Errorf("Expected error for query without class")
+	}
+}
+
+func TestQueryClass_Parse(t *testing.T) {
+	tests := map[string]string{
+		"SELECT * FROM Win32_Process":                   "Win32_Process",
+		"select Name from\tWin32_Service where Started": "Win32_Service",
+		"SELECT * FROM":                                 "",
+		"ASSOCIATORS OF {Win32_Process.Handle=4}":       "",
+	}
+	for query, expected := range tests {
+		if class := queryClass(query); class != expected {
+			t.Errorf("Unexpected class of %q; got %q, expected %q", query, class, expected)
+		}
+	}
+}
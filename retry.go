@@ -0,0 +1,87 @@
+// +build windows
+
+package wmi
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultTransientHResults are the HRESULTs considered transient by the
+// RetryPolicy without HResults set.
+var DefaultTransientHResults = []uint32{
+	wbemECallCancelled,
+	wbemEServerTooBusy,
+	wbemEQuotaViolation,
+	rpcSServerTooBusy,
+	rpcSCallFailed,
+}
+
+// RetryPolicy specifies how the queries failed with the transient errors are
+// retried. Every retry re-runs the whole query from scratch (the results of
+// the failed attempt are discarded), so the query could take up to
+// MaxAttempts times longer. Queries sending the objects as they are fetched
+// (e.g. `ClientQueryChan`) are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of the query attempts including the
+	// first one. Values less than 2 mean no retries.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. The delay is doubled for
+	// every next retry. The wait is interrupted by the query context, if any.
+	Backoff time.Duration
+
+	// HResults are the HRESULTs considered transient. If nil,
+	// DefaultTransientHResults are used. Use
+	//   append(wmi.DefaultTransientHResults, custom...)
+	// to extend the default set.
+	HResults []uint32
+}
+
+// do calls @f until it succeeds, fails with a non-transient error, the
+// attempts are exhausted or @ctx (if not nil) is done.
+func (p *RetryPolicy) do(ctx context.Context, f func() error) error {
+	delay := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.MaxAttempts || !p.isTransient(err) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		if ctx != nil {
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+		} else {
+			<-timer.C
+		}
+		delay *= 2
+	}
+}
+
+// isTransient checks if @err has one of the transient HRESULTs.
+func (p *RetryPolicy) isTransient(err error) bool {
+	code, ok := oleErrorCode(err)
+	var incomplete ErrIncompleteResult
+	if errors.As(err, &incomplete) {
+		code, ok = incomplete.Code, true
+	}
+	if !ok {
+		return false
+	}
+	codes := p.HResults
+	if codes == nil {
+		codes = DefaultTransientHResults
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
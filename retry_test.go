@@ -0,0 +1,71 @@
+// +build windows
+
+package wmi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bi-zone/go-ole"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	failing := func(attempts *int, errs ...error) func() error {
+		return func() error {
+			*attempts++
+			if *attempts <= len(errs) {
+				return errs[*attempts-1]
+			}
+			return nil
+		}
+	}
+	busy := ole.NewError(wbemEServerTooBusy)
+	p := &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	var attempts int
+	if err := p.do(nil, failing(&attempts, busy, ErrIncompleteResult{Code: wbemECallCancelled})); err != nil || attempts != 3 {
+		t.Errorf("Transient errors aren't retried; %d attempts, %v", attempts, err)
+	}
+	attempts = 0
+	if err := p.do(nil, failing(&attempts, busy, busy, busy)); err != busy || attempts != 3 {
+		t.Errorf("Attempts aren't limited; %d attempts, %v", attempts, err)
+	}
+
+	// Permanent errors fail fast.
+	invalid := newWMIError(ole.NewError(wbemEInvalidClass))
+	for _, err := range []error{invalid, errors.New("not a COM error")} {
+		attempts = 0
+		if got := p.do(nil, failing(&attempts, err)); got != err || attempts != 1 {
+			t.Errorf("Permanent error %v is retried; %d attempts", err, attempts)
+		}
+	}
+
+	// Custom transient codes.
+	custom := &RetryPolicy{MaxAttempts: 2, HResults: append(DefaultTransientHResults, wbemEInvalidClass)}
+	attempts = 0
+	if err := custom.do(nil, failing(&attempts, invalid)); err != nil || attempts != 2 {
+		t.Errorf("Custom transient code isn't retried; %d attempts, %v", attempts, err)
+	}
+
+	// Backoff is interrupted by the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	slow := &RetryPolicy{MaxAttempts: 5, Backoff: time.Minute}
+	start := time.Now()
+	attempts = 0
+	if err := slow.do(ctx, failing(&attempts, busy, busy)); err != busy || attempts != 1 {
+		t.Errorf("Unexpected result of cancelled retry; %d attempts, %v", attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Context deadline isn't respected; took %s", elapsed)
+	}
+
+	// Successful queries aren't affected.
+	c := &Client{RetryPolicy: p}
+	var processes []Win32_Process
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil || len(processes) != 1 {
+		t.Errorf("Failed to query with retry policy; %d objects, %v", len(processes), err)
+	}
+}
@@ -0,0 +1,94 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+)
+
+// SecurityDescriptor is a raw self-relative security descriptor, e.g. as
+// returned by `__SystemSecurity.GetSD` or `Win32_SecurityDescriptorHelper`.
+// Fields of this type receive the uint8 array properties as is:
+//   var out struct {
+//       SD          wmi.SecurityDescriptor
+//       ReturnValue uint32
+//   }
+//   err := conn.ExecMethod("__SystemSecurity=@", "GetSD", nil, &out)
+//
+// The bytes could be passed to the Windows security API (e.g. to
+// `ConvertSecurityDescriptorToStringSecurityDescriptor`) as is. Embedded
+// `Win32_SecurityDescriptor` objects (with `__ACE` and `__Trustee` ones)
+// could be decoded into structs instead, see `Decoder.Unmarshal`.
+type SecurityDescriptor []byte
+
+// SecurityDescriptor returns the security descriptor of the object identified
+// by @objectPath, e.g. of `Win32_LogicalFileSecuritySetting` or
+// `Win32_LogicalShareSecuritySetting` instance, or of the `__SystemSecurity=@`
+// namespace security object.
+//
+// The descriptor is got with `GetSD` method for `__SystemSecurity` and with
+// `GetSecurityDescriptor` method for the other classes. The latter returns
+// `Win32_SecurityDescriptor` object which is converted to the binary form
+// using `Win32_SecurityDescriptorHelper` (available in `root\cimv2` only).
+// Non-zero method return values are reported as ErrMethodFailed.
+//
+// Ref: https://docs.microsoft.com/en-us/windows/win32/wmisdk/win32-securitydescriptorhelper
+func (s *SWbemServicesConnection) SecurityDescriptor(objectPath string) (sd SecurityDescriptor, err error) {
+	class, _, err := ParseObjectPath(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(class, "__SystemSecurity") {
+		var out struct {
+			SD SecurityDescriptor
+		}
+		err = s.ExecMethod(objectPath, "GetSD", nil, &out)
+		return out.SD, err
+	}
+
+	err = s.execMethod(objectPath, "GetSecurityDescriptor", nil, func(out *ole.IDispatch) (err error) {
+		if err := checkReturnValue("GetSecurityDescriptor", out); err != nil {
+			return err
+		}
+		descriptor, err := oleutil.GetProperty(out, "Descriptor")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if clErr := descriptor.Clear(); clErr != nil {
+				err = multierror.Append(err, clErr)
+			}
+		}()
+		if descriptor.VT != ole.VT_DISPATCH || descriptor.ToIDispatch() == nil {
+			return fmt.Errorf("unexpected descriptor %s", descriptor.VT)
+		}
+
+		var binary struct {
+			BinarySD SecurityDescriptor
+		}
+		in := descriptorParam{descriptor.ToIDispatch()}
+		err = s.ExecMethod("Win32_SecurityDescriptorHelper", "Win32SDToBinarySD", in, &binary)
+		sd = binary.BinarySD
+		return err
+	})
+	return sd, err
+}
+
+// descriptorParam marshals `Win32_SecurityDescriptor` object into the
+// `Descriptor` in parameter.
+type descriptorParam struct {
+	descriptor *ole.IDispatch
+}
+
+func (p descriptorParam) MarshalOLE(dst *ole.IDispatch) error {
+	prop, err := oleutil.PutProperty(dst, "Descriptor", p.descriptor)
+	if err != nil {
+		return fmt.Errorf("can't put property %q; %v", "Descriptor", err)
+	}
+	return prop.Clear()
+}
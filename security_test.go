@@ -0,0 +1,47 @@
+// +build windows
+
+package wmi
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// checkSelfRelative checks that @sd looks like a self-relative descriptor.
+func checkSelfRelative(t *testing.T, sd SecurityDescriptor) {
+	t.Helper()
+	const seSelfRelative = 0x8000
+	if len(sd) < 20 || sd[0] != 1 || binary.LittleEndian.Uint16(sd[2:4])&seSelfRelative == 0 {
+		t.Errorf("Unexpected security descriptor %x", []byte(sd))
+	}
+}
+
+func TestClient_SecurityDescriptor(t *testing.T) {
+	path := ObjectPath("Win32_LogicalFileSecuritySetting", map[string]interface{}{"Path": `C:\Windows`})
+	sd, err := DefaultClient.SecurityDescriptor(path)
+	if err != nil {
+		t.Fatalf("Failed to get security descriptor of %q; %s", path, err)
+	}
+	checkSelfRelative(t, sd)
+
+	sd, err = DefaultClient.SecurityDescriptor("__SystemSecurity=@")
+	if err != nil {
+		t.Fatalf("Failed to get namespace security descriptor; %s", err)
+	}
+	checkSelfRelative(t, sd)
+
+	var out struct {
+		SD          SecurityDescriptor
+		ReturnValue uint32
+	}
+	if err := DefaultClient.ExecMethod("__SystemSecurity=@", "GetSD", nil, &out); err != nil {
+		t.Fatalf("Failed to exec GetSD; %s", err)
+	}
+	if string(out.SD) != string(sd) {
+		t.Errorf("Unexpected GetSD result into SecurityDescriptor field")
+	}
+
+	if _, err := DefaultClient.SecurityDescriptor(""); err == nil {
+		t.Errorf("Expected error for empty path")
+	}
+}
@@ -173,3 +173,13 @@ type Win32_OperatingSystem struct {
 	Version                                   string
 	WindowsDirectory                          string
 }
+
+// https://docs.microsoft.com/en-us/windows/win32/cimwin32prov/win32-service
+type Win32_Service struct {
+	Name        string
+	DisplayName string
+	State       string
+	StartMode   string
+	Started     bool
+	ProcessId   uint32
+}
@@ -3,6 +3,7 @@
 package wmi
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -12,6 +13,9 @@ import (
 	"github.com/scjalliance/comshim"
 )
 
+// defaultLocatorProgID is a ProgID of the COM object used to connect to WMI.
+const defaultLocatorProgID = "WbemScripting.SWbemLocator"
+
 // SWbemServices is used to access wmi on a different machines or namespaces
 // (with different `SWbemServices ConnectServer` args) using the single object.
 //
@@ -25,7 +29,18 @@ type SWbemServices struct {
 }
 
 // NewSWbemServices creates SWbemServices instance.
+//
+// If `SWbemLocator` object can't be created because WMI service is disabled or
+// not installed the returned error wraps ErrWMIUnavailable. Other failures
+// (e.g. COM initialization errors) are returned as is.
 func NewSWbemServices() (s *SWbemServices, err error) {
+	return newSWbemServices(defaultLocatorProgID)
+}
+
+// newSWbemServices creates SWbemServices instance using a locator COM object
+// of the @progID. An unknown custom @progID isn't reported as
+// ErrWMIUnavailable, it's likely a mistyped one.
+func newSWbemServices(progID string) (s *SWbemServices, err error) {
 	//  Be aware of reflections and COM usage.
 	defer func() {
 		if r := recover(); r != nil {
@@ -40,9 +55,12 @@ func NewSWbemServices() (s *SWbemServices, err error) {
 		}
 	}()
 
-	locatorIUnknown, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
+	locatorIUnknown, err := oleutil.CreateObject(progID)
 	if err != nil {
-		return nil, fmt.Errorf("CreateObject SWbemLocator error; %v", err)
+		if isUnavailableError(err) || (progID == defaultLocatorProgID && isClassStringError(err)) {
+			return nil, fmt.Errorf("%w; CreateObject %s error; %v", ErrWMIUnavailable, progID, err)
+		}
+		return nil, fmt.Errorf("CreateObject %s error; %v", progID, err)
 	} else if locatorIUnknown == nil {
 		return nil, ErrNilCreateObject
 	}
@@ -93,7 +111,34 @@ func (s *SWbemServices) Close() error {
 // changed using connectServerArgs. See Ref. for more info.
 //
 // Ref: https://docs.microsoft.com/en-us/windows/desktop/wmisdk/swbemlocator-connectserver
-func (s *SWbemServices) Query(query string, dst interface{}, connectServerArgs ...interface{}) (err error) {
+func (s *SWbemServices) Query(query string, dst interface{}, connectServerArgs ...interface{}) error {
+	return s.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.Query(query, dst)
+	})
+}
+
+// QueryMap runs the WQL query using a SWbemServices instance and loads the
+// values into @dst map keyed by the @keyField property. See
+// `SWbemServicesConnection.QueryMap` for the details.
+func (s *SWbemServices) QueryMap(query string, keyField string, dst interface{}, connectServerArgs ...interface{}) error {
+	return s.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.QueryMap(query, keyField, dst)
+	})
+}
+
+// QueryWith runs the WQL query using a SWbemServices instance with additional
+// @opts. See `SWbemServicesConnection.QueryWith` for the details.
+func (s *SWbemServices) QueryWith(ctx context.Context, query string, dst interface{}, opts QueryOptions, connectServerArgs ...interface{}) (res QueryResult, err error) {
+	err = s.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		res, err = conn.QueryWith(ctx, query, dst, opts)
+		return err
+	})
+	return res, err
+}
+
+// withConnection calls @f with a new temporary connection established using
+// @connectServerArgs.
+func (s *SWbemServices) withConnection(connectServerArgs []interface{}, f func(conn *SWbemServicesConnection) error) (err error) {
 	s.Lock()
 	if s.sWbemLocator == nil {
 		s.Unlock()
@@ -110,5 +155,5 @@ func (s *SWbemServices) Query(query string, dst interface{}, connectServerArgs .
 			err = multierror.Append(err, closeErr)
 		}
 	}()
-	return connection.Query(query, dst)
+	return f(connection)
 }
@@ -3,6 +3,7 @@
 package wmi
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -68,3 +69,15 @@ type MSFT_NetAdapter struct {
 	InterfaceIndex    int
 	DriverDescription string
 }
+
+func TestNewSWbemServices_Unavailable(t *testing.T) {
+	// Simulate the host without WMI installed.
+	s, err := newSWbemServices("WbemScripting.SWbemLocatorThatNeverExisted")
+	if err == nil {
+		_ = s.Close()
+		t.Fatal("Successfully created SWbemServices with unknown locator")
+	}
+	if !errors.Is(err, ErrWMIUnavailable) {
+		t.Errorf("Unexpected error; got %q, expected to wrap %q", err, ErrWMIUnavailable)
+	}
+}
@@ -0,0 +1,172 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
+)
+
+// QueryTable runs the WQL query using a SWbemServicesConnection instance and
+// returns the result as a table. @columns is a union of property names of all
+// the resulting objects in order of their first appearance. Every row holds
+// the object property values in the @columns order, values of properties
+// missing in the object are nil.
+//
+// Values are converted according to their CIM types (see `CIMType.GoType`),
+// embedded objects are returned as `map[string]interface{}`, arrays as
+// `[]interface{}`. NULL values are returned as nil.
+func (s *SWbemServicesConnection) QueryTable(query string) (columns []string, rows [][]interface{}, err error) {
+	s.Lock()
+	if s.sWbemServices == nil {
+		s.Unlock()
+		return nil, nil, ErrConnectionClosed
+	}
+	s.Unlock()
+
+	//  Be aware of reflections and COM usage.
+	defer func() {
+		if r := recover(); r != nil {
+			err = multierror.Append(err, fmt.Errorf("runtime panic; %v", r))
+		}
+	}()
+
+	resultRaw, err := oleutil.CallMethod(s.sWbemServices, "ExecQuery", query)
+	if err != nil {
+		return nil, nil, newWMIError(err)
+	}
+	defer func() {
+		if clErr := resultRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	columnIdx := make(map[string]int)
+	var objects []map[string]interface{}
+	err = forEach(resultRaw.ToIDispatch(), func(item *ole.IDispatch) error {
+		names, values, err := propertyValues(item)
+		if err != nil {
+			return err
+		}
+		object := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			if _, ok := columnIdx[name]; !ok {
+				columnIdx[name] = len(columns)
+				columns = append(columns, name)
+			}
+			object[name] = values[i]
+		}
+		objects = append(objects, object)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, newWMIError(err) // Query errors are reported by the enumeration.
+	}
+
+	rows = make([][]interface{}, len(objects))
+	for i, object := range objects {
+		rows[i] = make([]interface{}, len(columns))
+		for name, v := range object {
+			rows[i][columnIdx[name]] = v
+		}
+	}
+	return columns, rows, nil
+}
+
+// propertyValues returns names and converted values of all the @obj
+// properties. See `SWbemServicesConnection.QueryTable` for conversion details.
+func propertyValues(obj *ole.IDispatch) (names []string, values []interface{}, err error) {
+	propsRaw, err := oleutil.GetProperty(obj, "Properties_")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if clErr := propsRaw.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+
+	err = forEach(propsRaw.ToIDispatch(), func(prop *ole.IDispatch) error {
+		v, err := sWbemPropertyValue(prop)
+		if err != nil {
+			return err
+		}
+		name, err := oleutil.GetProperty(prop, "Name")
+		if err != nil {
+			return err
+		}
+
+		names = append(names, name.ToString())
+		values = append(values, v)
+		return name.Clear()
+	})
+	return names, values, err
+}
+
+// sWbemPropertyValue returns the converted value of SWbemProperty @prop.
+func sWbemPropertyValue(prop *ole.IDispatch) (value interface{}, err error) {
+	cimType, err := oleInt64(prop, "CIMType")
+	if err != nil {
+		return nil, err
+	}
+	v, err := oleutil.GetProperty(prop, "Value")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if clErr := v.Clear(); clErr != nil {
+			err = multierror.Append(err, clErr)
+		}
+	}()
+	return variantValue(v, CIMType(cimType))
+}
+
+// variantValue converts the property value @v of @cimType into a Go value.
+func variantValue(v *ole.VARIANT, cimType CIMType) (interface{}, error) {
+	switch {
+	case v.VT == ole.VT_NULL || v.VT == ole.VT_EMPTY:
+		return nil, nil
+	case v.VT == ole.VT_ARRAY|ole.VT_DISPATCH || v.VT == ole.VT_ARRAY|ole.VT_UNKNOWN:
+		// go-ole doesn't convert arrays of objects, collect them manually.
+		var arr []interface{}
+		err := forEachArrayObject(v.ToArray(), func(item *ole.IDispatch) error {
+			object, err := objectValue(item)
+			if err != nil {
+				return err
+			}
+			arr = append(arr, object)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return arr, nil
+	case v.VT&ole.VT_ARRAY != 0:
+		arr := v.ToArray().ToValueArray()
+		for i := range arr {
+			arr[i] = cimType.convert(arr[i])
+		}
+		return arr, nil
+	case v.VT == ole.VT_DISPATCH:
+		return objectValue(v.ToIDispatch())
+	default:
+		return cimType.convert(v.Value()), nil
+	}
+}
+
+// objectValue converts the embedded object @obj into a map of its property
+// values.
+func objectValue(obj *ole.IDispatch) (map[string]interface{}, error) {
+	names, values, err := propertyValues(obj)
+	if err != nil {
+		return nil, err
+	}
+	object := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		object[name] = values[i]
+	}
+	return object, nil
+}
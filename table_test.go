@@ -0,0 +1,71 @@
+// +build windows
+
+package wmi
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueryTable(t *testing.T) {
+	columns, rows, err := DefaultClient.QueryTable("SELECT * FROM Win32_Process")
+	if err != nil {
+		t.Fatalf("Failed to query processes table; %s", err)
+	}
+	if len(rows) == 0 {
+		t.Fatalf("No processes found")
+	}
+
+	idx := make(map[string]int)
+	for i, c := range columns {
+		idx[c] = i
+	}
+	for _, name := range []string{"Name", "ProcessId", "WorkingSetSize", "CreationDate", "TerminationDate"} {
+		if _, ok := idx[name]; !ok {
+			t.Fatalf("Column %q not found in %v", name, columns)
+		}
+	}
+
+	var systemFound bool
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			t.Fatalf("Row isn't aligned with columns; got %d values, expected %d", len(row), len(columns))
+		}
+		if _, ok := row[idx["Name"]].(string); !ok {
+			t.Errorf("Unexpected Name type %T", row[idx["Name"]])
+		}
+		if _, ok := row[idx["WorkingSetSize"]].(uint64); !ok {
+			t.Errorf("Unexpected WorkingSetSize type %T", row[idx["WorkingSetSize"]])
+		}
+		if v := row[idx["TerminationDate"]]; v != nil {
+			t.Errorf("Unexpected TerminationDate %v", v)
+		}
+		// CreationDate is NULL for some processes.
+		if v := row[idx["CreationDate"]]; v != nil {
+			if _, ok := v.(time.Time); !ok {
+				t.Errorf("Unexpected CreationDate type %T", v)
+			}
+		}
+
+		pid, ok := row[idx["ProcessId"]].(uint32)
+		if !ok {
+			t.Errorf("Unexpected ProcessId type %T", row[idx["ProcessId"]])
+		}
+		if pid == 4 {
+			systemFound = row[idx["Name"]] == "System"
+		}
+	}
+	if !systemFound {
+		t.Errorf("Failed to find System (PID=4) process")
+	}
+
+	_, _, err = DefaultClient.QueryTable("SELECT * FROM Win32_NoSuchClass")
+	var wmiErr WMIError
+	if !errors.As(err, &wmiErr) || !errors.Is(err, ErrInvalidClass) {
+		t.Errorf("Unexpected error for missing class; %v", err)
+	}
+	if _, _, err = DefaultClient.QueryTable("SELECT * FROM WHERE"); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Unexpected error for invalid query; %v", err)
+	}
+}
@@ -4,10 +4,18 @@ package wmi
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
+	"github.com/bi-zone/go-ole"
 	"github.com/hashicorp/go-multierror"
 )
 
@@ -21,13 +29,37 @@ var (
 	ErrNilCreateObject = errors.New("wmi: create object returned nil")
 )
 
-// QueryNamespace invokes Query with the given namespace on the local machine.
-func QueryNamespace(query string, dst interface{}, namespace string) error {
-	return Query(query, dst, nil, namespace)
+// QueryNamespace invokes Query with the given namespace. Server is the local
+// machine unless another one is specified as the first of
+// @connectServerArgs, the rest of them (user, password, etc.) follow the
+// namespace, e.g.
+//   QueryNamespace(query, &dst, `root\virtualization\v2`, "server", "user", "password")
+func QueryNamespace(query string, dst interface{}, namespace string, connectServerArgs ...interface{}) error {
+	var opts []interface{}
+	rest := make([]interface{}, 0, len(connectServerArgs))
+	for _, arg := range connectServerArgs {
+		if _, ok := arg.(Option); ok {
+			opts = append(opts, arg) // Could be in any position.
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	args := []interface{}{nil, namespace}
+	if len(rest) > 0 {
+		args[0] = rest[0]
+		args = append(args, rest[1:]...)
+	}
+	return Query(query, dst, append(args, opts...)...)
 }
 
 // Query runs the WQL query and appends the values to dst.
 //
+// @dst could also be a pointer to struct for the single-object queries (e.g.
+// by key): ErrNoResults is returned for the empty result, and
+// ErrMultipleResults if there is more than one object (the first one is
+// loaded anyway) unless `Decoder.FirstRowOnly` is set. See
+// `SWbemServicesConnection.Query` for other destination types.
+//
 // More info about result unmarshalling is available in `Decoder.Unmarshal` doc.
 //
 // By default, the local machine and default namespace are used. These can be
@@ -35,9 +67,69 @@ func QueryNamespace(query string, dst interface{}, namespace string) error {
 //
 //   https://docs.microsoft.com/en-us/windows/desktop/wmisdk/swbemlocator-connectserver
 //
+// Options (e.g. WithAllowMissingFields) could be passed along with
+// connectServerArgs to tweak the DefaultClient settings for the call, see
+// Option.
+//
 // Query is a wrapper around DefaultClient.Query.
 func Query(query string, dst interface{}, connectServerArgs ...interface{}) error {
-	return DefaultClient.Query(query, dst, connectServerArgs...)
+	c, ctx, args := withOptions(connectServerArgs)
+	return c.QueryContext(ctx, query, dst, args...)
+}
+
+// QueryReflect runs the WQL query and loads the results into the settable
+// @dst value. See `Client.QueryReflect` for the details.
+//
+// QueryReflect is a wrapper around DefaultClient.QueryReflect.
+func QueryReflect(query string, dst reflect.Value, connectServerArgs ...interface{}) error {
+	return defaultClient().QueryReflect(query, dst, connectServerArgs...)
+}
+
+// QueryContext runs the WQL query respecting the cancellation of @ctx. See
+// `Client.QueryContext` for the details.
+//
+// QueryContext is a wrapper around DefaultClient.QueryContext. Options are
+// accepted in the same way as in `Query`.
+func QueryContext(ctx context.Context, query string, dst interface{}, connectServerArgs ...interface{}) error {
+	c, _, args := withOptions(connectServerArgs)
+	return c.QueryContext(ctx, query, dst, args...)
+}
+
+// Refresh re-runs the WQL query and merges the results into the existing @dst
+// slice by the @keyField property. See `SWbemServicesConnection.Refresh` for
+// the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) Refresh(query string, dst interface{}, keyField string, connectServerArgs ...interface{}) (added, removed []int, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		var err error
+		added, removed, err = conn.Refresh(query, dst, keyField)
+		return err
+	})
+	return added, removed, err
+}
+
+// QueryWith runs the WQL query with additional @opts and returns the details
+// of the performed query. See `SWbemServicesConnection.QueryWith` for the
+// details.
+//
+// QueryWith is a wrapper around DefaultClient.QueryWith. Options are
+// accepted in the same way as in `Query`.
+func QueryWith(ctx context.Context, query string, dst interface{}, opts QueryOptions, connectServerArgs ...interface{}) (QueryResult, error) {
+	c, _, args := withOptions(connectServerArgs)
+	return c.QueryWith(ctx, query, dst, opts, args...)
+}
+
+// QueryProjected queries the @className objects into @dst selecting only the
+// properties of the @dst struct fields. See `Client.QueryProjected` for the
+// details, connectServerArgs are accepted in the same way as in `Query`.
+func QueryProjected(className, where string, dst interface{}, connectServerArgs ...interface{}) error {
+	c, ctx, args := withOptions(connectServerArgs)
+	query, err := BuildQuery(dst, className, where)
+	if err != nil {
+		return err
+	}
+	return c.QueryContext(ctx, query, dst, args...)
 }
 
 // CreateQuery returns a WQL query string that queries all columns of @src.
@@ -54,13 +146,12 @@ func Query(query string, dst interface{}, connectServerArgs ...interface{}) erro
 //   }
 //   var dst []Win32_Product
 //   query := wmi.CreateQuery(&dst, "WHERE InstallLocation != null")
+//
+// An empty string is returned if the query can't be built, use `BuildQuery`
+// to get the reason.
 func CreateQuery(src interface{}, where string) string {
-	s := reflect.Indirect(reflect.ValueOf(src))
-	t := s.Type()
-	if s.Kind() == reflect.Slice {
-		t = t.Elem()
-	}
-	return CreateQueryFrom(src, t.Name(), where)
+	query, _ := BuildQuery(src, "", where)
+	return query
 }
 
 // CreateQuery returns a WQL query string that queries all columns of @src from
@@ -69,30 +160,261 @@ func CreateQuery(src interface{}, where string) string {
 // N.B. The call is the same as `CreateQuery` but uses @from instead of structure
 // name as a class name.
 func CreateQueryFrom(src interface{}, from, where string) string {
+	query, _ := BuildQuery(src, from, where)
+	return query
+}
+
+// ClassNamer is implemented by the structure types mapped to the WMI class
+// named differently than the type, see `BuildQuery`. WMIClassName is called
+// on the zero value of the type.
+type ClassNamer interface {
+	WMIClassName() string
+}
+
+// BuildQuery returns a WQL query string that queries all the properties of
+// @src from class @from with condition @where (optional, should start with
+// "WHERE").
+//
+// @src could be T, *T, []T, or *[]T for some struct type T (slice elements
+// could also be pointers, e.g. []*T). If @from is empty
+// the class name is taken from the `class` option of the blank field (see
+// `structOptions`), e.g.
+//   type process struct {
+//       _    struct{} `wmi:",class=Win32_Process"`
+//       Name string
+//       PID  uint32 `wmi:"ProcessId"`
+//   }
+//   query, err := wmi.BuildQuery(&[]process{}, "", "WHERE ProcessId = 4")
+// returns `SELECT Name, ProcessId FROM Win32_Process WHERE ProcessId = 4`.
+// If there is no such option the class name is returned by the
+// `ClassNamer.WMIClassName` of T (or *T) if it's implemented, otherwise the
+// structure name is used.
+//
+// Property names are resolved in the same way as in `Decoder.Unmarshal`.
+// Unexported fields and fields tagged with `wmi:"-"` are skipped. Returns
+// ErrInvalidEntityType if @src isn't a structure and an error if it has no
+// properties to query.
+func BuildQuery(src interface{}, from, where string) (string, error) {
 	s := reflect.Indirect(reflect.ValueOf(src))
+	if !s.IsValid() {
+		return "", ErrInvalidEntityType
+	}
 	t := s.Type()
 	if s.Kind() == reflect.Slice {
 		t = t.Elem()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
 	}
 	if t.Kind() != reflect.Struct {
-		return ""
+		return "", ErrInvalidEntityType
+	}
+
+	structOpts := structOptions(t)
+	if from == "" {
+		if class, ok := structOpts.Value("class"); ok {
+			from = class
+		} else if namer, ok := reflect.New(t).Interface().(ClassNamer); ok {
+			from = namer.WMIClassName()
+		} else {
+			from = t.Name()
+		}
+	}
+	if from == "" {
+		return "", errors.New("no class name for the anonymous structure")
 	}
 
-	var b bytes.Buffer
-	b.WriteString("SELECT ")
 	var fields []string
-	for i := 0; i < t.NumField(); i++ {
-		name, _ := getFieldName(t.Field(i))
-		if name == "-" {
-			continue
+	for _, f := range cachedFields(t) {
+		if f.skipped() {
+			continue // Blank field holds structure options, unexported ones can't be set.
 		}
-		fields = append(fields, name)
+		fields = append(fields, f.name)
 	}
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no properties to query in %s", t)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("SELECT ")
 	b.WriteString(strings.Join(fields, ", "))
 	b.WriteString(" FROM ")
 	b.WriteString(from)
-	b.WriteString(" " + where)
-	return b.String()
+	if where != "" {
+		b.WriteString(" " + where)
+	}
+	return b.String(), nil
+}
+
+// WhereFromStruct returns a WQL WHERE clause matching the objects having the
+// same values as non-zero fields of @src (query by example), e.g.
+//   WhereFromStruct(Win32_Service{Name: "WinRM", Started: true})
+// returns `WHERE Name = "WinRM" AND Started = TRUE`.
+//
+// @src could be T or *T. Property names are resolved in the same way as in
+// `CreateQuery`. Zero fields (including nil pointers) are skipped unless
+// tagged with `keepzero` option. String values are escaped in the same way
+// as in `ObjectPath`, time.Time values are formatted as CIM_DATETIME. An
+// empty string is returned if there are no fields to match.
+func WhereFromStruct(src interface{}) (string, error) {
+	v := reflect.Indirect(reflect.ValueOf(src))
+	if v.Kind() != reflect.Struct {
+		return "", ErrInvalidEntityType
+	}
+
+	var conditions []string
+	for _, f := range cachedFields(v.Type()) {
+		name := f.name
+		if f.skipped() {
+			continue
+		}
+		fv, ok := fieldByIndex(v, f.index, false)
+		if !ok {
+			continue // Nil embedded struct.
+		}
+		if fv.IsZero() && !f.options.Contains("keepzero") {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				conditions = append(conditions, name+" IS NULL")
+				continue
+			}
+			fv = fv.Elem()
+		}
+		value, err := whereValue(fv)
+		if err != nil {
+			return "", fmt.Errorf("can't use field %q in WHERE; %v", f.Name, err)
+		}
+		conditions = append(conditions, name+" = "+value)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), nil
+}
+
+// WhereFromMap returns a WQL WHERE clause matching the objects having the
+// @conditions property values, e.g.
+//   WhereFromMap(map[string]interface{}{"Name": "O'Neil's app.exe", "ProcessId": 42})
+// returns `WHERE Name = "O'Neil's app.exe" AND ProcessId = 42`.
+//
+// Conditions are sorted by property name to make the result stable. Values
+// are formatted in the same way as in `WhereFromStruct`: strings are quoted
+// and escaped, numbers and booleans are formatted as WQL literals, time.Time
+// values as CIM_DATETIME. Pointers are dereferenced, nil values produce
+// `IS NULL` conditions. An empty string is returned for empty @conditions.
+//
+// Property names are pasted into the clause as is, so the names that aren't
+// plain identifiers (letters, digits and underscores) lead to the error.
+func WhereFromMap(conditions map[string]interface{}) (string, error) {
+	names := make([]string, 0, len(conditions))
+	for name := range conditions {
+		if !isIdentifier(name) {
+			return "", fmt.Errorf("can't use property %q in WHERE; not an identifier", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	clauses := make([]string, 0, len(names))
+	for _, name := range names {
+		v := reflect.ValueOf(conditions[name])
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if !v.IsValid() || v.Kind() == reflect.Ptr {
+			clauses = append(clauses, name+" IS NULL")
+			continue
+		}
+		value, err := whereValue(v)
+		if err != nil {
+			return "", fmt.Errorf("can't use property %q in WHERE; %v", name, err)
+		}
+		clauses = append(clauses, name+" = "+value)
+	}
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(clauses, " AND "), nil
+}
+
+// BuildQueryWhere returns a WQL query string that queries all columns of
+// @className objects matching the @conditions, see `WhereFromMap`, e.g.
+//   q, err := BuildQueryWhere("Win32_Service", map[string]interface{}{"Name": name})
+//
+// It's safe to use with the untrusted values unlike building the condition by
+// hand. The error is returned for the unsupported value types and for the
+// class and property names that aren't plain identifiers.
+func BuildQueryWhere(className string, conditions map[string]interface{}) (string, error) {
+	if !isIdentifier(className) {
+		return "", fmt.Errorf("invalid class name %q; not an identifier", className)
+	}
+	where, err := WhereFromMap(conditions)
+	if err != nil {
+		return "", err
+	}
+	query := "SELECT * FROM " + className
+	if where != "" {
+		query += " " + where
+	}
+	return query, nil
+}
+
+// CreateQueryWhere is `BuildQueryWhere` returning an empty string instead of
+// the error, same as `CreateQuery` does. Prefer `BuildQueryWhere` (or
+// `MustCreateQueryWhere`) for the dynamic conditions, so the error isn't
+// turned into an opaque WBEM_E_INVALID_QUERY of the empty query.
+func CreateQueryWhere(className string, conditions map[string]interface{}) string {
+	query, _ := BuildQueryWhere(className, conditions)
+	return query
+}
+
+// MustCreateQueryWhere is like `BuildQueryWhere` but panics on error. It's
+// intended for the conditions known to be valid, e.g. with constant names
+// and value types.
+func MustCreateQueryWhere(className string, conditions map[string]interface{}) string {
+	query, err := BuildQueryWhere(className, conditions)
+	if err != nil {
+		panic(fmt.Sprintf("wmi: MustCreateQueryWhere; %v", err))
+	}
+	return query
+}
+
+// isIdentifier reports if @s is a valid WQL class or property name, i.e. it
+// consists of letters, digits and underscores and doesn't start with a digit.
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// whereValue formats @v as a WQL literal.
+func whereValue(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return quotePathValue(v.String()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface()), nil
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return quotePathValue(FormatCIMDateTime(t)), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
 }
 
 // A Client is an WMI query client.
@@ -104,7 +426,13 @@ func CreateQueryFrom(src interface{}, from, where string) string {
 //
 // Important: Using zero-value Client does not speed up your queries comparing
 // to using `wmi.Query` method. Refer to benchmarks in repo README.md for more
-// info about the speed.
+// info about the speed. Use `Client.Connect` to reuse a single connection
+// across the queries.
+//
+// Calls of a Client are safe for concurrent use. Calls using the connection
+// established by `Client.Connect` are serialized, see Connect for the details.
+// A Client is not safe for concurrent modification: changing the Decoder
+// flags or calling Connect and Close should be serialized with other calls.
 type Client struct {
 	// Embedded Decoder for backward-compatibility.
 	Decoder
@@ -113,23 +441,716 @@ type Client struct {
 	// initialized and then reused across multiple queries. If it is null
 	// then the method will initialize a new temporary client each time.
 	SWbemServicesClient *SWbemServices
+
+	// Namespace is an optional WMI namespace used when connectServerArgs of
+	// the Client methods don't specify one, e.g. `root\virtualization\v2`.
+	// Default namespace is `root\cimv2`.
+	Namespace string
+
+	// LocatorProgID is an optional ProgID of the COM object used instead of
+	// the standard `WbemScripting.SWbemLocator` to create temporary
+	// SWbemServices (e.g. a wrapper registered in a sandboxed environment or a
+	// stub COM server for testing). The object should fully implement
+	// `SWbemLocator` interface, all the calls are passed to it as is, so use
+	// it only with the trusted objects. Ignored if SWbemServicesClient is set.
+	LocatorProgID string
+
+	// ConnectOptions is an optional typed form of connectServerArgs used when
+	// the Client methods are called without connectServerArgs, e.g. to
+	// query a remote host with the given credentials. `Client.Namespace` is
+	// used if the ConnectOptions.Namespace is empty. ConnectOptions security
+	// levels are applied to every established connection.
+	ConnectOptions *ConnectOptions
+
+	// Timeout is an optional max time to wait for every single query result
+	// object (see `QueryOptions.RowTimeout`) guarding against the hung
+	// providers. If any object isn't received in time, ErrTimeout is
+	// returned and partial results are discarded (the slice or map
+	// destination is reset to nil). Zero means infinite wait. Not supported
+	// on the threads initialized as STA by the caller, see ErrRowTimeoutSTA.
+	Timeout time.Duration
+
+	// MaxResults is an optional max number of objects a single query could
+	// load into the slice or map destination, guarding against the runaway
+	// queries (e.g. `Win32_NTLogEvent` without WHERE clause). The enumeration
+	// stops as soon as the next object is fetched and ErrResultLimitExceeded
+	// is returned, so no more than MaxResults objects are ever decoded. The
+	// loaded objects are kept in the destination. Streaming queries (e.g.
+	// `QueryChan` or `Client.QueryAsync`) stop after MaxResults objects with
+	// the same error. Unlike `QueryOptions.Limit` exceeding the limit is
+	// a failure. Zero means no limit.
+	MaxResults int
+
+	// RetryPolicy is an optional policy of re-running the queries failed with
+	// the transient errors (e.g. a busy remote server). The permanent
+	// failures (invalid class or query) are returned at once.
+	RetryPolicy *RetryPolicy
+
+	// Logger is an optional receiver of the diagnostic messages, nothing is
+	// logged by default.
+	Logger Logger
+
+	// Observer is an optional hook called after every query, e.g. to collect
+	// the query latency metrics.
+	Observer QueryObserver
+
+	conn        *SWbemServicesConnection // Established by `Client.Connect`.
+	connectArgs []interface{}            // Args of `Client.Connect`.
+	calls       chan func()              // Calls performed with the conn.
+}
+
+// Connect establishes the connection using @connectServerArgs that is reused
+// by all the subsequent Client calls without connectServerArgs, saving the
+// time of connecting to WMI on every call. The connection should be released
+// with `Client.Close`.
+//
+// The connection is owned by a dedicated goroutine locked to its OS thread.
+// All the calls using the connection (e.g. Query or ExecMethod) are passed to
+// that goroutine and performed one by one, so a connected Client could be
+// shared by the concurrent callers. Long-running calls producing results
+// asynchronously (`Client.Notify`, `ClientQueryChan`) don't block the others:
+// they establish their own connection using the same @connectServerArgs.
+//
+// Connect and Close shouldn't be performed concurrently with other calls.
+func (c *Client) Connect(connectServerArgs ...interface{}) error {
+	if c.calls != nil {
+		return errors.New("wmi: Client is already connected")
+	}
+
+	calls := make(chan func())
+	connected := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		err := c.withServices(func(s *SWbemServices) (err error) {
+			c.conn, err = c.connectServer(s, connectServerArgs)
+			return err
+		})
+		connected <- err
+		if err != nil {
+			return
+		}
+		for call := range calls {
+			call()
+		}
+	}()
+	if err := <-connected; err != nil {
+		return err
+	}
+	c.calls = calls
+	c.connectArgs = connectServerArgs
+	return nil
+}
+
+// Close releases the connection established by `Client.Connect` and stops
+// its goroutine. A closed Client establishes temporary connections again.
+func (c *Client) Close() error {
+	if c.calls == nil {
+		return nil
+	}
+	err := c.serialize(func(conn *SWbemServicesConnection) error {
+		return conn.Close()
+	})
+	close(c.calls)
+	c.conn, c.connectArgs, c.calls = nil, nil, nil
+	return err
+}
+
+// Services returns the underlying `SWbemServices` object of the connection
+// established by `Client.Connect`, see `SWbemServicesConnection.Services`.
+// An error is returned if the Client isn't connected.
+//
+// The connection is owned by the Client goroutine, so the COM calls on the
+// object should be made through `Client.Do` to not race with the other
+// Client calls. The object must not be released.
+func (c *Client) Services() (*ole.IDispatch, error) {
+	if c.calls == nil {
+		return nil, errors.New("wmi: Client is not connected")
+	}
+	var services *ole.IDispatch
+	err := c.serialize(func(conn *SWbemServicesConnection) error {
+		services = conn.Services()
+		return nil
+	})
+	return services, err
+}
+
+// Do calls @f with the underlying `SWbemServices` object of the connection,
+// e.g. to perform the operations the package doesn't wrap. Connection is
+// established in the same way as in `Client.Query`, so for a connected Client
+// @f is called on the Client goroutine one by one with the other calls.
+// @services must not be released or used after @f returns. Panics in @f are
+// returned as errors.
+func (c *Client) Do(f func(services *ole.IDispatch) error, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("runtime panic; %v", r)
+			}
+		}()
+		services := conn.Services()
+		if services == nil {
+			return ErrConnectionClosed
+		}
+		return f(services)
+	})
 }
 
 // DefaultClient is the default Client and is used by Query, QueryNamespace
+// and other package-level functions. Use `SetDefaultClient` to replace it
+// safely while the package-level functions could be called concurrently.
 var DefaultClient = &Client{}
 
+// defaultClientMu guards DefaultClient replacement.
+var defaultClientMu sync.RWMutex
+
+// SetDefaultClient replaces the DefaultClient used by the package-level
+// functions with @c, so the whole program could use the same settings (e.g.
+// decoder options or a reused SWbemServices) without passing the Client
+// everywhere. Nil @c resets the DefaultClient to the zero-value Client.
+func SetDefaultClient(c *Client) {
+	if c == nil {
+		c = &Client{}
+	}
+	defaultClientMu.Lock()
+	defer defaultClientMu.Unlock()
+	DefaultClient = c
+}
+
+// defaultClient returns the current DefaultClient.
+func defaultClient() *Client {
+	defaultClientMu.RLock()
+	defer defaultClientMu.RUnlock()
+	return DefaultClient
+}
+
 // Query runs the WQL query and appends the values to dst.
 //
+// @dst could also be a pointer to struct for the single-object queries (e.g.
+// by key): ErrNoResults is returned for the empty result, and
+// ErrMultipleResults if there is more than one object (the first one is
+// loaded anyway) unless `Decoder.FirstRowOnly` is set. See
+// `SWbemServicesConnection.Query` for other destination types.
+//
 // More info about result unmarshalling is available in `Decoder.Unmarshal` doc.
 //
 // By default, the local machine and default namespace are used. These can be
 // changed using connectServerArgs. See a reference below for details.
 //
 //   https://docs.microsoft.com/en-us/windows/desktop/wmisdk/swbemlocator-connectserver
-func (c *Client) Query(query string, dst interface{}, connectServerArgs ...interface{}) (err error) {
+func (c *Client) Query(query string, dst interface{}, connectServerArgs ...interface{}) error {
+	return c.QueryContext(context.Background(), query, dst, connectServerArgs...)
+}
+
+// QueryReflect runs the WQL query like `Client.Query` does, but loads the
+// results into the value @dst itself instead of the value pointed by the
+// argument. It's intended for the callers that have only reflect.Value of the
+// destination. @dst should be settable (e.g. obtained via
+// `reflect.New(t).Elem()`) slice, map or struct.
+func (c *Client) QueryReflect(query string, dst reflect.Value, connectServerArgs ...interface{}) error {
+	if !dst.IsValid() || !dst.CanSet() {
+		return fmt.Errorf("%w; destination value isn't settable", ErrInvalidEntityType)
+	}
+	return c.Query(query, dst.Addr().Interface(), connectServerArgs...)
+}
+
+// QueryContext runs the WQL query like `Client.Query` does, but respects the
+// cancellation and the deadline of @ctx.
+//
+// Since `SWbemServices.ExecQuery` call is synchronous, the query is performed
+// on a separate OS thread, so QueryContext returns `ctx.Err()` as soon as the
+// @ctx is done. The abandoned query is stopped after the next received object.
+// The objects decoded before the cancellation are discarded, @dst is left
+// untouched in such case.
+//
+// To keep @dst untouched the results of a cancellable @ctx are decoded into a
+// new value which then replaces the @dst one. So the backing array of the @dst
+// slice and its elements are never reused on this path, even if
+// `Decoder.ReuseElements` is set: the abandoned query could still write into
+// them after the return.
+func (c *Client) QueryContext(ctx context.Context, query string, dst interface{}, connectServerArgs ...interface{}) error {
+	if ctx.Done() == nil {
+		// Can't be cancelled, so don't bother with a goroutine.
+		return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+			return conn.Query(query, dst)
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	dstRefl := reflect.ValueOf(dst)
+	if dstRefl.Kind() != reflect.Ptr || dstRefl.IsNil() {
+		return ErrInvalidEntityType
+	}
+	// Decode into a temporary destination to keep @dst untouched.
+	tmp := reflect.New(dstRefl.Elem().Type())
+
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		done <- c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+			return conn.QueryContext(ctx, query, tmp.Interface())
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+			return err
+		}
+		dstRefl.Elem().Set(tmp.Elem())
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ping checks that the WMI server is reachable and the namespace is usable
+// without running any query, e.g. to mark a remote host unhealthy before
+// polling it. See `SWbemServicesConnection.Ping` for the details.
+//
+// Connection is established in the same way as in `Client.Query`, so the
+// connection failures are returned as is: ErrHostUnreachable,
+// ErrAccessDenied or ErrNamespaceNotFound. Ping returns `ctx.Err()` as soon
+// as the @ctx is done, same as `Client.QueryContext`.
+func (c *Client) Ping(ctx context.Context, connectServerArgs ...interface{}) error {
+	ping := func() error {
+		return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+			return conn.Ping()
+		})
+	}
+	if ctx.Done() == nil {
+		return ping()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		done <- ping()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// QueryProjected queries the @className objects matching the condition @where
+// (optional, should start with "WHERE") into @dst selecting only the
+// properties of the @dst struct fields instead of `SELECT *`, so the
+// provider doesn't compute the properties that aren't needed (which is slow
+// e.g. for `Win32_Product`). The query is built by `BuildQuery`, so empty
+// @className is taken from the struct type. @dst is the same as in
+// `Client.Query`.
+func (c *Client) QueryProjected(className, where string, dst interface{}, connectServerArgs ...interface{}) error {
+	query, err := BuildQuery(dst, className, where)
+	if err != nil {
+		return err
+	}
+	return c.Query(query, dst, connectServerArgs...)
+}
+
+// QueryOne runs the WQL query expected to return exactly one object (e.g. by
+// key) and unmarshals it into @dst. @dst should be a pointer to struct or to
+// `map[string]interface{}`.
+//
+// ErrNoResults is returned if the query returned no objects and
+// ErrMultipleResults if it returned more than one (the first one is loaded
+// into @dst anyway) unless `Decoder.FirstRowOnly` is set.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) QueryOne(query string, dst interface{}, connectServerArgs ...interface{}) error {
+	dstRefl := reflect.ValueOf(dst)
+	if dstRefl.Kind() != reflect.Ptr || dstRefl.IsNil() ||
+		(dstRefl.Elem().Kind() != reflect.Struct && dstRefl.Elem().Type() != objectMapType) {
+		return ErrInvalidEntityType
+	}
+	return c.Query(query, dst, connectServerArgs...)
+}
+
+// Count returns the number of the @class instances matching the optional
+// @where condition. See `SWbemServicesConnection.Count` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) Count(class, where string, connectServerArgs ...interface{}) (count int, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		count, err = conn.Count(class, where)
+		return err
+	})
+	return count, err
+}
+
+// EnumInstancePaths returns `__PATH` of all the @className instances. See
+// `SWbemServicesConnection.EnumInstancePaths` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) EnumInstancePaths(className string, connectServerArgs ...interface{}) (paths []string, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		paths, err = conn.EnumInstancePaths(className)
+		return err
+	})
+	return paths, err
+}
+
+// Get retrieves a single object by its @objectPath (e.g.
+// `Win32_Process.Handle="4"`) and unmarshals it into @dst. That is faster than
+// the equivalent WQL query. See `SWbemServicesConnection.Get` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) Get(objectPath string, dst interface{}, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.Get(objectPath, dst)
+	})
+}
+
+// Associators loads into @dst the objects associated with the object at
+// @objPath. See `SWbemServicesConnection.Associators` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) Associators(objPath, resultClass, assocClass string, dst interface{}, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.Associators(objPath, resultClass, assocClass, dst)
+	})
+}
+
+// References loads into @dst the association objects referring to the object
+// at @objPath. See `SWbemServicesConnection.References` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) References(objPath string, dst interface{}, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.References(objPath, dst)
+	})
+}
+
+// QueryMap runs the WQL query and loads the values into @dst map keyed by the
+// @keyField property. See `SWbemServicesConnection.QueryMap` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) QueryMap(query string, keyField string, dst interface{}, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.QueryMap(query, keyField, dst)
+	})
+}
+
+// QueryWith runs the WQL query with additional @opts and returns the details
+// of the performed query. See `SWbemServicesConnection.QueryWith` for the
+// details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) QueryWith(ctx context.Context, query string, dst interface{}, opts QueryOptions, connectServerArgs ...interface{}) (res QueryResult, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		res, err = conn.QueryWith(ctx, query, dst, opts)
+		return err
+	})
+	return res, err
+}
+
+// QueryMulti runs the @specs queries one by one on the same connection. See
+// `SWbemServicesConnection.QueryMulti` for the details.
+//
+// Connection is established in the same way as in `Client.Query`, so
+// a temporary connection is established only once for all the queries.
+func (c *Client) QueryMulti(specs []QuerySpec, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.QueryMulti(specs)
+	})
+}
+
+// DescribeClass returns names of all the properties of the @className class.
+// See `SWbemServicesConnection.DescribeClass` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) DescribeClass(className string, connectServerArgs ...interface{}) (names []string, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		names, err = conn.DescribeClass(className)
+		return err
+	})
+	return names, err
+}
+
+// ClassProperties returns the descriptions of all the @className class
+// properties including their qualifiers. See
+// `SWbemServicesConnection.ClassProperties` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) ClassProperties(className string, connectServerArgs ...interface{}) (props []PropertyInfo, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		props, err = conn.ClassProperties(className)
+		return err
+	})
+	return props, err
+}
+
+// ValidateStruct checks that the fields of the @dst struct match the
+// @className properties. See `SWbemServicesConnection.ValidateStruct` for the
+// details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) ValidateStruct(className string, dst interface{}, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.ValidateStruct(className, dst)
+	})
+}
+
+// QueryTable runs the WQL query and returns the result as a table. See
+// `SWbemServicesConnection.QueryTable` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) QueryTable(query string, connectServerArgs ...interface{}) (columns []string, rows [][]interface{}, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		columns, rows, err = conn.QueryTable(query)
+		return err
+	})
+	return columns, rows, err
+}
+
+// ClassExists checks if the @class exists. Connection is established in the
+// same way as in `Client.Query`.
+func (c *Client) ClassExists(class string, connectServerArgs ...interface{}) (ok bool, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		ok, err = conn.ClassExists(class)
+		return err
+	})
+	return ok, err
+}
+
+// InstanceExists checks if the object identified by @objectPath exists.
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) InstanceExists(objectPath string, connectServerArgs ...interface{}) (ok bool, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		ok, err = conn.InstanceExists(objectPath)
+		return err
+	})
+	return ok, err
+}
+
+// DescribeMethod returns the description of the @class @method.
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) DescribeMethod(class, method string, connectServerArgs ...interface{}) (info MethodInfo, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		info, err = conn.DescribeMethod(class, method)
+		return err
+	})
+	return info, err
+}
+
+// Exec executes the @method of the object identified by @objectPath with
+// @params and returns the method return value. See
+// `SWbemServicesConnection.Exec` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) Exec(objectPath, method string, params interface{}, connectServerArgs ...interface{}) (returnValue uint32, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		returnValue, err = conn.Exec(objectPath, method, params)
+		return err
+	})
+	return returnValue, err
+}
+
+// ExecMethod executes the @method of the object identified by @objectPath (or
+// a static method of the class) with @in parameters and unmarshals the out
+// parameters into @out. See `SWbemServicesConnection.ExecMethod` for the
+// details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) ExecMethod(objectPath, method string, in, out interface{}, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.ExecMethod(objectPath, method, in, out)
+	})
+}
+
+// PutInstance creates a new instance of the @className class from @src and
+// returns its object path. See `SWbemServicesConnection.PutInstance` for the
+// details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) PutInstance(className string, src interface{}, connectServerArgs ...interface{}) (path string, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		path, err = conn.PutInstance(className, src)
+		return err
+	})
+	return path, err
+}
+
+// UpdateInstance modifies the properties of the existing instance identified
+// by @objectPath. See `SWbemServicesConnection.UpdateInstance` for the
+// details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) UpdateInstance(objectPath string, changes map[string]interface{}, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.UpdateInstance(objectPath, changes)
+	})
+}
+
+// SecurityDescriptor returns the raw security descriptor of the object
+// identified by @objectPath. See `SWbemServicesConnection.SecurityDescriptor`
+// for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) SecurityDescriptor(objectPath string, connectServerArgs ...interface{}) (sd SecurityDescriptor, err error) {
+	err = c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		sd, err = conn.SecurityDescriptor(objectPath)
+		return err
+	})
+	return sd, err
+}
+
+// DeleteInstance deletes the instance identified by @objectPath. See
+// `SWbemServicesConnection.DeleteInstance` for the details.
+//
+// Connection is established in the same way as in `Client.Query`.
+func (c *Client) DeleteInstance(objectPath string, connectServerArgs ...interface{}) error {
+	return c.withConnection(connectServerArgs, func(conn *SWbemServicesConnection) error {
+		return conn.DeleteInstance(objectPath)
+	})
+}
+
+// NewRefresher creates a Refresher using a new connection established with
+// @connectServerArgs. The connection is closed with the Refresher.
+func (c *Client) NewRefresher(connectServerArgs ...interface{}) (r *Refresher, err error) {
+	err = c.withServices(func(s *SWbemServices) error {
+		conn, err := c.connectServer(s, connectServerArgs)
+		if err != nil {
+			return err
+		}
+		if r, err = conn.NewRefresher(); err != nil {
+			_ = conn.Close()
+			return err
+		}
+		r.ownConn = true
+		return nil
+	})
+	return r, err
+}
+
+// withConnection calls @f with either a connection established by
+// `Client.Connect` (if no @connectServerArgs are given) or a new temporary
+// connection established using @connectServerArgs. See `Client.withServices`
+// for the details.
+func (c *Client) withConnection(connectServerArgs []interface{}, f func(conn *SWbemServicesConnection) error) error {
+	if c.calls != nil && len(connectServerArgs) == 0 {
+		return c.serialize(f)
+	}
+	return c.withNewConnection(connectServerArgs, f)
+}
+
+// withOwnConnection calls @f with a new temporary connection even if the
+// Client is connected, so the long-running @f doesn't block the calls
+// serialized by `Client.serialize`. Empty @connectServerArgs are replaced
+// with the `Client.Connect` ones.
+func (c *Client) withOwnConnection(connectServerArgs []interface{}, f func(conn *SWbemServicesConnection) error) error {
+	if len(connectServerArgs) == 0 {
+		connectServerArgs = c.connectArgs
+	}
+	return c.withNewConnection(connectServerArgs, f)
+}
+
+// serialize calls @f with the connection established by `Client.Connect` on
+// the connection goroutine and waits for the result. A panic in @f is
+// returned as an error, so it doesn't break the connection goroutine.
+func (c *Client) serialize(f func(conn *SWbemServicesConnection) error) error {
+	done := make(chan error, 1)
+	c.calls <- func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("runtime panic; %v", r)
+			}
+		}()
+		// Patch decoder to use set decoder flags.
+		c.conn.Decoder = c.Decoder
+		c.conn.Decoder.Dereferencer = c.conn
+		c.configure(c.conn)
+		done <- f(c.conn)
+	}
+	return <-done
+}
+
+// withNewConnection calls @f with a new temporary connection established
+// using @connectServerArgs.
+func (c *Client) withNewConnection(connectServerArgs []interface{}, f func(conn *SWbemServicesConnection) error) error {
+	return c.withServices(func(s *SWbemServices) (err error) {
+		conn, err := c.connectServer(s, connectServerArgs)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := conn.Close(); closeErr != nil {
+				err = multierror.Append(err, closeErr)
+			}
+		}()
+		c.configure(conn)
+		return f(conn)
+	})
+}
+
+// configure applies the Client options to the @conn queries.
+func (c *Client) configure(conn *SWbemServicesConnection) {
+	conn.rowTimeout = c.Timeout
+	conn.maxResults = c.MaxResults
+	conn.retryPolicy = c.RetryPolicy
+	conn.logger = c.Logger
+	conn.observer = c.Observer
+}
+
+// connectServer establishes a new connection using @s and @connectServerArgs
+// and applies `Client.ConnectOptions` security levels, if any.
+func (c *Client) connectServer(s *SWbemServices, connectServerArgs []interface{}) (*SWbemServicesConnection, error) {
+	args := c.connectServerArgs(connectServerArgs)
+	conn, err := s.ConnectServer(args...)
+	if c.Logger != nil {
+		logConnect(c.Logger, args, err)
+	}
+	if err != nil || c.ConnectOptions == nil {
+		return conn, err
+	}
+	if err := conn.SetSecurity(c.ConnectOptions.ImpersonationLevel, c.ConnectOptions.AuthenticationLevel); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connectServerArgs returns @connectServerArgs (or `Client.ConnectOptions`
+// args if @connectServerArgs are empty) with `Client.Namespace` set as
+// a namespace argument if it's not specified.
+func (c *Client) connectServerArgs(connectServerArgs []interface{}) []interface{} {
+	if len(connectServerArgs) == 0 && c.ConnectOptions != nil {
+		connectServerArgs = c.ConnectOptions.ConnectServerArgs()
+	}
+	if c.Namespace == "" || (len(connectServerArgs) > 1 && connectServerArgs[1] != nil) {
+		return connectServerArgs
+	}
+	args := make([]interface{}, 2, len(connectServerArgs)+2)
+	copy(args, connectServerArgs)
+	args[1] = c.Namespace
+	if len(connectServerArgs) > 2 {
+		args = append(args, connectServerArgs[2:]...)
+	}
+	return args
+}
+
+// withServices calls @f with either a `Client.SWbemServicesClient` or a new
+// temporary SWbemServices. Client decoder is used in both cases.
+func (c *Client) withServices(f func(s *SWbemServices) error) (err error) {
 	client := c.SWbemServicesClient
 	if client == nil {
-		client, err = NewSWbemServices()
+		progID := c.LocatorProgID
+		if progID == "" {
+			progID = defaultLocatorProgID
+		}
+		client, err = newSWbemServices(progID)
 		if err != nil {
 			return err
 		}
@@ -140,5 +1161,5 @@ func (c *Client) Query(query string, dst interface{}, connectServerArgs ...inter
 		}()
 	}
 	client.Decoder = c.Decoder // Patch decoder to use set decoder flags inside `Query`.
-	return client.Query(query, dst, connectServerArgs...)
+	return f(client)
 }
@@ -3,11 +3,22 @@
 package wmi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"reflect"
+	"runtime"
 	"runtime/debug"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/bi-zone/go-ole"
+	"github.com/bi-zone/go-ole/oleutil"
+	"github.com/hashicorp/go-multierror"
 )
 
 func TestQuery(t *testing.T) {
@@ -39,6 +50,645 @@ func TestFieldMismatch(t *testing.T) {
 	}
 }
 
+func TestQueryMap(t *testing.T) {
+	type service struct {
+		Name  string
+		State string
+	}
+	var services map[string]service
+	if err := DefaultClient.QueryMap("SELECT Name, State FROM Win32_Service", "Name", &services); err != nil {
+		t.Fatalf("Failed to query services; %s", err)
+	}
+	s, ok := services["Winmgmt"]
+	if !ok {
+		t.Fatalf("Failed to find WMI service in %d services", len(services))
+	}
+	if s.Name != "Winmgmt" || s.State != "Running" {
+		t.Errorf("Unexpected WMI service; %+v", s)
+	}
+
+	// Key is converted to the map key type.
+	var processes map[uint32]*Win32_Process
+	if err := DefaultClient.QueryMap("SELECT * FROM Win32_Process", "ProcessId", &processes); err != nil {
+		t.Fatalf("Failed to query processes; %s", err)
+	}
+	if p := processes[4]; p == nil || p.Name != "System" {
+		t.Errorf("Failed to find System (PID=4) process; got %+v", p)
+	}
+
+	// Duplicate keys.
+	var states map[string]service
+	err := DefaultClient.QueryMap("SELECT Name, State FROM Win32_Service", "State", &states)
+	if err == nil {
+		t.Errorf("Expected duplicate key error")
+	}
+	c := Client{Decoder: Decoder{AllowDuplicateKeys: true}}
+	if err := c.QueryMap("SELECT Name, State FROM Win32_Service", "State", &states); err != nil {
+		t.Errorf("Failed to query services allowing duplicates; %s", err)
+	}
+	if len(states) == 0 || len(states) >= len(services) {
+		t.Errorf("Unexpected states count %d", len(states))
+	}
+}
+
+func TestQuery_KeyedMap(t *testing.T) {
+	type process struct {
+		ProcessId uint32 `wmi:",key"`
+		Name      string
+	}
+	var processes map[uint32]process
+	if err := DefaultClient.Query("SELECT ProcessId, Name FROM Win32_Process", &processes); err != nil {
+		t.Fatalf("Failed to query processes; %s", err)
+	}
+	if p, ok := processes[4]; !ok || p.ProcessId != 4 || p.Name != "System" {
+		t.Errorf("Failed to find System (PID=4) process; got %+v", p)
+	}
+
+	type service struct {
+		State string `wmi:"State,key"`
+		Name  string
+	}
+	var states map[string]*service
+	if err := DefaultClient.Query("SELECT Name, State FROM Win32_Service", &states); err == nil {
+		t.Errorf("Expected duplicate key error")
+	}
+	c := Client{Decoder: Decoder{AllowDuplicateKeys: true}}
+	if err := c.Query("SELECT Name, State FROM Win32_Service", &states); err != nil || states["Running"] == nil {
+		t.Errorf("Failed to query services allowing duplicates; %v", err)
+	}
+
+	var unkeyed map[string]struct{ Name string }
+	if err := DefaultClient.Query("SELECT Name FROM Win32_Service", &unkeyed); !errors.Is(err, ErrInvalidEntityType) {
+		t.Errorf("Unexpected error for map without key field; %v", err)
+	}
+}
+
+func TestClient_Exists(t *testing.T) {
+	tests := []struct {
+		check    func(string, ...interface{}) (bool, error)
+		path     string
+		expected bool
+	}{
+		{DefaultClient.ClassExists, "Win32_Process", true},
+		{DefaultClient.ClassExists, "Win32_NoSuchClass", false},
+		{DefaultClient.InstanceExists, `Win32_Process.Handle="4"`, true},
+		{DefaultClient.InstanceExists, `Win32_Process.Handle="4294967295"`, false},
+		{DefaultClient.InstanceExists, `Win32_NoSuchClass.Name="x"`, false},
+	}
+	for _, tt := range tests {
+		exists, err := tt.check(tt.path)
+		if err != nil {
+			t.Errorf("Failed to check %s existence; %s", tt.path, err)
+		} else if exists != tt.expected {
+			t.Errorf("Unexpected %s existence; got %v, expected %v", tt.path, exists, tt.expected)
+		}
+	}
+
+	// Other errors are propagated.
+	if _, err := DefaultClient.InstanceExists(`Win32_Process.Handle=`); err == nil {
+		t.Errorf("Expected error for invalid object path")
+	}
+}
+
+func TestQuery_SingleStruct(t *testing.T) {
+	// One object.
+	var system Win32_Process
+	if err := Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &system); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if system.Name != "System" {
+		t.Errorf("Unexpected System process name; got %q", system.Name)
+	}
+
+	// No objects.
+	var none Win32_Process
+	err := Query("SELECT * FROM Win32_Process WHERE ProcessId = 4294967295", &none)
+	if err != ErrNoResults {
+		t.Errorf("Unexpected error for empty result; got %v, expected %v", err, ErrNoResults)
+	}
+
+	// Many objects.
+	var many Win32_Process
+	err = Query("SELECT * FROM Win32_Process", &many)
+	if multiple, ok := err.(ErrMultipleResults); !ok || multiple.Count < 2 {
+		t.Errorf("Unexpected error for multiple results; got %v", err)
+	}
+
+	// Many objects with FirstRowOnly.
+	c := Client{Decoder: Decoder{FirstRowOnly: true}}
+	var first Win32_Process
+	if err := c.Query("SELECT * FROM Win32_Process", &first); err != nil {
+		t.Errorf("Failed to query first process; %s", err)
+	}
+	if first.Name == "" {
+		t.Errorf("First process isn't loaded")
+	}
+}
+
+func TestQueryContext(t *testing.T) {
+	query := "SELECT * FROM Win32_Process WHERE ProcessId = 4"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var processes []Win32_Process
+	if err := QueryContext(ctx, query, &processes); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if len(processes) != 1 || processes[0].Name != "System" {
+		t.Errorf("Unexpected processes; %+v", processes)
+	}
+
+	// Destination is untouched on cancellation.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	untouched := []Win32_Process{{Name: "untouched"}}
+	if err := QueryContext(ctx, "SELECT * FROM Win32_Process", &untouched); err != context.Canceled {
+		t.Errorf("Unexpected error for cancelled context; got %v", err)
+	}
+	if len(untouched) != 1 || untouched[0].Name != "untouched" {
+		t.Errorf("Destination is modified on cancellation; %+v", untouched)
+	}
+
+	// Deadline exceeded during the query.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := QueryContext(ctx, "SELECT * FROM Win32_Process", &untouched)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error for exceeded deadline; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Query isn't cancelled promptly; took %s", elapsed)
+	}
+	if len(untouched) != 1 || untouched[0].Name != "untouched" {
+		t.Errorf("Destination is modified on cancellation; %d objects", len(untouched))
+	}
+}
+
+func TestQueryNamespace_Children(t *testing.T) {
+	type namespace struct {
+		Name string
+	}
+	hasCIMv2 := func(namespaces []namespace) bool {
+		for _, ns := range namespaces {
+			if strings.EqualFold(ns.Name, "cimv2") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var namespaces []namespace
+	if err := QueryNamespace("SELECT Name FROM __NAMESPACE", &namespaces, "root"); err != nil {
+		t.Fatalf("Failed to query root namespaces; %s", err)
+	}
+	if !hasCIMv2(namespaces) {
+		t.Errorf("No cimv2 in root namespaces; %+v", namespaces)
+	}
+
+	c := Client{Namespace: "root"}
+	namespaces = nil
+	if err := c.Query("SELECT Name FROM __NAMESPACE", &namespaces); err != nil {
+		t.Fatalf("Failed to query root namespaces; %s", err)
+	}
+	if !hasCIMv2(namespaces) {
+		t.Errorf("No cimv2 in root namespaces; %+v", namespaces)
+	}
+
+	// Explicit namespace wins.
+	var processes []Win32_Process
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes, nil, `root\cimv2`); err != nil {
+		t.Errorf("Explicit namespace isn't used; %s", err)
+	}
+}
+
+func TestClient_Connect(t *testing.T) {
+	c := &Client{}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect; %s", err)
+	}
+	conn := c.conn
+	if err := c.Connect(); err == nil {
+		t.Errorf("Expected error for repeated Connect")
+	}
+
+	for i := 0; i < 3; i++ {
+		var processes []Win32_Process
+		if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+			t.Fatalf("Failed to query System process; %s", err)
+		}
+		if len(processes) != 1 || c.conn != conn {
+			t.Errorf("Connection isn't reused; %d processes", len(processes))
+		}
+	}
+
+	// Decoder changes are respected by the connected Client.
+	c.AllowMissingFields = true
+	var missing []struct{ Name, NoSuchField string }
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &missing); err != nil {
+		t.Errorf("Client decoder isn't used; %s", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Failed to close; %s", err)
+	}
+	if _, err := conn.DescribeClass("Win32_Process"); err != ErrConnectionClosed {
+		t.Errorf("Connection isn't closed; %v", err)
+	}
+	var processes []Win32_Process
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Errorf("Failed to query with closed Client; %s", err)
+	}
+}
+
+// TestClient_ConcurrentQueries is expected to be run with -race.
+func TestClient_ConcurrentQueries(t *testing.T) {
+	c := &Client{}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect; %s", err)
+	}
+	defer c.Close()
+
+	const workers = 8
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				var processes []Win32_Process
+				if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+					errs <- err
+					return
+				}
+				if len(processes) != 1 {
+					errs <- fmt.Errorf("unexpected result length %d", len(processes))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent query failed; %s", err)
+	}
+
+	// Panic doesn't break the connection goroutine.
+	err := c.withConnection(nil, func(*SWbemServicesConnection) error {
+		panic("test panic")
+	})
+	if err == nil {
+		t.Errorf("Expected error for panicking call")
+	}
+	var processes []Win32_Process
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Errorf("Failed to query after panic; %s", err)
+	}
+}
+
+func TestClient_Count(t *testing.T) {
+	var processes []struct{ ProcessId uint32 }
+	if err := Query("SELECT ProcessId FROM Win32_Process WHERE ProcessId = 0 OR ProcessId = 4", &processes); err != nil {
+		t.Fatalf("Failed to query processes; %s", err)
+	}
+	count, err := DefaultClient.Count("Win32_Process", "WHERE ProcessId = 0 OR ProcessId = 4")
+	if err != nil {
+		t.Fatalf("Failed to count processes; %s", err)
+	}
+	if count != len(processes) {
+		t.Errorf("Unexpected count; got %d, expected %d", count, len(processes))
+	}
+	if count, err := DefaultClient.Count("Win32_OperatingSystem", ""); err != nil || count != 1 {
+		t.Errorf("Unexpected operating systems count; got %d, %v", count, err)
+	}
+
+	_, err = DefaultClient.Count("Win32_NoSuchClass", "")
+	if err == nil || !strings.Contains(err.Error(), "Win32_NoSuchClass") {
+		t.Errorf("Unexpected error for missing class; %v", err)
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	c := &Client{Timeout: time.Nanosecond}
+	var processes []Win32_Process
+	err := c.Query("SELECT * FROM Win32_Process", &processes)
+	switch {
+	case err == ErrTimeout && processes != nil:
+		t.Errorf("Partial results aren't discarded on timeout; got %d objects", len(processes))
+	case err != nil && err != ErrTimeout:
+		t.Errorf("Unexpected error; got %v, expected %v", err, ErrTimeout)
+	case err != nil && !errors.Is(err, ErrRowTimeout):
+		t.Errorf("Client timeout doesn't match ErrRowTimeout")
+	}
+	if errors.Is(ErrRowTimeout, ErrTimeout) {
+		t.Errorf("Query row timeout matches Client one")
+	}
+
+	// Query row timeout overrides the Client one and is reported as is.
+	_, err = c.QueryWith(context.Background(), "SELECT * FROM Win32_Process", &processes, QueryOptions{RowTimeout: time.Nanosecond})
+	if err != nil && (err != ErrRowTimeout || errors.Is(err, ErrTimeout)) {
+		t.Errorf("Unexpected query row timeout error; %v", err)
+	}
+
+	c.Timeout = time.Minute
+	if err := c.Query("SELECT * FROM Win32_Process", &processes); err != nil || len(processes) == 0 {
+		t.Errorf("Unexpected query result; got %d objects, %v", len(processes), err)
+	}
+}
+
+type recordingLogger []string
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, args...))
+}
+
+func TestClient_Logger(t *testing.T) {
+	var logger recordingLogger
+	c := &Client{Namespace: `root\cimv2`, Logger: &logger}
+	var processes []struct{ Name string }
+	if err := c.Query("SELECT Name FROM Win32_Process", &processes); err != nil {
+		t.Fatalf("Failed to query processes; %s", err)
+	}
+	expected := []string{
+		`wmi: connected to "." namespace "root\\cimv2"`,
+		fmt.Sprintf(`wmi: query "SELECT Name FROM Win32_Process" decoded %d objects`, len(processes)),
+	}
+	if !reflect.DeepEqual([]string(logger), expected) {
+		t.Errorf("Unexpected log; got %q, expected %q", logger, expected)
+	}
+
+	logger = nil
+	_ = c.Query("SELECT * FROM Win32_Unknown", &processes)
+	if len(logger) != 2 || !strings.Contains(logger[1], "failed after 0 objects") {
+		t.Errorf("Query error isn't logged; got %q", logger)
+	}
+}
+
+func TestClient_Observer(t *testing.T) {
+	type observation struct {
+		query   string
+		elapsed time.Duration
+		rows    int
+		err     error
+	}
+	var observed []observation
+	c := &Client{Observer: func(query string, elapsed time.Duration, rows int, err error) {
+		observed = append(observed, observation{query, elapsed, rows, err})
+	}}
+
+	var processes []struct{ Name string }
+	if err := c.Query("SELECT Name FROM Win32_Process", &processes); err != nil {
+		t.Fatalf("Failed to query processes; %s", err)
+	}
+	_ = c.Query("SELECT * FROM Win32_Unknown", &processes)
+	if len(observed) != 2 {
+		t.Fatalf("Unexpected number of observations; got %d, expected 2", len(observed))
+	}
+	if o := observed[0]; o.query != "SELECT Name FROM Win32_Process" || o.elapsed <= 0 || o.rows != len(processes) || o.err != nil {
+		t.Errorf("Unexpected observation; %+v", o)
+	}
+	if o := observed[1]; o.rows != 0 || o.err == nil {
+		t.Errorf("Query error isn't observed; %+v", o)
+	}
+}
+
+func TestClient_QueryMulti(t *testing.T) {
+	var observed []string
+	var logger recordingLogger
+	c := &Client{Logger: &logger, Observer: func(query string, _ time.Duration, _ int, _ error) {
+		observed = append(observed, query)
+	}}
+	var systems []struct{ Caption string }
+	var bios struct{ Manufacturer string }
+	var unknown []struct{ Name string }
+	err := c.QueryMulti([]QuerySpec{
+		{Query: "SELECT Caption FROM Win32_OperatingSystem", Dst: &systems},
+		{Query: "SELECT * FROM Win32_Unknown", Dst: &unknown},
+		{Query: "SELECT Manufacturer FROM Win32_BIOS", Dst: &bios},
+	})
+	var mErr *multierror.Error
+	if !errors.As(err, &mErr) || len(mErr.Errors) != 1 || !strings.Contains(mErr.Errors[0].Error(), "Win32_Unknown") {
+		t.Errorf("Unexpected error; %v", err)
+	}
+	if len(systems) != 1 || systems[0].Caption == "" || bios.Manufacturer == "" {
+		t.Errorf("Unexpected results; %+v, %+v", systems, bios)
+	}
+	connects := 0
+	for _, msg := range logger {
+		if strings.HasPrefix(msg, "wmi: connected") {
+			connects++
+		}
+	}
+	if len(observed) != 3 || connects != 1 {
+		t.Errorf("Queries aren't performed on the same connection; %q, %q", observed, logger)
+	}
+
+	if err := c.QueryMulti(nil); err != nil {
+		t.Errorf("Unexpected error for no queries; %s", err)
+	}
+}
+
+func TestClient_Get(t *testing.T) {
+	c := &Client{}
+	var process Win32_Process
+	if err := c.Get(`Win32_Process.Handle="4"`, &process); err != nil {
+		t.Fatalf("Failed to get process by path; %s", err)
+	}
+	if process.ProcessId != 4 || process.Name != "System" {
+		t.Errorf("Unexpected process; %+v", process)
+	}
+
+	var props map[string]interface{}
+	if err := c.Get(ObjectPath("Win32_Process", map[string]interface{}{"Handle": "4"}), &props); err != nil {
+		t.Fatalf("Failed to get process into map; %s", err)
+	}
+	if props["Name"] != "System" {
+		t.Errorf("Unexpected process properties; %v", props)
+	}
+
+	err := c.Get(`Win32_Process.Handle="999999999"`, &process)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error for missing object; got %v, expected %v", err, ErrNotFound)
+	}
+	if err := c.Get(`Win32_Process.Handle="4"`, process); err == nil {
+		t.Errorf("Expected error for non-pointer destination")
+	}
+}
+
+func TestClient_Associators(t *testing.T) {
+	c := &Client{}
+	var disks []struct {
+		DeviceID string
+		Path     string `wmi:"__PATH"`
+	}
+	if err := c.Query("SELECT DeviceID, __PATH FROM Win32_LogicalDisk WHERE DeviceID = 'C:'", &disks); err != nil || len(disks) != 1 {
+		t.Fatalf("Failed to query system disk; %d objects, %v", len(disks), err)
+	}
+
+	var partitions []struct{ DeviceID string }
+	if err := c.Associators(disks[0].Path, "Win32_DiskPartition", "", &partitions); err != nil {
+		t.Fatalf("Failed to query associators; %s", err)
+	}
+	if len(partitions) == 0 {
+		t.Errorf("Expected partitions of the system disk")
+	}
+
+	var refs []struct {
+		Antecedent string
+		Dependent  string
+	}
+	if err := c.References(disks[0].Path, &refs); err != nil {
+		t.Fatalf("Failed to query references; %s", err)
+	}
+	if len(refs) == 0 {
+		t.Errorf("Expected references to the system disk")
+	}
+
+	if err := c.Associators("", "", "", &partitions); err != ErrNoObjectPath {
+		t.Errorf("Unexpected error for empty path; got %v, expected %v", err, ErrNoObjectPath)
+	}
+	malformed := `Win32_LogicalDisk.DeviceID="C:`
+	if err := c.Associators(malformed, "", "", &partitions); err == nil {
+		t.Errorf("Expected error for malformed path")
+	}
+	if err := c.References(malformed, &refs); err == nil {
+		t.Errorf("Expected error for malformed path")
+	}
+	tests := []struct{ result, assoc, expected string }{
+		{"", "", "ASSOCIATORS OF {Win32_LogicalDisk.DeviceID='C:'}"},
+		{"Win32_DiskPartition", "", "ASSOCIATORS OF {Win32_LogicalDisk.DeviceID='C:'} WHERE ResultClass = Win32_DiskPartition"},
+		{"Win32_DiskPartition", "Win32_LogicalDiskToPartition", "ASSOCIATORS OF {Win32_LogicalDisk.DeviceID='C:'} WHERE ResultClass = Win32_DiskPartition AssocClass = Win32_LogicalDiskToPartition"},
+	}
+	for _, tt := range tests {
+		if q := associatorsQuery("Win32_LogicalDisk.DeviceID='C:'", AssocOpts{ResultClass: tt.result, AssocClass: tt.assoc}); q != tt.expected {
+			t.Errorf("Unexpected associators query; got %q, expected %q", q, tt.expected)
+		}
+	}
+}
+
+func TestWMIError(t *testing.T) {
+	var dst []struct{ Name string }
+	err := Query("SELECT Name FROM Win32_NoSuchClass", &dst)
+	var wmiErr WMIError
+	if !errors.As(err, &wmiErr) {
+		t.Fatalf("Expected WMIError for missing class; got %v", err)
+	}
+	if uint32(wmiErr.HResult) != wbemEInvalidClass || wmiErr.Code != "WBEM_E_INVALID_CLASS" {
+		t.Errorf("Unexpected WMIError for missing class; %+v", wmiErr)
+	}
+	if !errors.Is(err, ErrInvalidClass) || errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("WMIError doesn't match ErrInvalidClass; %v", err)
+	}
+
+	err = Query("SELECT Name FROM WHERE", &dst)
+	if !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Unexpected error for invalid query; got %v", err)
+	}
+
+	// Failure reported by the enumeration is a WMIError too.
+	_, err = DefaultClient.QueryWith(context.Background(), "SELECT Name FROM Win32_NoSuchClass", &dst, QueryOptions{ForwardOnly: true})
+	if !errors.As(err, &wmiErr) || !errors.Is(err, ErrInvalidClass) {
+		t.Errorf("Unexpected forward-only error for missing class; %v", err)
+	}
+	incomplete := ErrIncompleteResult{Code: wbemEProviderNotFound}
+	if !errors.As(incomplete, &wmiErr) || wmiErr.Code != "WBEM_E_PROVIDER_NOT_FOUND" || !errors.Is(incomplete, ErrProviderNotFound) {
+		t.Errorf("Unexpected WMIError of provider failure; %+v", wmiErr)
+	}
+	if errors.As(ErrIncompleteResult{Code: wbemSNoMoreData}, &wmiErr) {
+		t.Errorf("WMIError is returned for the success code")
+	}
+
+	// Existing class without matching instances isn't an error.
+	dst = nil
+	if err := Query("SELECT Name FROM Win32_Process WHERE ProcessId = 4294967295", &dst); err != nil || dst == nil || len(dst) != 0 {
+		t.Errorf("Unexpected empty result; %v, %v", dst, err)
+	}
+}
+
+func TestClient_QueryWith(t *testing.T) {
+	query := "SELECT * FROM Win32_Process"
+	var processes []Win32_Process
+	res, err := DefaultClient.QueryWith(context.Background(), query, &processes, QueryOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("Failed to query processes; %s", err)
+	}
+	if res.Query != query || res.Rows != 2 || len(processes) != 2 || !res.Truncated {
+		t.Errorf("Unexpected query result %+v of %d processes", res, len(processes))
+	}
+	if res.Duration <= 0 || len(res.Warnings) != 0 {
+		t.Errorf("Unexpected query result %+v", res)
+	}
+
+	// Field mismatch is reported as a warning.
+	var mismatch []struct{ Name, Nonexistent string }
+	res, err = DefaultClient.QueryWith(context.Background(), query, &mismatch, QueryOptions{})
+	if err != nil {
+		t.Fatalf("Failed to query processes; %s", err)
+	}
+	if res.Truncated || res.Rows != len(mismatch) || len(res.Warnings) != len(mismatch) {
+		t.Errorf("Unexpected query result %+v of %d processes", res, len(mismatch))
+	}
+
+	// Forward-only enumeration loads the same objects.
+	var system []Win32_Process
+	res, err = DefaultClient.QueryWith(context.Background(), query+" WHERE ProcessId = 4", &system, QueryOptions{ForwardOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to query processes with forward-only enumerator; %s", err)
+	}
+	if res.Rows != 1 || len(system) != 1 || system[0].Name != "System" {
+		t.Errorf("Unexpected forward-only query result %+v; %+v", res, system)
+	}
+
+	// Cancelled context.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DefaultClient.QueryWith(ctx, query, &processes, QueryOptions{}); err != context.Canceled {
+		t.Errorf("Unexpected error for cancelled context; got %v", err)
+	}
+}
+
+func TestClient_LocatorProgID(t *testing.T) {
+	var processes []Win32_Process
+	c := Client{LocatorProgID: "WbemScripting.SWbemLocator"}
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil {
+		t.Errorf("Failed to query with standard locator; %s", err)
+	}
+
+	progID := "WbemScripting.SWbemLocatorThatNeverExisted"
+	c = Client{LocatorProgID: progID}
+	err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes)
+	if err == nil || errors.Is(err, ErrWMIUnavailable) || !strings.Contains(err.Error(), progID) {
+		t.Errorf("Unexpected error for unknown locator; got %v", err)
+	}
+}
+
+func TestSetDefaultClient(t *testing.T) {
+	defer SetDefaultClient(nil)
+
+	var processes []struct{ Name, NoSuchField string }
+	query := "SELECT * FROM Win32_Process WHERE ProcessId = 4"
+	if err := Query(query, &processes); err == nil {
+		t.Fatalf("Expected ErrFieldMismatch with the zero-value client")
+	}
+
+	SetDefaultClient(&Client{Decoder: Decoder{AllowMissingFields: true}})
+	if err := Query(query, &processes); err != nil {
+		t.Errorf("Default client settings aren't used by Query; %s", err)
+	}
+	if _, err := QueryWith(context.Background(), query, &processes, QueryOptions{}); err != nil {
+		t.Errorf("Default client settings aren't used by QueryWith; %s", err)
+	}
+	if len(processes) != 1 || processes[0].Name != "System" {
+		t.Errorf("Unexpected processes; %+v", processes)
+	}
+
+	SetDefaultClient(nil)
+	if DefaultClient == nil || DefaultClient.AllowMissingFields {
+		t.Errorf("Default client isn't reset; %+v", DefaultClient)
+	}
+}
+
 func TestStrings(t *testing.T) {
 	printed := false
 	f := func() {
@@ -133,3 +783,487 @@ func TestCreateQueryTags(t *testing.T) {
 		t.Errorf("Got unexpected query; got %q, expected %q", got, expected)
 	}
 }
+
+func TestBuildQuery(t *testing.T) {
+	type process struct {
+		_       struct{} `wmi:",class=Win32_Process"`
+		Name    string
+		PID     uint32 `wmi:"ProcessId"`
+		Ignored string `wmi:"-"`
+		private string
+	}
+	query, err := BuildQuery(&[]process{}, "", "WHERE ProcessId = 4")
+	if expected := "SELECT Name, ProcessId FROM Win32_Process WHERE ProcessId = 4"; err != nil || query != expected {
+		t.Errorf("Unexpected query; got %q, %v, expected %q", query, err, expected)
+	}
+	query, err = BuildQuery([]*Win32_OperatingSystem{}, "CIM_OperatingSystem", "")
+	if err != nil || !strings.HasPrefix(query, "SELECT ") || !strings.HasSuffix(query, " FROM CIM_OperatingSystem") {
+		t.Errorf("Unexpected query with explicit class; got %q, %v", query, err)
+	}
+
+	// The built query is runnable.
+	var processes []process
+	if err := Query(CreateQuery(&processes, "WHERE ProcessId = 4"), &processes); err != nil || len(processes) != 1 {
+		t.Errorf("Failed to run the built query; %v", err)
+	}
+
+	type empty struct {
+		Ignored string `wmi:"-"`
+		private string
+	}
+	if _, err := BuildQuery(empty{}, "", ""); err == nil {
+		t.Errorf("Expected error for the struct without properties")
+	}
+	if _, err := BuildQuery(struct{ Name string }{}, "", ""); err == nil {
+		t.Errorf("Expected error for the anonymous struct")
+	}
+	if _, err := BuildQuery(42, "", ""); err != ErrInvalidEntityType {
+		t.Errorf("Unexpected error for non-struct; %v", err)
+	}
+	if query := CreateQuery(empty{}, ""); query != "" {
+		t.Errorf("Unexpected CreateQuery result for the struct without properties; %q", query)
+	}
+
+	// Class name is taken from the method.
+	query, err = BuildQuery([]Process{}, "", "")
+	if expected := "SELECT Name FROM Win32_Process"; err != nil || query != expected {
+		t.Errorf("Unexpected query of ClassNamer; got %q, %v, expected %q", query, err, expected)
+	}
+}
+
+// Process is a Win32_Process mapped using ClassNamer.
+type Process struct {
+	Name string
+}
+
+func (*Process) WMIClassName() string {
+	return "Win32_Process"
+}
+
+func TestWhereFromStruct(t *testing.T) {
+	type TestStruct struct {
+		_         struct{} `wmi:",prefix=Win32_"`
+		Name      string
+		Size      int     `wmi:"Count"`
+		Started   bool    `wmi:",keepzero"`
+		Path      *string `wmi:"PathName"`
+		UserField string  `wmi:"-"`
+		Ignored   []string
+	}
+	path := `C:\Program Files\"App"`
+	tests := []struct {
+		src      interface{}
+		expected string
+	}{
+		{TestStruct{}, "WHERE Win32_Started = FALSE"},
+		{&TestStruct{Name: "WinRM", Started: true, UserField: "skip"}, `WHERE Win32_Name = "WinRM" AND Win32_Started = TRUE`},
+		{TestStruct{Size: 42, Path: &path}, `WHERE Count = 42 AND Win32_Started = FALSE AND PathName = "C:\\Program Files\\\"App\""`},
+		{struct{ Name string }{}, ""},
+	}
+	for _, tt := range tests {
+		got, err := WhereFromStruct(tt.src)
+		if err != nil {
+			t.Errorf("Failed to build WHERE from %+v; %s", tt.src, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("Unexpected WHERE; got %s, expected %s", got, tt.expected)
+		}
+	}
+
+	if _, err := WhereFromStruct(TestStruct{Ignored: []string{"a"}}); err == nil {
+		t.Errorf("Expected error for unsupported field type")
+	}
+	if _, err := WhereFromStruct(3); err == nil {
+		t.Errorf("Expected error for non-struct")
+	}
+}
+
+func TestClient_QueryReflect(t *testing.T) {
+	dst := reflect.New(reflect.SliceOf(reflect.TypeOf(Win32_Process{}))).Elem()
+	if err := QueryReflect("SELECT * FROM Win32_Process", dst); err != nil {
+		t.Fatalf("Failed to query into reflect.Value; %s", err)
+	}
+	processes := dst.Interface().([]Win32_Process)
+	if len(processes) == 0 {
+		t.Fatal("No processes are loaded")
+	}
+
+	var one Win32_Process
+	if err := QueryReflect("SELECT * FROM Win32_Process WHERE ProcessId = 4", reflect.ValueOf(&one).Elem()); err != nil {
+		t.Fatalf("Failed to query into struct reflect.Value; %s", err)
+	}
+	if one.ProcessId != 4 {
+		t.Errorf("Unexpected process loaded; %+v", one)
+	}
+
+	// Values without address can't be loaded.
+	if err := QueryReflect("SELECT * FROM Win32_Process", reflect.ValueOf(processes)); !errors.Is(err, ErrInvalidEntityType) {
+		t.Errorf("Unexpected error for non-settable value; %v", err)
+	}
+	if err := QueryReflect("SELECT * FROM Win32_Process", reflect.Value{}); !errors.Is(err, ErrInvalidEntityType) {
+		t.Errorf("Unexpected error for invalid value; %v", err)
+	}
+}
+
+func TestClient_EnumInstancePaths(t *testing.T) {
+	paths, err := DefaultClient.EnumInstancePaths("Win32_Process")
+	if err != nil {
+		t.Fatalf("Failed to enumerate Win32_Process instances; %s", err)
+	}
+	found := false
+	for _, p := range paths {
+		if strings.HasSuffix(p, `:Win32_Process.Handle="4"`) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("System process path isn't enumerated; %q", paths)
+	}
+
+	// Paths could be used to get the objects.
+	var process Win32_Process
+	if err := DefaultClient.Get(paths[0], &process); err != nil {
+		t.Errorf("Failed to get %q; %s", paths[0], err)
+	}
+
+	_, err = DefaultClient.EnumInstancePaths("Win32_NoSuchClass")
+	var wmiErr WMIError
+	if !errors.As(err, &wmiErr) || !errors.Is(err, ErrInvalidClass) {
+		t.Errorf("Unexpected error for invalid class; %v", err)
+	}
+}
+
+func TestQuery_Options(t *testing.T) {
+	query := "SELECT Name FROM Win32_Process WHERE ProcessId = 4"
+	var processes []struct {
+		Name    string
+		Missing string
+	}
+	if err := Query(query, &processes); err == nil {
+		t.Fatalf("Expected field mismatch without options")
+	}
+	if err := Query(query, &processes, WithAllowMissingFields()); err != nil || len(processes) != 1 {
+		t.Errorf("Failed to query with WithAllowMissingFields; %v", err)
+	}
+	if DefaultClient.AllowMissingFields {
+		t.Errorf("DefaultClient is modified by the options")
+	}
+
+	// Later options override the earlier ones.
+	err := Query(query, &processes, WithAllowMissingFields(), WithDecoder(Decoder{}))
+	if _, ok := err.(ErrFieldMismatch); !ok {
+		t.Errorf("WithDecoder doesn't override the decoder; %v", err)
+	}
+
+	// Options are accepted along with connectServerArgs in any position.
+	if err := Query(query, &processes, WithNamespace(`root\default`)); !errors.Is(err, ErrInvalidClass) {
+		t.Errorf("Unexpected error in a namespace without Win32_Process; %v", err)
+	}
+	err = QueryNamespace(query, &processes, `root\cimv2`, WithNamespace(`root\default`), nil, WithAllowMissingFields())
+	if err != nil || len(processes) != 1 {
+		t.Errorf("Failed to query with QueryNamespace and options; %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Query(query, &processes, WithContext(ctx)); err != context.Canceled {
+		t.Errorf("Unexpected error with cancelled context; %v", err)
+	}
+}
+
+func TestClient_Ping(t *testing.T) {
+	var c Client
+	if err := c.Ping(context.Background()); err != nil {
+		t.Errorf("Failed to ping local WMI; %s", err)
+	}
+
+	c.Namespace = `root\notExistingNamespace`
+	if _, ok := c.Ping(context.Background()).(ErrNamespaceNotFound); !ok {
+		t.Errorf("Expected ErrNamespaceNotFound")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Ping(ctx); err != context.Canceled {
+		t.Errorf("Unexpected ping result with cancelled context; %v", err)
+	}
+}
+
+func TestClient_QueryProjected(t *testing.T) {
+	var queries []string
+	c := Client{Observer: func(query string, _ time.Duration, _ int, _ error) {
+		queries = append(queries, query)
+	}}
+	var processes []*struct {
+		Name string
+		PID  uint32 `wmi:"ProcessId"`
+	}
+	if err := c.QueryProjected("Win32_Process", "WHERE ProcessId = 4", &processes); err != nil {
+		t.Fatalf("Failed to query System process; %s", err)
+	}
+	if len(processes) != 1 || processes[0].PID != 4 || processes[0].Name != "System" {
+		t.Errorf("Unexpected System process; %+v", processes)
+	}
+	expected := []string{"SELECT Name, ProcessId FROM Win32_Process WHERE ProcessId = 4"}
+	if !reflect.DeepEqual(queries, expected) {
+		t.Errorf("Unexpected queries; got %q, expected %q", queries, expected)
+	}
+
+	if err := c.QueryProjected("", "", &processes); err == nil {
+		t.Errorf("Expected error for the anonymous struct")
+	}
+}
+
+func TestQuery_COMInitialization(t *testing.T) {
+	query := "SELECT Name FROM Win32_Process WHERE ProcessId = 4"
+
+	// Fresh goroutines without any COM initialization.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dst []Win32_Process
+			errs <- Query(query, &dst)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Query from fresh goroutine failed; %s", err)
+		}
+	}
+
+	// Threads initialized by the caller.
+	for _, model := range []uint32{ole.COINIT_APARTMENTTHREADED, ole.COINIT_MULTITHREADED} {
+		done := make(chan error)
+		go func() {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			if err := ole.CoInitializeEx(0, model); err != nil {
+				done <- err
+				return
+			}
+			defer ole.CoUninitialize()
+			var dst []Win32_Process
+			if err := Query(query, &dst); err != nil {
+				done <- err
+				return
+			}
+			// Row timeout fetches the objects from another thread.
+			c := Client{Timeout: time.Minute}
+			err := c.Query(query, &dst)
+			switch {
+			case model == ole.COINIT_APARTMENTTHREADED && err != ErrRowTimeoutSTA:
+				done <- fmt.Errorf("expected ErrRowTimeoutSTA with row timeout; got %v", err)
+			case model == ole.COINIT_MULTITHREADED && err != nil:
+				done <- fmt.Errorf("query with row timeout; %v", err)
+			default:
+				done <- nil
+			}
+		}()
+		if err := <-done; err != nil {
+			t.Errorf("Query from thread initialized with %d failed; %s", model, err)
+		}
+	}
+}
+
+func TestClient_MaxResults(t *testing.T) {
+	c := &Client{MaxResults: 2}
+	var processes []Win32_Process
+	err := c.Query("SELECT * FROM Win32_Process", &processes)
+	if exceeded, ok := err.(ErrResultLimitExceeded); !ok || exceeded.Limit != 2 {
+		t.Fatalf("Unexpected error for exceeded limit; %v", err)
+	}
+	if len(processes) != 2 {
+		t.Errorf("Unexpected processes count; got %d, expected 2", len(processes))
+	}
+
+	// Results within the limit.
+	if err := c.Query("SELECT * FROM Win32_Process WHERE ProcessId = 4", &processes); err != nil || len(processes) != 1 {
+		t.Errorf("Failed to query within the limit; %d processes, %v", len(processes), err)
+	}
+
+	// Streaming stops at the limit.
+	objects, errs := ClientQueryChan[Win32_Process](c, context.Background(), "SELECT * FROM Win32_Process")
+	received := 0
+	for range objects {
+		received++
+	}
+	if err := <-errs; received != 2 || err == nil {
+		t.Errorf("Unexpected streaming result; %d objects, %v", received, err)
+	}
+}
+
+func TestClient_Services(t *testing.T) {
+	var c Client
+	if _, err := c.Services(); err == nil {
+		t.Errorf("Expected error for not connected Client")
+	}
+
+	getText := func(services *ole.IDispatch) (text string, err error) {
+		class, err := oleutil.CallMethod(services, "Get", "Win32_Process")
+		if err != nil {
+			return "", err
+		}
+		defer class.Clear()
+		mof, err := oleutil.CallMethod(class.ToIDispatch(), "GetObjectText_")
+		if err != nil {
+			return "", err
+		}
+		defer mof.Clear()
+		return mof.ToString(), nil
+	}
+	checkText := func(services *ole.IDispatch) error {
+		text, err := getText(services)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(text, "class Win32_Process") {
+			return fmt.Errorf("unexpected MOF text %q", text)
+		}
+		return nil
+	}
+
+	// Temporary connection.
+	if err := c.Do(checkText); err != nil {
+		t.Errorf("Failed to get MOF text with temporary connection; %s", err)
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Failed to connect; %s", err)
+	}
+	defer c.Close()
+	services, err := c.Services()
+	if err != nil || services == nil {
+		t.Fatalf("Failed to get services of connected Client; %v", err)
+	}
+	err = c.Do(func(s *ole.IDispatch) error {
+		if s != services {
+			return errors.New("unexpected services object")
+		}
+		return checkText(s)
+	})
+	if err != nil {
+		t.Errorf("Failed to get MOF text with Client connection; %s", err)
+	}
+}
+
+func TestClient_Refresh(t *testing.T) {
+	type process struct {
+		Name string
+		PID  uint32 `wmi:"ProcessId"`
+	}
+	var c Client
+	system := &process{Name: "stale", PID: 4}
+	processes := []*process{{Name: "gone", PID: 0xFFFFFFF0}, system, nil}
+	query := fmt.Sprintf("SELECT Name, ProcessId FROM Win32_Process WHERE ProcessId = 4 OR ProcessId = %d", os.Getpid())
+
+	added, removed, err := c.Refresh(query, &processes, "ProcessId")
+	if err != nil {
+		t.Fatalf("Failed to refresh processes; %s", err)
+	}
+	if !reflect.DeepEqual(added, []int{1}) || !reflect.DeepEqual(removed, []int{0, 2}) {
+		t.Errorf("Unexpected changes; added %v, removed %v", added, removed)
+	}
+	if len(processes) != 2 || processes[0] != system || system.Name != "System" {
+		t.Fatalf("System process isn't updated in place; %+v", processes)
+	}
+	if processes[1].PID != uint32(os.Getpid()) {
+		t.Errorf("Unexpected added process; %+v", processes[1])
+	}
+
+	// Nothing changes on the repeated refresh.
+	added, removed, err = c.Refresh(query, &processes, "ProcessId")
+	if err != nil || len(added) != 0 || len(removed) != 0 {
+		t.Errorf("Unexpected repeated refresh result; added %v, removed %v, err %v", added, removed, err)
+	}
+
+	if _, _, err := c.Refresh(query, &processes, "NoSuchProperty"); err == nil {
+		t.Errorf("Expected error for the missing key field")
+	}
+	duplicates := []process{{PID: 4}, {PID: 4}}
+	if _, _, err := c.Refresh(query, &duplicates, "ProcessId"); err == nil {
+		t.Errorf("Expected error for the duplicate keys")
+	}
+	if duplicates[0].Name != "" || len(duplicates) != 2 {
+		t.Errorf("Destination is modified on error; %+v", duplicates)
+	}
+
+	c.AllowDuplicateKeys = true
+	added, removed, err = c.Refresh(query, &duplicates, "ProcessId")
+	if err != nil {
+		t.Fatalf("Failed to refresh with duplicate keys; %s", err)
+	}
+	if !reflect.DeepEqual(removed, []int{1}) || len(added) != 1 || duplicates[0].Name != "System" {
+		t.Errorf("Unexpected refresh with duplicate keys; added %v, removed %v, %+v", added, removed, duplicates)
+	}
+}
+
+func TestCreateQueryWhere(t *testing.T) {
+	started := true
+	var nilPath *string
+	created := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	tests := []struct {
+		name       string
+		conditions map[string]interface{}
+		expected   string
+	}{
+		{"empty", nil, "SELECT * FROM Win32_Process"},
+		{"quotes", map[string]interface{}{"Name": `O'Neil's "app".exe`},
+			`SELECT * FROM Win32_Process WHERE Name = "O'Neil's \"app\".exe"`},
+		{"backslashes", map[string]interface{}{"ExecutablePath": `C:\Windows\`},
+			`SELECT * FROM Win32_Process WHERE ExecutablePath = "C:\\Windows\\"`},
+		{"datetime", map[string]interface{}{"CreationDate": created},
+			`SELECT * FROM Win32_Process WHERE CreationDate = "20200102030405.000006+000"`},
+		{"sorted", map[string]interface{}{"ProcessId": uint32(4), "Name": "System", "Started": &started, "Path": nilPath},
+			`SELECT * FROM Win32_Process WHERE Name = "System" AND Path IS NULL AND ProcessId = 4 AND Started = TRUE`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CreateQueryWhere("Win32_Process", tt.conditions); got != tt.expected {
+				t.Errorf("Unexpected query; got %s, expected %s", got, tt.expected)
+			}
+		})
+	}
+
+	if _, err := WhereFromMap(map[string]interface{}{"Name": []string{"a"}}); err == nil {
+		t.Errorf("Expected error for unsupported value type")
+	}
+	if q := CreateQueryWhere("Win32_Process", map[string]interface{}{"Name": struct{}{}}); q != "" {
+		t.Errorf("Expected empty query for unsupported value type; got %s", q)
+	}
+
+	// Names are pasted as is, so only identifiers are allowed.
+	injected := map[string]interface{}{"Name = 'x' OR Name": "y"}
+	if _, err := WhereFromMap(injected); err == nil {
+		t.Errorf("Expected error for non-identifier property name")
+	}
+	if q, err := BuildQueryWhere("Win32_Process", injected); err == nil {
+		t.Errorf("Expected error for non-identifier property name; got %s", q)
+	}
+	if q, err := BuildQueryWhere("Win32_Process WHERE Name = 'x'", nil); err == nil {
+		t.Errorf("Expected error for non-identifier class name; got %s", q)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("MustCreateQueryWhere doesn't panic on error")
+			}
+		}()
+		MustCreateQueryWhere("Win32_Process", injected)
+	}()
+	if q := MustCreateQueryWhere("Win32_Process", map[string]interface{}{"ProcessId": 4}); q != "SELECT * FROM Win32_Process WHERE ProcessId = 4" {
+		t.Errorf("Unexpected MustCreateQueryWhere query; %s", q)
+	}
+
+	// Check the query is valid.
+	var processes []struct{ Name string }
+	q := CreateQueryWhere("Win32_Process", map[string]interface{}{"ProcessId": 4, "Name": "System"})
+	if err := Query(q, &processes); err != nil || len(processes) != 1 {
+		t.Errorf("Unexpected result of %s; %v, %v", q, processes, err)
+	}
+}